@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"image"
 	"image/color"
+	"io"
+	"sync"
+	"unicode/utf8"
 
 	"github.com/clktmr/n64/drivers/controller"
 	"github.com/clktmr/n64/drivers/draw"
@@ -14,6 +17,7 @@ import (
 )
 
 type Console struct {
+	mtx    sync.Mutex
 	buf    bytes.Buffer
 	scroll image.Point
 }
@@ -23,13 +27,90 @@ var font = basicfont12.NewFace()
 func NewConsole() *Console { return &Console{} }
 
 func (v *Console) Write(p []byte) (n int, err error) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
 	n, err = v.buf.Write(p)
-	v.Draw()
+	v.draw()
 	rdp.RDP.Flush()
 	return
 }
 
+// Input returns a reader of the lines typed on r, e.g. a
+// [keyboard.Keyboard], which serves as stdin of an on-device shell. Typed text
+// is echoed to the console and a backspace deletes the last rune of the
+// current line. If other output was written since the current line was echoed,
+// the line is echoed again below it. Read only returns complete lines,
+// including their newline.
+func (v *Console) Input(r io.Reader) io.Reader {
+	return &lineReader{v: v, r: r}
+}
+
+type lineReader struct {
+	v           *Console
+	r           io.Reader
+	line, ready []byte
+	start       int // offset of line in v.buf
+	buf         [64]byte
+}
+
+func (l *lineReader) Read(p []byte) (n int, err error) {
+	for len(l.ready) == 0 {
+		n, err = l.r.Read(l.buf[:])
+		l.edit(l.buf[:n])
+		if err != nil {
+			if len(l.ready) == 0 {
+				return 0, err
+			}
+			break
+		}
+	}
+	n = copy(p, l.ready)
+	l.ready = l.ready[n:]
+	return n, nil
+}
+
+func (l *lineReader) edit(b []byte) {
+	v := l.v
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	// The console's buffer only grows by writes, so the line is still at
+	// its end if its length matches.
+	if v.buf.Len() != l.start+len(l.line) {
+		if out := v.buf.Bytes(); len(l.line) > 0 && out[len(out)-1] != '\n' {
+			v.buf.WriteByte('\n')
+		}
+		l.start = v.buf.Len()
+		v.buf.Write(l.line)
+	}
+
+	for _, c := range b {
+		switch c {
+		case '\b':
+			if len(l.line) == 0 {
+				continue
+			}
+			_, size := utf8.DecodeLastRune(l.line)
+			l.line = l.line[:len(l.line)-size]
+			v.buf.Truncate(v.buf.Len() - size)
+		case '\n':
+			l.line = append(l.line, c)
+			l.ready = append(l.ready, l.line...)
+			l.line = l.line[:0]
+			v.buf.WriteByte(c)
+			l.start = v.buf.Len()
+		default:
+			l.line = append(l.line, c)
+			v.buf.WriteByte(c)
+		}
+	}
+	v.draw()
+	rdp.RDP.Flush()
+}
+
 func (v *Console) Update(input controller.Controller) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
 	pressed := input.Pressed()
 	switch {
 	case pressed&joybus.ButtonCUp != 0:
@@ -43,8 +124,13 @@ func (v *Console) Update(input controller.Controller) {
 	}
 }
 
-// FIXME sync via mutex with Write?
 func (v *Console) Draw() {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	v.draw()
+}
+
+func (v *Console) draw() {
 	fb := video.Framebuffer()
 	if fb == nil {
 		return
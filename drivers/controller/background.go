@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/clktmr/n64/debug"
+	"github.com/clktmr/n64/rcp"
+	"github.com/clktmr/n64/rcp/serial"
+)
+
+const pifRamSize = 64
+
+// rawBlock allocates joybus commands in a plain buffer with the same layout
+// as in a [serial.CommandBlock]. This allows parsing responses which were
+// copied out of interrupt context.
+type rawBlock struct {
+	buf [pifRamSize]byte
+	n   int
+}
+
+func (b *rawBlock) Alloc(n int) ([]byte, error) {
+	if b.n+n > len(b.buf)-1 {
+		return nil, errors.New("command block full")
+	}
+	b.n += n
+	return b.buf[b.n-n : b.n], nil
+}
+
+// snapshot holds the raw responses of a single background poll.
+type snapshot struct {
+	info, states [pifRamSize]byte
+}
+
+// state shared with interrupt handler
+var (
+	bgScratch   snapshot
+	bgSnapshots rcp.IntrOutput[snapshot]
+	viHandler   func()
+)
+
+var (
+	bgMtx        sync.Mutex
+	bgInfo       rawBlock
+	bgStates     rawBlock
+	bgCmds       pollCommands
+	bgChained    bool
+	bgActive     bool
+	bgLastSeq    uint32
+	bgStale      bool   // layout changed, bgStaleSeq has the previous layout
+	bgStaleSeq   uint32 // last snapshot taken with the previous layout
+	bgBackground *serial.Background
+
+	// commands in bgBackground, to update them in place
+	bgBlockCmds   pollCommands
+	bgBlockStates *serial.CommandBlock
+)
+
+func init() {
+	bgLayout(layout{})
+}
+
+// bgLayout prepares the background commands and the parser of their
+// responses for layout l.
+func bgLayout(l layout) {
+	bgInfo, bgStates = rawBlock{}, rawBlock{}
+	err := bgCmds.init(&bgInfo, &bgStates, l)
+	debug.AssertErrNil(err)
+
+	info := serial.NewCommandBlock(serial.CmdConfigureJoybus)
+	bgBlockStates = serial.NewCommandBlock(serial.CmdConfigureJoybus)
+	err = bgBlockCmds.init(info, bgBlockStates, l)
+	debug.AssertErrNil(err)
+	bgBackground = serial.NewBackground(pollDone, info, bgBlockStates)
+}
+
+// StartPolling starts polling all ports in the background once per frame. The
+// serial interface is triggered by the vertical blank interrupt, so video
+// output must be enabled. Use [Latest] to read the most recent states.
+//
+// Background polling doesn't interfere with [Poll] or other users of the
+// serial interface, which will wait for a running background poll to finish.
+func StartPolling() {
+	bgMtx.Lock()
+	defer bgMtx.Unlock()
+
+	if !bgChained {
+		viHandler = rcp.Handler(rcp.IntrVideo)
+		rcp.SetHandler(rcp.IntrVideo, pollHandler)
+		bgChained = true
+	}
+
+	serial.SetBackground(bgBackground)
+	bgActive = true
+}
+
+// StopPolling stops polling in the background. It blocks until a currently
+// running background poll has finished.
+func StopPolling() {
+	bgMtx.Lock()
+	defer bgMtx.Unlock()
+
+	serial.SetBackground(nil)
+	bgActive = false
+}
+
+// Latest updates states with the most recent result of the background polling
+// started by [StartPolling]. Returns false if there was no new result since
+// the last call, in which case states are updated nonetheless, i.e. nothing was
+// pressed or released.
+//
+// If a GameCube controller was plugged or unplugged, the background commands
+// are changed accordingly, which waits for a running background poll to finish
+// and leaves states unchanged for a single call. Otherwise Latest never blocks
+// on the serial bus. GameCube controllers use the origin read by the last call
+// to [Poll], if any.
+func Latest(states *[4]Controller) (updated bool) {
+	bgMtx.Lock()
+	defer bgMtx.Unlock()
+
+	snap, seq := bgSnapshots.Get()
+	if bgStale {
+		if seq == bgStaleSeq {
+			return false
+		}
+		bgStale = false
+	}
+	updated = seq != bgLastSeq
+	bgLastSeq = seq
+
+	bgInfo.buf = snap.info
+	bgStates.buf = snap.states
+	bgCmds.update(states)
+
+	if !bgActive {
+		return
+	}
+	if l := bgCmds.wantLayout(); l.gc != bgCmds.layout.gc {
+		bgLayout(l)
+		serial.SetBackground(bgBackground)
+		_, bgStaleSeq = bgSnapshots.Get()
+		bgStale = true
+	} else if l.rumble != bgCmds.layout.rumble {
+		// The responses don't change, so update the commands in place.
+		bgCmds.setRumble(l.rumble)
+		bgBlockCmds.setRumble(l.rumble)
+		bgBackground.Update(1, bgBlockStates)
+	}
+	return
+}
+
+//go:nosplit
+//go:nowritebarrierrec
+func pollHandler() {
+	if viHandler != nil {
+		viHandler()
+	}
+	serial.Trigger()
+}
+
+//go:nosplit
+//go:nowritebarrierrec
+func pollDone(i int, resp []byte) {
+	switch i {
+	case 0:
+		copy(bgScratch.info[:], resp)
+	case 1:
+		copy(bgScratch.states[:], resp)
+		bgSnapshots.Put(&bgScratch)
+	}
+}
@@ -23,6 +23,12 @@ func (p *Port) Nr() uint8 {
 	return p.number
 }
 
+// Device returns the type of device which was connected to the port during
+// the last call to [Poll].
+func (p *Port) Device() joybus.Device {
+	return p.current.device
+}
+
 const (
 	pakInserted    = 0x01
 	pakNotInserted = 0x02
@@ -40,6 +46,8 @@ type Controller struct {
 		yAxis int8
 	}
 
+	gc gcState
+
 	err error
 }
 
@@ -93,27 +101,33 @@ func (c *Controller) DY() int8 {
 // Present reports whether a controller is connected to the port. It will return
 // false if no device is connected or
 func (c *Controller) Present() bool {
-	return c.Port.current.device == joybus.Controller
+	return isController(c.Port.current.device)
 }
 
 // Plugged reports if a controller was plugged into the port between the last
 // two calls to [Poll].
 func (c *Controller) Plugged() bool {
-	return c.Port.current.device == joybus.Controller &&
-		c.Port.last.device != joybus.Controller
+	return isController(c.Port.current.device) &&
+		!isController(c.Port.last.device)
 }
 
 // Unplugged reports if a controller was unplugged from the port between the
 // last two calls to [Poll].
 func (c *Controller) Unplugged() bool {
-	return c.Port.current.device != joybus.Controller &&
-		c.Port.last.device == joybus.Controller
+	return !isController(c.Port.current.device) &&
+		isController(c.Port.last.device)
+}
+
+// isController reports whether dev is a standard or GameCube controller.
+func isController(dev joybus.Device) bool {
+	return dev == joybus.Controller || dev.GameCube()
 }
 
 // PakInserted reports if a pak accessory was inserted into the controller
 // between the last two calls to [Poll].
 func (c *Controller) PakInserted() bool {
-	return c.Port.current.flags&pakInserted != 0 &&
+	return c.Port.current.device == joybus.Controller &&
+		c.Port.current.flags&pakInserted != 0 &&
 		c.Port.last.flags&pakInserted == 0
 }
 
@@ -0,0 +1,118 @@
+package controller
+
+import "github.com/clktmr/n64/rcp/serial/joybus"
+
+// gcCThreshold is the C-stick deflection at which the corresponding C button
+// is reported as pressed.
+const gcCThreshold = 48
+
+// gcCenter is used as origin until it was read from the controller.
+var gcCenter = joybus.GCState{StickX: 0x80, StickY: 0x80, CStickX: 0x80, CStickY: 0x80}
+
+// gcState is the extended state of a GameCube controller.
+type gcState struct {
+	current, last joybus.GCState
+	origin        joybus.GCState
+	hasOrigin     bool
+}
+
+var gcButtonMap = [...]struct {
+	gc  joybus.GCButtonMask
+	n64 joybus.ButtonMask
+}{
+	{joybus.GCButtonA, joybus.ButtonA},
+	{joybus.GCButtonB, joybus.ButtonB},
+	{joybus.GCButtonZ, joybus.ButtonZ},
+	{joybus.GCButtonStart, joybus.ButtonStart},
+	{joybus.GCButtonDUp, joybus.ButtonDUp},
+	{joybus.GCButtonDDown, joybus.ButtonDDown},
+	{joybus.GCButtonDLeft, joybus.ButtonDLeft},
+	{joybus.GCButtonDRight, joybus.ButtonDRight},
+	{joybus.GCButtonL, joybus.ButtonL},
+	{joybus.GCButtonR, joybus.ButtonR},
+}
+
+// mapped returns the state of a standard controller equivalent to the
+// GameCube state. The C-stick is mapped to the C buttons.
+func (s *gcState) mapped() (down joybus.ButtonMask, x, y int8) {
+	origin := s.originOrCenter()
+	for _, v := range gcButtonMap {
+		if s.current.Buttons&v.gc != 0 {
+			down |= v.n64
+		}
+	}
+
+	cx := axis(s.current.CStickX, origin.CStickX)
+	cy := axis(s.current.CStickY, origin.CStickY)
+	switch {
+	case cx >= gcCThreshold:
+		down |= joybus.ButtonCRight
+	case cx <= -gcCThreshold:
+		down |= joybus.ButtonCLeft
+	}
+	switch {
+	case cy >= gcCThreshold:
+		down |= joybus.ButtonCUp
+	case cy <= -gcCThreshold:
+		down |= joybus.ButtonCDown
+	}
+
+	x = axis(s.current.StickX, origin.StickX)
+	y = axis(s.current.StickY, origin.StickY)
+	return
+}
+
+func (s *gcState) originOrCenter() joybus.GCState {
+	if s.hasOrigin {
+		return s.origin
+	}
+	return gcCenter
+}
+
+// axis returns the signed offset of v from its origin.
+func axis(v, origin uint8) int8 {
+	return int8(min(max(int(v)-int(origin), -128), 127))
+}
+
+// trigger returns the travel of an analog trigger from its origin.
+func trigger(v, origin uint8) uint8 {
+	return uint8(max(int(v)-int(origin), 0))
+}
+
+// GameCube reports whether a GameCube controller is connected to the port.
+//
+// The state of a GameCube controller is mapped to that of a standard
+// controller: Buttons with a counterpart are reported by [Controller.Down],
+// the C-stick additionally presses the C buttons and the analog stick is
+// reported relative to the origin read by [Poll]. The extended state is
+// available via [Controller.GCDown], [Controller.CStick] and
+// [Controller.Triggers].
+func (c *Controller) GameCube() bool {
+	return c.Port.current.device.GameCube()
+}
+
+// GCDown reports which buttons of a GameCube controller were pressed during
+// the last call to [Poll].
+func (c *Controller) GCDown() joybus.GCButtonMask {
+	return c.gc.current.Buttons &^ joybus.GCNeedOrigin
+}
+
+// GCPressed reports which buttons of a GameCube controller were pressed
+// between the last two calls to [Poll].
+func (c *Controller) GCPressed() joybus.GCButtonMask {
+	return (c.gc.current.Buttons &^ c.gc.last.Buttons) &^ joybus.GCNeedOrigin
+}
+
+// CStick returns the position of a GameCube controller's C-stick relative to
+// its origin.
+func (c *Controller) CStick() (x, y int8) {
+	origin := c.gc.originOrCenter()
+	return axis(c.gc.current.CStickX, origin.CStickX), axis(c.gc.current.CStickY, origin.CStickY)
+}
+
+// Triggers returns how far the analog L and R triggers of a GameCube
+// controller are pressed, relative to their origin.
+func (c *Controller) Triggers() (l, r uint8) {
+	origin := c.gc.originOrCenter()
+	return trigger(c.gc.current.L, origin.L), trigger(c.gc.current.R, origin.R)
+}
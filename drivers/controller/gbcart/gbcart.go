@@ -0,0 +1,192 @@
+// Package gbcart implements access to Game Boy cartridges.
+//
+// The cartridge is accessed through its 16-bit address bus, e.g. via the
+// Transfer Pak. The cartridge header is parsed to determine the memory bank
+// controller (MBC), which is then used to make the whole ROM and the external
+// save RAM accessible as [io.ReaderAt] and [io.WriterAt]. Supported are
+// cartridges without MBC and with MBC1, MBC2, MBC3 (including its real time
+// clock) and MBC5.
+package gbcart
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Errors returned by gbcart.
+var (
+	ErrChecksum    = errors.New("header checksum mismatch")
+	ErrUnsupported = errors.New("unsupported cartridge type")
+	ErrNoClock     = errors.New("cartridge has no real time clock")
+)
+
+// Bus provides access to the Game Boy cartridge's address space from 0x0000 to
+// 0xffff. Offsets passed to ReadAt and WriteAt are Game Boy addresses.
+//
+// Writes to MBC registers, i.e. the ROM area and the RTC registers, are single
+// byte writes via WriteAt, unless the Bus also implements [RegisterWriter].
+type Bus interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// RegisterWriter can be implemented by a [Bus] which can't write single bytes,
+// e.g. because it always writes whole blocks.
+type RegisterWriter interface {
+	// WriteReg writes v to the register mapped at addr. The value must be
+	// the last one written to addr.
+	WriteReg(addr uint16, v byte) error
+}
+
+// Cartridge represents a Game Boy cartridge connected via a [Bus].
+type Cartridge struct {
+	mtx sync.Mutex
+	bus Bus
+	hdr Header
+	reg [1]byte
+}
+
+// Open reads the cartridge header and returns a Cartridge if the header is
+// valid and its type is supported.
+func Open(bus Bus) (*Cartridge, error) {
+	var h [headerEnd - headerStart]byte
+	_, err := bus.ReadAt(h[:], headerStart)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr, err := parseHeader(&h)
+	if err != nil {
+		return nil, err
+	}
+	if !hdr.Type.Supported() {
+		return nil, ErrUnsupported
+	}
+
+	c := &Cartridge{bus: bus, hdr: hdr}
+	if err = c.enableRAM(false); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Header returns the parsed cartridge header.
+func (c *Cartridge) Header() Header {
+	return c.hdr
+}
+
+// ROM returns the cartridge's read-only memory.
+func (c *Cartridge) ROM() *ROM {
+	return &ROM{c}
+}
+
+// RAM returns the cartridge's external RAM, which usually holds the savegame.
+// Its size is zero if the cartridge has no RAM.
+func (c *Cartridge) RAM() *RAM {
+	return &RAM{c}
+}
+
+// ROM implements [io.ReaderAt] for the whole cartridge ROM, switching banks as
+// necessary.
+type ROM struct{ c *Cartridge }
+
+// Size returns the ROM size in bytes as specified in the header.
+func (r *ROM) Size() int64 { return r.c.hdr.ROMSize }
+
+func (r *ROM) ReadAt(p []byte, off int64) (n int, err error) {
+	c := r.c
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.banked(p, off, c.hdr.ROMSize, romBankSize, c.mapROM, c.bus.ReadAt)
+}
+
+// RAM implements [io.ReaderAt] and [io.WriterAt] for the cartridge's external
+// RAM, switching banks as necessary. The RAM is only enabled during the
+// respective read or write to protect it from corruption, e.g. when the
+// cartridge is removed.
+//
+// The MBC2 has 512 half-bytes of builtin RAM. Only the lower four bits of each
+// byte are stored, the upper four bits always read as zero.
+type RAM struct{ c *Cartridge }
+
+// Size returns the RAM size in bytes as specified in the header.
+func (r *RAM) Size() int64 { return r.c.hdr.RAMSize }
+
+func (r *RAM) ReadAt(p []byte, off int64) (n int, err error) {
+	c := r.c
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if err = c.enableRAM(true); err != nil {
+		return
+	}
+	n, err = c.banked(p, off, c.hdr.RAMSize, ramBankSize, c.mapRAM, c.bus.ReadAt)
+	if errDisable := c.enableRAM(false); err == nil {
+		err = errDisable
+	}
+
+	if c.hdr.Type.mbc() == mbc2 {
+		for i := range p[:n] {
+			p[i] &= 0x0f
+		}
+	}
+	return
+}
+
+func (r *RAM) WriteAt(p []byte, off int64) (n int, err error) {
+	c := r.c
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if err = c.enableRAM(true); err != nil {
+		return
+	}
+	n, err = c.banked(p, off, c.hdr.RAMSize, ramBankSize, c.mapRAM, c.bus.WriteAt)
+	if errDisable := c.enableRAM(false); err == nil {
+		err = errDisable
+	}
+	return
+}
+
+// banked splits the access to p at off into chunks which don't cross bank
+// boundaries. Each bank is mapped via mapBank before fn is called with the
+// bus address of the chunk.
+func (c *Cartridge) banked(p []byte, off, size, bankSize int64,
+	mapBank func(bank int) (int64, error),
+	fn func(p []byte, addr int64) (int, error)) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= size {
+		return 0, io.EOF
+	}
+	if remaining := size - off; int64(len(p)) > remaining {
+		p = p[:remaining]
+		defer func() {
+			if err == nil {
+				err = io.EOF
+			}
+		}()
+	}
+
+	for n < len(p) {
+		bank, bankOff := off/bankSize, off%bankSize
+		var base int64
+		base, err = mapBank(int(bank))
+		if err != nil {
+			return
+		}
+
+		chunk := p[n:min(len(p), n+int(bankSize-bankOff))]
+		var copied int
+		copied, err = fn(chunk, base+bankOff)
+		n += copied
+		off += int64(copied)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,389 @@
+//go:build !n64
+
+package gbcart
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// emuCart emulates a Game Boy cartridge as seen on its address bus.
+type emuCart struct {
+	typ  Type
+	rom  []byte
+	ram  []byte
+	rtc  [rtcRegCount]byte
+	rtcL [rtcRegCount]byte // latched rtc registers
+
+	ramEnabled bool
+	romBank    int
+	bankHigh   int // RAM bank or upper ROM bank bits
+	mode       byte
+}
+
+func newEmuCart(typ Type, romSize, ramSize byte) *emuCart {
+	rnd := rand.New(rand.NewSource(int64(typ)))
+	e := &emuCart{
+		typ: typ,
+		rom: make([]byte, (32<<10)<<romSize),
+	}
+	rnd.Read(e.rom)
+	switch {
+	case typ.mbc() == mbc2:
+		e.ram = make([]byte, mbc2RAMSize)
+	case typ.HasRAM():
+		e.ram = make([]byte, ramSizes[ramSize])
+	}
+
+	h := e.rom[headerStart:headerEnd]
+	copy(h[offTitle-headerStart:], "TESTCART\x00\x00\x00\x00\x00\x00\x00\x00")
+	h[offOldLicensee-headerStart] = 0x33
+	copy(h[offNewLicensee-headerStart:], "01")
+	h[offType-headerStart] = byte(typ)
+	h[offROMSize-headerStart] = romSize
+	h[offRAMSize-headerStart] = ramSize
+	h[offDestination-headerStart] = 0x01
+	var csum byte
+	for _, v := range e.rom[offTitle:offHeaderChecksum] {
+		csum = csum - v - 1
+	}
+	h[offHeaderChecksum-headerStart] = csum
+	return e
+}
+
+func (e *emuCart) ReadAt(p []byte, off int64) (int, error) {
+	for i := range p {
+		p[i] = e.read(uint16(off) + uint16(i))
+	}
+	return len(p), nil
+}
+
+func (e *emuCart) WriteAt(p []byte, off int64) (int, error) {
+	for i, v := range p {
+		e.write(uint16(off)+uint16(i), v)
+	}
+	return len(p), nil
+}
+
+func (e *emuCart) read(addr uint16) byte {
+	mbc := e.typ.mbc()
+	switch {
+	case addr < 0x4000:
+		bank := 0
+		if mbc == mbc1 && e.mode == 1 {
+			bank = e.bankHigh << 5
+		}
+		return e.rom[(bank*romBankSize+int(addr))%len(e.rom)]
+	case addr < 0x8000:
+		bank := 1
+		switch mbc {
+		case mbc1:
+			bank = max(e.romBank&0x1f, 1) | e.bankHigh<<5
+		case mbc2:
+			bank = max(e.romBank&0xf, 1)
+		case mbc3:
+			bank = max(e.romBank&0x7f, 1)
+		case mbc5:
+			bank = e.romBank
+		}
+		return e.rom[(bank*romBankSize+int(addr-0x4000))%len(e.rom)]
+	case addr >= 0xa000 && addr < 0xc000:
+		if mbc != mbcNone && !e.ramEnabled {
+			return 0xff
+		}
+		switch mbc {
+		case mbc2:
+			return e.ram[addr&0x1ff] | 0xf0
+		case mbc3:
+			if e.bankHigh >= rtcSeconds {
+				return e.rtcL[e.bankHigh-rtcSeconds]
+			}
+		}
+		if idx := e.ramIndex(addr); idx < len(e.ram) {
+			return e.ram[idx]
+		}
+	}
+	return 0xff
+}
+
+func (e *emuCart) ramIndex(addr uint16) int {
+	bank := 0
+	switch e.typ.mbc() {
+	case mbc1:
+		if e.mode == 1 {
+			bank = e.bankHigh
+		}
+	case mbc3, mbc5:
+		bank = e.bankHigh
+	}
+	if e.typ.HasRumble() {
+		bank &= 0x7
+	}
+	return bank*ramBankSize + int(addr-ramBase)
+}
+
+func (e *emuCart) write(addr uint16, v byte) {
+	mbc := e.typ.mbc()
+	switch {
+	case mbc == mbcNone && addr < 0x8000:
+	case mbc == mbc2 && addr < 0x4000:
+		if addr&0x100 != 0 {
+			e.romBank = int(v & 0xf)
+		} else {
+			e.ramEnabled = v&0xf == ramEnable
+		}
+	case addr < 0x2000:
+		e.ramEnabled = v&0xf == ramEnable
+	case addr < 0x4000:
+		switch mbc {
+		case mbc1:
+			e.romBank = int(v & 0x1f)
+		case mbc3:
+			e.romBank = int(v & 0x7f)
+		case mbc5:
+			if addr < 0x3000 {
+				e.romBank = e.romBank&0x100 | int(v)
+			} else {
+				e.romBank = e.romBank&0xff | int(v&1)<<8
+			}
+		}
+	case addr < 0x6000:
+		switch mbc {
+		case mbc1:
+			e.bankHigh = int(v & 0x3)
+		default:
+			e.bankHigh = int(v & 0xf)
+		}
+	case addr < 0x8000:
+		switch mbc {
+		case mbc1:
+			e.mode = v & 0x1
+		case mbc3:
+			if e.mode == 0 && v == 1 {
+				e.rtcL = e.rtc
+			}
+			e.mode = v
+		}
+	case addr >= 0xa000 && addr < 0xc000:
+		if mbc != mbcNone && !e.ramEnabled {
+			return
+		}
+		switch mbc {
+		case mbc2:
+			e.ram[addr&0x1ff] = v & 0xf
+			return
+		case mbc3:
+			if e.bankHigh >= rtcSeconds {
+				e.rtc[e.bankHigh-rtcSeconds] = v
+				return
+			}
+		}
+		if idx := e.ramIndex(addr); idx < len(e.ram) {
+			e.ram[idx] = v
+		}
+	}
+}
+
+var testCarts = map[string]struct {
+	typ              Type
+	romSize, ramSize byte
+}{
+	"ROM+RAM":                {ROMRAMBattery, 0, 2},
+	"MBC1 2MiB ROM":          {MBC1RAMBattery, 6, 2},
+	"MBC1 32KiB RAM":         {MBC1RAMBattery, 4, 3},
+	"MBC2":                   {MBC2Battery, 3, 0},
+	"MBC3":                   {MBC3RAMBattery, 6, 3},
+	"MBC3+TIMER":             {MBC3TimerRAMBattery, 5, 3},
+	"MBC5":                   {MBC5RAMBattery, 8, 4},
+	"MBC5+RUMBLE":            {MBC5RumbleRAMBattery, 5, 3},
+	"MBC5 without RAM":       {MBC5, 2, 0},
+	"MBC3+TIMER without RAM": {MBC3TimerBattery, 1, 0},
+}
+
+func TestHeader(t *testing.T) {
+	for name, tc := range testCarts {
+		t.Run(name, func(t *testing.T) {
+			e := newEmuCart(tc.typ, tc.romSize, tc.ramSize)
+			c, err := Open(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hdr := c.Header()
+			if hdr.Title != "TESTCART" {
+				t.Errorf("title: expected %q, got %q", "TESTCART", hdr.Title)
+			}
+			if hdr.Licensee != "01" {
+				t.Errorf("licensee: expected %q, got %q", "01", hdr.Licensee)
+			}
+			if hdr.Type != tc.typ {
+				t.Errorf("type: expected %v, got %v", tc.typ, hdr.Type)
+			}
+			if hdr.ROMSize != int64(len(e.rom)) {
+				t.Errorf("rom size: expected %v, got %v", len(e.rom), hdr.ROMSize)
+			}
+			if hdr.RAMSize != int64(len(e.ram)) {
+				t.Errorf("ram size: expected %v, got %v", len(e.ram), hdr.RAMSize)
+			}
+		})
+	}
+}
+
+func TestHeaderChecksum(t *testing.T) {
+	e := newEmuCart(MBC1, 0, 0)
+	e.rom[offTitle] ^= 0xff
+	if _, err := Open(e); err != ErrChecksum {
+		t.Fatalf("expected %v, got %v", ErrChecksum, err)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	e := newEmuCart(HuC3, 0, 0)
+	if _, err := Open(e); err != ErrUnsupported {
+		t.Fatalf("expected %v, got %v", ErrUnsupported, err)
+	}
+}
+
+func TestROM(t *testing.T) {
+	for name, tc := range testCarts {
+		t.Run(name, func(t *testing.T) {
+			e := newEmuCart(tc.typ, tc.romSize, tc.ramSize)
+			c, err := Open(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rom := make([]byte, c.ROM().Size())
+			_, err = io.ReadFull(io.NewSectionReader(c.ROM(), 0, c.ROM().Size()), rom)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(rom, e.rom) {
+				t.Fatal("rom mismatch")
+			}
+
+			// unaligned read across bank boundary
+			buf := make([]byte, 100)
+			off := int64(romBankSize - 50)
+			if _, err = c.ROM().ReadAt(buf, off); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf, e.rom[off:off+100]) {
+				t.Fatal("rom mismatch at bank boundary")
+			}
+
+			n, err := c.ROM().ReadAt(buf, c.ROM().Size()-10)
+			if n != 10 || err != io.EOF {
+				t.Fatalf("expected 10, EOF; got %v, %v", n, err)
+			}
+		})
+	}
+}
+
+func TestRAM(t *testing.T) {
+	for name, tc := range testCarts {
+		t.Run(name, func(t *testing.T) {
+			e := newEmuCart(tc.typ, tc.romSize, tc.ramSize)
+			c, err := Open(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ram := c.RAM()
+			if ram.Size() == 0 {
+				if _, err = ram.ReadAt(make([]byte, 1), 0); err != io.EOF {
+					t.Fatalf("expected EOF, got %v", err)
+				}
+				return
+			}
+
+			data := make([]byte, ram.Size())
+			rand.Read(data)
+			if tc.typ.mbc() == mbc2 {
+				for i := range data {
+					data[i] &= 0xf
+				}
+			}
+
+			for off := int64(0); off < ram.Size(); off += 1000 {
+				end := min(off+1000, ram.Size())
+				if _, err = ram.WriteAt(data[off:end], off); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if e.ramEnabled {
+				t.Error("ram left enabled after write")
+			}
+			if !bytes.Equal(e.ram, data) {
+				t.Fatal("ram mismatch after write")
+			}
+
+			readback := make([]byte, ram.Size())
+			if _, err = ram.ReadAt(readback, 0); err != nil {
+				t.Fatal(err)
+			}
+			if e.ramEnabled {
+				t.Error("ram left enabled after read")
+			}
+			if !bytes.Equal(readback, data) {
+				t.Fatal("ram mismatch after read")
+			}
+		})
+	}
+}
+
+func TestClock(t *testing.T) {
+	for _, ramSize := range []byte{2, 3} { // single and multiple RAM banks
+		e := newEmuCart(MBC3TimerRAMBattery, 6, ramSize)
+		c, err := Open(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ram := make([]byte, c.RAM().Size())
+		if _, err = c.RAM().WriteAt(bytes.Repeat([]byte{0x55}, len(ram)), 0); err != nil {
+			t.Fatal(err)
+		}
+
+		clk := Clock{Seconds: 42, Minutes: 13, Hours: 7, Days: 300, Carry: true}
+		if err = c.SetClock(clk); err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.Clock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != clk {
+			t.Fatalf("ram size %d: expected %+v, got %+v", ramSize, clk, got)
+		}
+
+		// clock access must not alter RAM contents
+		if _, err = c.RAM().ReadAt(ram, 0); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(ram, bytes.Repeat([]byte{0x55}, len(ram))) {
+			t.Fatalf("ram size %d: ram corrupted by clock access", ramSize)
+		}
+
+		// RAM written after clock access must not end up in the RTC
+		if _, err = c.RAM().WriteAt([]byte{0xaa}, 0); err != nil {
+			t.Fatal(err)
+		}
+		if e.ram[0] != 0xaa {
+			t.Fatalf("ram size %d: ram write after clock access got lost", ramSize)
+		}
+		if got, err = c.Clock(); err != nil || got != clk {
+			t.Fatalf("ram size %d: clock changed by ram write: %+v, %v", ramSize, got, err)
+		}
+	}
+
+	e := newEmuCart(MBC3RAMBattery, 6, 3)
+	c, err := Open(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Clock(); err != ErrNoClock {
+		t.Fatalf("expected %v, got %v", ErrNoClock, err)
+	}
+}
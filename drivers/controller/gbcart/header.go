@@ -0,0 +1,200 @@
+package gbcart
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	headerStart = 0x0100
+	headerEnd   = 0x0150
+
+	offTitle          = 0x0134
+	offCGB            = 0x0143
+	offNewLicensee    = 0x0144
+	offSGB            = 0x0146
+	offType           = 0x0147
+	offROMSize        = 0x0148
+	offRAMSize        = 0x0149
+	offDestination    = 0x014a
+	offOldLicensee    = 0x014b
+	offVersion        = 0x014c
+	offHeaderChecksum = 0x014d
+	offGlobalChecksum = 0x014e
+)
+
+// Type is the cartridge type byte in the header. It identifies the memory bank
+// controller and additional hardware present on the cartridge.
+type Type byte
+
+const (
+	ROMOnly              Type = 0x00
+	MBC1                 Type = 0x01
+	MBC1RAM              Type = 0x02
+	MBC1RAMBattery       Type = 0x03
+	MBC2                 Type = 0x05
+	MBC2Battery          Type = 0x06
+	ROMRAM               Type = 0x08
+	ROMRAMBattery        Type = 0x09
+	MBC3TimerBattery     Type = 0x0f
+	MBC3TimerRAMBattery  Type = 0x10
+	MBC3                 Type = 0x11
+	MBC3RAM              Type = 0x12
+	MBC3RAMBattery       Type = 0x13
+	MBC5                 Type = 0x19
+	MBC5RAM              Type = 0x1a
+	MBC5RAMBattery       Type = 0x1b
+	MBC5Rumble           Type = 0x1c
+	MBC5RumbleRAM        Type = 0x1d
+	MBC5RumbleRAMBattery Type = 0x1e
+	PocketCamera         Type = 0xfc
+	BandaiTAMA5          Type = 0xfd
+	HuC3                 Type = 0xfe
+	HuC1RAMBattery       Type = 0xff
+)
+
+type mbcKind uint8
+
+const (
+	mbcUnsupported mbcKind = iota
+	mbcNone
+	mbc1
+	mbc2
+	mbc3
+	mbc5
+)
+
+// Hardware features of a cartridge type.
+const (
+	featRAM = 1 << iota
+	featBattery
+	featTimer
+	featRumble
+)
+
+var types = map[Type]struct {
+	name  string
+	mbc   mbcKind
+	feats uint8
+}{
+	ROMOnly:              {"ROM", mbcNone, 0},
+	MBC1:                 {"MBC1", mbc1, 0},
+	MBC1RAM:              {"MBC1+RAM", mbc1, featRAM},
+	MBC1RAMBattery:       {"MBC1+RAM+BATTERY", mbc1, featRAM | featBattery},
+	MBC2:                 {"MBC2", mbc2, featRAM},
+	MBC2Battery:          {"MBC2+BATTERY", mbc2, featRAM | featBattery},
+	ROMRAM:               {"ROM+RAM", mbcNone, featRAM},
+	ROMRAMBattery:        {"ROM+RAM+BATTERY", mbcNone, featRAM | featBattery},
+	MBC3TimerBattery:     {"MBC3+TIMER+BATTERY", mbc3, featTimer | featBattery},
+	MBC3TimerRAMBattery:  {"MBC3+TIMER+RAM+BATTERY", mbc3, featTimer | featRAM | featBattery},
+	MBC3:                 {"MBC3", mbc3, 0},
+	MBC3RAM:              {"MBC3+RAM", mbc3, featRAM},
+	MBC3RAMBattery:       {"MBC3+RAM+BATTERY", mbc3, featRAM | featBattery},
+	MBC5:                 {"MBC5", mbc5, 0},
+	MBC5RAM:              {"MBC5+RAM", mbc5, featRAM},
+	MBC5RAMBattery:       {"MBC5+RAM+BATTERY", mbc5, featRAM | featBattery},
+	MBC5Rumble:           {"MBC5+RUMBLE", mbc5, featRumble},
+	MBC5RumbleRAM:        {"MBC5+RUMBLE+RAM", mbc5, featRumble | featRAM},
+	MBC5RumbleRAMBattery: {"MBC5+RUMBLE+RAM+BATTERY", mbc5, featRumble | featRAM | featBattery},
+	PocketCamera:         {"POCKET CAMERA", mbcUnsupported, featRAM | featBattery},
+	BandaiTAMA5:          {"BANDAI TAMA5", mbcUnsupported, 0},
+	HuC3:                 {"HuC3", mbcUnsupported, 0},
+	HuC1RAMBattery:       {"HuC1+RAM+BATTERY", mbcUnsupported, featRAM | featBattery},
+}
+
+func (t Type) String() string {
+	if v, ok := types[t]; ok {
+		return v.name
+	}
+	return fmt.Sprintf("unknown (%#02x)", byte(t))
+}
+
+func (t Type) mbc() mbcKind { return types[t].mbc }
+
+// Supported reports whether the memory bank controller of this cartridge type
+// is implemented by this package.
+func (t Type) Supported() bool { return t.mbc() > mbcUnsupported }
+
+// HasRAM reports whether the cartridge has external RAM.
+func (t Type) HasRAM() bool { return types[t].feats&featRAM != 0 }
+
+// HasBattery reports whether the cartridge's RAM or timer is battery buffered.
+func (t Type) HasBattery() bool { return types[t].feats&featBattery != 0 }
+
+// HasTimer reports whether the cartridge has a real time clock.
+func (t Type) HasTimer() bool { return types[t].feats&featTimer != 0 }
+
+// HasRumble reports whether the cartridge has a rumble motor.
+func (t Type) HasRumble() bool { return types[t].feats&featRumble != 0 }
+
+// Header holds the information stored in the cartridge header at 0x0100 to
+// 0x014f.
+type Header struct {
+	Title          string // Upper case ASCII, up to 16 characters
+	CGB            byte   // Game Boy Color flag, 0x80 or 0xc0 if CGB is supported
+	Licensee       string // Two character licensee code or hex value of the old code
+	SGB            bool   // Super Game Boy functions supported
+	Type           Type
+	ROMSize        int64 // ROM size in bytes
+	RAMSize        int64 // External RAM size in bytes, including MBC2's builtin RAM
+	Japanese       bool  // Destination code is Japan
+	Version        byte
+	Checksum       byte // Header checksum over 0x0134 to 0x014c
+	GlobalChecksum uint16
+}
+
+var ramSizes = [...]int64{0, 2 << 10, 8 << 10, 32 << 10, 128 << 10, 64 << 10}
+
+func parseHeader(h *[headerEnd - headerStart]byte) (hdr Header, err error) {
+	at := func(off int) byte { return h[off-headerStart] }
+
+	var csum byte
+	for off := offTitle; off < offHeaderChecksum; off++ {
+		csum = csum - at(off) - 1
+	}
+	if csum != at(offHeaderChecksum) {
+		return hdr, ErrChecksum
+	}
+
+	title := h[offTitle-headerStart : offCGB-headerStart+1]
+	hdr.CGB = at(offCGB)
+	if hdr.CGB&0x80 != 0 {
+		title = title[:len(title)-1]
+	}
+	if i := bytes.IndexByte(title, 0); i >= 0 {
+		title = title[:i]
+	}
+	hdr.Title = string(title)
+
+	if old := at(offOldLicensee); old == 0x33 {
+		hdr.Licensee = string(h[offNewLicensee-headerStart : offSGB-headerStart])
+	} else {
+		hdr.Licensee = fmt.Sprintf("%02X", old)
+	}
+
+	hdr.SGB = at(offSGB) == 0x03
+	hdr.Type = Type(at(offType))
+	hdr.Japanese = at(offDestination) == 0x00
+	hdr.Version = at(offVersion)
+	hdr.Checksum = at(offHeaderChecksum)
+	hdr.GlobalChecksum = uint16(at(offGlobalChecksum))<<8 | uint16(at(offGlobalChecksum+1))
+
+	romSize := at(offROMSize)
+	if romSize > 8 {
+		return hdr, ErrUnsupported
+	}
+	hdr.ROMSize = (32 << 10) << romSize
+
+	switch ramSize := at(offRAMSize); {
+	case hdr.Type.mbc() == mbc2:
+		hdr.RAMSize = mbc2RAMSize
+	case !hdr.Type.HasRAM():
+		hdr.RAMSize = 0
+	case int(ramSize) < len(ramSizes):
+		hdr.RAMSize = ramSizes[ramSize]
+	default:
+		return hdr, ErrUnsupported
+	}
+
+	return hdr, nil
+}
@@ -0,0 +1,107 @@
+package gbcart
+
+const (
+	romBankSize = 0x4000
+	ramBankSize = 0x2000
+	ramBase     = 0xa000
+
+	mbc2RAMSize = 512
+)
+
+// MBC register addresses. Each register is mirrored in the address range up to
+// the next register.
+const (
+	regRAMEnable   = 0x0000
+	regROMBank     = 0x2000
+	regROMBankHigh = 0x3000 // MBC5 only
+	regRAMBank     = 0x4000 // Also selects RTC registers and upper ROM bank bits
+	regMode        = 0x6000 // MBC1 banking mode, MBC3 clock latch
+
+	mbc2ROMBank = 0x2100 // Address bit 8 selects ROM bank register on MBC2
+)
+
+const (
+	ramEnable  = 0x0a
+	ramDisable = 0x00
+)
+
+func (c *Cartridge) writeReg(addr int64, v byte) error {
+	if w, ok := c.bus.(RegisterWriter); ok {
+		return w.WriteReg(uint16(addr), v)
+	}
+	c.reg[0] = v
+	_, err := c.bus.WriteAt(c.reg[:], addr)
+	return err
+}
+
+// mapROM makes the ROM bank visible on the bus and returns the address it was
+// mapped to.
+func (c *Cartridge) mapROM(bank int) (base int64, err error) {
+	if bank == 0 && c.hdr.Type.mbc() != mbc1 {
+		return 0x0000, nil
+	}
+
+	switch c.hdr.Type.mbc() {
+	case mbcNone:
+		return romBankSize, nil
+	case mbc1:
+		// Banks 0x00, 0x20, 0x40 and 0x60 can't be mapped to 0x4000, but
+		// appear at 0x0000 in advanced banking mode.
+		if err = c.writeReg(regRAMBank, byte(bank>>5)&0x3); err != nil {
+			return
+		}
+		if bank&0x1f == 0 {
+			return 0x0000, c.writeReg(regMode, 0x1)
+		}
+		return romBankSize, c.writeReg(regROMBank, byte(bank)&0x1f)
+	case mbc2:
+		return romBankSize, c.writeReg(mbc2ROMBank, byte(bank)&0xf)
+	case mbc3:
+		return romBankSize, c.writeReg(regROMBank, byte(bank))
+	case mbc5:
+		if err = c.writeReg(regROMBankHigh, byte(bank>>8)&0x1); err != nil {
+			return
+		}
+		return romBankSize, c.writeReg(regROMBank, byte(bank))
+	}
+	return 0, ErrUnsupported
+}
+
+// mapRAM makes the RAM bank visible on the bus and returns the address it was
+// mapped to.
+func (c *Cartridge) mapRAM(bank int) (base int64, err error) {
+	// The RTC registers share the bank register with the RAM, so it must
+	// be written even if there is only a single bank.
+	if c.hdr.RAMSize <= ramBankSize && !c.hdr.Type.HasTimer() {
+		return ramBase, nil
+	}
+
+	switch c.hdr.Type.mbc() {
+	case mbc1:
+		if err = c.writeReg(regMode, 0x1); err != nil {
+			return
+		}
+		return ramBase, c.writeReg(regRAMBank, byte(bank)&0x3)
+	case mbc3:
+		return ramBase, c.writeReg(regRAMBank, byte(bank)&0x7)
+	case mbc5:
+		mask := byte(0xf)
+		if c.hdr.Type.HasRumble() {
+			mask = 0x7 // bit 3 controls the rumble motor
+		}
+		return ramBase, c.writeReg(regRAMBank, byte(bank)&mask)
+	}
+	return 0, ErrUnsupported
+}
+
+// enableRAM enables or disables access to the external RAM and the RTC.
+func (c *Cartridge) enableRAM(on bool) error {
+	if c.hdr.Type.mbc() == mbcNone {
+		return nil
+	}
+	v := byte(ramDisable)
+	if on {
+		v = ramEnable
+	}
+	return c.writeReg(regRAMEnable, v)
+}
@@ -0,0 +1,130 @@
+package gbcart
+
+import "time"
+
+// MBC3 real time clock registers, selected via regRAMBank.
+const (
+	rtcSeconds = 0x08 + iota
+	rtcMinutes
+	rtcHours
+	rtcDaysLow
+	rtcDaysHigh
+	rtcRegCount = iota
+)
+
+const (
+	rtcDayMSB = 0x01
+	rtcHalt   = 0x40
+	rtcCarry  = 0x80
+)
+
+// Clock holds the state of the MBC3 real time clock.
+type Clock struct {
+	Seconds uint8
+	Minutes uint8
+	Hours   uint8
+	Days    uint16 // 9-bit day counter
+	Halt    bool   // Clock is stopped
+	Carry   bool   // Day counter overflowed
+}
+
+// Duration returns the time elapsed on the clock, ignoring the carry.
+func (clk Clock) Duration() time.Duration {
+	return time.Duration(clk.Days)*24*time.Hour +
+		time.Duration(clk.Hours)*time.Hour +
+		time.Duration(clk.Minutes)*time.Minute +
+		time.Duration(clk.Seconds)*time.Second
+}
+
+// Clock latches and returns the current state of the cartridge's real time
+// clock. Returns [ErrNoClock] if the cartridge has no timer.
+func (c *Cartridge) Clock() (clk Clock, err error) {
+	if !c.hdr.Type.HasTimer() {
+		return clk, ErrNoClock
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if err = c.enableRAM(true); err != nil {
+		return
+	}
+	defer func() {
+		if errDisable := c.enableRAM(false); err == nil {
+			err = errDisable
+		}
+	}()
+
+	if err = c.writeReg(regMode, 0x0); err != nil {
+		return
+	}
+	if err = c.writeReg(regMode, 0x1); err != nil {
+		return
+	}
+
+	var regs [rtcRegCount]byte
+	for i := range regs {
+		if err = c.writeReg(regRAMBank, byte(rtcSeconds+i)); err != nil {
+			return
+		}
+		if _, err = c.bus.ReadAt(regs[i:i+1], ramBase); err != nil {
+			return
+		}
+	}
+
+	clk.Seconds = regs[rtcSeconds-rtcSeconds] & 0x3f
+	clk.Minutes = regs[rtcMinutes-rtcSeconds] & 0x3f
+	clk.Hours = regs[rtcHours-rtcSeconds] & 0x1f
+	dh := regs[rtcDaysHigh-rtcSeconds]
+	clk.Days = uint16(dh&rtcDayMSB)<<8 | uint16(regs[rtcDaysLow-rtcSeconds])
+	clk.Halt = dh&rtcHalt != 0
+	clk.Carry = dh&rtcCarry != 0
+	return
+}
+
+// SetClock sets the cartridge's real time clock. Returns [ErrNoClock] if the
+// cartridge has no timer.
+func (c *Cartridge) SetClock(clk Clock) (err error) {
+	if !c.hdr.Type.HasTimer() {
+		return ErrNoClock
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if err = c.enableRAM(true); err != nil {
+		return
+	}
+	defer func() {
+		if errDisable := c.enableRAM(false); err == nil {
+			err = errDisable
+		}
+	}()
+
+	dh := byte(clk.Days>>8) & rtcDayMSB
+	if clk.Halt {
+		dh |= rtcHalt
+	}
+	if clk.Carry {
+		dh |= rtcCarry
+	}
+
+	// Halt the clock while writing to prevent ticks between register writes.
+	regs := [...]struct{ reg, val byte }{
+		{rtcDaysHigh, dh | rtcHalt},
+		{rtcSeconds, clk.Seconds},
+		{rtcMinutes, clk.Minutes},
+		{rtcHours, clk.Hours},
+		{rtcDaysLow, byte(clk.Days)},
+		{rtcDaysHigh, dh},
+	}
+	for _, r := range regs {
+		if err = c.writeReg(regRAMBank, r.reg); err != nil {
+			return
+		}
+		if err = c.writeReg(ramBase, r.val); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -1,12 +1,16 @@
 package controller_test
 
 import (
+	"bytes"
+	"image"
+	"io"
 	"io/fs"
 	"testing"
 	"time"
 
 	"github.com/clktmr/n64/drivers/controller"
 	"github.com/clktmr/n64/drivers/controller/pakfs"
+	"github.com/clktmr/n64/drivers/controller/recording"
 	"github.com/clktmr/n64/rcp/serial/joybus"
 	n64testing "github.com/clktmr/n64/testing"
 )
@@ -62,8 +66,21 @@ func TestControllerState(t *testing.T) {
 							}
 							time.Sleep(500 * time.Millisecond)
 						}
+						pak.Play(controller.Sequence(
+							controller.Ramp(1, 0, 2*time.Second),
+							controller.Pause(500*time.Millisecond),
+							controller.Constant(0.5, time.Second),
+						))
 					case *controller.TransferPak:
 						t.Log(i, "transfer pak detected")
+						cart, err := pak.Cartridge()
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						hdr := cart.Header()
+						t.Logf("%d %q %v ROM: %d RAM: %d", i, hdr.Title,
+							hdr.Type, hdr.ROMSize, hdr.RAMSize)
 					default:
 						t.Log(i, "no pak type detected")
 					}
@@ -84,6 +101,153 @@ func TestControllerState(t *testing.T) {
 			if gamepad.DX() != 0 || gamepad.DY() != 0 {
 				t.Log(i, "X: ", gamepad.X(), "Y:", gamepad.Y())
 			}
+			if gamepad.GameCube() {
+				controller.SetGCRumble(uint8(i), gamepad.GCDown()&joybus.GCButtonX != 0)
+			}
+			if gamepad.GameCube() && gamepad.GCPressed() != 0 {
+				cx, cy := gamepad.CStick()
+				l, r := gamepad.Triggers()
+				t.Log(i, "gamecube pressed:", gamepad.GCPressed(), "C:", cx, cy, "L:", l, "R:", r)
+				const reset = joybus.GCButtonX | joybus.GCButtonY | joybus.GCButtonStart
+				if gamepad.GCDown()&reset == reset {
+					return
+				}
+			}
+		}
+	}
+}
+
+func TestBackgroundPolling(t *testing.T) {
+	var polled, latest [4]controller.Controller
+	controller.Poll(&polled)
+
+	controller.StartPolling()
+	defer controller.StopPolling()
+
+	time.Sleep(100 * time.Millisecond)
+	if !controller.Latest(&latest) {
+		t.Fatal("no background poll result")
+	}
+
+	// Poll must still work while polling in background
+	controller.Poll(&polled)
+	for i := range latest {
+		if latest[i].Present() != polled[i].Present() {
+			t.Errorf("port %d: present %v, expected %v", i,
+				latest[i].Present(), polled[i].Present())
+		}
+	}
+}
+
+func TestMouse(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+
+	t.Log("Connect a mouse to port 1 and press both buttons to end the test.")
+
+	var controllers [4]controller.Controller
+	mouse := controller.NewMouse(image.Rect(0, 0, 320, 240))
+	for {
+		controller.Poll(&controllers)
+		mouse.Update(&controllers[0])
+		if !mouse.Present() {
+			continue
+		}
+		if dx, dy := mouse.Delta(); dx != 0 || dy != 0 {
+			t.Log("pointer", mouse.Pos())
+		}
+		if pressed := mouse.Pressed(); pressed != 0 {
+			t.Log("pressed", pressed)
+		}
+		if mouse.Down() == controller.MouseLeft|controller.MouseRight {
+			break
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var buf bytes.Buffer
+	w := recording.NewWriter(&buf)
+	pad := recording.State{Device: joybus.Controller, Flags: 0x02}
+	frames := []recording.Frame{
+		{Number: 0, States: [4]recording.State{pad}},
+		{Number: 3, States: [4]recording.State{{Device: joybus.Controller, Flags: 0x02, Buttons: joybus.ButtonA}}},
+		{Number: 4, States: [4]recording.State{pad}},
+	}
+	for i := range frames {
+		if err := w.WriteFrame(&frames[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.End(5); err != nil {
+		t.Fatal(err)
+	}
+
+	var controllers [4]controller.Controller
+	player := controller.NewPlayer(&buf)
+	var pressed []int
+	for frame := 0; ; frame++ {
+		err := player.Poll(&controllers)
+		if err == io.EOF {
+			if frame != 6 {
+				t.Errorf("expected 6 frames, got %d", frame)
+			}
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if frame == 0 && !controllers[0].Plugged() {
+			t.Error("expected controller to be plugged")
+		}
+		if controllers[0].Pressed()&joybus.ButtonA != 0 {
+			pressed = append(pressed, frame)
+		}
+	}
+	if len(pressed) != 1 || pressed[0] != 3 {
+		t.Errorf("expected A pressed in frame 3, got %v", pressed)
+	}
+}
+
+func TestManager(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+
+	t.Log("Insert and remove paks, press L+R+Start to end the test.")
+
+	var controllers [4]controller.Controller
+	manager := controller.NewManager(16)
+	for {
+		controller.Poll(&controllers)
+		manager.Update(&controllers)
+
+	events:
+		for {
+			select {
+			case ev := <-manager.Events():
+				t.Log(ev.Port, ev.Type, ev.Err)
+				if ev.Type != controller.EventPakInserted || ev.Err != nil {
+					continue
+				}
+				switch pak := ev.Pak.(type) {
+				case *controller.MemPak:
+					fsys, err := manager.FS(ev.Port)
+					if err != nil {
+						t.Error(err)
+						continue
+					}
+					t.Log(ev.Port, "controller pak", fsys.Label(), fsys.Free())
+				case *controller.RumblePak:
+					pak.Play(controller.Constant(0.5, 500*time.Millisecond))
+				}
+			default:
+				break events
+			}
+		}
+
+		if controllers[0].Pressed()&joybus.ButtonReset != 0 {
+			break
 		}
 	}
 }
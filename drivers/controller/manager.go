@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/clktmr/n64/drivers/controller/pakfs"
+)
+
+var (
+	ErrNoPak   = errors.New("no pak inserted")
+	ErrProbing = errors.New("pak is being probed")
+	ErrNoFS    = errors.New("pak has no filesystem")
+)
+
+// EventType describes a change of a port reported by [Manager].
+type EventType int
+
+const (
+	EventPlugged     EventType = iota // Controller was plugged into the port
+	EventUnplugged                    // Controller was unplugged
+	EventPakInserted                  // Pak was inserted and probed
+	EventPakRemoved                   // Pak was removed, its handle is invalid
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPlugged:
+		return "controller plugged"
+	case EventUnplugged:
+		return "controller unplugged"
+	case EventPakInserted:
+		return "pak inserted"
+	case EventPakRemoved:
+		return "pak removed"
+	}
+	return "unknown event"
+}
+
+// Event is emitted by [Manager] on each change of a port.
+type Event struct {
+	Port uint8
+	Type EventType
+
+	// The probed pak and its probing error. Only set for EventPakInserted.
+	Pak io.ReaderAt
+	Err error
+}
+
+// managedPort is the accessory state of a single port.
+type managedPort struct {
+	handle *pakHandle // nil if no pak is inserted
+	pak    io.ReaderAt
+	fs     *pakfs.FS
+	err    error
+}
+
+// Manager tracks the accessories of all four ports. Inserted paks are probed in
+// the background and cached until they are removed, see [Manager.Pak].
+//
+// Handles returned by the Manager are invalidated when the pak is removed from
+// the controller. Accessing them afterwards returns [ErrPakRemoved], even if
+// another pak was inserted in the meantime.
+type Manager struct {
+	mtx    sync.Mutex
+	ports  [4]managedPort
+	events chan Event
+}
+
+// NewManager returns a Manager which buffers up to n events, see
+// [Manager.Events].
+func NewManager(n int) *Manager {
+	return &Manager{events: make(chan Event, n)}
+}
+
+// Events returns the channel on which events are emitted. Events are dropped
+// if the channel's buffer is full.
+func (m *Manager) Events() <-chan Event {
+	return m.events
+}
+
+func (m *Manager) emit(ev Event) {
+	select {
+	case m.events <- ev:
+	default:
+	}
+}
+
+// Update processes the states of all ports, which must be passed after each
+// call to [Poll] or [Latest]. Probing of inserted paks is started in the
+// background, so Update doesn't block on the serial interface.
+func (m *Manager) Update(states *[4]Controller) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for i := range states {
+		c, p, port := &states[i], &m.ports[i], uint8(i)
+
+		if c.Plugged() {
+			m.emit(Event{Port: port, Type: EventPlugged})
+		}
+		if p.handle != nil && (c.PakRemoved() || c.Unplugged() || c.PakInserted()) {
+			p.handle.removed.Store(true)
+			m.ports[i] = managedPort{}
+			m.emit(Event{Port: port, Type: EventPakRemoved})
+		}
+		if c.PakInserted() {
+			p.handle = &pakHandle{}
+			p.err = ErrProbing
+			go m.probe(port, p.handle)
+		}
+		if c.Unplugged() {
+			m.emit(Event{Port: port, Type: EventUnplugged})
+		}
+	}
+}
+
+func (m *Manager) probe(port uint8, handle *pakHandle) {
+	pak := newPak(port)
+	pak.handle = handle
+	acc, fsys, err := probePak(pak)
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	p := &m.ports[port]
+	if p.handle != handle {
+		return // removed while probing
+	}
+	p.pak, p.fs, p.err = acc, fsys, err
+	m.emit(Event{Port: port, Type: EventPakInserted, Pak: acc, Err: err})
+}
+
+// Pak returns the pak inserted in the controller at port. It's one of
+// [*MemPak], [*RumblePak], [*TransferPak] or a generic [*Pak]. Returns
+// [ErrNoPak] if no pak is inserted and [ErrProbing] if the pak's type isn't
+// known yet.
+func (m *Manager) Pak(port uint8) (io.ReaderAt, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	p := &m.ports[port]
+	if p.handle == nil {
+		return nil, ErrNoPak
+	}
+	return p.pak, p.err
+}
+
+// FS returns the filesystem of the Controller Pak inserted in the controller
+// at port. It's read once after insertion and shared by all callers. Returns
+// [ErrNoFS] if the pak isn't a Controller Pak or its filesystem is damaged.
+func (m *Manager) FS(port uint8) (*pakfs.FS, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	p := &m.ports[port]
+	if p.handle == nil {
+		return nil, ErrNoPak
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.fs == nil {
+		return nil, ErrNoFS
+	}
+	return p.fs, nil
+}
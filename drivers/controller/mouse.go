@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"image"
+
+	"github.com/clktmr/n64/rcp/serial/joybus"
+	"github.com/clktmr/n64/rcp/video"
+)
+
+// Buttons of the N64 Mouse as reported by [Mouse.Down].
+const (
+	MouseLeft  = joybus.ButtonA
+	MouseRight = joybus.ButtonB
+)
+
+// Mouse tracks the pointer of an N64 Mouse.
+//
+// The mouse responds to the same state command as a controller, but reports
+// the movement since the last poll instead of an absolute stick position.
+// Mouse accumulates these movements into a position on the screen.
+type Mouse struct {
+	// Bounds limits the pointer position. If empty, the bounds of
+	// [video.Framebuffer] are used.
+	Bounds image.Rectangle
+
+	pos        image.Point
+	dx, dy     int
+	down, last joybus.ButtonMask
+	present    bool
+	placed     bool
+}
+
+// NewMouse returns a mouse with its pointer limited to bounds. The pointer
+// starts in the center of its bounds.
+func NewMouse(bounds image.Rectangle) *Mouse {
+	return &Mouse{Bounds: bounds}
+}
+
+// Update moves the pointer by the movement reported in c, which must be the
+// state of the mouse's port after a call to [Poll] or [Latest]. If no mouse is
+// connected to the port, all buttons are released and the pointer stays
+// where it is.
+func (m *Mouse) Update(c *Controller) {
+	m.last = m.down
+	m.present = c.Device() == joybus.Mouse && c.err == nil
+	if !m.present {
+		m.down, m.dx, m.dy = 0, 0, 0
+		return
+	}
+
+	bounds := m.bounds()
+	if !m.placed {
+		m.SetPos(bounds.Min.Add(bounds.Size().Div(2)))
+	}
+
+	// Mouse reports upward movement as positive y.
+	m.down = c.current.down & (MouseLeft | MouseRight)
+	m.dx, m.dy = int(c.current.xAxis), -int(c.current.yAxis)
+	m.pos = clamp(m.pos.Add(image.Pt(m.dx, m.dy)), bounds)
+}
+
+func (m *Mouse) bounds() image.Rectangle {
+	if !m.Bounds.Empty() {
+		return m.Bounds
+	}
+	if fb := video.Framebuffer(); fb != nil {
+		return fb.Bounds()
+	}
+	return image.Rectangle{}
+}
+
+// clamp returns the point inside r which is closest to p.
+func clamp(p image.Point, r image.Rectangle) image.Point {
+	if r.Empty() {
+		return p
+	}
+	p.X = min(max(p.X, r.Min.X), r.Max.X-1)
+	p.Y = min(max(p.Y, r.Min.Y), r.Max.Y-1)
+	return p
+}
+
+// Present reports whether a mouse was connected during the last call to
+// [Mouse.Update].
+func (m *Mouse) Present() bool {
+	return m.present
+}
+
+// Pos returns the pointer position.
+func (m *Mouse) Pos() image.Point {
+	return m.pos
+}
+
+// SetPos moves the pointer to p, clamped to the mouse's bounds.
+func (m *Mouse) SetPos(p image.Point) {
+	m.pos = clamp(p, m.bounds())
+	m.placed = true
+}
+
+// Delta returns the movement reported by the last call to [Mouse.Update], in
+// screen orientation, i.e. positive dy is downwards. The movement isn't
+// limited by the mouse's bounds.
+func (m *Mouse) Delta() (dx, dy int) {
+	return m.dx, m.dy
+}
+
+// Down reports which buttons were pressed during the last call to
+// [Mouse.Update].
+func (m *Mouse) Down() joybus.ButtonMask {
+	return m.down
+}
+
+// Pressed reports which buttons were pressed between the last two calls to
+// [Mouse.Update].
+func (m *Mouse) Pressed() joybus.ButtonMask {
+	return m.down &^ m.last
+}
+
+// Released reports which buttons were released between the last two calls to
+// [Mouse.Update].
+func (m *Mouse) Released() joybus.ButtonMask {
+	return m.last &^ m.down
+}
@@ -1,18 +1,17 @@
 package controller
 
 import (
+	"errors"
 	"io"
+	"sync/atomic"
 
 	"github.com/clktmr/n64/debug"
 	"github.com/clktmr/n64/drivers/controller/pakfs"
-	"github.com/clktmr/n64/rcp/serial"
-	"github.com/clktmr/n64/rcp/serial/joybus"
+	"github.com/clktmr/n64/drivers/controller/pakio"
 )
 
-const pakSize = 1 << 16 // whole addressable space
-
 const (
-	blockSize = 32 // Number of bytes in a single read/write joybus command
+	blockSize = pakio.BlockSize
 	blockMask = blockSize - 1
 )
 
@@ -34,125 +33,66 @@ const (
 	probePowerOff = 0xfe // Special value powers off the pak, if supported by the pak
 )
 
+// ErrPakRemoved is returned when accessing a pak obtained from a [Manager]
+// after it was removed from the controller.
+var ErrPakRemoved = errors.New("pak removed")
+
+// pakHandle is shared by all copies of a Pak and marks it as removed.
+type pakHandle struct {
+	removed atomic.Bool
+}
+
 // Pak represents a generic pak implementing [io.ReaderAt] and [io.WriterAt].
 type Pak struct {
 	port   uint8
-	offset uint16
-
-	readCmdBlock  serial.CommandBlock
-	writeCmdBlock serial.CommandBlock
-	readCmd       joybus.ReadPakCommand
-	writeCmd      joybus.WritePakCommand
+	handle *pakHandle // nil if not managed by a Manager
+	dev    *pakio.Pak
 }
 
-func newPak(port uint8) (pak *Pak) {
-	pak = &Pak{
-		port:          port,
-		readCmdBlock:  *serial.NewCommandBlock(serial.CmdConfigureJoybus),
-		writeCmdBlock: *serial.NewCommandBlock(serial.CmdConfigureJoybus),
-	}
-
-	var err error
-	for range pak.port {
-		err = joybus.ControlByte(&pak.readCmdBlock, joybus.CtrlSkip)
-		debug.AssertErrNil(err)
-	}
-	pak.readCmd, err = joybus.NewReadPakCommand(&pak.readCmdBlock)
-	err = joybus.ControlByte(&pak.readCmdBlock, joybus.CtrlAbort)
-	debug.AssertErrNil(err)
-
-	for range pak.port {
-		err = joybus.ControlByte(&pak.writeCmdBlock, joybus.CtrlSkip)
-		debug.AssertErrNil(err)
-	}
-	pak.writeCmd, err = joybus.NewWritePakCommand(&pak.writeCmdBlock)
-	err = joybus.ControlByte(&pak.writeCmdBlock, joybus.CtrlAbort)
+func newPak(port uint8) *Pak {
+	p, err := pakio.New(bus, port)
 	debug.AssertErrNil(err)
+	return &Pak{port: port, dev: p}
+}
 
-	return
+// removed reports whether the pak is known to be removed.
+func (pak *Pak) removed() bool {
+	return pak.handle != nil && pak.handle.removed.Load()
 }
 
 func (pak *Pak) ReadAt(p []byte, off int64) (n int, err error) {
-	startOffset := off & blockMask
-
-	for n < len(p) {
-		pak.readCmd.Reset()
-		pak.readCmd.SetAddress(uint16(off))
-		serial.Run(&pak.readCmdBlock)
-
-		var rx []byte
-		rx, err = pak.readCmd.Data()
-		copied := copy(p[n:], rx[startOffset:])
-		n += copied
-		startOffset = 0 // reset, only for first iteration needed
-		if err != nil {
-			return
-		}
-
-		off += int64(copied)
-		if off >= pakSize {
-			return n, io.EOF
-		}
+	if pak.removed() {
+		return 0, ErrPakRemoved
 	}
-
-	return
+	return pak.dev.ReadAt(p, off)
 }
 
 func (pak *Pak) WriteAt(p []byte, off int64) (n int, err error) {
-	var tmp [blockSize]byte
-
-	startOffset := off & blockMask
-
-	for n < len(p) {
-		// read first and last blocks if only partly written
-		if startOffset != 0 || len(p[n:]) < blockSize {
-			_, err = pak.ReadAt(tmp[:], off&^blockMask)
-			if err != nil {
-				return
-			}
-		}
-
-		copied := copy(tmp[startOffset:], p[n:])
-		startOffset = 0 // reset, only for first iteration needed
-
-		pak.writeCmd.Reset()
-		err = pak.writeCmd.SetData(tmp[:])
-		if err != nil {
-			return
-		}
-
-		pak.writeCmd.SetAddress(uint16(off))
-
-		serial.Run(&pak.writeCmdBlock)
-
-		err = pak.writeCmd.Result()
-		if err != nil {
-			return
-		}
-
-		n += copied
-
-		off += int64(copied)
-		if off >= pakSize {
-			return n, io.EOF
-		}
+	if pak.removed() {
+		return 0, ErrPakRemoved
 	}
-
-	return
+	return pak.dev.WriteAt(p, off)
 }
 
 // ProbePak tries to identify the paks type and returns a [MemPak], [RumblePak]
 // or [TransferPak] respectively. If no type could be determined, a generic
 // [Pak] is returned.
 func ProbePak(port uint8) (io.ReaderAt, error) {
+	pak, _, err := probePak(newPak(port))
+	return pak, err
+}
+
+// probePak identifies the pak's type. If it's a [MemPak] the filesystem is
+// returned as well.
+func probePak(pak *Pak) (io.ReaderAt, *pakfs.FS, error) {
 	var err error
-	pak := newPak(port)
 
 	// Controller Pak is special as it does use pakProbe for SRAM bank
 	// selection. Probe by looking for a filesystem instead.
-	_, errFS := pakfs.Read(pak)
+	mem := &MemPak{*pak}
+	fsys, errFS := pakfs.Read(mem)
 	if errFS == nil {
-		return &MemPak{*pak}, nil
+		return mem, fsys, nil
 	}
 
 	data := [1]byte{}
@@ -169,24 +109,25 @@ func ProbePak(port uint8) (io.ReaderAt, error) {
 		data[0] = t.probeVal
 		_, err = pak.WriteAt(data[:], pakProbe)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		_, err = pak.ReadAt(data[:], pakProbe)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		if data[0] == t.probeVal {
 			if t.ctor == nil {
 				break
 			}
-			return t.ctor(pak)
+			acc, err := t.ctor(pak)
+			return acc, nil, err
 		}
 	}
 
 	// No type detected, return generic Pak.
-	return pak, nil
+	return pak, nil, nil
 }
 
 // MemPak represents a Controller Pak with a [pakfs.FS] filesystem.
@@ -228,13 +169,3 @@ func (pak *RumblePak) Set(on bool) error {
 func (pak *RumblePak) Toggle() error {
 	return pak.Set(!pak.on)
 }
-
-// TransferPak represents a Transfer Pak providing read and write access to an
-// Game Boy cartridge.
-type TransferPak struct {
-	Pak
-}
-
-func newTransferPak(pak *Pak) (io.ReaderAt, error) {
-	return &TransferPak{*pak}, nil
-}
@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"bytes"
+	_ "embed"
+	"io"
+	"testing"
+	"testing/fstest"
+
+	"github.com/clktmr/n64/drivers/controller/pakfs"
+	"github.com/clktmr/n64/rcp/serial"
+	"github.com/clktmr/n64/rcp/serial/joybus"
+	"github.com/clktmr/n64/rcp/serial/joybus/joybustest"
+)
+
+//go:embed pakfs/testdata/clktmr.mpk
+var mpkImage []byte
+
+// simulate executes all command blocks on pif instead of the console's PIF
+// until the test has finished.
+func simulate(t *testing.T, pif *joybustest.PIF) {
+	bus = pif
+	t.Cleanup(func() { bus = serial.Joybus })
+}
+
+func TestSimulatedPoll(t *testing.T) {
+	ctrl := &joybustest.Controller{}
+	ctrl.Set(joybus.ButtonB|joybus.ButtonStart, 30, -100)
+	simulate(t, &joybustest.PIF{Ports: [4]joybustest.Device{nil, nil, ctrl}})
+
+	var states [4]Controller
+	Poll(&states)
+	for i, c := range states {
+		if c.Present() != (i == 2) {
+			t.Fatalf("port %d: unexpected presence %v", i, c.Present())
+		}
+	}
+	c := &states[2]
+	if c.Down() != joybus.ButtonB|joybus.ButtonStart || c.X() != 30 || c.Y() != -100 {
+		t.Errorf("unexpected state %v %d %d", c.Down(), c.X(), c.Y())
+	}
+	if c.PakInserted() {
+		t.Error("unexpected pak")
+	}
+
+	ctrl.Insert(&joybustest.RumblePak{})
+	Poll(&states)
+	if !states[2].PakInserted() {
+		t.Error("pak not detected")
+	}
+}
+
+func TestSimulatedMemPak(t *testing.T) {
+	img := joybustest.NewMemPak(mpkImage)
+	ctrl := &joybustest.Controller{}
+	ctrl.Insert(img)
+	simulate(t, &joybustest.PIF{Ports: [4]joybustest.Device{nil, ctrl}})
+
+	pak, err := ProbePak(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem, ok := pak.(*MemPak)
+	if !ok {
+		t.Fatalf("expected *MemPak, got %T", pak)
+	}
+
+	pfs, err := pakfs.Read(mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fstest.TestFS(pfs, "PERFECT ", "PERFECT DARK", "V82, \"METIN\""); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := pfs.Create("SIMULATED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("written through the simulated PIF")
+	if _, err = f.WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(img.Data, data) {
+		t.Fatal("data not written to pak image")
+	}
+
+	pfs, err = pakfs.Read(bytes.NewReader(img.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := pfs.Open("SIMULATED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(data))
+	if _, err = io.ReadFull(r, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("expected %q, got %q", data, got)
+	}
+}
+
+func TestSimulatedRumblePak(t *testing.T) {
+	rumble := &joybustest.RumblePak{}
+	ctrl := &joybustest.Controller{}
+	ctrl.Insert(rumble)
+	pif := &joybustest.PIF{Ports: [4]joybustest.Device{ctrl}}
+	simulate(t, pif)
+
+	pak, err := ProbePak(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp, ok := pak.(*RumblePak)
+	if !ok {
+		t.Fatalf("expected *RumblePak, got %T", pak)
+	}
+	if err = rp.Set(true); err != nil {
+		t.Fatal(err)
+	}
+	if !rumble.On {
+		t.Error("motor not switched on")
+	}
+	if err = rp.Toggle(); err != nil {
+		t.Fatal(err)
+	}
+	if rumble.On {
+		t.Error("motor not switched off")
+	}
+
+	pif.Inject(0, joybustest.BadChecksum)
+	if err = rp.Set(true); err == nil {
+		t.Error("expected error on corrupted checksum")
+	}
+}
@@ -0,0 +1,148 @@
+// Package pakio implements reading and writing the address space of a pak
+// inserted into a controller.
+//
+// The joybus commands are executed by a [Transport], which is
+// [github.com/clktmr/n64/rcp/serial.Joybus] on the console. On the host,
+// [github.com/clktmr/n64/rcp/serial/joybus/joybustest.PIF] simulates the
+// controllers and their paks.
+package pakio
+
+import (
+	"io"
+
+	"github.com/clktmr/n64/rcp/serial/joybus"
+)
+
+const (
+	Size      = 1 << 16 // whole addressable space
+	BlockSize = 32      // number of bytes in a single read or write command
+
+	blockMask = BlockSize - 1
+)
+
+// Transport executes joybus command blocks on the PIF.
+type Transport interface {
+	// NewBlock returns an empty command block.
+	NewBlock() joybus.Allocator
+
+	// Run executes a block returned by NewBlock and blocks until the
+	// responses were written back.
+	Run(block joybus.Allocator)
+}
+
+// Pak accesses a pak via [joybus.ReadPakCommand] and
+// [joybus.WritePakCommand]. It implements [io.ReaderAt] and [io.WriterAt].
+type Pak struct {
+	bus Transport
+
+	readBlock  joybus.Allocator
+	writeBlock joybus.Allocator
+	readCmd    joybus.ReadPakCommand
+	writeCmd   joybus.WritePakCommand
+}
+
+// New returns a Pak accessing the pak inserted into the controller at port.
+func New(bus Transport, port uint8) (pak *Pak, err error) {
+	pak = &Pak{
+		bus:        bus,
+		readBlock:  bus.NewBlock(),
+		writeBlock: bus.NewBlock(),
+	}
+
+	for range port {
+		if err = joybus.ControlByte(pak.readBlock, joybus.CtrlSkip); err != nil {
+			return
+		}
+	}
+	if pak.readCmd, err = joybus.NewReadPakCommand(pak.readBlock); err != nil {
+		return
+	}
+	if err = joybus.ControlByte(pak.readBlock, joybus.CtrlAbort); err != nil {
+		return
+	}
+
+	for range port {
+		if err = joybus.ControlByte(pak.writeBlock, joybus.CtrlSkip); err != nil {
+			return
+		}
+	}
+	if pak.writeCmd, err = joybus.NewWritePakCommand(pak.writeBlock); err != nil {
+		return
+	}
+	err = joybus.ControlByte(pak.writeBlock, joybus.CtrlAbort)
+	return
+}
+
+// ReadAt reads len(p) bytes starting at off. It returns [io.EOF] if the end
+// of the address space was reached.
+func (pak *Pak) ReadAt(p []byte, off int64) (n int, err error) {
+	startOffset := off & blockMask
+
+	for n < len(p) {
+		pak.readCmd.Reset()
+		pak.readCmd.SetAddress(uint16(off))
+		pak.bus.Run(pak.readBlock)
+
+		var rx []byte
+		rx, err = pak.readCmd.Data()
+		copied := copy(p[n:], rx[startOffset:])
+		n += copied
+		startOffset = 0 // reset, only for first iteration needed
+		if err != nil {
+			return
+		}
+
+		off += int64(copied)
+		if off >= Size {
+			return n, io.EOF
+		}
+	}
+
+	return
+}
+
+// WriteAt writes len(p) bytes starting at off. Blocks which are only partly
+// written are read first. It returns [io.EOF] if the end of the address space
+// was reached.
+func (pak *Pak) WriteAt(p []byte, off int64) (n int, err error) {
+	var tmp [BlockSize]byte
+
+	startOffset := off & blockMask
+
+	for n < len(p) {
+		// read first and last blocks if only partly written
+		if startOffset != 0 || len(p[n:]) < BlockSize {
+			_, err = pak.ReadAt(tmp[:], off&^blockMask)
+			if err != nil {
+				return
+			}
+		}
+
+		copied := copy(tmp[startOffset:], p[n:])
+		startOffset = 0 // reset, only for first iteration needed
+
+		pak.writeCmd.Reset()
+		err = pak.writeCmd.SetData(tmp[:])
+		if err != nil {
+			return
+		}
+
+		pak.writeCmd.SetAddress(uint16(off))
+
+		pak.bus.Run(pak.writeBlock)
+
+		err = pak.writeCmd.Result()
+		if err != nil {
+			return
+		}
+
+		n += copied
+
+		off += int64(copied)
+		if off >= Size {
+			return n, io.EOF
+		}
+	}
+
+	return
+}
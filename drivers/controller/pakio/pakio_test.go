@@ -0,0 +1,164 @@
+//go:build !n64
+
+package pakio_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"testing"
+	"testing/fstest"
+
+	"github.com/clktmr/n64/drivers/controller/pakfs"
+	"github.com/clktmr/n64/drivers/controller/pakio"
+	"github.com/clktmr/n64/rcp/serial/joybus"
+	"github.com/clktmr/n64/rcp/serial/joybus/joybustest"
+)
+
+func newPak(t *testing.T, port uint8, dev joybustest.Pak) (*pakio.Pak, *joybustest.PIF) {
+	t.Helper()
+	ctrl := &joybustest.Controller{}
+	ctrl.Insert(dev)
+	pif := &joybustest.PIF{}
+	pif.Ports[port] = ctrl
+	pak, err := pakio.New(pif, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pak, pif
+}
+
+func TestReadWrite(t *testing.T) {
+	img := joybustest.NewMemPak(nil)
+	pak, _ := newPak(t, 3, img)
+
+	// Unaligned and spanning multiple blocks
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	if n, err := pak.WriteAt(data, 0x123); err != nil || n != len(data) {
+		t.Fatalf("wrote %d bytes: %v", n, err)
+	}
+	if !bytes.Equal(img.Data[0x123:0x123+len(data)], data) {
+		t.Fatal("data not written to pak image")
+	}
+	if img.Data[0x122] != 0 || img.Data[0x123+len(data)] != 0 {
+		t.Fatal("partly written blocks were modified")
+	}
+
+	got := make([]byte, len(data))
+	if n, err := pak.ReadAt(got, 0x123); err != nil || n != len(got) {
+		t.Fatalf("read %d bytes: %v", n, err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("expected %q, got %q", data, got)
+	}
+
+	if n, err := pak.ReadAt(got, pakio.Size-10); err != io.EOF || n != 10 {
+		t.Errorf("expected 10 bytes and EOF, got %d bytes and %v", n, err)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	pak, pif := newPak(t, 1, joybustest.NewMemPak(nil))
+
+	var buf [pakio.BlockSize]byte
+	for _, tc := range []struct {
+		fault joybustest.Fault
+		err   error
+	}{
+		{joybustest.NoResponse, joybus.ErrPIFNoResponse},
+		{joybustest.InvalidResponse, joybus.ErrPIFInvalidResponse},
+		{joybustest.BadChecksum, joybus.ErrChecksum},
+	} {
+		pif.Inject(1, tc.fault)
+		if _, err := pak.ReadAt(buf[:], 0); err != tc.err {
+			t.Errorf("read: expected %v, got %v", tc.err, err)
+		}
+		pif.Inject(1, tc.fault)
+		if _, err := pak.WriteAt(buf[:], 0); err != tc.err {
+			t.Errorf("write: expected %v, got %v", tc.err, err)
+		}
+	}
+
+	// A partly written block fails on reading it
+	pif.Inject(1, joybustest.BadChecksum)
+	if n, err := pak.WriteAt(buf[:1], 0x40); err != joybus.ErrChecksum || n != 0 {
+		t.Errorf("expected checksum error, wrote %d bytes: %v", n, err)
+	}
+
+	pif.Ports[1].(*joybustest.Controller).Insert(nil)
+	if _, err := pak.ReadAt(buf[:], 0); err != joybus.ErrChecksum {
+		t.Errorf("removed pak: expected %v, got %v", joybus.ErrChecksum, err)
+	}
+}
+
+func TestRumble(t *testing.T) {
+	rumble := &joybustest.RumblePak{}
+	pak, _ := newPak(t, 0, rumble)
+
+	// Probing like the controller package does
+	for _, probe := range []byte{0x01, 0x80} {
+		data := []byte{probe}
+		if _, err := pak.WriteAt(data, 0x801f); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pak.ReadAt(data, 0x801f); err != nil {
+			t.Fatal(err)
+		}
+		if (data[0] == probe) != (probe == 0x80) {
+			t.Errorf("probe %#02x: read back %#02x", probe, data[0])
+		}
+	}
+
+	for _, on := range []byte{1, 0} {
+		if _, err := pak.WriteAt([]byte{on}, 0xc01f); err != nil {
+			t.Fatal(err)
+		}
+		if rumble.On != (on == 1) {
+			t.Errorf("expected motor %v", on == 1)
+		}
+	}
+}
+
+func TestPakfs(t *testing.T) {
+	img, err := os.ReadFile(path.Join("..", "pakfs", "testdata", "clktmr.mpk"))
+	if err != nil {
+		t.Fatal("missing testdata:", err)
+	}
+	mem := joybustest.NewMemPak(img)
+	pak, _ := newPak(t, 2, mem)
+
+	pfs, err := pakfs.Read(pak)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fstest.TestFS(pfs, "PERFECT ", "PERFECT DARK", "V82, \"METIN\""); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := pfs.Create("SIMULATED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("written through the simulated PIF")
+	if _, err = f.WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read the image directly to check the written filesystem
+	pfs, err = pakfs.Read(bytes.NewReader(mem.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := pfs.Open("SIMULATED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(data))
+	if _, err = io.ReadFull(r, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("expected %q, got %q", data, got)
+	}
+}
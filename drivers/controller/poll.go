@@ -1,69 +1,206 @@
 package controller
 
 import (
+	"sync"
+	"sync/atomic"
+
 	"github.com/clktmr/n64/debug"
+	"github.com/clktmr/n64/drivers/controller/pakio"
 	"github.com/clktmr/n64/rcp/serial"
 	"github.com/clktmr/n64/rcp/serial/joybus"
 )
 
-var (
-	cmdAllStates      *serial.CommandBlock
-	cmdAllStatesPorts [4]joybus.ControllerStateCommand
-
-	cmdAllInfo      *serial.CommandBlock
-	cmdAllInfoPorts [4]joybus.InfoCommand
-)
+// layout selects the state command sent to each port.
+type layout struct {
+	gc     [4]bool // port has a GameCube controller
+	rumble [4]bool // GameCube controller's rumble motor is on
+}
 
-func init() {
-	var err error
+// pollCommands holds the info and state commands for all four ports.
+type pollCommands struct {
+	info   [4]joybus.InfoCommand
+	states [4]joybus.ControllerStateCommand
+	gc     [4]joybus.GCStateCommand
+	layout layout
+}
 
-	cmdAllStates = serial.NewCommandBlock(serial.CmdConfigureJoybus)
-	for i := range cmdAllStatesPorts {
-		cmdAllStatesPorts[i], err = joybus.NewControllerStateCommand(cmdAllStates)
-		debug.AssertErrNil(err)
+func (c *pollCommands) init(info, states joybus.Allocator, l layout) (err error) {
+	for i := range c.info {
+		if c.info[i], err = joybus.NewInfoCommand(info); err != nil {
+			return
+		}
 	}
-	err = joybus.ControlByte(cmdAllStates, joybus.CtrlAbort)
-	debug.AssertErrNil(err)
+	if err = joybus.ControlByte(info, joybus.CtrlAbort); err != nil {
+		return
+	}
+	return c.initStates(states, l)
+}
 
-	cmdAllInfo = serial.NewCommandBlock(serial.CmdConfigureJoybus)
-	for i := range cmdAllInfoPorts {
-		cmdAllInfoPorts[i], err = joybus.NewInfoCommand(cmdAllInfo)
-		debug.AssertErrNil(err)
+func (c *pollCommands) initStates(states joybus.Allocator, l layout) (err error) {
+	c.layout = l
+	c.states = [4]joybus.ControllerStateCommand{}
+	c.gc = [4]joybus.GCStateCommand{}
+	for i := range c.states {
+		if l.gc[i] {
+			if c.gc[i], err = joybus.NewGCStateCommand(states); err != nil {
+				return
+			}
+			c.gc[i].SetRumble(l.rumble[i])
+		} else {
+			if c.states[i], err = joybus.NewControllerStateCommand(states); err != nil {
+				return
+			}
+		}
 	}
-	err = joybus.ControlByte(cmdAllInfo, joybus.CtrlAbort)
-	debug.AssertErrNil(err)
+	return joybus.ControlByte(states, joybus.CtrlAbort)
 }
 
-// Updates the state of all four controllers and stores them in states. Blocks
-// until all states were received.
-func Poll(states *[4]Controller) {
-	// poll info
-	for _, cmd := range cmdAllInfoPorts {
+func (c *pollCommands) reset() {
+	for _, cmd := range c.info {
 		cmd.Reset()
 	}
-	serial.Run(cmdAllInfo)
+	for i := range c.states {
+		if c.layout.gc[i] {
+			c.gc[i].Reset()
+		} else {
+			c.states[i].Reset()
+		}
+	}
+}
 
-	// poll states
-	for _, cmd := range cmdAllStatesPorts {
-		cmd.Reset()
+// setRumble updates the rumble flags of the GameCube state commands in place.
+func (c *pollCommands) setRumble(rumble [4]bool) {
+	c.layout.rumble = rumble
+	for i := range c.gc {
+		if c.layout.gc[i] {
+			c.gc[i].SetRumble(rumble[i])
+		}
 	}
+}
 
-	serial.Run(cmdAllStates)
+// wantLayout returns the layout matching the devices reported by the info
+// commands.
+func (c *pollCommands) wantLayout() (l layout) {
+	for i, cmd := range c.info {
+		dev, _, err := cmd.Info()
+		l.gc[i] = err == nil && dev.GameCube()
+		l.rumble[i] = l.gc[i] && gcRumble[i].Load()
+	}
+	return
+}
 
+// update parses the responses into states.
+func (c *pollCommands) update(states *[4]Controller) {
 	p := states
 	for i := range p {
 		var err error
 
 		p[i].Port.number = uint8(i + 1)
 		p[i].Port.last = p[i].Port.current
-		dev, flags, err := cmdAllInfoPorts[i].Info()
+		dev, flags, err := c.info[i].Info()
 		p[i].Port.current.device = dev
 		p[i].Port.current.flags = flags
 		p[i].Port.err = err
 
 		p[i].last = p[i].current
 		cur := &p[i].current
-		cur.down, cur.xAxis, cur.yAxis, p[i].err = cmdAllStatesPorts[i].State()
+		if c.layout.gc[i] {
+			p[i].gc.last = p[i].gc.current
+			p[i].gc.current, p[i].err = c.gc[i].State()
+			cur.down, cur.xAxis, cur.yAxis = p[i].gc.mapped()
+		} else {
+			p[i].gc = gcState{}
+			cur.down, cur.xAxis, cur.yAxis, p[i].err = c.states[i].State()
+		}
+
+		rumblers[i].poll(&p[i])
 	}
-	return
+}
+
+// bus executes all command blocks except the background ones. Tests replace it
+// to simulate the connected devices.
+var bus pakio.Transport = serial.Joybus
+
+var (
+	cmdAllInfo   *serial.CommandBlock
+	cmdAllStates *serial.CommandBlock
+	cmdAll       pollCommands
+)
+
+func init() {
+	cmdAllInfo = serial.NewCommandBlock(serial.CmdConfigureJoybus)
+	cmdAllStates = serial.NewCommandBlock(serial.CmdConfigureJoybus)
+	err := cmdAll.init(cmdAllInfo, cmdAllStates, layout{})
+	debug.AssertErrNil(err)
+}
+
+// Updates the state of all four controllers and stores them in states. Blocks
+// until all states were received.
+//
+// GameCube controllers are detected automatically. Their origin is read when
+// they are plugged in or request it, see [Controller.GameCube].
+func Poll(states *[4]Controller) {
+	cmdAll.reset()
+	bus.Run(cmdAllInfo)
+
+	if l := cmdAll.wantLayout(); l.gc != cmdAll.layout.gc {
+		cmdAllStates = serial.NewCommandBlock(serial.CmdConfigureJoybus)
+		err := cmdAll.initStates(cmdAllStates, l)
+		debug.AssertErrNil(err)
+	} else {
+		cmdAll.setRumble(l.rumble)
+	}
+	bus.Run(cmdAllStates)
+
+	cmdAll.update(states)
+
+	for i := range states {
+		c := &states[i]
+		if c.GameCube() && (!c.gc.hasOrigin || c.gc.current.Buttons&joybus.GCNeedOrigin != 0) {
+			c.gc.origin, c.gc.hasOrigin = readGCOrigin(uint8(i))
+		}
+	}
+}
+
+// gcRumble holds the requested state of each GameCube controller's rumble
+// motor.
+var gcRumble [4]atomic.Bool
+
+// SetGCRumble switches the rumble motor of the GameCube controller connected
+// to port on or off. The motor is switched with the next call to [Poll] or,
+// if polling in the background, [Latest].
+func SetGCRumble(port uint8, on bool) {
+	gcRumble[port].Store(on)
+}
+
+var gcOrigin struct {
+	sync.Mutex
+	blocks [4]*serial.CommandBlock
+	cmds   [4]joybus.GCOriginCommand
+}
+
+func readGCOrigin(port uint8) (origin joybus.GCState, ok bool) {
+	gcOrigin.Lock()
+	defer gcOrigin.Unlock()
+
+	block := gcOrigin.blocks[port]
+	if block == nil {
+		block = serial.NewCommandBlock(serial.CmdConfigureJoybus)
+		var err error
+		for range port {
+			err = joybus.ControlByte(block, joybus.CtrlSkip)
+			debug.AssertErrNil(err)
+		}
+		gcOrigin.cmds[port], err = joybus.NewGCOriginCommand(block)
+		debug.AssertErrNil(err)
+		err = joybus.ControlByte(block, joybus.CtrlAbort)
+		debug.AssertErrNil(err)
+		gcOrigin.blocks[port] = block
+	}
+
+	cmd := gcOrigin.cmds[port]
+	cmd.Reset()
+	bus.Run(block)
+	origin, err := cmd.Origin()
+	return origin, err == nil
 }
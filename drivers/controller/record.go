@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"io"
+
+	"github.com/clktmr/n64/drivers/controller/recording"
+)
+
+// Recorder records the controller states of each frame, see
+// [recording.Writer]. Any [io.Writer] can be used as destination, e.g. a file
+// on the SD card, a [pakfs.File] wrapped by [io.OffsetWriter] or an USB
+// connection.
+//
+// GameCube specific state, i.e. C-stick and analog triggers, isn't recorded.
+type Recorder struct {
+	w     *recording.Writer
+	frame recording.Frame
+	count uint32
+}
+
+// NewRecorder returns a Recorder writing to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: recording.NewWriter(w)}
+}
+
+// Poll calls [Poll] and records the states as the next frame.
+func (r *Recorder) Poll(states *[4]Controller) error {
+	Poll(states)
+	return r.Record(states)
+}
+
+// Record records states as the next frame. Use it instead of [Recorder.Poll]
+// if the states are obtained otherwise, e.g. by [Latest].
+func (r *Recorder) Record(states *[4]Controller) error {
+	r.frame.Number = r.count
+	r.count++
+	for i := range states {
+		c := &states[i]
+		r.frame.States[i] = recording.State{
+			Device:  c.Port.current.device,
+			Flags:   c.Port.current.flags,
+			Buttons: c.current.down,
+			X:       c.current.xAxis,
+			Y:       c.current.yAxis,
+		}
+	}
+	return r.w.WriteFrame(&r.frame)
+}
+
+// Close marks the last recorded frame as the end of the recording. It doesn't
+// close the underlying writer.
+func (r *Recorder) Close() error {
+	if r.count == 0 {
+		return nil
+	}
+	return r.w.End(r.count - 1)
+}
+
+// Player replays a recording made by [Recorder]. Its Poll method can be used
+// in place of [Poll], which makes replaying deterministic as long as the game
+// polls once per frame.
+type Player struct {
+	r     *recording.Reader
+	cur   recording.Frame
+	next  recording.Frame
+	err   error
+	count uint32
+	ended bool
+}
+
+// NewPlayer returns a Player reading the recording from r.
+func NewPlayer(r io.Reader) *Player {
+	p := &Player{r: recording.NewReader(r)}
+	p.next, p.err = p.r.ReadFrame()
+	p.ended = p.err == io.EOF // empty recording
+	return p
+}
+
+// Poll updates states to the next recorded frame. It returns [io.EOF] after
+// the last frame of the recording and leaves states unchanged.
+func (p *Player) Poll(states *[4]Controller) error {
+	if p.ended {
+		return io.EOF
+	}
+	for p.err == nil && p.next.Number <= p.count {
+		p.cur = p.next
+		p.next, p.err = p.r.ReadFrame()
+	}
+	if p.err == io.EOF && p.count == p.cur.Number {
+		p.ended = true // last frame
+	} else if p.err != nil {
+		return p.err
+	}
+	p.count++
+
+	for i := range states {
+		c, s := &states[i], &p.cur.States[i]
+		c.Port.number = uint8(i + 1)
+		c.Port.last = c.Port.current
+		c.Port.current.device = s.Device
+		c.Port.current.flags = s.Flags
+		c.Port.err = nil
+
+		c.last = c.current
+		c.current.down = s.Buttons
+		c.current.xAxis, c.current.yAxis = s.X, s.Y
+		c.gc = gcState{}
+		c.err = nil
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+// Package recording implements a compact binary format for recording the
+// states of all four controller ports over time.
+//
+// A recording starts with a header, followed by a record for each frame in
+// which the state of at least one port changed:
+//
+//	header:  "N64I" version
+//	record:  uvarint(frame - previous frame) portmask state...
+//	state:   device(2) flags(1) buttons(2) x(1) y(1)
+//
+// The portmask has bit i set if the state of port i follows. All multibyte
+// values are big endian. Frames without changes aren't stored, which makes
+// recordings small if the input doesn't change on most frames. A record with
+// an empty portmask marks the last frame of the recording.
+package recording
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/clktmr/n64/rcp/serial/joybus"
+)
+
+const (
+	magic   = "N64I"
+	version = 1
+
+	stateSize = 7
+)
+
+var (
+	ErrFormat = errors.New("invalid recording")
+	ErrOrder  = errors.New("frames not in ascending order")
+)
+
+// State is the recorded state of a single joybus port.
+type State struct {
+	Device  joybus.Device
+	Flags   byte // Flags as returned by [joybus.InfoCommand]
+	Buttons joybus.ButtonMask
+	X, Y    int8
+}
+
+func (s *State) put(b []byte) {
+	binary.BigEndian.PutUint16(b[0:], uint16(s.Device))
+	b[2] = s.Flags
+	binary.BigEndian.PutUint16(b[3:], uint16(s.Buttons))
+	b[5], b[6] = byte(s.X), byte(s.Y)
+}
+
+func (s *State) get(b []byte) {
+	s.Device = joybus.Device(binary.BigEndian.Uint16(b[0:]))
+	s.Flags = b[2]
+	s.Buttons = joybus.ButtonMask(binary.BigEndian.Uint16(b[3:]))
+	s.X, s.Y = int8(b[5]), int8(b[6])
+}
+
+// Frame holds the states of all four ports at a given frame number.
+type Frame struct {
+	Number uint32
+	States [4]State
+}
+
+// Writer writes frames to a recording.
+type Writer struct {
+	w       io.Writer
+	last    Frame
+	started bool
+	buf     []byte
+}
+
+// NewWriter returns a Writer writing a recording to w. The header is written
+// with the first frame.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, buf: make([]byte, 0, binary.MaxVarintLen32+1+4*stateSize)}
+}
+
+// WriteFrame appends f to the recording if it differs from the last written
+// frame. Frame numbers must be ascending.
+func (w *Writer) WriteFrame(f *Frame) error {
+	b := w.buf[:0]
+	if !w.started {
+		b = append(b, magic...)
+		b = append(b, version)
+	} else if f.Number <= w.last.Number {
+		return ErrOrder
+	}
+
+	var mask byte
+	for i := range f.States {
+		if !w.started || f.States[i] != w.last.States[i] {
+			mask |= 1 << i
+		}
+	}
+	if mask == 0 {
+		return nil
+	}
+	return w.write(b, f, mask)
+}
+
+// End marks number as the last frame of the recording, which is necessary if
+// the input didn't change during the last frames. It must be called after the
+// last frame was written.
+func (w *Writer) End(number uint32) error {
+	if !w.started || number <= w.last.Number {
+		return nil
+	}
+	f := w.last
+	f.Number = number
+	return w.write(w.buf[:0], &f, 0)
+}
+
+func (w *Writer) write(b []byte, f *Frame, mask byte) error {
+	b = binary.AppendUvarint(b, uint64(f.Number-w.last.Number))
+	b = append(b, mask)
+	for i := range f.States {
+		if mask&(1<<i) != 0 {
+			var s [stateSize]byte
+			f.States[i].put(s[:])
+			b = append(b, s[:]...)
+		}
+	}
+
+	if _, err := w.w.Write(b); err != nil {
+		return err
+	}
+	w.last = *f
+	w.started = true
+	return nil
+}
+
+// Reader reads frames from a recording.
+type Reader struct {
+	r       *bufio.Reader
+	frame   Frame
+	started bool
+}
+
+// NewReader returns a Reader reading a recording from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// ReadFrame returns the next recorded frame. Ports which didn't change keep
+// their state from the previous frame. Returns [io.EOF] at the end of the
+// recording.
+func (r *Reader) ReadFrame() (f Frame, err error) {
+	if !r.started {
+		var hdr [len(magic) + 1]byte
+		if _, err = io.ReadFull(r.r, hdr[:]); err != nil {
+			if err != io.EOF {
+				err = ErrFormat
+			}
+			return
+		}
+		if string(hdr[:len(magic)]) != magic {
+			return f, ErrFormat
+		}
+		if hdr[len(magic)] != version {
+			return f, fmt.Errorf("%w: unsupported version %d", ErrFormat, hdr[len(magic)])
+		}
+		r.started = true
+	}
+
+	delta, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		if err != io.EOF {
+			err = ErrFormat
+		}
+		return
+	}
+	mask, err := r.r.ReadByte()
+	if err != nil || mask&0xf0 != 0 {
+		return f, ErrFormat
+	}
+	r.frame.Number += uint32(delta)
+	for i := range r.frame.States {
+		if mask&(1<<i) != 0 {
+			var s [stateSize]byte
+			if _, err = io.ReadFull(r.r, s[:]); err != nil {
+				return f, ErrFormat
+			}
+			r.frame.States[i].get(s[:])
+		}
+	}
+	return r.frame, nil
+}
@@ -0,0 +1,154 @@
+//go:build !n64
+
+package recording
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/clktmr/n64/rcp/serial/joybus"
+)
+
+var pad = State{Device: joybus.Controller, Flags: 0x02}
+
+func testFrames() []Frame {
+	var frames []Frame
+	f := Frame{States: [4]State{pad}}
+	for n := range uint32(300) {
+		f.Number = n
+		switch n {
+		case 180:
+			f.States[0].Buttons = joybus.ButtonA | joybus.ButtonStart
+		case 185:
+			f.States[0].Buttons = 0
+			f.States[0].X, f.States[0].Y = -12, 80
+		case 200:
+			f.States[2] = pad
+			f.States[2].Flags = 0x01
+		}
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	frames := testFrames()
+	for i := range frames {
+		if err := w.WriteFrame(&frames[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.End(299); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() > 80 {
+		t.Errorf("recording too large: %d bytes", buf.Len())
+	}
+	if err := w.WriteFrame(&frames[0]); err != ErrOrder {
+		t.Errorf("expected %v, got %v", ErrOrder, err)
+	}
+
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	var got []Frame
+	for {
+		f, err := r.ReadFrame()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, f)
+	}
+	want := []Frame{frames[0], frames[180], frames[185], frames[200], frames[299]}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d frames, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("frame %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+
+	for _, data := range [][]byte{
+		[]byte("N64X\x01"),
+		[]byte("N64I\x02"),
+		buf.Bytes()[:buf.Len()-1],
+	} {
+		r = NewReader(bytes.NewReader(data))
+		var err error
+		for err == nil {
+			_, err = r.ReadFrame()
+		}
+		if !errors.Is(err, ErrFormat) {
+			t.Errorf("% x: expected %v, got %v", data, ErrFormat, err)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	var bin bytes.Buffer
+	w := NewWriter(&bin)
+	frames := testFrames()
+	for i := range frames {
+		if err := w.WriteFrame(&frames[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.End(299); err != nil {
+		t.Fatal(err)
+	}
+
+	var text strings.Builder
+	if err := Dump(&text, bytes.NewReader(bin.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "180 0 0500 02 A+Start 0 0\n") ||
+		!strings.HasSuffix(text.String(), "299 end\n") {
+		t.Errorf("unexpected dump:\n%s", text.String())
+	}
+
+	var compiled bytes.Buffer
+	if err := Compile(&compiled, strings.NewReader(text.String())); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(compiled.Bytes(), bin.Bytes()) {
+		t.Errorf("expected % x, got % x", bin.Bytes(), compiled.Bytes())
+	}
+
+	// Edit by adding a button press.
+	edited := strings.Replace(text.String(), "299 end", "250 0 0500 02 B+C▲ 0 0 # jump", 1)
+	compiled.Reset()
+	if err := Compile(&compiled, strings.NewReader(edited)); err != nil {
+		t.Fatal(err)
+	}
+	r := NewReader(&compiled)
+	var f Frame
+	for {
+		next, err := r.ReadFrame()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		f = next
+	}
+	if f.Number != 250 || f.States[0].Buttons != joybus.ButtonB|joybus.ButtonCUp || f.States[2].Flags != 0x01 {
+		t.Errorf("unexpected last frame %+v", f)
+	}
+
+	for _, bad := range []string{
+		"10 0 0500 02 - 0",
+		"10 4 0500 02 - 0 0",
+		"10 0 0500 02 A+X 0 0",
+		"10 0 0500 02 - 0 0\n5 0 0500 02 A 0 0",
+	} {
+		if err := Compile(io.Discard, strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
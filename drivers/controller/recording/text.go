@@ -0,0 +1,162 @@
+package recording
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/clktmr/n64/rcp/serial/joybus"
+)
+
+// The text format has a line for each changed port of a frame:
+//
+//	<frame> <port> <device> <flags> <buttons> <x> <y>
+//
+// Device and flags are hexadecimal, buttons are joined by '+' or '-' if none
+// is pressed. The last frame is marked by a line containing only the frame
+// number followed by "end". Everything after '#' is a comment.
+const textHeader = "# frame port device flags buttons x y\n"
+
+// Dump writes the recording read from src as text to dst.
+func Dump(dst io.Writer, src io.Reader) error {
+	r := NewReader(src)
+	w := bufio.NewWriter(dst)
+	w.WriteString(textHeader)
+
+	var last Frame
+	for first := true; ; first = false {
+		f, err := r.ReadFrame()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		changed := false
+		for i, s := range f.States {
+			if first || s != last.States[i] {
+				changed = true
+				fmt.Fprintf(w, "%d %d %04x %02x %s %d %d\n", f.Number, i,
+					uint16(s.Device), s.Flags, formatButtons(s.Buttons), s.X, s.Y)
+			}
+		}
+		if !changed {
+			fmt.Fprintf(w, "%d end\n", f.Number)
+		}
+		last = f
+	}
+	return w.Flush()
+}
+
+// Compile reads a recording in text format from src and writes it to dst.
+// Lines must be sorted by frame number. Ports not mentioned in a frame keep
+// their state from the previous frame.
+func Compile(dst io.Writer, src io.Reader) error {
+	w := NewWriter(dst)
+	var f Frame
+	pending := false
+
+	scanner := bufio.NewScanner(src)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if pending && fields[0] != strconv.FormatUint(uint64(f.Number), 10) {
+			if err := w.WriteFrame(&f); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			pending = false
+		}
+		if len(fields) == 2 && fields[1] == "end" {
+			number, err := strconv.ParseUint(fields[0], 10, 32)
+			if err == nil {
+				err = w.End(uint32(number))
+			}
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			continue
+		}
+		number, port, s, err := parseLine(fields)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		f.Number = number
+		f.States[port] = s
+		pending = true
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if pending {
+		return w.WriteFrame(&f)
+	}
+	return nil
+}
+
+func parseLine(fields []string) (number uint32, port int, s State, err error) {
+	if len(fields) != 7 {
+		err = fmt.Errorf("expected 7 fields, got %d", len(fields))
+		return
+	}
+	n, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return
+	}
+	number = uint32(n)
+	p, err := strconv.ParseUint(fields[1], 10, 2)
+	if err != nil {
+		return
+	}
+	port = int(p)
+	dev, err := strconv.ParseUint(fields[2], 16, 16)
+	if err != nil {
+		return
+	}
+	s.Device = joybus.Device(dev)
+	flags, err := strconv.ParseUint(fields[3], 16, 8)
+	if err != nil {
+		return
+	}
+	s.Flags = byte(flags)
+	if s.Buttons, err = parseButtons(fields[4]); err != nil {
+		return
+	}
+	x, err := strconv.ParseInt(fields[5], 10, 8)
+	if err != nil {
+		return
+	}
+	y, err := strconv.ParseInt(fields[6], 10, 8)
+	if err != nil {
+		return
+	}
+	s.X, s.Y = int8(x), int8(y)
+	return
+}
+
+func formatButtons(b joybus.ButtonMask) string {
+	if b == 0 {
+		return "-"
+	}
+	return strings.ReplaceAll(b.String(), " + ", "+")
+}
+
+func parseButtons(s string) (b joybus.ButtonMask, err error) {
+	if s == "-" {
+		return 0, nil
+	}
+next:
+	for name := range strings.SplitSeq(s, "+") {
+		for i := range 16 {
+			if mask := joybus.ButtonMask(1 << i); mask.String() == name {
+				b |= mask
+				continue next
+			}
+		}
+		return 0, fmt.Errorf("unknown button %q", name)
+	}
+	return
+}
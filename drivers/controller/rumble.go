@@ -0,0 +1,224 @@
+package controller
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/clktmr/n64/rcp"
+)
+
+// Step is a segment of a rumble [Effect]. The intensity changes linearly from
+// From to To over the step's Duration. Intensities range from 0 (off) to 1
+// (full strength).
+type Step struct {
+	Duration time.Duration
+	From, To float32
+}
+
+// Effect is a sequence of steps which can be played by [RumblePak.Play].
+//
+// The Rumble Pak's motor can only be switched on or off. Intensities between 0
+// and 1 are achieved by pulse-width modulation, where each call to [Poll]
+// advances the effect and switches the motor if needed. Hence the resolution
+// of an effect is limited by the rate at which [Poll] is called.
+type Effect []Step
+
+// Constant returns an effect with constant intensity for duration d.
+func Constant(intensity float32, d time.Duration) Effect {
+	return Effect{{d, intensity, intensity}}
+}
+
+// Ramp returns an effect which changes intensity linearly from from to to over
+// duration d.
+func Ramp(from, to float32, d time.Duration) Effect {
+	return Effect{{d, from, to}}
+}
+
+// Pause returns an effect which turns the motor off for duration d.
+func Pause(d time.Duration) Effect {
+	return Constant(0, d)
+}
+
+// Sequence returns an effect playing all effects one after another.
+func Sequence(effects ...Effect) (e Effect) {
+	for _, v := range effects {
+		e = append(e, v...)
+	}
+	return
+}
+
+// Repeat returns an effect playing e n times.
+func (e Effect) Repeat(n int) (r Effect) {
+	r = make(Effect, 0, len(e)*n)
+	for range n {
+		r = append(r, e...)
+	}
+	return
+}
+
+// Duration returns the total duration of the effect.
+func (e Effect) Duration() (d time.Duration) {
+	for _, step := range e {
+		d += step.Duration
+	}
+	return
+}
+
+// intensity returns the effect's intensity at time t. Returns false if t is
+// past the end of the effect.
+func (e Effect) intensity(t time.Duration) (float32, bool) {
+	for _, step := range e {
+		if t < step.Duration {
+			pos := float32(t) / float32(step.Duration)
+			return step.From + (step.To-step.From)*pos, true
+		}
+		t -= step.Duration
+	}
+	return 0, false
+}
+
+// rumbler plays effects on a single port.
+type rumbler struct {
+	mtx sync.Mutex
+	cur *playback
+}
+
+// playback is a single effect played by a rumbler.
+type playback struct {
+	tick      chan struct{}
+	cancel    chan struct{}
+	done      chan struct{}
+	cancelled bool        // guarded by rumbler.mtx
+	gone      atomic.Bool // pak was removed, don't access it anymore
+}
+
+var rumblers [4]rumbler
+
+// Play starts playing the effect in the background, replacing any effect
+// which is currently playing on the same port. The effect is stopped
+// automatically if the pak is removed or the console's reset button is
+// pressed.
+//
+// Effects only advance while [Poll] is called regularly, usually once per
+// frame. Calling [RumblePak.Set] or [RumblePak.Toggle] while an effect is
+// playing has undefined results.
+func (pak *RumblePak) Play(e Effect) {
+	r := &rumblers[pak.port]
+	r.stop()
+
+	p := &playback{
+		tick:   make(chan struct{}, 1),
+		cancel: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	r.mtx.Lock()
+	r.cur = p
+	r.mtx.Unlock()
+
+	go p.run(pak, e)
+}
+
+// Stop cancels the currently playing effect and turns the motor off. It blocks
+// until the motor was turned off.
+func (pak *RumblePak) Stop() {
+	rumblers[pak.port].stop()
+}
+
+// Playing reports whether an effect is currently playing.
+func (pak *RumblePak) Playing() bool {
+	r := &rumblers[pak.port]
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.cur == nil {
+		return false
+	}
+	select {
+	case <-r.cur.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (r *rumbler) stop() {
+	r.mtx.Lock()
+	p := r.cur
+	r.cur = nil
+	if p != nil && !p.cancelled {
+		p.cancelled = true
+		close(p.cancel)
+	}
+	r.mtx.Unlock()
+
+	if p != nil {
+		<-p.done
+	}
+}
+
+// poll advances the currently playing effect. Must be called with the port's
+// state after it was updated by [Poll].
+func (r *rumbler) poll(c *Controller) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	p := r.cur
+	if p == nil || p.cancelled {
+		return
+	}
+
+	if c.PakRemoved() || c.Unplugged() {
+		p.gone.Store(true)
+		p.cancelled = true
+		close(p.cancel)
+		return
+	}
+
+	select {
+	case p.tick <- struct{}{}:
+	default: // previous tick not yet consumed
+	}
+}
+
+func (p *playback) run(pak *RumblePak, e Effect) {
+	defer close(p.done)
+	defer func() {
+		if !p.gone.Load() {
+			pak.Set(false)
+		}
+	}()
+
+	start := time.Now()
+	var acc float32
+	for {
+		select {
+		case <-p.cancel:
+			return
+		case <-p.tick:
+		}
+
+		if rcp.ResetPending() {
+			return
+		}
+
+		intensity, ok := e.intensity(time.Since(start))
+		if !ok {
+			return
+		}
+
+		// Sigma-delta modulation of the intensity.
+		acc += min(max(intensity, 0), 1)
+		on := acc >= 0.5
+		if on {
+			acc -= 1
+		}
+
+		if on != pak.on {
+			if err := pak.Set(on); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"errors"
+	"io"
+
+	"github.com/clktmr/n64/drivers/controller/gbcart"
+)
+
+// Errors returned by the Transfer Pak.
+var (
+	ErrNoCartridge = errors.New("no cartridge inserted")
+	ErrNoAccess    = errors.New("cartridge access not enabled")
+)
+
+// Transfer Pak registers. Each register occupies a 4 KiB area of the pak's
+// address space.
+const (
+	tpakPower  = 0x8000 // Write probeTransfer to power on, probePowerOff to power off
+	tpakBank   = 0xa000 // Selects which 16 KiB of the cartridge are mapped to tpakWindow
+	tpakStatus = 0xb000 // Write 1 to enable cartridge access, reads TransferPakStatus
+
+	tpakWindow     = 0xc000
+	tpakWindowBits = 14
+	tpakWindowSize = 1 << tpakWindowBits
+	tpakWindowMask = tpakWindowSize - 1
+)
+
+// TransferPakStatus is the status register of the Transfer Pak.
+type TransferPakStatus byte
+
+const (
+	TransferPakAccess    TransferPakStatus = 0x01 // Cartridge access is enabled
+	TransferPakWasReset  TransferPakStatus = 0x04 // Cartridge was reset since last status read
+	TransferPakResetting TransferPakStatus = 0x08 // Cartridge is held in reset
+	TransferPakRemoved   TransferPakStatus = 0x40 // No cartridge inserted
+	TransferPakPowered   TransferPakStatus = 0x80 // Cartridge is powered
+)
+
+// TransferPak represents a Transfer Pak providing read and write access to an
+// Game Boy cartridge.
+//
+// The Transfer Pak maps 16 KiB of the cartridge's address space into the pak's
+// address space at a time. Use [TransferPak.Cartridge] to access the ROM and
+// save RAM of the cartridge without dealing with the banking.
+type TransferPak struct {
+	Pak
+	bank int // currently mapped 16 KiB window, -1 if unknown
+}
+
+func newTransferPak(pak *Pak) (io.ReaderAt, error) {
+	return &TransferPak{*pak, -1}, nil
+}
+
+// writeReg fills a whole block at addr with v. Writing a single byte would
+// read-modify-write the block, which isn't possible for registers.
+func (pak *TransferPak) writeReg(addr uint16, v byte) error {
+	var data [blockSize]byte
+	for i := range data {
+		data[i] = v
+	}
+	_, err := pak.Pak.WriteAt(data[:], int64(addr)&^blockMask)
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// SetPower powers the cartridge on or off.
+func (pak *TransferPak) SetPower(on bool) error {
+	pak.bank = -1
+	v := byte(probePowerOff)
+	if on {
+		v = probeTransfer
+	}
+	return pak.writeReg(tpakPower, v)
+}
+
+// SetAccess enables or disables access to the cartridge. Access must be
+// enabled before reading or writing the cartridge.
+func (pak *TransferPak) SetAccess(on bool) error {
+	var v byte
+	if on {
+		v = byte(TransferPakAccess)
+	}
+	return pak.writeReg(tpakStatus, v)
+}
+
+// Status reads the Transfer Pak's status register.
+func (pak *TransferPak) Status() (TransferPakStatus, error) {
+	var data [1]byte
+	_, err := pak.Pak.ReadAt(data[:], tpakStatus)
+	return TransferPakStatus(data[0]), err
+}
+
+// Cartridge powers the cartridge, enables access to it and returns a
+// [gbcart.Cartridge] for reading and writing its ROM and save RAM.
+func (pak *TransferPak) Cartridge() (*gbcart.Cartridge, error) {
+	if err := pak.SetPower(true); err != nil {
+		return nil, err
+	}
+	if err := pak.SetAccess(true); err != nil {
+		return nil, err
+	}
+
+	status, err := pak.Status()
+	if err != nil {
+		return nil, err
+	}
+	if status&TransferPakRemoved != 0 {
+		return nil, ErrNoCartridge
+	}
+	if status&TransferPakAccess == 0 {
+		return nil, ErrNoAccess
+	}
+
+	return gbcart.Open(&cartBus{pak})
+}
+
+func (pak *TransferPak) setBank(bank int) error {
+	if bank == pak.bank {
+		return nil
+	}
+	if err := pak.writeReg(tpakBank, byte(bank)); err != nil {
+		pak.bank = -1
+		return err
+	}
+	pak.bank = bank
+	return nil
+}
+
+// cartBus implements [gbcart.Bus] by mapping the cartridge's address space
+// through the Transfer Pak's window.
+type cartBus struct {
+	pak *TransferPak
+}
+
+func (b *cartBus) ReadAt(p []byte, off int64) (n int, err error) {
+	return b.access(p, off, b.pak.Pak.ReadAt)
+}
+
+func (b *cartBus) WriteAt(p []byte, off int64) (n int, err error) {
+	return b.access(p, off, b.pak.Pak.WriteAt)
+}
+
+func (b *cartBus) WriteReg(addr uint16, v byte) error {
+	if err := b.pak.setBank(int(addr >> tpakWindowBits)); err != nil {
+		return err
+	}
+	return b.pak.writeReg(tpakWindow+addr&tpakWindowMask, v)
+}
+
+func (b *cartBus) access(p []byte, off int64, fn func([]byte, int64) (int, error)) (n int, err error) {
+	if off < 0 || off+int64(len(p)) > 1<<16 {
+		return 0, io.EOF
+	}
+
+	for n < len(p) {
+		if err = b.pak.setBank(int(off >> tpakWindowBits)); err != nil {
+			return
+		}
+
+		windowOff := off & tpakWindowMask
+		chunk := p[n:min(len(p), n+int(tpakWindowSize-windowOff))]
+		var copied int
+		copied, err = fn(chunk, tpakWindow+windowOff)
+		n += copied
+		off += int64(copied)
+		if err == io.EOF && copied == len(chunk) {
+			err = nil // end of pak address space, but not of the window
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
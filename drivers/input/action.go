@@ -0,0 +1,171 @@
+package input
+
+import "github.com/clktmr/n64/rcp/serial/joybus"
+
+// DefaultStickThreshold is the normalized stick deflection at which a stick
+// direction becomes active.
+const DefaultStickThreshold = 0.5
+
+// Player tracks the logical actions of a single player, as mapped by its
+// [Profile].
+//
+// If several bindings are active at the same time, a binding is suppressed
+// while another active binding contains all of its inputs. E.g. if "jump" is
+// bound to A and "dive" to Z+A, pressing Z+A only triggers "dive".
+type Player struct {
+	Profile *Profile
+	Stick   Stick
+
+	// StickThreshold is the normalized deflection at which a stick
+	// direction becomes active.
+	StickThreshold float32
+
+	active, prev map[string]bool
+}
+
+// NewPlayer returns a player using profile p, [DefaultStick] and
+// [DefaultStickThreshold].
+func NewPlayer(p *Profile) *Player {
+	return &Player{
+		Profile:        p,
+		Stick:          DefaultStick,
+		StickThreshold: DefaultStickThreshold,
+	}
+}
+
+// Update evaluates all actions for the new gamepad state. A nil pad
+// deactivates all actions, e.g. if the controller was unplugged.
+func (pl *Player) Update(pad Gamepad) {
+	if pl.active == nil {
+		pl.active, pl.prev = make(map[string]bool), make(map[string]bool)
+	}
+	pl.prev, pl.active = pl.active, pl.prev
+	clear(pl.active)
+	if pad == nil || pl.Profile == nil {
+		return
+	}
+
+	cur := Binding{Buttons: pad.Down(), Stick: direction(pad, &pl.Stick, pl.StickThreshold)}
+
+	// Collect all active bindings for chord suppression.
+	var triggered []Binding
+	for _, bindings := range pl.Profile.actions {
+		for _, b := range bindings {
+			if cur.contains(b) {
+				triggered = append(triggered, b)
+			}
+		}
+	}
+
+	for action, bindings := range pl.Profile.actions {
+		for _, b := range bindings {
+			if cur.contains(b) && !suppressed(b, triggered) {
+				pl.active[action] = true
+				break
+			}
+		}
+	}
+}
+
+// direction returns the stick directions of pad with a deflection of at least
+// t.
+func direction(pad Gamepad, s *Stick, t float32) (d Direction) {
+	x, y := s.Normalize(pad.X(), pad.Y())
+	switch {
+	case y >= t:
+		d |= StickUp
+	case y <= -t:
+		d |= StickDown
+	}
+	switch {
+	case x >= t:
+		d |= StickRight
+	case x <= -t:
+		d |= StickLeft
+	}
+	return
+}
+
+// suppressed reports whether another binding in triggered is a superset of b.
+func suppressed(b Binding, triggered []Binding) bool {
+	for _, o := range triggered {
+		if o != b && o.contains(b) {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether the action was active during the last call to
+// [Player.Update].
+func (pl *Player) Active(action string) bool {
+	return pl.active[action]
+}
+
+// Pressed reports whether the action became active during the last call to
+// [Player.Update].
+func (pl *Player) Pressed(action string) bool {
+	return pl.active[action] && !pl.prev[action]
+}
+
+// Released reports whether the action became inactive during the last call to
+// [Player.Update].
+func (pl *Player) Released(action string) bool {
+	return !pl.active[action] && pl.prev[action]
+}
+
+// Capture records a binding from a gamepad, e.g. to let players remap their
+// controls. All inputs which were active at the same time are combined into a
+// chord, which is returned once all of them are released.
+//
+// Capturing only starts after all inputs were released, so the button which
+// was used to start remapping isn't recorded.
+type Capture struct {
+	Stick          Stick
+	StickThreshold float32
+
+	armed bool
+	chord Binding
+}
+
+// NewCapture returns a Capture using [DefaultStick] and
+// [DefaultStickThreshold].
+func NewCapture() *Capture {
+	return &Capture{Stick: DefaultStick, StickThreshold: DefaultStickThreshold}
+}
+
+// Reset discards the inputs recorded so far.
+func (c *Capture) Reset() {
+	c.armed = false
+	c.chord = Binding{}
+}
+
+// Update records the new gamepad state. Returns the captured binding and true
+// once a chord was completed.
+func (c *Capture) Update(pad Gamepad) (b Binding, ok bool) {
+	var cur Binding
+	if pad != nil {
+		cur.Buttons = pad.Down() & bindableButtons
+		cur.Stick = direction(pad, &c.Stick, c.StickThreshold)
+	}
+
+	if !c.armed {
+		c.armed = cur.Zero()
+		return
+	}
+
+	c.chord.Buttons |= cur.Buttons
+	c.chord.Stick |= cur.Stick
+	if cur.Zero() && !c.chord.Zero() {
+		b, ok = c.chord, true
+		c.chord = Binding{}
+	}
+	return
+}
+
+var bindableButtons = func() (mask joybus.ButtonMask) {
+	for _, v := range buttonNames {
+		mask |= v.button
+	}
+	return
+}()
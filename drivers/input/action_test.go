@@ -0,0 +1,216 @@
+//go:build !n64
+
+package input
+
+import (
+	"io"
+	"os"
+	"path"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/clktmr/n64/drivers/controller/pakfs"
+	"github.com/clktmr/n64/rcp/serial/joybus"
+)
+
+const testProfile = `
+# platformer controls
+jump = A
+dive = Z+A
+crouch = Z
+camera_left = CLeft, R+StickLeft
+walk_up = stickup
+`
+
+func TestParseBinding(t *testing.T) {
+	tests := map[string]Binding{
+		"A":              {Buttons: joybus.ButtonA},
+		"z + a":          {Buttons: joybus.ButtonZ | joybus.ButtonA},
+		"R+StickLeft":    {Buttons: joybus.ButtonR, Stick: StickLeft},
+		"StickUp+DRight": {Buttons: joybus.ButtonDRight, Stick: StickUp},
+		"":               {},
+	}
+	for s, want := range tests {
+		got, err := ParseBinding(s)
+		if err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+		if got != want {
+			t.Errorf("%q: expected %v, got %v", s, want, got)
+		}
+		if again, _ := ParseBinding(got.String()); again != got {
+			t.Errorf("%q: String doesn't round-trip: %q", s, got.String())
+		}
+	}
+
+	for _, s := range []string{"X", "A+", "Reset"} {
+		if _, err := ParseBinding(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestProfileText(t *testing.T) {
+	p := NewProfile()
+	if err := p.UnmarshalText([]byte(testProfile)); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"camera_left", "crouch", "dive", "jump", "walk_up"}
+	if got := p.Actions(); !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if got := p.Bindings("camera_left"); len(got) != 2 || got[1] != (Binding{joybus.ButtonR, StickLeft}) {
+		t.Fatalf("unexpected bindings %v", got)
+	}
+
+	text, err := p.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := NewProfile()
+	if err = q.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range p.Actions() {
+		if !slices.Equal(p.Bindings(action), q.Bindings(action)) {
+			t.Errorf("%s: mismatch after round-trip", action)
+		}
+	}
+
+	for _, s := range []string{"jump A", "jump = X", "ju mp = A"} {
+		if err := q.UnmarshalText([]byte(s)); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	fsys := fstest.MapFS{"controls.txt": {Data: []byte(testProfile)}}
+	p, err := LoadProfile(fsys, "controls.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Bind("jump", Buttons(joybus.ButtonB))
+
+	// Store in a controller pak and load it back.
+	data, err := os.ReadFile(path.Join("..", "controller", "pakfs", "testdata", "clktmr.mpk"))
+	if err != nil {
+		t.Fatal("missing testdata:", err)
+	}
+	pfs, err := pakfs.Read(&memDev{data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Store(pfs, "CONTROLS"); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := LoadProfile(pfs, "CONTROLS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := q.Bindings("jump"); !slices.Equal(got, []Binding{Buttons(joybus.ButtonB)}) {
+		t.Fatalf("expected rebound jump, got %v", got)
+	}
+	if !slices.Equal(q.Actions(), p.Actions()) {
+		t.Fatalf("expected %v, got %v", p.Actions(), q.Actions())
+	}
+
+	// Overwrite with a shorter profile
+	short := NewProfile()
+	short.Bind("jump", Buttons(joybus.ButtonA))
+	if err = short.Store(pfs, "CONTROLS"); err != nil {
+		t.Fatal(err)
+	}
+	q, err = LoadProfile(pfs, "CONTROLS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(q.Actions(), []string{"jump"}) {
+		t.Fatalf("expected only jump, got %v", q.Actions())
+	}
+}
+
+type memDev struct{ data []byte }
+
+func (m *memDev) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	return copy(p, m.data[off:]), nil
+}
+
+func (m *memDev) WriteAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > int64(len(m.data)) {
+		return 0, io.ErrShortWrite
+	}
+	return copy(m.data[off:], p), nil
+}
+
+func TestPlayer(t *testing.T) {
+	p := NewProfile()
+	if err := p.UnmarshalText([]byte(testProfile)); err != nil {
+		t.Fatal(err)
+	}
+	pl := NewPlayer(p)
+
+	steps := []struct {
+		down    joybus.ButtonMask
+		x, y    int8
+		active  []string
+		pressed []string
+	}{
+		{0, 0, 0, nil, nil},
+		{joybus.ButtonA, 0, 0, []string{"jump"}, []string{"jump"}},
+		{joybus.ButtonA, 0, 0, []string{"jump"}, nil},
+		{joybus.ButtonZ | joybus.ButtonA, 0, 0, []string{"dive"}, []string{"dive"}},
+		{joybus.ButtonZ, 0, 0, []string{"crouch"}, []string{"crouch"}},
+		{joybus.ButtonR, -70, 0, []string{"camera_left"}, []string{"camera_left"}},
+		{joybus.ButtonR, -10, 75, []string{"walk_up"}, []string{"walk_up"}},
+		{joybus.ButtonCLeft, 0, 75, []string{"camera_left", "walk_up"}, []string{"camera_left"}},
+	}
+	for i, step := range steps {
+		pl.Update(&pad{step.down, step.x, step.y})
+		for _, action := range p.Actions() {
+			if got, want := pl.Active(action), slices.Contains(step.active, action); got != want {
+				t.Errorf("step %d: %s: expected active %v, got %v", i, action, want, got)
+			}
+			if got, want := pl.Pressed(action), slices.Contains(step.pressed, action); got != want {
+				t.Errorf("step %d: %s: expected pressed %v, got %v", i, action, want, got)
+			}
+		}
+	}
+
+	pl.Update(nil)
+	if pl.Active("camera_left") || !pl.Released("camera_left") || !pl.Released("walk_up") {
+		t.Error("expected all actions released without gamepad")
+	}
+}
+
+func TestCapture(t *testing.T) {
+	c := NewCapture()
+	steps := []struct {
+		down joybus.ButtonMask
+		x    int8
+		ok   bool
+	}{
+		{joybus.ButtonA, 0, false}, // still held from menu selection
+		{0, 0, false},
+		{joybus.ButtonR, 0, false},
+		{joybus.ButtonR, 70, false},
+		{0, 70, false},
+		{0, 0, true},
+	}
+	var b Binding
+	var ok bool
+	for i, step := range steps {
+		b, ok = c.Update(&pad{step.down, step.x, 0})
+		if ok != step.ok {
+			t.Fatalf("step %d: expected %v, got %v", i, step.ok, ok)
+		}
+	}
+	if want := (Binding{joybus.ButtonR, StickRight}); b != want {
+		t.Fatalf("expected %v, got %v", want, b)
+	}
+}
@@ -0,0 +1,227 @@
+// Package input turns raw gamepad states into button events and normalized
+// analog stick values. A [Profile] additionally maps logical actions to
+// rebindable buttons and stick directions.
+//
+// It builds upon the states returned by
+// [github.com/clktmr/n64/drivers/controller.Poll] or
+// [github.com/clktmr/n64/drivers/controller.Latest], but accepts any
+// [Gamepad], which allows feeding synthetic states, e.g. for testing or
+// replays.
+package input
+
+import (
+	"math/bits"
+	"time"
+
+	"github.com/clktmr/n64/rcp/serial/joybus"
+)
+
+// Gamepad is the raw state of a gamepad, e.g.
+// [github.com/clktmr/n64/drivers/controller.Controller].
+type Gamepad interface {
+	Down() joybus.ButtonMask
+	X() int8
+	Y() int8
+}
+
+// EventType specifies the kind of an [Event].
+type EventType uint8
+
+const (
+	ButtonDown   EventType = iota // Button was pressed
+	ButtonUp                      // Button was released
+	ButtonRepeat                  // Button is held down, see [Repeat]
+)
+
+func (t EventType) String() string {
+	switch t {
+	case ButtonDown:
+		return "down"
+	case ButtonUp:
+		return "up"
+	case ButtonRepeat:
+		return "repeat"
+	}
+	return "unknown"
+}
+
+// Event is a single button event.
+type Event struct {
+	Type   EventType
+	Port   int               // Index of the gamepad passed to [Input.Update]
+	Button joybus.ButtonMask // Always exactly one button
+	Time   time.Time
+}
+
+// Repeat configures the generation of [ButtonRepeat] events while a button is
+// held down.
+type Repeat struct {
+	Delay    time.Duration     // Time until the first repeat, zero disables repeat
+	Interval time.Duration     // Time between subsequent repeats
+	Buttons  joybus.ButtonMask // Buttons which generate repeat events
+}
+
+// DefaultRepeat repeats the D-Pad and C buttons, which are typically used for
+// menu navigation.
+var DefaultRepeat = Repeat{
+	Delay:    400 * time.Millisecond,
+	Interval: 100 * time.Millisecond,
+	Buttons: joybus.ButtonDUp | joybus.ButtonDDown | joybus.ButtonDLeft | joybus.ButtonDRight |
+		joybus.ButtonCUp | joybus.ButtonCDown | joybus.ButtonCLeft | joybus.ButtonCRight,
+}
+
+// MaxPorts is the number of gamepads an [Input] can track.
+const MaxPorts = 4
+
+type portState struct {
+	present bool
+	down    joybus.ButtonMask
+	repeat  [16]time.Time // time of next repeat for each button
+	x, y    float32
+	cal     *Calibrator // non-nil while calibrating
+}
+
+// Input generates events from a sequence of gamepad states and normalizes
+// their analog sticks.
+type Input struct {
+	// Handler is called for each event during [Input.Update].
+	Handler func(Event)
+
+	Repeat Repeat
+	Sticks [MaxPorts]Stick
+
+	ports [MaxPorts]portState
+}
+
+// NewInput returns an Input calling handler for each event. It uses
+// [DefaultRepeat] and [DefaultStick] for all ports.
+func NewInput(handler func(Event)) *Input {
+	in := &Input{
+		Handler: handler,
+		Repeat:  DefaultRepeat,
+	}
+	for i := range in.Sticks {
+		in.Sticks[i] = DefaultStick
+	}
+	return in
+}
+
+// Chan returns a channel which receives all events. It replaces the Input's
+// Handler. Events are dropped if the channel's buffer of size n is full.
+func (in *Input) Chan(n int) <-chan Event {
+	ch := make(chan Event, n)
+	in.Handler = func(ev Event) {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+	return ch
+}
+
+// Update generates the events for the new gamepad states, which were read at
+// time now. Gamepads are identified by their index, a nil Gamepad releases
+// all buttons of the port. Update must be called regularly, e.g. after each
+// call to [github.com/clktmr/n64/drivers/controller.Poll], for repeat events
+// to be generated in time.
+func (in *Input) Update(now time.Time, pads ...Gamepad) {
+	for port := range in.ports {
+		var pad Gamepad
+		if port < len(pads) {
+			pad = pads[port]
+		}
+		in.update(now, port, pad)
+	}
+}
+
+func (in *Input) update(now time.Time, port int, pad Gamepad) {
+	p := &in.ports[port]
+
+	var down joybus.ButtonMask
+	p.present = pad != nil
+	if p.present {
+		down = pad.Down()
+		if p.cal != nil {
+			p.cal.Sample(pad.X(), pad.Y())
+		}
+		p.x, p.y = in.Sticks[port].Normalize(pad.X(), pad.Y())
+	} else {
+		p.x, p.y = 0, 0
+	}
+
+	changed := down ^ p.down
+	for b := range buttons(changed & p.down) {
+		in.emit(Event{ButtonUp, port, 1 << b, now})
+	}
+	for b := range buttons(changed & down) {
+		in.emit(Event{ButtonDown, port, 1 << b, now})
+		p.repeat[b] = now.Add(in.Repeat.Delay)
+	}
+
+	if in.Repeat.Delay > 0 {
+		for b := range buttons(down &^ changed & in.Repeat.Buttons) {
+			for !now.Before(p.repeat[b]) {
+				in.emit(Event{ButtonRepeat, port, 1 << b, p.repeat[b]})
+				if in.Repeat.Interval <= 0 {
+					p.repeat[b] = now.Add(1) // at most one repeat per update
+					break
+				}
+				p.repeat[b] = p.repeat[b].Add(in.Repeat.Interval)
+			}
+		}
+	}
+
+	p.down = down
+}
+
+func (in *Input) emit(ev Event) {
+	if in.Handler != nil {
+		in.Handler(ev)
+	}
+}
+
+// Down reports which buttons of the port were down during the last call to
+// [Input.Update].
+func (in *Input) Down(port int) joybus.ButtonMask {
+	return in.ports[port].down
+}
+
+// Stick returns the normalized analog stick position of the port, as of the
+// last call to [Input.Update]. Both values range from -1 to 1.
+func (in *Input) Stick(port int) (x, y float32) {
+	p := &in.ports[port]
+	return p.x, p.y
+}
+
+// StartCalibration starts calibrating the port's analog stick. The stick must
+// be centered during the next call to [Input.Update]. All following updates
+// extend the calibrated range until [Input.EndCalibration] is called.
+func (in *Input) StartCalibration(port int) {
+	in.ports[port].cal = &Calibrator{}
+}
+
+// EndCalibration ends calibration of the port's analog stick and applies the
+// result to its [Stick]. If no samples were taken, the calibration is left
+// unchanged.
+func (in *Input) EndCalibration(port int) Calibration {
+	p := &in.ports[port]
+	if p.cal != nil && p.cal.Samples() > 0 {
+		in.Sticks[port].Calibration = p.cal.Calibration()
+	}
+	p.cal = nil
+	return in.Sticks[port].Calibration
+}
+
+// buttons yields the bit index of each button in mask, starting with the
+// lowest bit.
+func buttons(mask joybus.ButtonMask) func(yield func(int) bool) {
+	return func(yield func(int) bool) {
+		for mask != 0 {
+			b := bits.TrailingZeros16(uint16(mask))
+			if !yield(b) {
+				return
+			}
+			mask &^= 1 << b
+		}
+	}
+}
@@ -0,0 +1,158 @@
+//go:build !n64
+
+package input
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/clktmr/n64/rcp/serial/joybus"
+)
+
+type pad struct {
+	down joybus.ButtonMask
+	x, y int8
+}
+
+func (p *pad) Down() joybus.ButtonMask { return p.down }
+func (p *pad) X() int8                 { return p.x }
+func (p *pad) Y() int8                 { return p.y }
+
+func TestEvents(t *testing.T) {
+	var events []Event
+	in := NewInput(func(ev Event) { events = append(events, ev) })
+	in.Repeat = Repeat{Delay: 300 * time.Millisecond, Interval: 100 * time.Millisecond, Buttons: joybus.ButtonDUp}
+
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	p := &pad{}
+	steps := []struct {
+		ms   int
+		down joybus.ButtonMask
+		want []Event
+	}{
+		{0, 0, nil},
+		{10, joybus.ButtonA | joybus.ButtonDUp, []Event{
+			{ButtonDown, 1, joybus.ButtonDUp, at(10)},
+			{ButtonDown, 1, joybus.ButtonA, at(10)},
+		}},
+		{200, joybus.ButtonA | joybus.ButtonDUp, nil},
+		{520, joybus.ButtonA | joybus.ButtonDUp, []Event{
+			{ButtonRepeat, 1, joybus.ButtonDUp, at(310)},
+			{ButtonRepeat, 1, joybus.ButtonDUp, at(410)},
+			{ButtonRepeat, 1, joybus.ButtonDUp, at(510)},
+		}},
+		{530, joybus.ButtonDUp, []Event{
+			{ButtonUp, 1, joybus.ButtonA, at(530)},
+		}},
+		{540, 0, []Event{
+			{ButtonUp, 1, joybus.ButtonDUp, at(540)},
+		}},
+		{1000, 0, nil},
+	}
+
+	for _, step := range steps {
+		events = events[:0]
+		p.down = step.down
+		in.Update(at(step.ms), nil, p)
+		if !slices.Equal(events, step.want) {
+			t.Fatalf("at %dms: expected %v, got %v", step.ms, step.want, events)
+		}
+		if in.Down(1) != step.down {
+			t.Fatalf("at %dms: expected down %v, got %v", step.ms, step.down, in.Down(1))
+		}
+	}
+
+	// unplugging releases all buttons
+	p.down = joybus.ButtonB
+	in.Update(at(1100), nil, p)
+	events = events[:0]
+	in.Update(at(1200))
+	want := []Event{{ButtonUp, 1, joybus.ButtonB, at(1200)}}
+	if !slices.Equal(events, want) {
+		t.Fatalf("expected %v, got %v", want, events)
+	}
+}
+
+func TestChan(t *testing.T) {
+	in := NewInput(nil)
+	ch := in.Chan(1)
+	in.Update(time.Now(), &pad{down: joybus.ButtonA | joybus.ButtonB})
+	if ev := <-ch; ev.Button != joybus.ButtonB {
+		t.Fatalf("expected %v, got %v", joybus.ButtonB, ev.Button)
+	}
+	select {
+	case ev := <-ch:
+		t.Fatalf("expected dropped event, got %v", ev)
+	default:
+	}
+}
+
+func near(a, b float32) bool { return math.Abs(float64(a-b)) < 1e-3 }
+
+func TestNormalize(t *testing.T) {
+	circle := Stick{DeadZone: 0.1, Gate: GateCircle, Calibration: DefaultCalibration}
+	square := Stick{DeadZone: 0.1, Gate: GateSquare, Calibration: DefaultCalibration}
+	offCenter := Stick{Calibration: Calibration{CenterX: 10, MinX: -70, MaxX: 90, MinY: -80, MaxY: 80}}
+
+	tests := map[string]struct {
+		stick  Stick
+		x, y   int8
+		fx, fy float32
+	}{
+		"center":          {circle, 0, 0, 0, 0},
+		"dead zone":       {circle, 4, -4, 0, 0},
+		"full right":      {circle, 80, 0, 1, 0},
+		"beyond range":    {circle, 127, 0, 1, 0},
+		"full left":       {circle, -80, 0, -1, 0},
+		"half up":         {circle, 0, 44, 0, 0.5},
+		"circle diagonal": {circle, 80, 80, float32(math.Sqrt2 / 2), float32(math.Sqrt2 / 2)},
+		"square diagonal": {square, 80, 80, 1, 1},
+		"square dead x":   {square, 4, 80, 0, 1},
+		"off center":      {offCenter, 10, 0, 0, 0},
+		"off center max":  {offCenter, 90, 0, 1, 0},
+		"off center min":  {offCenter, -70, 0, -1, 0},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			fx, fy := tc.stick.Normalize(tc.x, tc.y)
+			if !near(fx, tc.fx) || !near(fy, tc.fy) {
+				t.Fatalf("expected (%v, %v), got (%v, %v)", tc.fx, tc.fy, fx, fy)
+			}
+		})
+	}
+}
+
+func TestCalibration(t *testing.T) {
+	in := NewInput(nil)
+	p := &pad{x: 5, y: -3}
+	in.StartCalibration(0)
+	in.Update(time.Now(), p)
+	for _, pos := range [][2]int8{{70, -3}, {-60, 60}, {5, -75}, {40, 40}} {
+		p.x, p.y = pos[0], pos[1]
+		in.Update(time.Now(), p)
+	}
+	cal := in.EndCalibration(0)
+	want := Calibration{CenterX: 5, CenterY: -3, MinX: -60, MaxX: 70, MinY: -75, MaxY: 60}
+	if cal != want {
+		t.Fatalf("expected %+v, got %+v", want, cal)
+	}
+
+	p.x, p.y = 70, -3
+	in.Update(time.Now(), p)
+	if x, y := in.Stick(0); !near(x, 1) || !near(y, 0) {
+		t.Fatalf("expected (1, 0), got (%v, %v)", x, y)
+	}
+
+	// axes which weren't moved keep the default range
+	var c Calibrator
+	c.Sample(0, 0)
+	c.Sample(80, 0)
+	want = Calibration{MinX: -80, MaxX: 80, MinY: -80, MaxY: 80}
+	if cal := c.Calibration(); cal != want {
+		t.Fatalf("expected %+v, got %+v", want, cal)
+	}
+}
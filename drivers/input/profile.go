@@ -0,0 +1,324 @@
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/clktmr/n64/drivers/controller/pakfs"
+	"github.com/clktmr/n64/rcp/serial/joybus"
+)
+
+// Direction is a set of analog stick directions.
+type Direction uint8
+
+const (
+	StickUp Direction = 1 << iota
+	StickDown
+	StickLeft
+	StickRight
+)
+
+var buttonNames = [...]struct {
+	name   string
+	button joybus.ButtonMask
+}{
+	{"A", joybus.ButtonA},
+	{"B", joybus.ButtonB},
+	{"Z", joybus.ButtonZ},
+	{"Start", joybus.ButtonStart},
+	{"DUp", joybus.ButtonDUp},
+	{"DDown", joybus.ButtonDDown},
+	{"DLeft", joybus.ButtonDLeft},
+	{"DRight", joybus.ButtonDRight},
+	{"L", joybus.ButtonL},
+	{"R", joybus.ButtonR},
+	{"CUp", joybus.ButtonCUp},
+	{"CDown", joybus.ButtonCDown},
+	{"CLeft", joybus.ButtonCLeft},
+	{"CRight", joybus.ButtonCRight},
+}
+
+var directionNames = [...]struct {
+	name string
+	dir  Direction
+}{
+	{"StickUp", StickUp},
+	{"StickDown", StickDown},
+	{"StickLeft", StickLeft},
+	{"StickRight", StickRight},
+}
+
+// Binding is a combination of buttons and stick directions, which must all be
+// active at the same time to trigger an action. A binding with more than one
+// input is called a chord.
+type Binding struct {
+	Buttons joybus.ButtonMask
+	Stick   Direction
+}
+
+// Buttons returns a binding of the given buttons.
+func Buttons(b joybus.ButtonMask) Binding {
+	return Binding{Buttons: b}
+}
+
+// Zero reports whether the binding has no inputs. A zero binding never
+// triggers.
+func (b Binding) Zero() bool {
+	return b.Buttons == 0 && b.Stick == 0
+}
+
+// contains reports whether all inputs of o are also part of b.
+func (b Binding) contains(o Binding) bool {
+	return b.Buttons&o.Buttons == o.Buttons && b.Stick&o.Stick == o.Stick
+}
+
+// String returns the binding in the format accepted by [ParseBinding], e.g.
+// "Z+A" or "R+StickLeft".
+func (b Binding) String() string {
+	var names []string
+	for _, v := range buttonNames {
+		if b.Buttons&v.button != 0 {
+			names = append(names, v.name)
+		}
+	}
+	for _, v := range directionNames {
+		if b.Stick&v.dir != 0 {
+			names = append(names, v.name)
+		}
+	}
+	return strings.Join(names, "+")
+}
+
+// ParseBinding parses a binding from the names of its inputs joined by '+',
+// e.g. "Z+A". Button names are A, B, Z, Start, L, R, DUp, DDown, DLeft,
+// DRight, CUp, CDown, CLeft and CRight. Stick directions are StickUp,
+// StickDown, StickLeft and StickRight. Names are case-insensitive. An empty
+// string results in a zero binding.
+func ParseBinding(s string) (b Binding, err error) {
+	if strings.TrimSpace(s) == "" {
+		return
+	}
+outer:
+	for name := range strings.SplitSeq(s, "+") {
+		name = strings.TrimSpace(name)
+		for _, v := range buttonNames {
+			if strings.EqualFold(name, v.name) {
+				b.Buttons |= v.button
+				continue outer
+			}
+		}
+		for _, v := range directionNames {
+			if strings.EqualFold(name, v.name) {
+				b.Stick |= v.dir
+				continue outer
+			}
+		}
+		return Binding{}, fmt.Errorf("unknown input %q", name)
+	}
+	return
+}
+
+// Profile maps logical actions to bindings. Each action can have multiple
+// alternative bindings. The zero value is an empty profile.
+//
+// A profile can be stored as text with one action per line, followed by '='
+// and a comma separated list of its bindings. Empty lines and lines starting
+// with '#' are ignored:
+//
+//	# platformer controls
+//	jump = A
+//	dive = Z+A
+//	camera_left = CLeft, R+StickLeft
+//
+// The text ends at the first NUL byte, so profiles can be read from files
+// which are padded with zeroes, e.g. notes of a
+// [github.com/clktmr/n64/drivers/controller/pakfs.FS].
+type Profile struct {
+	actions map[string][]Binding
+}
+
+// NewProfile returns an empty profile.
+func NewProfile() *Profile {
+	return &Profile{actions: make(map[string][]Binding)}
+}
+
+// LoadProfile reads the profile from the named file in fsys, e.g. a
+// [github.com/clktmr/n64/drivers/cartfs.FS] or a
+// [github.com/clktmr/n64/drivers/controller/pakfs.FS].
+func LoadProfile(fsys fs.FS, name string) (*Profile, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	p := NewProfile()
+	if err = p.UnmarshalText(data); err != nil {
+		return nil, &fs.PathError{Op: "load", Path: name, Err: err}
+	}
+	return p, nil
+}
+
+// Clone returns a copy of the profile, e.g. to derive per-player profiles from
+// the game's defaults.
+func (p *Profile) Clone() *Profile {
+	c := NewProfile()
+	for action, b := range p.actions {
+		c.actions[action] = slices.Clone(b)
+	}
+	return c
+}
+
+// Bind replaces all bindings of the action. Zero bindings are dropped.
+func (p *Profile) Bind(action string, bindings ...Binding) {
+	bindings = slices.DeleteFunc(slices.Clone(bindings), Binding.Zero)
+	if len(bindings) == 0 {
+		delete(p.actions, action)
+		return
+	}
+	if p.actions == nil {
+		p.actions = make(map[string][]Binding)
+	}
+	p.actions[action] = bindings
+}
+
+// Add adds an alternative binding to the action.
+func (p *Profile) Add(action string, b Binding) {
+	if b.Zero() {
+		return
+	}
+	if p.actions == nil {
+		p.actions = make(map[string][]Binding)
+	}
+	p.actions[action] = append(p.actions[action], b)
+}
+
+// Unbind removes all bindings of the action.
+func (p *Profile) Unbind(action string) {
+	delete(p.actions, action)
+}
+
+// Bindings returns the bindings of the action.
+func (p *Profile) Bindings(action string) []Binding {
+	return slices.Clone(p.actions[action])
+}
+
+// Actions returns the names of all bound actions in sorted order.
+func (p *Profile) Actions() []string {
+	return slices.Sorted(maps.Keys(p.actions))
+}
+
+// MarshalText implements [encoding.TextMarshaler].
+func (p *Profile) MarshalText() ([]byte, error) {
+	var buf bytes.Buffer
+	for _, action := range p.Actions() {
+		if !validAction(action) {
+			return nil, fmt.Errorf("invalid action name %q", action)
+		}
+		buf.WriteString(action)
+		buf.WriteString(" = ")
+		for i, b := range p.actions[action] {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(b.String())
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler]. It replaces all
+// bindings of the profile.
+func (p *Profile) UnmarshalText(text []byte) error {
+	if i := bytes.IndexByte(text, 0); i >= 0 {
+		text = text[:i]
+	}
+
+	actions := make(map[string][]Binding)
+	s := bufio.NewScanner(bytes.NewReader(text))
+	for line := 1; s.Scan(); line++ {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || l[0] == '#' {
+			continue
+		}
+		action, list, ok := strings.Cut(l, "=")
+		action = strings.TrimSpace(action)
+		if !ok || !validAction(action) {
+			return fmt.Errorf("line %d: expected action = bindings", line)
+		}
+		for v := range strings.SplitSeq(list, ",") {
+			b, err := ParseBinding(v)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			if !b.Zero() {
+				actions[action] = append(actions[action], b)
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	p.actions = actions
+	return nil
+}
+
+// WriteTo writes the profile's text representation to w. Use [Profile.Store]
+// to write it to a controller pak.
+func (p *Profile) WriteTo(w io.Writer) (int64, error) {
+	text, err := p.MarshalText()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(text)
+	return int64(n), err
+}
+
+// Store writes the profile to the named file in fsys, which is created if it
+// doesn't exist. The text is terminated by a NUL byte, so the remainder of
+// the file's last page is ignored by [LoadProfile].
+func (p *Profile) Store(fsys *pakfs.FS, name string) error {
+	text, err := p.MarshalText()
+	if err != nil {
+		return err
+	}
+	text = append(text, 0)
+
+	var f *pakfs.File
+	if fd, err := fsys.Open(name); err == nil {
+		f = fd.(*pakfs.File)
+	} else if errors.Is(err, fs.ErrNotExist) {
+		if f, err = fsys.Create(name); err != nil {
+			return err
+		}
+	} else {
+		return err
+	}
+
+	if err = fsys.Truncate(name, int64(len(text))); err != nil {
+		return err
+	}
+	_, err = f.WriteAt(text, 0)
+	return err
+}
+
+func validAction(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '_', r == '-', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package input
+
+import "math"
+
+// Gate is the shape of the analog stick's range of motion.
+type Gate uint8
+
+const (
+	// GateCircle limits the stick to the unit circle. This approximates the
+	// octagonal gate of the N64 controller and gives the same maximum
+	// speed in all directions.
+	GateCircle Gate = iota
+
+	// GateSquare limits each axis independently to the range -1 to 1.
+	GateSquare
+)
+
+// Calibration describes the raw values of the analog stick's center and
+// extremes.
+type Calibration struct {
+	CenterX, CenterY int8
+	MinX, MaxX       int8
+	MinY, MaxY       int8
+}
+
+// DefaultCalibration matches a typical N64 controller, which reaches around
+// ±80 on each axis.
+var DefaultCalibration = Calibration{
+	MinX: -80, MaxX: 80,
+	MinY: -80, MaxY: 80,
+}
+
+// Stick normalizes raw analog stick values.
+type Stick struct {
+	// DeadZone is the fraction of the range around the center which is
+	// reported as zero. The remaining range is rescaled to start at zero.
+	DeadZone float32
+	Gate     Gate
+	Calibration
+}
+
+// DefaultStick is a [Stick] with a small dead zone and [DefaultCalibration].
+var DefaultStick = Stick{
+	DeadZone:    0.1,
+	Gate:        GateCircle,
+	Calibration: DefaultCalibration,
+}
+
+// Normalize converts a raw stick position to values in the range -1 to 1.
+func (s *Stick) Normalize(x, y int8) (fx, fy float32) {
+	fx = axis(x, s.CenterX, s.MinX, s.MaxX)
+	fy = axis(y, s.CenterY, s.MinY, s.MaxY)
+
+	switch s.Gate {
+	case GateSquare:
+		fx = deadZone(fx, s.DeadZone)
+		fy = deadZone(fy, s.DeadZone)
+	default:
+		r := float32(math.Hypot(float64(fx), float64(fy)))
+		if r == 0 {
+			return 0, 0
+		}
+		scale := deadZone(min(r, 1), s.DeadZone) / r
+		fx, fy = fx*scale, fy*scale
+	}
+	return
+}
+
+// axis maps v to -1 to 1, where center maps to 0.
+func axis(v, center, lo, hi int8) float32 {
+	d := float32(int(v) - int(center))
+	var r float32
+	if d >= 0 {
+		r = float32(int(hi) - int(center))
+	} else {
+		r = float32(int(center) - int(lo))
+	}
+	if r <= 0 {
+		return 0
+	}
+	return min(max(d/r, -1), 1)
+}
+
+// deadZone maps the magnitude of v from dz..1 to 0..1.
+func deadZone(v, dz float32) float32 {
+	if dz <= 0 {
+		return v
+	}
+	a := float32(math.Abs(float64(v)))
+	if a <= dz {
+		return 0
+	}
+	return float32(math.Copysign(float64((a-dz)/(1-dz)), float64(v)))
+}
+
+// Calibrator learns the [Calibration] of an analog stick on the fly. The first
+// sample is taken as the center, so the stick must not be touched when
+// calibration starts. Subsequent samples extend the range, e.g. while the user
+// rotates the stick along its gate.
+type Calibrator struct {
+	cal     Calibration
+	samples int
+}
+
+// Reset restarts the calibration.
+func (c *Calibrator) Reset() {
+	*c = Calibrator{}
+}
+
+// Sample adds a raw stick position to the calibration.
+func (c *Calibrator) Sample(x, y int8) {
+	if c.samples == 0 {
+		c.cal = Calibration{x, y, x, x, y, y}
+	}
+	c.samples++
+	c.cal.MinX, c.cal.MaxX = min(c.cal.MinX, x), max(c.cal.MaxX, x)
+	c.cal.MinY, c.cal.MaxY = min(c.cal.MinY, y), max(c.cal.MaxY, y)
+}
+
+// Samples returns the number of samples taken since the last reset.
+func (c *Calibrator) Samples() int {
+	return c.samples
+}
+
+// Calibration returns the calibration learned so far. Axes which weren't moved
+// far enough from the center keep their range from [DefaultCalibration].
+func (c *Calibrator) Calibration() Calibration {
+	const minRange = 20
+	cal := c.cal
+	if int(cal.MaxX)-int(cal.CenterX) < minRange {
+		cal.MaxX = int8(min(int(cal.CenterX)+int(DefaultCalibration.MaxX), math.MaxInt8))
+	}
+	if int(cal.CenterX)-int(cal.MinX) < minRange {
+		cal.MinX = int8(max(int(cal.CenterX)+int(DefaultCalibration.MinX), math.MinInt8))
+	}
+	if int(cal.MaxY)-int(cal.CenterY) < minRange {
+		cal.MaxY = int8(min(int(cal.CenterY)+int(DefaultCalibration.MaxY), math.MaxInt8))
+	}
+	if int(cal.CenterY)-int(cal.MinY) < minRange {
+		cal.MinY = int8(max(int(cal.CenterY)+int(DefaultCalibration.MinY), math.MinInt8))
+	}
+	return cal
+}
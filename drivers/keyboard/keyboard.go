@@ -0,0 +1,183 @@
+// Package keyboard implements a driver for the Randnet keyboard.
+//
+// The keyboard reports up to three pressed keys per poll. [Keyboard] turns
+// these into key events and typed text, which can be read via its
+// [io.Reader] implementation, e.g. as stdin of a [console.Console].
+package keyboard
+
+import (
+	"slices"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/clktmr/n64/rcp/serial/joybus"
+)
+
+// Modifier is a bitmask of active modifier keys and locks.
+type Modifier uint8
+
+const (
+	ModShift Modifier = 1 << iota
+	ModCtrl
+	ModAlt
+	ModCapsLock
+	ModNumLock
+)
+
+// Event is a single key event.
+type Event struct {
+	Key  Key
+	Down bool     // Key was pressed, otherwise released
+	Rune rune     // Rune produced by the key, zero for key up events
+	Mods Modifier // Active modifiers when the event occurred
+}
+
+// Keyboard generates key events and text from keyboard states.
+type Keyboard struct {
+	// Handler is called for each event during [Keyboard.Update].
+	Handler func(Event)
+	Keymap  *Keymap
+
+	down [3]Key
+	mods Modifier
+	home bool
+
+	mtx  sync.Mutex
+	cond sync.Cond
+	text []byte
+}
+
+// New returns a Keyboard which produces runes according to keymap.
+func New(keymap *Keymap) *Keyboard {
+	k := &Keyboard{Keymap: keymap}
+	k.cond.L = &k.mtx
+	return k
+}
+
+// Update generates the events for a new keyboard state, as returned by
+// [joybus.KeyboardStateCommand]. States with [joybus.KeyboardTooManyKeys] set
+// are ignored, as they don't report which keys are pressed.
+func (k *Keyboard) Update(keys [3]uint16, status joybus.KeyboardStatus) {
+	if status&joybus.KeyboardTooManyKeys != 0 {
+		return
+	}
+	k.home = status&joybus.KeyboardHome != 0
+
+	var down [3]Key
+	for i, v := range keys {
+		down[i] = Key(v)
+	}
+
+	for _, key := range k.down {
+		if key != KeyNone && !slices.Contains(down[:], key) {
+			k.release(key, down)
+		}
+	}
+	for _, key := range down {
+		if key != KeyNone && !slices.Contains(k.down[:], key) {
+			k.press(key)
+		}
+	}
+	k.down = down
+}
+
+func (k *Keyboard) press(key Key) {
+	switch key {
+	case KeyShiftL, KeyShiftR:
+		k.mods |= ModShift
+	case KeyCtrl:
+		k.mods |= ModCtrl
+	case KeyAlt:
+		k.mods |= ModAlt
+	case KeyCapsLock:
+		k.mods ^= ModCapsLock
+	case KeyNumLock:
+		k.mods ^= ModNumLock
+	}
+
+	ev := Event{Key: key, Down: true, Mods: k.mods}
+	if k.Keymap != nil && k.mods&(ModCtrl|ModAlt) == 0 {
+		ev.Rune = k.Keymap.Rune(key, k.mods)
+	}
+	if ev.Rune != 0 {
+		k.mtx.Lock()
+		k.text = utf8.AppendRune(k.text, ev.Rune)
+		k.cond.Broadcast()
+		k.mtx.Unlock()
+	}
+	k.emit(ev)
+}
+
+func (k *Keyboard) release(key Key, down [3]Key) {
+	switch key {
+	case KeyShiftL, KeyShiftR:
+		if !slices.Contains(down[:], KeyShiftL+KeyShiftR-key) {
+			k.mods &^= ModShift
+		}
+	case KeyCtrl:
+		k.mods &^= ModCtrl
+	case KeyAlt:
+		k.mods &^= ModAlt
+	}
+	k.emit(Event{Key: key, Mods: k.mods})
+}
+
+func (k *Keyboard) emit(ev Event) {
+	if k.Handler != nil {
+		k.Handler(ev)
+	}
+}
+
+// Down reports whether key was pressed during the last call to
+// [Keyboard.Update].
+func (k *Keyboard) Down(key Key) bool {
+	return key != KeyNone && slices.Contains(k.down[:], key)
+}
+
+// Home reports whether the Home key was pressed during the last call to
+// [Keyboard.Update]. The Home key doesn't generate events.
+func (k *Keyboard) Home() bool {
+	return k.home
+}
+
+// Modifiers returns the currently active modifiers.
+func (k *Keyboard) Modifiers() Modifier {
+	return k.mods
+}
+
+// LEDs returns the LED state reflecting the active locks, which should be sent
+// with the next poll. The power LED is always lit.
+func (k *Keyboard) LEDs() (leds joybus.KeyboardLED) {
+	leds = joybus.LEDPower
+	if k.mods&ModCapsLock != 0 {
+		leds |= joybus.LEDCapsLock
+	}
+	if k.mods&ModNumLock != 0 {
+		leds |= joybus.LEDNumLock
+	}
+	return
+}
+
+// Read reads the text typed so far. It blocks until at least one byte is
+// available. Enter yields '\n' and Backspace yields '\b'.
+func (k *Keyboard) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+	for len(k.text) == 0 {
+		k.cond.Wait()
+	}
+	n = copy(p, k.text)
+	k.text = k.text[:copy(k.text, k.text[n:])]
+	return
+}
+
+// Buffered returns the number of bytes which can be read without blocking.
+func (k *Keyboard) Buffered() int {
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+	return len(k.text)
+}
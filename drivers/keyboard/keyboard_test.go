@@ -0,0 +1,101 @@
+//go:build !n64
+
+package keyboard
+
+import (
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/clktmr/n64/rcp/serial/joybus"
+)
+
+// key returns the first key producing r in keymap m.
+func key(t *testing.T, m *Keymap, r rune) Key {
+	for k, v := range m.plain {
+		if v == r {
+			return k
+		}
+	}
+	t.Fatalf("no key for %q", r)
+	return KeyNone
+}
+
+func TestEvents(t *testing.T) {
+	var events []Event
+	k := New(US)
+	k.Handler = func(ev Event) { events = append(events, ev) }
+
+	a := key(t, US, 'a')
+	one := key(t, US, '1')
+	steps := []struct {
+		keys   [3]Key
+		status joybus.KeyboardStatus
+		want   []Event
+	}{
+		{[3]Key{a}, 0, []Event{{a, true, 'a', 0}}},
+		{[3]Key{a, KeyShiftL}, 0, []Event{{KeyShiftL, true, 0, ModShift}}},
+		{[3]Key{KeyShiftL, one}, 0, []Event{{a, false, 0, ModShift}, {one, true, '!', ModShift}}},
+		{[3]Key{}, joybus.KeyboardTooManyKeys, nil},
+		{[3]Key{}, 0, []Event{{KeyShiftL, false, 0, 0}, {one, false, 0, 0}}},
+		{[3]Key{KeyCapsLock}, 0, []Event{{KeyCapsLock, true, 0, ModCapsLock}}},
+		{[3]Key{a}, 0, []Event{{KeyCapsLock, false, 0, ModCapsLock}, {a, true, 'A', ModCapsLock}}},
+		{[3]Key{a, KeyShiftR}, 0, []Event{{KeyShiftR, true, 0, ModShift | ModCapsLock}}},
+		{[3]Key{KeyShiftR, one}, 0, []Event{{a, false, 0, ModShift | ModCapsLock}, {one, true, '!', ModShift | ModCapsLock}}},
+	}
+	for i, step := range steps {
+		events = nil
+		var keys [3]uint16
+		for j, v := range step.keys {
+			keys[j] = uint16(v)
+		}
+		k.Update(keys, step.status)
+		if !slices.Equal(events, step.want) {
+			t.Errorf("step %d: expected %v, got %v", i, step.want, events)
+		}
+	}
+
+	if k.LEDs() != joybus.LEDPower|joybus.LEDCapsLock {
+		t.Errorf("unexpected LEDs %#x", k.LEDs())
+	}
+}
+
+func TestKeymaps(t *testing.T) {
+	for _, tc := range []struct {
+		m     *Keymap
+		plain rune
+		shift rune
+	}{
+		{US, '2', '@'},
+		{JP, '2', '"'},
+		{US, ';', ':'},
+		{JP, ';', '+'},
+		{JP, '¥', '|'},
+	} {
+		k := key(t, tc.m, tc.plain)
+		if r := tc.m.Rune(k, ModShift); r != tc.shift {
+			t.Errorf("%s: %q: expected %q, got %q", tc.m.Name, tc.plain, tc.shift, r)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	k := New(JP)
+	for _, r := range "hi¥" {
+		k.Update([3]uint16{uint16(key(t, JP, r))}, 0)
+		k.Update([3]uint16{}, 0)
+	}
+	k.Update([3]uint16{uint16(KeyEnter)}, 0)
+
+	buf := make([]byte, 16)
+	n, err := io.ReadAtLeast(k, buf, len("hi¥\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "hi¥\n" {
+		t.Fatalf("expected %q, got %q", "hi¥\n", got)
+	}
+	if k.Buffered() != 0 {
+		t.Fatal("expected empty buffer")
+	}
+}
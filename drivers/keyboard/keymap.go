@@ -0,0 +1,119 @@
+package keyboard
+
+// Key is the scan code of a key, which encodes its position in the keyboard
+// matrix as row<<8 | column.
+type Key uint16
+
+// Keys which don't produce text. All other keys are named by their runes in a
+// [Keymap].
+const (
+	KeyNone Key = 0
+
+	KeyEsc Key = 0x0101
+	KeyF1  Key = 0x0102
+	KeyF2  Key = 0x0103
+	KeyF3  Key = 0x0104
+	KeyF4  Key = 0x0105
+	KeyF5  Key = 0x0106
+	KeyF6  Key = 0x0107
+	KeyF7  Key = 0x0108
+	KeyF8  Key = 0x0109
+	KeyF9  Key = 0x010a
+	KeyF10 Key = 0x010b
+	KeyF11 Key = 0x010c
+	KeyF12 Key = 0x010d
+
+	KeyZenkaku   Key = 0x0201
+	KeyBackspace Key = 0x020f
+
+	KeyTab   Key = 0x0301
+	KeyEnter Key = 0x030e
+
+	KeyCapsLock Key = 0x0401
+
+	KeyShiftL Key = 0x0501
+	KeyShiftR Key = 0x050d
+	KeyUp     Key = 0x050e
+
+	KeyCtrl     Key = 0x0601
+	KeyAlt      Key = 0x0602
+	KeyMuhenkan Key = 0x0603
+	KeySpace    Key = 0x0604
+	KeyHenkan   Key = 0x0605
+	KeyKana     Key = 0x0606
+	KeyLeft     Key = 0x0607
+	KeyDown     Key = 0x0608
+	KeyRight    Key = 0x0609
+	KeyNumLock  Key = 0x060a
+)
+
+// Keymap maps keys to the runes they produce.
+type Keymap struct {
+	Name  string
+	plain map[Key]rune
+	shift map[Key]rune
+}
+
+// Rune returns the rune produced by key k, or 0 if it doesn't produce any.
+func (m *Keymap) Rune(k Key, mods Modifier) rune {
+	switch k {
+	case KeySpace:
+		return ' '
+	case KeyEnter:
+		return '\n'
+	case KeyTab:
+		return '\t'
+	case KeyBackspace:
+		return '\b'
+	}
+
+	r, ok := m.plain[k]
+	if !ok {
+		return 0
+	}
+	shift := mods&ModShift != 0
+	if r >= 'a' && r <= 'z' && mods&ModCapsLock != 0 {
+		shift = !shift
+	}
+	if shift {
+		return m.shift[k]
+	}
+	return r
+}
+
+// newKeymap creates a keymap from rows of plain and shifted runes, where the
+// n-th rune is produced by the key in column n+first. A space marks a column
+// without rune.
+func newKeymap(name string, rows map[Key][2]string) *Keymap {
+	m := &Keymap{name, make(map[Key]rune), make(map[Key]rune)}
+	for first, row := range rows {
+		plain, shift := []rune(row[0]), []rune(row[1])
+		for i := range plain {
+			k := first + Key(i)
+			if plain[i] != ' ' {
+				m.plain[k] = plain[i]
+			}
+			if shift[i] != ' ' {
+				m.shift[k] = shift[i]
+			}
+		}
+	}
+	return m
+}
+
+// JP is the keymap printed on the keycaps of the Randnet keyboard, which uses
+// a JIS layout.
+var JP = newKeymap("JP", map[Key][2]string{
+	0x0202: {"1234567890-^¥", "!\"#$%&'() =~|"},
+	0x0302: {"qwertyuiop@[", "QWERTYUIOP`{"},
+	0x0402: {"asdfghjkl;:]", "ASDFGHJKL+*}"},
+	0x0502: {"zxcvbnm,./\\", "ZXCVBNM<>?_"},
+})
+
+// US interprets the keys by their position on a keyboard with US layout.
+var US = newKeymap("US", map[Key][2]string{
+	0x0202: {"1234567890-=\\", "!@#$%^&*()_+|"},
+	0x0302: {"qwertyuiop[]", "QWERTYUIOP{}"},
+	0x0402: {"asdfghjkl;' ", "ASDFGHJKL:\" "},
+	0x0502: {"zxcvbnm,./ ", "ZXCVBNM<>? "},
+})
@@ -0,0 +1,54 @@
+//go:build n64
+
+package keyboard
+
+import (
+	"sync"
+
+	"github.com/clktmr/n64/debug"
+	"github.com/clktmr/n64/rcp/serial"
+	"github.com/clktmr/n64/rcp/serial/joybus"
+)
+
+type port struct {
+	mtx   sync.Mutex
+	block *serial.CommandBlock
+	cmd   joybus.KeyboardStateCommand
+}
+
+var ports [4]port
+
+// Poll reads the state of the keyboard connected to the joybus port and
+// updates k. The keyboard's LEDs are set to [Keyboard.LEDs]. If the keyboard
+// doesn't respond, all keys are released. Use [controller.Port.Device] to find
+// the port a keyboard is connected to.
+func (k *Keyboard) Poll(nr uint8) error {
+	p := &ports[nr]
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.block == nil {
+		p.block = serial.NewCommandBlock(serial.CmdConfigureJoybus)
+		var err error
+		for range nr {
+			err = joybus.ControlByte(p.block, joybus.CtrlSkip)
+			debug.AssertErrNil(err)
+		}
+		p.cmd, err = joybus.NewKeyboardStateCommand(p.block)
+		debug.AssertErrNil(err)
+		err = joybus.ControlByte(p.block, joybus.CtrlAbort)
+		debug.AssertErrNil(err)
+	}
+
+	p.cmd.Reset()
+	p.cmd.SetLEDs(k.LEDs())
+	serial.Run(p.block)
+
+	keys, status, err := p.cmd.State()
+	if err != nil {
+		k.Update([3]uint16{}, 0)
+		return err
+	}
+	k.Update(keys, status)
+	return nil
+}
@@ -0,0 +1,103 @@
+package rspq
+
+import (
+	"github.com/clktmr/n64/debug"
+	"github.com/clktmr/n64/rcp/cpu"
+)
+
+const (
+	maxBlockNestingLevel = 8                        // size of RSPQPointerStack
+	lowpriCallSlot       = maxBlockNestingLevel     // RSPQDramLowpriAddr
+	highpriCallSlot      = maxBlockNestingLevel + 1 // RSPQDramHighpriAddr
+	blockMinSize         = 2 * MaxCommandSize
+	blockMaxSize         = 4096
+)
+
+// Block is a recorded sequence of commands, which can be run multiple times
+// by the RSP with a single command. See rspq_block_t in libdragon.
+type Block struct {
+	chunks  [][]uint32
+	cur     int // next free word in last chunk
+	nesting int // level of nested blocks called by this block
+
+	pinner cpu.Pinner
+}
+
+// block is the block currently recorded, if any.
+var block *Block
+
+// BeginBlock starts recording a block. All commands passed to [Write] are
+// added to the block instead of being sent to the RSP, until [EndBlock] is
+// called. This includes commands written by other goroutines meanwhile.
+// Blocks can't be recorded recursively, but recorded blocks can be run while
+// recording another one.
+func BeginBlock() {
+	mtx.Lock()
+	defer mtx.Unlock()
+	debug.Assert(block == nil, "rspq: block already being recorded")
+	block = &Block{}
+	block.grow(blockMinSize)
+}
+
+// EndBlock stops recording and returns the recorded block.
+func EndBlock() *Block {
+	mtx.Lock()
+	defer mtx.Unlock()
+	debug.Assert(block != nil, "rspq: no block being recorded")
+	b := block
+	block = nil
+	b.append(CmdRet, uint32(b.nesting<<2))
+	return b
+}
+
+// Run enqueues a call of the block.
+func (b *Block) Run() {
+	debug.Assert(b.chunks != nil, "rspq: block already freed")
+	mtx.Lock()
+	defer mtx.Unlock()
+	write(CmdCall, uint32(cpu.PhysicalAddressSlice(b.chunks[0])), uint32(b.nesting<<2))
+
+	// The callee uses the return slot of its nesting level, so the block
+	// being recorded must use a higher one.
+	if block != nil && block.nesting <= b.nesting {
+		block.nesting = b.nesting + 1
+		debug.Assert(block.nesting < maxBlockNestingLevel, "rspq: max block nesting level")
+	}
+}
+
+// Free releases the memory of the block. The block must not be run anymore
+// and must not be in use by the RSP or any other block.
+func (b *Block) Free() {
+	b.pinner.Unpin()
+	b.chunks = nil
+}
+
+// grow appends a new chunk of at least size words.
+func (b *Block) grow(size int) {
+	chunk := cpu.MakePaddedSlice[uint32](min(size, blockMaxSize))
+	cpu.WritebackSlice(chunk)
+	cpu.PinSlice(&b.pinner, chunk)
+	b.chunks = append(b.chunks, chunk)
+	b.cur = 0
+}
+
+func (b *Block) append(c Command, args ...uint32) {
+	chunk := cpu.UncachedSlice(b.chunks[len(b.chunks)-1])
+	if len(args) == 0 {
+		chunk[b.cur] = uint32(c) << 24
+		b.cur++
+	} else {
+		debug.Assert(args[0]&0xff000000 == 0, "invalid command")
+		copy(chunk[b.cur:], args)
+		chunk[b.cur] |= uint32(c) << 24
+		b.cur += len(args)
+	}
+
+	// Keep enough space to jump to the next chunk
+	if b.cur+MaxCommandSize > len(chunk) {
+		prev := chunk
+		jump := b.cur
+		b.grow(2 * len(chunk))
+		prev[jump] = uint32(CmdJump)<<24 | uint32(cpu.PhysicalAddressSlice(b.chunks[len(b.chunks)-1]))
+	}
+}
@@ -24,7 +24,7 @@ const (
 
 func dma(p []byte, dmemAddr cpu.Addr, n uint32, flags uint32) {
 	debug.Assert(dmemAddr&0x7 == 0 && n&0x7 == 0, "unaligned dma")
-	Write(CmdDma, uint32(cpu.PhysicalAddressSlice(p)), uint32(dmemAddr), n-1, flags)
+	write(CmdDma, uint32(cpu.PhysicalAddressSlice(p)), uint32(dmemAddr), n-1, flags)
 }
 
 const dmaBusyOrFull = 12
@@ -32,11 +32,15 @@ const dmaBusyOrFull = 12
 // dmaWrite enqueues a DMA write command (dmem to rdram)
 func DMAWrite(p []byte, addr cpu.Addr, n uint32) {
 	cpu.InvalidateSlice(p)
+	mtx.Lock()
 	dma(p, addr, n, 0xffff_8000|dmaBusyOrFull)
+	mtx.Unlock()
 }
 
 // dmaWrite enqueues a DMA read command (rdram to dmem)
 func DMARead(p []byte, addr cpu.Addr, n uint32) {
 	cpu.WritebackSlice(p)
+	mtx.Lock()
 	dma(p, addr, n, dmaBusyOrFull)
+	mtx.Unlock()
 }
@@ -0,0 +1,272 @@
+package rspq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unsafe"
+
+	"github.com/clktmr/n64/rcp/cpu"
+	"github.com/clktmr/n64/rcp/rsp"
+	"github.com/clktmr/n64/rcp/rsp/ucode"
+)
+
+// Location of the command buffer in DMEM, see RSPQ_DMEM_BUFFER in libdragon.
+const (
+	dmemBuffer     = 0x160
+	dmemBufferSize = 0x100
+)
+
+// Assertion codes of rsp_queue, passed in register $at.
+var assertCodes = map[uint16]string{
+	0xff01: "invalid overlay",
+	0xff02: "invalid command",
+}
+
+// Snapshot is the state of the RSP, as dumped by the rsp_crash microcode. It
+// mirrors rsp_snapshot_t in libdragon.
+type Snapshot struct {
+	GPR  [32]uint32
+	VPR  [32][8]uint16
+	Acc  [3][8]uint16 // high, middle and low slice of the accumulator
+	COP0 [16]uint32
+	COP2 [3]uint32 // VCO, VCC, VCE
+	PC   cpu.Addr
+	DMEM [0x1000]byte
+	IMEM [0x1000]byte
+
+	queue     []byte // command buffer in RDRAM around the current command
+	queueAddr cpu.Addr
+}
+
+// TakeSnapshot halts the RSP and dumps its registers and memories. Since this
+// overwrites IMEM, [Reset] must be called before using the rspq again.
+func TakeSnapshot() *Snapshot {
+	s := &Snapshot{}
+	rsp.Halt()
+	s.PC = rsp.PC()
+
+	buf := cpu.MakePaddedSlice[byte](0x1000)
+	for _, mem := range []struct {
+		m   rsp.Memory
+		dst []byte
+	}{{rsp.DMEM, s.DMEM[:]}, {rsp.IMEM, s.IMEM[:]}} {
+		if _, err := mem.m.ReadAt(buf, 0); err != nil {
+			panic(err)
+		}
+		copy(mem.dst, buf)
+	}
+
+	r, err := rspQueueFiles.Open("rsp_crash.ucode")
+	if err != nil {
+		panic(err)
+	}
+	uc, err := ucode.Load(r)
+	if err != nil {
+		panic(err)
+	}
+	rsp.Load(uc)
+	rsp.Resume()
+	for !rsp.Stopped() {
+		// wait
+	}
+
+	regs := buf[:764]
+	if _, err := rsp.DMEM.ReadAt(regs, 0); err != nil {
+		panic(err)
+	}
+	dump := struct {
+		GPR  [32]uint32
+		VPR  [32][8]uint16
+		Acc  [3][8]uint16
+		COP0 [16]uint32
+		COP2 [3]uint32
+	}{}
+	err = binary.Read(bytes.NewReader(regs), binary.BigEndian, &dump)
+	if err != nil {
+		panic(err)
+	}
+	s.GPR, s.VPR, s.Acc, s.COP0, s.COP2 = dump.GPR, dump.VPR, dump.Acc, dump.COP0, dump.COP2
+	s.copyQueue()
+	return s
+}
+
+// copyQueue saves the commands preceding and following the current one, if
+// it's in one of the rspq buffers.
+func (s *Snapshot) copyQueue() {
+	dramAddr := cpu.Addr(binary.BigEndian.Uint32(s.DMEM[rspqDataAddress+unsafe.Offsetof(rspqData.RSPQDramAddr):]))
+	cur := dramAddr + cpu.Addr(s.GPR[28])
+	for _, ctx := range []*context{lowpri, highpri} {
+		for _, buf := range ctx.buffers {
+			start := cpu.PhysicalAddressSlice(buf)
+			if cur < start || cur >= start+cpu.Addr(len(buf)*4) {
+				continue
+			}
+			first := max(int(cur-start)/4-32, 0) &^ 7
+			words := cpu.UncachedSlice(buf)[first:min(first+64, len(buf))]
+			s.queueAddr = start + cpu.Addr(first*4)
+			s.queue = make([]byte, 0, len(words)*4)
+			for _, w := range words {
+				s.queue = binary.BigEndian.AppendUint32(s.queue, w)
+			}
+			return
+		}
+	}
+}
+
+// Assertion returns the assertion code, if the RSP halted in the assertion
+// loop of rsp_queue.
+func (s *Snapshot) Assertion() (code uint16, ok bool) {
+	pc := int(s.PC & 0xffc)
+	if pc+8 > len(s.IMEM) || binary.BigEndian.Uint32(s.IMEM[pc+4:]) != 0x00ba000d {
+		return 0, false
+	}
+	return uint16(s.GPR[1] >> 16), true
+}
+
+var gprNames = [32]string{
+	"zr", "at", "v0", "v1", "a0", "a1", "a2", "a3",
+	"t0", "t1", "t2", "t3", "t4", "t5", "t6", "t7",
+	"s0", "s1", "s2", "s3", "s4", "s5", "s6", "s7",
+	"t8", "t9", "k0", "k1", "gp", "sp", "fp", "ra",
+}
+
+var cop0Names = [16]string{
+	"MEM_ADDR", "DRAM_ADDR", "RD_LEN", "WR_LEN",
+	"STATUS", "DMA_FULL", "DMA_BUSY", "SEMAPHORE",
+	"DP_START", "DP_END", "DP_CURRENT", "DP_STATUS",
+	"DP_CLOCK", "DP_BUSY", "DP_PIPE_BUSY", "DP_TMEM",
+}
+
+// Report writes a human readable report of the snapshot, including the state
+// of the rspq as found in DMEM.
+func (s *Snapshot) Report(w io.Writer) {
+	fmt.Fprintf(w, "RSP CRASH @ PC=%#03x", s.PC&0xffc)
+	if code, ok := s.Assertion(); ok {
+		fmt.Fprintf(w, " | ASSERTION %#04x", code)
+		if msg, ok := assertCodes[code]; ok {
+			fmt.Fprintf(w, " (%s)", msg)
+		}
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "GP registers:")
+	for i, v := range s.GPR {
+		fmt.Fprintf(w, "%s:%08x", gprNames[i], v)
+		if i%8 == 7 {
+			fmt.Fprintln(w)
+		} else {
+			fmt.Fprint(w, " ")
+		}
+	}
+
+	fmt.Fprintln(w, "VU registers:")
+	for i, v := range s.VPR {
+		fmt.Fprintf(w, "$v%02d: %04x\n", i, v)
+	}
+	for i, name := range []string{"hi", "md", "lo"} {
+		fmt.Fprintf(w, "acc%s: %04x\n", name, s.Acc[i])
+	}
+	fmt.Fprintf(w, "vco:%04x vcc:%04x vce:%02x\n", uint16(s.COP2[0]), uint16(s.COP2[1]), uint8(s.COP2[2]))
+
+	fmt.Fprintln(w, "COP0 registers:")
+	for i, v := range s.COP0 {
+		fmt.Fprintf(w, "%-12s %08x", cop0Names[i], v)
+		if i%4 == 3 {
+			fmt.Fprintln(w)
+		} else {
+			fmt.Fprint(w, "  ")
+		}
+	}
+
+	s.reportQueue(w)
+}
+
+func (s *Snapshot) reportQueue(w io.Writer) {
+	var q rspQueue
+	r := bytes.NewReader(s.DMEM[rspqDataAddress:])
+	if err := binary.Read(r, binary.BigEndian, &q); err != nil {
+		fmt.Fprintln(w, "RSPQ: invalid state:", err)
+		return
+	}
+	gp := s.GPR[28]
+	cur := q.RSPQDramAddr + cpu.Addr(gp)
+
+	fmt.Fprintf(w, "RSPQ: Normal  DRAM address: %08x\n", q.RSPQDramLowpriAddr)
+	fmt.Fprintf(w, "RSPQ: Highpri DRAM address: %08x\n", q.RSPQDramHighpriAddr)
+	fmt.Fprintf(w, "RSPQ: Current DRAM address: %08x + GP=%x = %08x\n", q.RSPQDramAddr, gp, cur)
+	fmt.Fprintf(w, "RSPQ: RDP buffers: %08x %08x, current %08x, sentinel %08x\n",
+		q.RSPQRdpBuffers[0], q.RSPQRdpBuffers[1], q.RSPQRdpCurrent, q.RSPQRdpSentinel)
+	fmt.Fprintf(w, "RSPQ: Current overlay: %s\n", overlayName(&q))
+
+	fmt.Fprintln(w, "RSPQ: Command queue (DMEM):")
+	writeWords(w, s.DMEM[dmemBuffer:dmemBuffer+dmemBufferSize], gp)
+	if s.queue != nil {
+		fmt.Fprintf(w, "RSPQ: Command queue (RDRAM %08x):\n", s.queueAddr)
+		writeWords(w, s.queue, uint32(cur-s.queueAddr))
+	}
+}
+
+// writeWords dumps p as words, eight per line. The word at offset mark is
+// followed by an asterisk.
+func writeWords(w io.Writer, p []byte, mark uint32) {
+	for i := 0; i+4 <= len(p); i += 4 {
+		sep := " "
+		if uint32(i) == mark {
+			sep = "*"
+		}
+		if i%32 == 28 || i+8 > len(p) {
+			sep += "\n"
+		}
+		fmt.Fprintf(w, "%08x%s", binary.BigEndian.Uint32(p[i:]), sep)
+	}
+}
+
+// overlayName describes the overlay currently loaded by rsp_queue, along with
+// the id returned by [Register].
+func overlayName(q *rspQueue) string {
+	ovl := int(q.CurrentOvl)
+	if ovl == 0 {
+		return "none"
+	}
+	size := int(unsafe.Sizeof(q.Tables.OverlayDescriptor[0]))
+	idx := ovl / size
+	if ovl%size != 0 || idx >= len(q.Tables.OverlayDescriptor) {
+		return fmt.Sprintf("invalid (%#x)", ovl)
+	}
+
+	var ids []string
+	for id, o := range q.Tables.OverlayTable {
+		if int(o) == ovl {
+			ids = append(ids, fmt.Sprintf("%#08x", uint32(id)<<28))
+		}
+	}
+	name := "unknown"
+	code := q.Tables.OverlayDescriptor[idx].Code
+	if o := overlays[idx]; o != nil && cpu.PhysicalAddressSlice(o.uc.Text[rspqTextSize:]) == code {
+		name = o.uc.Name
+	}
+	return fmt.Sprintf("%s, id %s", name, strings.Join(ids, ", "))
+}
+
+// CrashError is returned by [Check] if the RSP crashed.
+type CrashError struct {
+	*Snapshot
+}
+
+func (e *CrashError) Error() string {
+	var b strings.Builder
+	e.Report(&b)
+	return b.String()
+}
+
+// Check returns a [CrashError] with a snapshot of the RSP if the rspq crashed,
+// otherwise nil.
+func Check() error {
+	if !Crashed() {
+		return nil
+	}
+	return &CrashError{TakeSnapshot()}
+}
@@ -12,7 +12,6 @@ import (
 	"github.com/clktmr/n64/drivers/cartfs"
 	"github.com/clktmr/n64/drivers/rspq"
 	"github.com/clktmr/n64/rcp/cpu"
-	"github.com/clktmr/n64/rcp/rsp"
 	"github.com/clktmr/n64/rcp/rsp/ucode"
 )
 
@@ -26,10 +25,7 @@ var (
 	rspMixerId     uint32
 )
 
-const (
-	cmdExec             rspq.Command = 0x0
-	cmdVADPCMDecompress rspq.Command = 0x1
-)
+//go:generate go tool n64go ucode gen -cmd Exec -cmd VADPCMDecompress rsp_mixer.ucode
 
 const MaxChannels = 32
 
@@ -125,7 +121,10 @@ func Init() {
 	if err != nil {
 		panic(err)
 	}
-	rspMixerId = rspq.Register(uc)
+	rspMixerId, err = rspq.Register(uc)
+	if err != nil {
+		panic(err)
+	}
 }
 
 // SetSampleRate sets the sample rate of the mixers output. All inputs will be
@@ -158,7 +157,7 @@ type Reader struct{}
 
 const loopOverread = 64
 
-// exec queues an [cmdExec] command to the rspq.
+// exec queues an [cmdExec] command to the rspq's highpri queue.
 func exec(volume float32, channels int, dst []byte) {
 	debug.Assert(cpu.PhysicalAddressSlice(dst)&0xf == 0, "buffer alignment")
 	debug.Assert(cpu.PhysicalAddress(state.Value())&0xf == 0, "settings alignment")
@@ -166,7 +165,7 @@ func exec(volume float32, channels int, dst []byte) {
 	state.Writeback()
 	state.Invalidate()
 
-	rspq.Write(cmdExec|rspq.Command(rspMixerId>>24),
+	rspq.HighpriWrite(cmdExec|rspq.Command(rspMixerId>>24),
 		uint32(uint16(volume*0xffff)),
 		uint32((len(dst)>>2)<<16|channels),
 		uint32(cpu.PhysicalAddressSlice(dst)),
@@ -237,13 +236,10 @@ func (b *Reader) Read(p []byte) (n int, err error) {
 	}
 
 	cpu.InvalidateSlice(p)
+	rspq.HighpriBegin()
 	exec(volume, numChannels+1, p)
-	for !rsp.Stopped() {
-		// wait
-	}
-	if rspq.Crashed() {
-		panic("rsp crash")
-	}
+	rspq.HighpriEnd()
+	rspq.HighpriSync()
 	inputsBuf.Free()
 
 	return len(p), nil
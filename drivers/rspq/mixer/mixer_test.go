@@ -14,6 +14,7 @@ import (
 	"github.com/clktmr/n64/drivers/controller"
 	"github.com/clktmr/n64/drivers/rspq"
 	"github.com/clktmr/n64/drivers/rspq/mixer"
+	"github.com/clktmr/n64/drivers/rspq/mixer/vadpcm"
 	"github.com/clktmr/n64/rcp/audio"
 	"github.com/clktmr/n64/rcp/cpu"
 	"github.com/clktmr/n64/rcp/serial/joybus"
@@ -24,6 +25,7 @@ var (
 	//go:embed testdata/sfx_alarm_loop3.pcm_s16be
 	//go:embed testdata/sfx_wpn_cannon2.pcm_s16be
 	//go:embed testdata/sfx_wpn_machinegun_loop1.pcm_s16be
+	//go:embed testdata/sfx_wpn_cannon2.vadpcm
 	_testdata embed.FS
 	testdata  cartfs.FS = cartfs.Embed(_testdata)
 )
@@ -138,3 +140,67 @@ func TestMixing(t *testing.T) {
 		t.Error("got", result)
 	}
 }
+
+func TestVADPCM(t *testing.T) {
+	rspq.Reset()
+	mixer.Init()
+
+	f, err := testdata.Open("testdata/sfx_wpn_cannon2.vadpcm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := mixer.NewVADPCM(f.(io.ReadSeeker))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec.SampleRate() != 44100 {
+		t.Fatalf("unexpected sample rate %d", dec.SampleRate())
+	}
+	got, err := io.ReadAll(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decode on the CPU for reference
+	f.(io.Seeker).Seek(0, io.SeekStart)
+	hdr, err := vadpcm.ReadHeader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, err := vadpcm.NewDecoder(hdr.Codebook).Decode(nil, frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := binary.Append(nil, binary.BigEndian, samples[:hdr.Samples])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d bytes, want %d", len(got), len(want))
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("rsp and cpu decoding differ")
+	}
+
+	// Seek back a little, as the mixer does, and to the start.
+	for _, off := range []int64{int64(len(want)) - 100, 0, 12345} {
+		if _, err := dec.Seek(off, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4096)
+		n, err := io.ReadFull(dec, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], want[off:min(off+4096, int64(len(want)))]) {
+			t.Fatalf("offset %d: decoded data differs", off)
+		}
+	}
+	if size, _ := dec.Seek(0, io.SeekEnd); size != int64(len(want)) {
+		t.Fatalf("got size %d, want %d", size, len(want))
+	}
+}
@@ -0,0 +1,19 @@
+// Code generated by "n64go ucode gen" from rsp_mixer.ucode; DO NOT EDIT.
+
+package mixer
+
+import (
+	"github.com/clktmr/n64/drivers/rspq"
+)
+
+// Commands of rsp_mixer.ucode
+const (
+	cmdExec             rspq.Command = 0x00 // 16 bytes
+	cmdVADPCMDecompress rspq.Command = 0x01 // 16 bytes
+)
+
+// DMEM offsets of rsp_mixer.ucode
+const (
+	dmemOverlayHeader = 0x260
+	dmemState         = 0x2a0
+)
@@ -0,0 +1,156 @@
+package mixer
+
+import (
+	"io"
+
+	"github.com/clktmr/n64/drivers/rspq"
+	"github.com/clktmr/n64/drivers/rspq/mixer/vadpcm"
+	"github.com/clktmr/n64/rcp/cpu"
+)
+
+// Frames decoded per command. The ucode decodes in place, so a chunk must fit
+// into the remaining DMEM.
+const (
+	vadpcmChunkFrames = 64
+	vadpcmChunkSize   = vadpcmChunkFrames * vadpcm.FrameSamples << bps
+)
+
+// VADPCM decodes a VADPCM stream, as created by "n64go audio", on the RSP. It
+// implements io.ReadSeeker, yielding 16 bit PCM to be played by a [Source]:
+//
+//	dec, err := mixer.NewVADPCM(f)
+//	...
+//	src := mixer.NewSource(mixer.Loop(dec), dec.SampleRate())
+//
+// The stream is decoded in chunks while reading. The last two chunks are
+// kept, seeking backwards beyond them restarts decoding from the beginning of
+// the stream.
+type VADPCM struct {
+	r   io.ReadSeeker
+	hdr *vadpcm.Header
+
+	book  *cpu.Padded[[vadpcm.MaxPredictors]vadpcm.Predictor, cpu.Align16]
+	state *cpu.Padded[[2][8]int16, cpu.Align16]
+
+	buf   []byte // decoded samples, starting at offset start of the stream
+	start int64
+	n     int // number of decoded bytes in buf
+	frame int // next frame to decode
+	off   int64
+}
+
+// NewVADPCM reads the header of the VADPCM file r and returns a decoder for
+// it. [Init] must be called before reading from the decoder.
+func NewVADPCM(r io.ReadSeeker) (*VADPCM, error) {
+	hdr, err := vadpcm.ReadHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	v := &VADPCM{
+		r:     r,
+		hdr:   hdr,
+		book:  cpu.NewPadded[[vadpcm.MaxPredictors]vadpcm.Predictor, cpu.Align16](),
+		state: cpu.NewPadded[[2][8]int16, cpu.Align16](),
+		buf:   cpu.MakePaddedSliceAligned[byte](2*vadpcmChunkSize, 16),
+	}
+	copy(v.book.Value()[:], hdr.Codebook)
+	v.book.Writeback()
+	v.state.Writeback()
+	return v, nil
+}
+
+// SampleRate returns the sample rate of the stream.
+func (v *VADPCM) SampleRate() uint {
+	return uint(v.hdr.SampleRate)
+}
+
+func (v *VADPCM) size() int64 {
+	return int64(v.hdr.Samples) << bps
+}
+
+// rewind restarts decoding from the beginning of the stream.
+func (v *VADPCM) rewind() error {
+	_, err := v.r.Seek(int64(v.hdr.Size()), io.SeekStart)
+	if err != nil {
+		return err
+	}
+	*v.state.Value() = [2][8]int16{}
+	v.state.Writeback()
+	v.start, v.n, v.frame = 0, 0, 0
+	return nil
+}
+
+// decode decodes the next chunk, dropping all but the last decoded chunk from
+// buf.
+func (v *VADPCM) decode() error {
+	if v.n > vadpcmChunkSize {
+		drop := v.n - vadpcmChunkSize
+		copy(v.buf, v.buf[drop:v.n])
+		v.start += int64(drop)
+		v.n -= drop
+	}
+
+	frames := min(vadpcmChunkFrames, v.hdr.Frames()-v.frame)
+	dst := v.buf[v.n : v.n+frames*vadpcm.FrameSamples<<bps]
+	in := dst[(len(dst)-frames*vadpcm.FrameSize)&^7:][:frames*vadpcm.FrameSize]
+	if _, err := io.ReadFull(v.r, in); err != nil {
+		return err
+	}
+	cpu.WritebackSlice(dst)
+
+	rspq.HighpriBegin()
+	rspq.HighpriWrite(cmdVADPCMDecompress|rspq.Command(rspMixerId>>24),
+		uint32(cpu.PhysicalAddressSlice(in)),
+		uint32(frames-1)<<24|uint32(cpu.PhysicalAddressSlice(dst)),
+		uint32(cpu.PhysicalAddress(v.state.Value())),
+		uint32(cpu.PhysicalAddress(v.book.Value())))
+	rspq.HighpriEnd()
+	rspq.HighpriSync()
+
+	cpu.InvalidateSlice(dst)
+	v.n += len(dst)
+	v.frame += frames
+	return nil
+}
+
+func (v *VADPCM) Read(p []byte) (n int, err error) {
+	if v.off >= v.size() {
+		return 0, io.EOF
+	}
+	if v.off < v.start {
+		if err = v.rewind(); err != nil {
+			return
+		}
+	}
+	for n < len(p) && v.off < v.size() {
+		if v.off >= v.start+int64(v.n) {
+			if err = v.decode(); err != nil {
+				return
+			}
+			continue
+		}
+		end := min(int64(v.n), v.size()-v.start)
+		nn := copy(p[n:], v.buf[v.off-v.start:end])
+		n += nn
+		v.off += int64(nn)
+	}
+	return
+}
+
+func (v *VADPCM) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	default:
+		return 0, errWhence
+	case io.SeekStart:
+		// do nothing
+	case io.SeekCurrent:
+		offset += v.off
+	case io.SeekEnd:
+		offset += v.size()
+	}
+	if offset < 0 {
+		return 0, errOffset
+	}
+	v.off = offset
+	return offset, nil
+}
@@ -0,0 +1,225 @@
+package vadpcm
+
+import (
+	"io"
+	"math"
+)
+
+// maxScale is the largest scale exponent used by the encoder. Larger scales
+// saturate the residuals of the RSP.
+const maxScale = 12
+
+// stats holds the correlations of a frame, which are sufficient to compute the
+// squared prediction error of any order 2 predictor.
+type stats struct {
+	r0, r1, r2    float64 // x[n]*x[n], x[n]*x[n-1], x[n]*x[n-2]
+	r11, r12, r22 float64 // x[n-1]*x[n-1], x[n-1]*x[n-2], x[n-2]*x[n-2]
+}
+
+func (s *stats) add(o *stats) {
+	s.r0 += o.r0
+	s.r1 += o.r1
+	s.r2 += o.r2
+	s.r11 += o.r11
+	s.r12 += o.r12
+	s.r22 += o.r22
+}
+
+// predErr returns the squared prediction error of x[n] = c1*x[n-1] + c2*x[n-2].
+func (s *stats) predErr(c [2]float64) float64 {
+	return s.r0 - 2*(c[0]*s.r1+c[1]*s.r2) +
+		c[0]*c[0]*s.r11 + 2*c[0]*c[1]*s.r12 + c[1]*c[1]*s.r22
+}
+
+// solve returns the stable predictor minimizing the error.
+func (s *stats) solve() (c [2]float64) {
+	det := s.r11*s.r22 - s.r12*s.r12
+	if math.Abs(det) > 1e-9*s.r11*s.r22 && det != 0 {
+		c[0] = (s.r1*s.r22 - s.r2*s.r12) / det
+		c[1] = (s.r2*s.r11 - s.r1*s.r12) / det
+	} else if s.r11 != 0 {
+		c[0] = s.r1 / s.r11
+	}
+	// Keep the poles inside the unit circle
+	c[1] = min(max(c[1], -0.97), 0.97)
+	lim := (1 - c[1]) * 0.995
+	c[0] = min(max(c[0], -lim), lim)
+	return
+}
+
+// predictor converts the coefficients to their fixed point impulse responses.
+func predictor(c [2]float64) (p Predictor) {
+	for k := range Order {
+		// Impulse response to the last (k=1) or second to last (k=0)
+		// sample, h holds the two most recent samples.
+		h := [2]float64{float64(k), float64(1 - k)}
+		for i := range 8 {
+			x := c[0]*h[0] + c[1]*h[1]
+			p[k][i] = clamp16(int64(math.Round(x * 2048)))
+			h[0], h[1] = x, h[0]
+		}
+	}
+	return
+}
+
+// NewCodebook returns a codebook of up to n predictors suitable for encoding
+// samples. The predictors are found by clustering the frames by their optimal
+// predictor.
+func NewCodebook(samples []int16, n int) Codebook {
+	n = min(max(n, 1), MaxPredictors)
+
+	var frames []stats
+	var total stats
+	for f := 0; f < len(samples); f += FrameSamples {
+		var s stats
+		for i := f; i < min(f+FrameSamples, len(samples)); i++ {
+			x := float64(samples[i])
+			var x1, x2 float64
+			if i >= 1 {
+				x1 = float64(samples[i-1])
+			}
+			if i >= 2 {
+				x2 = float64(samples[i-2])
+			}
+			s.r0 += x * x
+			s.r1 += x * x1
+			s.r2 += x * x2
+			s.r11 += x1 * x1
+			s.r12 += x1 * x2
+			s.r22 += x2 * x2
+		}
+		if s.r0 != 0 {
+			frames = append(frames, s)
+		}
+		total.add(&s)
+	}
+
+	// Split each predictor in two until there are enough of them and
+	// refine them after each split, see Linde-Buzo-Gray algorithm.
+	coeffs := [][2]float64{total.solve()}
+	assigned := make([]int, len(frames))
+	for len(coeffs) < n {
+		for _, c := range coeffs {
+			if len(coeffs) == n {
+				break
+			}
+			coeffs = append(coeffs, [2]float64{c[0]*0.95 - 0.1, c[1]*0.95 + 0.05})
+		}
+		for range 16 {
+			changed := false
+			for i := range frames {
+				best := 0
+				for j, c := range coeffs {
+					if frames[i].predErr(c) < frames[i].predErr(coeffs[best]) {
+						best = j
+					}
+				}
+				changed = changed || assigned[i] != best
+				assigned[i] = best
+			}
+			for j := range coeffs {
+				var s stats
+				for i := range frames {
+					if assigned[i] == j {
+						s.add(&frames[i])
+					}
+				}
+				if s.r0 != 0 {
+					coeffs[j] = s.solve()
+				}
+			}
+			if !changed {
+				break
+			}
+		}
+	}
+
+	book := make(Codebook, 0, len(coeffs))
+	for _, c := range coeffs {
+		book = append(book, predictor(c))
+	}
+	return book
+}
+
+// Encoder encodes 16 bit PCM to VADPCM frames.
+type Encoder struct {
+	book Codebook
+	prev [Order]int16
+}
+
+// NewEncoder returns an Encoder using the predictors in book.
+func NewEncoder(book Codebook) *Encoder {
+	return &Encoder{book: book}
+}
+
+// Encode encodes src and appends the frames to dst. The last frame is padded
+// with silence if the length of src isn't a multiple of [FrameSamples].
+func (e *Encoder) Encode(dst []byte, src []int16) []byte {
+	for len(src) > 0 {
+		var x [FrameSamples]int16
+		src = src[copy(x[:], src):]
+
+		var best [FrameSize]byte
+		bestErr, bestPrev := int64(math.MaxInt64), e.prev
+		for idx := range e.book {
+			for scale := range maxScale + 1 {
+				frame, prev, err := e.encodeFrame(idx, scale, &x)
+				if err < bestErr {
+					best, bestErr, bestPrev = frame, err, prev
+				}
+			}
+		}
+		e.prev = bestPrev
+		dst = append(dst, best[:]...)
+	}
+	return dst
+}
+
+// encodeFrame encodes x using the given predictor and scale exponent. It
+// returns the frame, the decoder's history after decoding it and the squared
+// error of the decoded samples.
+func (e *Encoder) encodeFrame(idx, scale int, x *[FrameSamples]int16) (frame [FrameSize]byte, prev [Order]int16, err int64) {
+	p := &e.book[idx]
+	prev = e.prev
+	frame[0] = byte(scale<<4 | idx)
+	var res [FrameSamples]int64
+	for half := 0; half < FrameSamples; half += 8 {
+		for i := range 8 {
+			// The decoder outputs (prediction>>11) + residual
+			d := int64(x[half+i]) - predict(p, &prev, res[half:], i)>>11
+			n := floorDiv(2*d+1<<scale, 2<<scale)
+			n = min(max(n, -8), 7)
+			res[half+i] = n << scale
+			frame[1+(half+i)/2] |= byte(n&0xf) << (4 * (1 - i&1))
+		}
+		out := decodeHalf(p, &prev, res[half:])
+		for i, y := range out {
+			d := int64(y) - int64(x[half+i])
+			err += d * d
+		}
+	}
+	return
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if (a%b != 0) && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// Encode writes samples as VADPCM file to w, using a codebook of up to
+// predictors entries generated by [NewCodebook].
+func Encode(w io.Writer, samples []int16, sampleRate uint32, predictors int) error {
+	h := &Header{
+		SampleRate: sampleRate,
+		Samples:    uint32(len(samples)),
+		Codebook:   NewCodebook(samples, predictors),
+	}
+	if err := WriteHeader(w, h); err != nil {
+		return err
+	}
+	_, err := w.Write(NewEncoder(h.Codebook).Encode(nil, samples))
+	return err
+}
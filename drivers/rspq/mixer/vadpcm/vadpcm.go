@@ -0,0 +1,182 @@
+// Package vadpcm implements encoding and decoding of mono VADPCM audio, the
+// adaptive differential PCM format decoded by the mixer's RSP microcode.
+//
+// A VADPCM stream compresses 16 samples of 16 bit PCM into a frame of 9 bytes.
+// The first byte of a frame holds the scale exponent in its upper and the
+// index of the predictor in its lower nibble, followed by 16 signed 4 bit
+// residuals. Each sample is predicted from the two previous samples by one of
+// up to [MaxPredictors] predictors stored in the stream's codebook.
+//
+// A file starts with a header, followed by the codebook and the frames:
+//
+//	header:   "VADP" version predictors(1) reserved(2) samplerate(4) samples(4)
+//	codebook: predictors * [Predictor]
+//	frames:   ceil(samples / 16) * frame
+//
+// All multibyte values are big endian.
+package vadpcm
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	magic   = "VADP"
+	version = 1
+
+	HeaderSize = 16
+
+	FrameSize    = 9  // bytes per frame
+	FrameSamples = 16 // samples per frame
+
+	MaxPredictors = 8 // maximum number of predictors in a codebook
+	Order         = 2 // number of previous samples used for prediction
+)
+
+var (
+	ErrFormat    = errors.New("invalid vadpcm file")
+	ErrCodebook  = errors.New("invalid codebook")
+	ErrPredictor = errors.New("predictor out of range")
+)
+
+// Predictor is a single entry of a codebook in the layout expected by the RSP.
+// Predictor[0][i] and Predictor[1][i] are the weights of the second to last
+// and last sample of the previous half frame in the i-th sample of the current
+// half frame, scaled by 2048. Predictor[1] also weights the residuals of the
+// current half frame.
+type Predictor [Order][8]int16
+
+// Codebook is the set of predictors used by a stream.
+type Codebook []Predictor
+
+// Header describes a VADPCM stream.
+type Header struct {
+	SampleRate uint32
+	Samples    uint32 // number of decoded samples
+	Codebook   Codebook
+}
+
+// Frames returns the number of frames in the stream.
+func (h *Header) Frames() int {
+	return (int(h.Samples) + FrameSamples - 1) / FrameSamples
+}
+
+// Size returns the encoded size of the header including the codebook, which is
+// the offset of the first frame in the file.
+func (h *Header) Size() int {
+	return HeaderSize + len(h.Codebook)*binary.Size(Predictor{})
+}
+
+// ReadHeader reads the header and codebook of a VADPCM file.
+func ReadHeader(r io.Reader) (*Header, error) {
+	var b [HeaderSize]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, err
+	}
+	if string(b[:4]) != magic || b[4] != version {
+		return nil, ErrFormat
+	}
+	n := int(b[5])
+	if n == 0 || n > MaxPredictors {
+		return nil, ErrCodebook
+	}
+	h := &Header{
+		SampleRate: binary.BigEndian.Uint32(b[8:]),
+		Samples:    binary.BigEndian.Uint32(b[12:]),
+		Codebook:   make(Codebook, n),
+	}
+	if err := binary.Read(r, binary.BigEndian, h.Codebook); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// WriteHeader writes the header and codebook of a VADPCM file.
+func WriteHeader(w io.Writer, h *Header) error {
+	if len(h.Codebook) == 0 || len(h.Codebook) > MaxPredictors {
+		return ErrCodebook
+	}
+	var b [HeaderSize]byte
+	copy(b[:], magic)
+	b[4] = version
+	b[5] = byte(len(h.Codebook))
+	binary.BigEndian.PutUint32(b[8:], h.SampleRate)
+	binary.BigEndian.PutUint32(b[12:], h.Samples)
+	if _, err := w.Write(b[:]); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, h.Codebook)
+}
+
+func clamp16(x int64) int16 {
+	return int16(min(max(x, -0x8000), 0x7fff))
+}
+
+// residuals unpacks the scaled residuals of a frame. Like the RSP it saturates
+// the product and interprets a scale exponent of 15 as -32768.
+func residuals(frame []byte) (res [FrameSamples]int64) {
+	scale := int64(int16(1 << (frame[0] >> 4)))
+	for i, b := range frame[1:FrameSize] {
+		res[2*i] = int64(clamp16(int64(int8(b)>>4) * scale))
+		res[2*i+1] = int64(clamp16(int64(int8(b<<4)>>4) * scale))
+	}
+	return
+}
+
+// predict returns the prediction of sample i in a half frame given the previous
+// samples prev and the residuals of the preceding samples in res, scaled by
+// 2048.
+func predict(p *Predictor, prev *[Order]int16, res []int64, i int) int64 {
+	acc := int64(p[0][i])*int64(prev[0]) + int64(p[1][i])*int64(prev[1])
+	for j := range i {
+		acc += int64(p[1][i-j-1]) * res[j]
+	}
+	return acc
+}
+
+// decodeHalf decodes half a frame from its residuals and updates prev.
+func decodeHalf(p *Predictor, prev *[Order]int16, res []int64) (out [8]int16) {
+	for i := range out {
+		out[i] = clamp16((predict(p, prev, res, i) + res[i]<<11) >> 11)
+	}
+	*prev = [Order]int16{out[6], out[7]}
+	return
+}
+
+// Decoder decodes VADPCM frames bit exact to the RSP.
+type Decoder struct {
+	book Codebook
+	prev [Order]int16
+}
+
+// NewDecoder returns a Decoder using the predictors in book.
+func NewDecoder(book Codebook) *Decoder {
+	return &Decoder{book: book}
+}
+
+// Reset clears the history of previous samples, as required before decoding
+// from the start of a stream.
+func (d *Decoder) Reset() {
+	d.prev = [Order]int16{}
+}
+
+// Decode decodes the frames in src and appends the samples to dst.
+func (d *Decoder) Decode(dst []int16, src []byte) ([]int16, error) {
+	for ; len(src) >= FrameSize; src = src[FrameSize:] {
+		idx := int(src[0] & 0xf)
+		if idx >= len(d.book) {
+			return dst, ErrPredictor
+		}
+		res := residuals(src)
+		for half := 0; half < FrameSamples; half += 8 {
+			out := decodeHalf(&d.book[idx], &d.prev, res[half:])
+			dst = append(dst, out[:]...)
+		}
+	}
+	if len(src) != 0 {
+		return dst, io.ErrUnexpectedEOF
+	}
+	return dst, nil
+}
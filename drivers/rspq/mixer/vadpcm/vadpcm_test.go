@@ -0,0 +1,165 @@
+//go:build !n64
+
+package vadpcm_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/clktmr/n64/drivers/rspq/mixer/vadpcm"
+)
+
+// testSignal returns a chirp with some noise, which is hard to predict by a
+// single predictor.
+func testSignal(n int) []int16 {
+	rng := rand.New(rand.NewSource(1))
+	samples := make([]int16, n)
+	for i := range samples {
+		t := float64(i) / 22050
+		x := 12000*math.Sin(2*math.Pi*(100+2000*t)*t) + 4000*math.Sin(2*math.Pi*3000*t)
+		samples[i] = int16(x + rng.NormFloat64()*200)
+	}
+	return samples
+}
+
+func snr(want, got []int16) float64 {
+	var signal, noise float64
+	for i := range want {
+		d := float64(want[i]) - float64(got[i])
+		signal += float64(want[i]) * float64(want[i])
+		noise += d * d
+	}
+	return 10 * math.Log10(signal/noise)
+}
+
+func TestRoundtrip(t *testing.T) {
+	samples := testSignal(22050)
+	for _, n := range []int{1, 2, 4, vadpcm.MaxPredictors} {
+		var buf bytes.Buffer
+		if err := vadpcm.Encode(&buf, samples, 22050, n); err != nil {
+			t.Fatal(err)
+		}
+
+		h, err := vadpcm.ReadHeader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.SampleRate != 22050 || h.Samples != uint32(len(samples)) || len(h.Codebook) != n {
+			t.Fatalf("unexpected header %+v", h)
+		}
+		if buf.Len() != h.Frames()*vadpcm.FrameSize {
+			t.Fatalf("%d predictors: got %d bytes of frames, want %d", n, buf.Len(), h.Frames()*vadpcm.FrameSize)
+		}
+
+		got, err := vadpcm.NewDecoder(h.Codebook).Decode(nil, buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != h.Frames()*vadpcm.FrameSamples {
+			t.Fatalf("decoded %d samples, want %d", len(got), h.Frames()*vadpcm.FrameSamples)
+		}
+		if db := snr(samples, got[:len(samples)]); db < 20 {
+			t.Errorf("%d predictors: snr %.1fdB too low", n, db)
+		} else {
+			t.Logf("%d predictors: snr %.1fdB", n, db)
+		}
+	}
+}
+
+func TestEncoderStreaming(t *testing.T) {
+	samples := testSignal(1000)
+	book := vadpcm.NewCodebook(samples, 4)
+	want := vadpcm.NewEncoder(book).Encode(nil, samples)
+
+	var got []byte
+	enc := vadpcm.NewEncoder(book)
+	for chunk := range slices.Chunk(samples[:992], 32) {
+		got = enc.Encode(got, chunk)
+	}
+	got = enc.Encode(got, samples[992:])
+	if !bytes.Equal(got, want) {
+		t.Fatal("chunked encoding differs")
+	}
+
+	// Decoding must be resumable at any frame
+	dec := vadpcm.NewDecoder(book)
+	all, _ := dec.Decode(nil, want)
+	dec.Reset()
+	var parts []int16
+	for chunk := range slices.Chunk(want, 5*vadpcm.FrameSize) {
+		var err error
+		parts, err = dec.Decode(parts, chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !slices.Equal(all, parts) {
+		t.Fatal("chunked decoding differs")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	// The first predictor repeats the last sample, the second one predicts
+	// silence.
+	var hold vadpcm.Predictor
+	for i := range hold[1] {
+		hold[1][i] = 2048
+	}
+	dec := vadpcm.NewDecoder(vadpcm.Codebook{hold, {}})
+	frames := []byte{
+		0x20, 0x10, 0, 0, 0, 0, 0, 0, 0xf0, // +4, then -4 at sample 14
+		0x31, 0x7f, 0x80, 0, 0, 0, 0, 0, 0, // 56, -8, -64
+	}
+	got, err := dec.Decode(nil, frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int16{
+		4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 0, 0,
+		56, -8, -64, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if _, err := dec.Decode(nil, []byte{0x02, 0, 0, 0, 0, 0, 0, 0, 0}); err != vadpcm.ErrPredictor {
+		t.Errorf("expected ErrPredictor, got %v", err)
+	}
+	if _, err := dec.Decode(nil, frames[:10]); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	if _, err := vadpcm.ReadHeader(bytes.NewReader([]byte("RIFF\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"))); err != vadpcm.ErrFormat {
+		t.Errorf("expected ErrFormat, got %v", err)
+	}
+	if err := vadpcm.WriteHeader(io.Discard, &vadpcm.Header{}); err != vadpcm.ErrCodebook {
+		t.Errorf("expected ErrCodebook, got %v", err)
+	}
+	h := &vadpcm.Header{SampleRate: 8000, Samples: 17, Codebook: make(vadpcm.Codebook, 3)}
+	h.Codebook[2][1][7] = -1234
+	var buf bytes.Buffer
+	if err := vadpcm.WriteHeader(&buf, h); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != h.Size() {
+		t.Fatalf("header size %d, want %d", buf.Len(), h.Size())
+	}
+	data := buf.Bytes()
+	got, err := vadpcm.ReadHeader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SampleRate != h.SampleRate || got.Samples != h.Samples || !slices.Equal(got.Codebook, h.Codebook) || got.Frames() != 2 {
+		t.Fatalf("got %+v, want %+v", got, h)
+	}
+	if _, err := vadpcm.ReadHeader(bytes.NewReader(data[:20])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected ErrUnexpectedEOF, got %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package rspq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"unsafe"
+
+	"github.com/clktmr/n64/debug"
+	"github.com/clktmr/n64/rcp/cpu"
+	"github.com/clktmr/n64/rcp/rsp"
+	"github.com/clktmr/n64/rcp/rsp/ucode"
+)
+
+const (
+	maxOverlayCount        = 8
+	overlayIdCount         = 16
+	maxOverlayCommandCount = ((maxOverlayCount - 1) * 16)
+)
+
+var (
+	ErrOverlayCount = errors.New("rspq: too many overlays")
+	ErrCommandCount = errors.New("rspq: no free overlay ids")
+	ErrNotFound     = errors.New("rspq: overlay not registered")
+)
+
+// Offset of CurrentOvl in DMEM
+const currentOvlAddress = rspqDataAddress + unsafe.Offsetof(rspqData.CurrentOvl)
+
+type overlay struct {
+	uc       *ucode.UCode
+	id       int // first of the assigned ids
+	commands int
+
+	// Keeps a reference to the overlay to prevent it being garbage
+	// collected, since the RSP will need to load it whenever processing a
+	// command.
+	pinner cpu.Pinner
+}
+
+func (o *overlay) slots() int { return (o.commands + 15) >> 4 }
+
+// overlays are the registered overlays, indexed like
+// rspqData.Tables.OverlayDescriptor.
+var overlays [maxOverlayCount]*overlay
+
+// tables is the copy of rspqData.Tables loaded into DMEM by the RSP.
+var tables = cpu.MakePaddedSliceAligned[byte](binary.Size(&rspqData.Tables), 8)
+
+// Register makes the commands of an overlay available to the rspq. The
+// returned id must be ORed with the overlay's command index to get the
+// [Command] passed to [Write]. Overlays with more than 16 commands use
+// multiple ids.
+func Register(p *ucode.UCode) (overlayId uint32, err error) {
+	mtx.Lock()
+	defer mtx.Unlock()
+	debug.Assert(block == nil, "rspq: register while recording a block")
+
+	r := bytes.NewReader(p.Data[rspqDataSize:])
+	hdr, err := loadOverlayHeader(r)
+	if err != nil {
+		return 0, err
+	}
+
+	idx := slices.IndexFunc(rspqData.Tables.OverlayDescriptor[1:], func(o overlayDescriptor) bool {
+		return o.Code == 0
+	})
+	if idx == -1 {
+		return 0, ErrOverlayCount
+	}
+	idx += 1
+
+	slotCount := (len(hdr.Commands) + 15) >> 4
+
+	id := bytes.Index(rspqData.Tables.OverlayTable[1:], make([]byte, slotCount))
+	if id == -1 {
+		return 0, ErrCommandCount
+	}
+	id += 1
+
+	desc := &rspqData.Tables.OverlayDescriptor[idx]
+	code := p.Text[rspqTextSize:]
+	data := p.Data[rspqDataSize:]
+	state := p.Data[hdr.Fields.StateStart : hdr.Fields.StateStart+hdr.Fields.StateSize]
+	desc.Code = cpu.PhysicalAddressSlice(code)
+	desc.Data = cpu.PhysicalAddressSlice(data)
+	desc.CodeSize = uint16(len(code))
+	desc.DataSize = uint16(len(data))
+	desc.State = cpu.PhysicalAddressSlice(state)
+
+	// Let the assigned ids point at the overlay
+	for i := range slotCount {
+		rspqData.Tables.OverlayTable[id+i] = uint8(idx * int(unsafe.Sizeof(rspqData.Tables.OverlayDescriptor[0])))
+	}
+	hdr.Fields.CommandBase = uint16(id << 5)
+	err = hdr.Store(bytes.NewBuffer(p.Data[rspqDataSize:rspqDataSize]))
+	if err != nil {
+		return 0, err
+	}
+
+	o := &overlay{uc: p, id: id, commands: len(hdr.Commands)}
+	o.pinner.Pin(unsafe.SliceData(p.Text))
+	o.pinner.Pin(unsafe.SliceData(p.Data))
+	overlays[idx] = o
+
+	cpu.WritebackSlice(p.Text)
+	cpu.WritebackSlice(p.Data)
+
+	updateTables()
+
+	return uint32(id << 28), nil
+}
+
+// Unregister removes the overlay registered with overlayId. It blocks until
+// the RSP has processed all commands enqueued so far, including those of the
+// overlay, and the queue is idle. Afterwards the overlay's ids may be assigned
+// to another overlay.
+func Unregister(overlayId uint32) error {
+	mtx.Lock()
+	defer mtx.Unlock()
+	debug.Assert(block == nil, "rspq: unregister while recording a block")
+
+	id := int(overlayId >> 28)
+	ovl := int(rspqData.Tables.OverlayTable[id])
+	idx := ovl / int(unsafe.Sizeof(rspqData.Tables.OverlayDescriptor[0]))
+	o := overlays[idx]
+	if id == 0 || idx == 0 || o == nil || o.id != id {
+		return ErrNotFound
+	}
+
+	WaitSyncpoint(syncpoint())
+	rsp.Halt()
+
+	// The rsp_queue doesn't reload an overlay if its index matches the
+	// current one, so make sure a new overlay with the same index isn't
+	// mistaken for the unregistered one.
+	var current [2]byte
+	_, err := rsp.DMEM.ReadAt(current[:], int64(currentOvlAddress))
+	if err != nil {
+		return err
+	}
+	if int(binary.BigEndian.Uint16(current[:])) == ovl {
+		_, err = rsp.DMEM.WriteAt(make([]byte, 2), int64(currentOvlAddress))
+		if err != nil {
+			return err
+		}
+	}
+	rsp.Resume()
+
+	rspqData.Tables.OverlayDescriptor[idx] = overlayDescriptor{}
+	for i := range o.slots() {
+		rspqData.Tables.OverlayTable[id+i] = 0
+	}
+	updateTables()
+
+	overlays[idx] = nil
+	o.pinner.Unpin()
+	return nil
+}
+
+// updateTables lets the RSP load the updated tables, so the queue keeps
+// running. A pending load of an earlier update might load the new tables too,
+// which doesn't harm since they only differ in unused entries.
+func updateTables() {
+	_, err := binary.Encode(tables, binary.BigEndian, &rspqData.Tables)
+	if err != nil {
+		panic(err)
+	}
+	cpu.WritebackSlice(tables)
+	dma(tables, rspqDataAddress, uint32(len(tables)), dmaBusyOrFull)
+}
+
+// OverlayInfo describes a registered overlay.
+type OverlayInfo struct {
+	ID       uint32 // as returned by [Register]
+	UCode    *ucode.UCode
+	Commands int // number of commands, starting at Command(ID >> 24)
+}
+
+// Overlays returns all registered overlays, ordered by id.
+func Overlays() (infos []OverlayInfo) {
+	for _, o := range overlays {
+		if o != nil {
+			infos = append(infos, OverlayInfo{uint32(o.id) << 28, o.uc, o.commands})
+		}
+	}
+	slices.SortFunc(infos, func(a, b OverlayInfo) int { return int(a.ID>>28) - int(b.ID>>28) })
+	return
+}
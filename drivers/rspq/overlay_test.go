@@ -0,0 +1,109 @@
+package rspq_test
+
+import (
+	"slices"
+	"testing"
+	"unsafe"
+
+	"github.com/clktmr/n64/drivers/rspq"
+	"github.com/clktmr/n64/drivers/rspq/vec"
+	"github.com/clktmr/n64/rcp/cpu"
+)
+
+var vecs = [2]vec.Vec4{{0.1, -0.2, 0.3, -0.4}, {0.5, -0.6, -0.7, 0.8}}
+
+// overlayIDs returns the ids of all registered overlays.
+func overlayIDs() (ids []uint32) {
+	for _, info := range rspq.Overlays() {
+		ids = append(ids, info.ID)
+	}
+	return
+}
+
+// vecRoundtrip loads vecs into DMEM and stores them back using the rsp_vec
+// overlay registered as id. The commands are written like [vec.Load] and
+// [vec.Store] do, which only use the overlay registered by [vec.Init].
+func vecRoundtrip(t *testing.T, id uint32) {
+	t.Helper()
+	const (
+		cmdLoad  = 0x0
+		cmdStore = 0x1
+	)
+	src := vec.MakeSlots(1)
+	src[0].Set(&vecs)
+	dst := vec.MakeSlots(1)
+	const slotSize = int(unsafe.Sizeof(vec.Slot{}))
+	size := uint32(slotSize-1) << 16
+
+	cpu.WritebackSlice(unsafe.Slice((*byte)(unsafe.Pointer(&src[0])), slotSize))
+	cpu.InvalidateSlice(unsafe.Slice((*byte)(unsafe.Pointer(&dst[0])), slotSize))
+	rspq.Write(cmdLoad|rspq.Command(id>>24), uint32(cpu.PhysicalAddressSlice(src)), size)
+	rspq.Write(cmdStore|rspq.Command(id>>24), uint32(cpu.PhysicalAddressSlice(dst)), size)
+
+	rspq.WaitSyncpoint(rspq.Syncpoint())
+	if rspq.Crashed() {
+		t.Fatal("rspq crashed")
+	}
+	if dst[0] != src[0] {
+		t.Fatalf("overlay %#x: got %v, want %v", id, dst[0].Get(), src[0].Get())
+	}
+}
+
+func TestOverlayUnregister(t *testing.T) {
+	rspq.Reset()
+
+	for {
+		_, err := rspq.Register(vec.UCode())
+		if err == rspq.ErrOverlayCount {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := overlayIDs()
+	if len(ids) != 7 {
+		t.Fatalf("registered %d overlays, want 7", len(ids))
+	}
+	for i, info := range rspq.Overlays() {
+		if info.Commands != 3 {
+			t.Fatalf("overlay %d: got id %#x with %d commands", i, info.ID, info.Commands)
+		}
+	}
+	for _, id := range ids {
+		vecRoundtrip(t, id)
+	}
+
+	if err := rspq.Unregister(ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := rspq.Unregister(ids[2]); err != rspq.ErrNotFound {
+		t.Fatalf("unregistered twice: %v", err)
+	}
+	if slices.Contains(overlayIDs(), ids[2]) {
+		t.Fatal("overlay still listed")
+	}
+
+	// Overlays registered before unregistering keep working
+	vecRoundtrip(t, ids[1])
+	vecRoundtrip(t, ids[3])
+
+	// An overlay registered after unregistering reuses the freed id
+	id, err := rspq.Register(vec.UCode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != ids[2] {
+		t.Fatalf("got id %#x, want %#x", id, ids[2])
+	}
+	vecRoundtrip(t, id)
+	vecRoundtrip(t, ids[1])
+
+	for _, id := range ids {
+		if err := rspq.Unregister(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(rspq.Overlays()) != 0 {
+		t.Fatal("overlays still listed")
+	}
+}
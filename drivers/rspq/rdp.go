@@ -0,0 +1,33 @@
+package rspq
+
+import (
+	"github.com/clktmr/n64/debug"
+	"github.com/clktmr/n64/rcp/cpu"
+	"github.com/clktmr/n64/rcp/rdp"
+)
+
+// RDPSubmitter passes the buffers of an [rdp.DisplayList] to the RDP via the
+// rspq. This keeps commands written by the CPU in order with the ones written
+// to the RDP by rspq overlays. Enable it with:
+//
+//	rdp.RDP.SetSubmitter(rspq.RDPSubmitter{})
+//
+// The RDP commands can't be recorded in blocks.
+type RDPSubmitter struct{}
+
+var _ rdp.Submitter = RDPSubmitter{}
+
+// SetBuffer implements [rdp.Submitter]. The rsp_queue waits for the RDP to start
+// the current buffer before switching to the new one, so once the syncpoint
+// is reached all buffers before the current one were read.
+func (RDPSubmitter) SetBuffer(start, end, sentinel cpu.Addr) {
+	debug.Assert(block == nil, "rspq: rdp buffer in block")
+	Write(CmdRdpSetBuffer, uint32(end), uint32(start), uint32(sentinel))
+	WaitSyncpoint(Syncpoint())
+}
+
+// AppendBuffer implements [rdp.Submitter].
+func (RDPSubmitter) AppendBuffer(end cpu.Addr) {
+	debug.Assert(block == nil, "rspq: rdp buffer in block")
+	Write(CmdRdpAppendBuffer, uint32(end))
+}
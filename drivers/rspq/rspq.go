@@ -2,16 +2,16 @@
 package rspq
 
 import (
-	"bytes"
 	"embed"
 	"embedded/mmio"
 	"encoding/binary"
 	"io"
-	"slices"
-	"unsafe"
+	"sync"
+	"time"
 
 	"github.com/clktmr/n64/debug"
 	"github.com/clktmr/n64/drivers/cartfs"
+	"github.com/clktmr/n64/rcp"
 	"github.com/clktmr/n64/rcp/cpu"
 	"github.com/clktmr/n64/rcp/rsp"
 	"github.com/clktmr/n64/rcp/rsp/ucode"
@@ -48,10 +48,15 @@ func init() {
 	cpu.WritebackSlice(highpri.buffers[1])
 	cpu.WritebackSlice(dummyOverlayState[:])
 
+	rcp.SetHandler(rcp.IntrRSP, handler)
+
 	Reset()
 }
 
 func Reset() {
+	mtx.Lock()
+	defer mtx.Unlock()
+
 	r, err := rspQueueFiles.Open("rsp_queue.ucode")
 	if err != nil {
 		panic(err)
@@ -71,6 +76,16 @@ func Reset() {
 	highpri.ClearBuffer(1)
 	lowpri.cur = 0
 	highpri.cur = 0
+	ctx = lowpri
+	block = nil
+	syncpointsGen = SyncpointID(syncpointsDone.Load())
+
+	for i, o := range overlays {
+		if o != nil {
+			o.pinner.Unpin()
+			overlays[i] = nil
+		}
+	}
 
 	rspqData = rspQueue{}
 	rspqData.RSPQDramLowpriAddr = cpu.PhysicalAddressSlice(lowpri.buffers[lowpri.bufIdx])
@@ -116,8 +131,26 @@ func (p *context) Append(bufidx int, c Command, args ...uint32) {
 	}
 }
 
-// TODO should be implemented in assembly for performance
+// mtx serializes writing to the queue. A goroutine writing a highpri sequence
+// holds it from [HighpriBegin] until [HighpriEnd].
+var mtx sync.Mutex
+
+// Write enqueues a command. It blocks while another goroutine writes a highpri
+// sequence and must not be called between [HighpriBegin] and [HighpriEnd],
+// see [HighpriWrite].
 func Write(c Command, args ...uint32) {
+	mtx.Lock()
+	write(c, args...)
+	mtx.Unlock()
+}
+
+// TODO should be implemented in assembly for performance
+func write(c Command, args ...uint32) {
+	if block != nil {
+		block.append(c, args...)
+		return
+	}
+
 	ctx.Append(ctx.bufIdx, c, args...)
 
 	rsp.SetSignals(sigMore)
@@ -142,97 +175,27 @@ func switchContexts(newctx *context) {
 	ctx = newctx
 }
 
-// nextBuffer switches to the other buffer of the current context
+// nextBuffer switches to the other buffer of the current context. If the RSP
+// didn't finish the other buffer yet, it blocks until the RSP raises an
+// interrupt after finishing it.
 func nextBuffer() {
-	// TODO if block { ... }
-	for rsp.Signals()&ctx.bufdoneSig == 0 {
-		// wait
-		rsp.Resume()
+	for {
+		bufdoneCond.Wait(0) // clear cond
+		if rsp.Signals()&ctx.bufdoneSig != 0 {
+			break
+		}
+		if !bufdoneCond.Wait(1 * time.Second) {
+			panic(&CrashError{TakeSnapshot()})
+		}
 	}
 	rsp.ClearSignals(ctx.bufdoneSig)
 	ctx.bufIdx = 1 - ctx.bufIdx
 
 	ctx.ClearBuffer(ctx.bufIdx)
 
-	ctx.Append(1-ctx.bufIdx, CmdWriteStatus, uint32(ctx.bufdoneSig.SetMask()))
+	ctx.Append(1-ctx.bufIdx, CmdWriteStatus, statusSetIntr|uint32(ctx.bufdoneSig.SetMask()))
 	ctx.Append(1-ctx.bufIdx, CmdJump, uint32(cpu.PhysicalAddressSlice(ctx.buffers[ctx.bufIdx])))
 
 	rsp.Resume()
 	ctx.cur = 0
 }
-
-var q rspQueue
-
-// ucodes keeps a reference to registered rspq overlays to prevent them being
-// garbage collected, since the RSP will need to load them whenever processing a
-// command
-var ucodes = make([]*ucode.UCode, 0, 8)
-var pinner cpu.Pinner
-
-func Register(p *ucode.UCode) (overlayId uint32) {
-	r := bytes.NewReader(p.Data[rspqDataSize:])
-	hdr, err := loadOverlayHeader(r)
-	if err != nil {
-		panic(err)
-	}
-
-	idx := slices.IndexFunc(q.Tables.OverlayDescriptor[1:], func(o overlayDescriptor) bool {
-		return o.Code == 0
-	})
-	if idx == -1 {
-		panic("max overlay count")
-	}
-	idx += 1
-
-	slotCount := (len(hdr.Commands) + 15) >> 4
-
-	id := bytes.Index(q.Tables.OverlayTable[1:], make([]byte, slotCount))
-	if id == -1 {
-		panic("max command count")
-	}
-	id += 1
-
-	desc := &rspqData.Tables.OverlayDescriptor[idx]
-	code := p.Text[rspqTextSize:]
-	data := p.Data[rspqDataSize:]
-	state := p.Data[hdr.Fields.StateStart : hdr.Fields.StateStart+hdr.Fields.StateSize]
-	desc.Code = cpu.PhysicalAddressSlice(code)
-	desc.Data = cpu.PhysicalAddressSlice(data)
-	desc.CodeSize = uint16(len(code))
-	desc.DataSize = uint16(len(data))
-	desc.State = cpu.PhysicalAddressSlice(state)
-
-	// Let the assigned ids point at the overlay
-	for i := range slotCount {
-		rspqData.Tables.OverlayTable[id+i] = uint8(idx * int(unsafe.Sizeof(rspqData.Tables.OverlayDescriptor[0])))
-	}
-	hdr.Fields.CommandBase = uint16(id << 5)
-	err = hdr.Store(bytes.NewBuffer(p.Data[rspqDataSize:rspqDataSize]))
-	if err != nil {
-		panic(err)
-	}
-
-	pinner.Pin(unsafe.SliceData(p.Text))
-	pinner.Pin(unsafe.SliceData(p.Data))
-	ucodes = append(ucodes, p)
-
-	cpu.WritebackSlice(p.Text)
-	cpu.WritebackSlice(p.Data)
-
-	// TODO let rsp do the dma, so we don't have to wait
-	for !rsp.Stopped() {
-		// wait
-	}
-	err = binary.Write(io.NewOffsetWriter(rsp.DMEM, rspqDataAddress), binary.BigEndian, &rspqData)
-	if err != nil {
-		panic(err)
-	}
-
-	return uint32(id << 28)
-}
-
-const (
-	maxOverlayCount        = 8
-	overlayIdCount         = 16
-	maxOverlayCommandCount = ((maxOverlayCount - 1) * 16)
-)
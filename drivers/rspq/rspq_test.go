@@ -2,11 +2,17 @@ package rspq_test
 
 import (
 	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"strings"
 	"testing"
 
 	"github.com/clktmr/n64/drivers/rspq"
 	"github.com/clktmr/n64/rcp/cpu"
+	"github.com/clktmr/n64/rcp/rdp"
 	"github.com/clktmr/n64/rcp/rsp"
+	"github.com/clktmr/n64/rcp/texture"
 	n64testing "github.com/clktmr/n64/testing"
 )
 
@@ -36,6 +42,19 @@ func TestCrash(t *testing.T) {
 	if !rspq.Crashed() {
 		t.Fatal("rspq should have crashed")
 	}
+
+	err := rspq.Check()
+	var crash *rspq.CrashError
+	if !errors.As(err, &crash) {
+		t.Fatalf("expected CrashError, got %v", err)
+	}
+	if code, ok := crash.Assertion(); !ok || code != 0xff02 {
+		t.Errorf("expected assertion 0xff02, got %#x", code)
+	}
+	if report := err.Error(); !strings.Contains(report, "invalid command") {
+		t.Errorf("unexpected report:\n%s", report)
+	}
+	rspq.Reset()
 }
 
 func TestDMA(t *testing.T) {
@@ -59,3 +78,204 @@ func TestDMA(t *testing.T) {
 		t.Fatalf("dma data mismatch\n%q\n%q", got, expected)
 	}
 }
+
+func TestBlock(t *testing.T) {
+	rspq.Reset()
+
+	data := cpu.MakePaddedSlice[byte](64)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	rspq.BeginBlock()
+	rspq.DMARead(data, 256, uint32(len(data)))
+	inner := rspq.EndBlock()
+	defer inner.Free()
+
+	// Record enough commands to span multiple chunks
+	rspq.BeginBlock()
+	for range 1000 {
+		rspq.Write(rspq.CmdNoop)
+	}
+	inner.Run()
+	outer := rspq.EndBlock()
+	defer outer.Free()
+
+	outer.Run()
+	outer.Run()
+	for !rsp.Stopped() {
+		// wait
+	}
+	if err := rspq.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := cpu.MakePaddedSlice[byte](len(data))
+	if _, err := rsp.DMEM.ReadAt(got, 256); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("dma data mismatch\n%q\n%q", got, data)
+	}
+}
+
+func TestSyncpoint(t *testing.T) {
+	rspq.Reset()
+
+	var ids []rspq.SyncpointID
+	for range 10 {
+		for range 100 {
+			rspq.Write(rspq.CmdNoop)
+		}
+		ids = append(ids, rspq.Syncpoint())
+	}
+	rspq.WaitSyncpoint(ids[len(ids)-1])
+	for i, id := range ids {
+		if !id.Reached() {
+			t.Errorf("syncpoint %d not reached", i)
+		}
+	}
+}
+
+// highpriDMARead is like [rspq.DMARead], but in highpri mode.
+func highpriDMARead(p []byte, addr cpu.Addr) {
+	cpu.WritebackSlice(p)
+	rspq.HighpriWrite(rspq.CmdDma, uint32(cpu.PhysicalAddressSlice(p)), uint32(addr), uint32(len(p)-1), 12)
+}
+
+func TestHighpri(t *testing.T) {
+	rspq.Reset()
+
+	data := cpu.MakePaddedSlice[byte](64)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	for range 300 {
+		rspq.Write(rspq.CmdNoop)
+	}
+	rspq.HighpriBegin()
+	highpriDMARead(data, 256)
+	rspq.HighpriEnd()
+	rspq.HighpriSync()
+
+	got := cpu.MakePaddedSlice[byte](len(data))
+	rspq.WaitSyncpoint(rspq.Syncpoint())
+	if _, err := rsp.DMEM.ReadAt(got, 256); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("dma data mismatch\n%q\n%q", got, data)
+	}
+}
+
+func TestHighpriQueued(t *testing.T) {
+	rspq.Reset()
+
+	data := cpu.MakePaddedSlice[byte](128)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	for range 300 {
+		rspq.Write(rspq.CmdNoop)
+	}
+	for i := range 2 {
+		rspq.HighpriBegin()
+		highpriDMARead(data[64*i:][:64], 256+64*cpu.Addr(i))
+		rspq.HighpriEnd()
+	}
+	rspq.HighpriSync()
+
+	got := cpu.MakePaddedSlice[byte](len(data))
+	if _, err := rsp.DMEM.ReadAt(got, 256); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("dma data mismatch\n%q\n%q", got, data)
+	}
+}
+
+func TestHighpriConcurrent(t *testing.T) {
+	rspq.Reset()
+
+	data := cpu.MakePaddedSlice[byte](64)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	// Keep writing to the lowpri queue from another goroutine, which must
+	// not end up in the highpri sequences.
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				rspq.Write(rspq.CmdNoop)
+				rspq.WaitSyncpoint(rspq.Syncpoint())
+			}
+		}
+	}()
+
+	got := cpu.MakePaddedSlice[byte](len(data))
+	for i := range 100 {
+		data[0] = byte(i)
+		rspq.HighpriBegin()
+		highpriDMARead(data, 256)
+		rspq.HighpriEnd()
+		rspq.HighpriSync()
+		rspq.DMAWrite(got, 256, uint32(len(got)))
+		rspq.WaitSyncpoint(rspq.Syncpoint())
+		if !bytes.Equal(got, data) {
+			close(done)
+			t.Fatalf("dma data mismatch\n%q\n%q", got, data)
+		}
+	}
+	close(done)
+	<-stopped
+	if rspq.Crashed() {
+		t.Fatal("rspq crashed")
+	}
+}
+
+func TestRDP(t *testing.T) {
+	rspq.Reset()
+	rdp.RDP.SetSubmitter(rspq.RDPSubmitter{})
+	defer rdp.RDP.SetSubmitter(nil)
+
+	colors := [2]color.RGBA{{R: 0xf8, A: 0xff}, {G: 0xf8, B: 0x78, A: 0xff}}
+	img := texture.NewFramebuffer(image.Rect(0, 0, 32, 32))
+	bounds := img.Bounds()
+
+	dl := &rdp.RDP
+	dl.SetColorImage(img)
+	dl.SetScissor(bounds, rdp.InterlaceNone)
+	dl.SetOtherModes(rdp.OtherModes(
+		rdp.ForceBlend|rdp.AtomicPrimitive,
+		rdp.CycleTypeFill, rdp.RGBDitherNone, rdp.AlphaDitherNone, rdp.ZmodeOpaque, rdp.CvgDestClamp, rdp.BlendMode(0),
+	))
+
+	img.Invalidate()
+
+	// Draw single pixels for multiple buffer switches
+	for y := range bounds.Max.Y {
+		for x := range bounds.Max.X {
+			dl.SetFillColor(colors[(x+y)%2])
+			dl.FillRectangle(image.Rect(x, y, x+1, y+1))
+		}
+	}
+	dl.Flush()
+
+	if rspq.Crashed() {
+		t.Fatal("rspq crashed")
+	}
+	for y := range bounds.Max.Y {
+		for x := range bounds.Max.X {
+			result := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			if result != colors[(x+y)%2] {
+				t.Fatalf("%v at (%d,%d)", result, x, y)
+			}
+		}
+	}
+}
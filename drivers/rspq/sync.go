@@ -0,0 +1,152 @@
+package rspq
+
+import (
+	"embedded/rtos"
+	"sync/atomic"
+	"time"
+
+	"github.com/clktmr/n64/debug"
+	"github.com/clktmr/n64/rcp/cpu"
+	"github.com/clktmr/n64/rcp/rsp"
+)
+
+// Status register bits as seen by the microcode
+const (
+	statusSetIntr  = 1 << 4 // write access, raises the RSP interrupt
+	statusSigShift = 7      // read access, position of signal 0
+)
+
+var (
+	syncpointsGen  SyncpointID
+	syncpointsDone atomic.Uint32
+	syncpointCond  rtos.Cond
+	highpriCond    rtos.Cond
+	bufdoneCond    rtos.Cond
+)
+
+//go:nosplit
+//go:nowritebarrierrec
+func handler() {
+	sigs := rsp.Signals()
+	if sigs&sigSyncpoint != 0 {
+		rsp.ClearSignals(sigSyncpoint)
+		syncpointsDone.Add(1)
+		syncpointCond.Signal()
+	}
+	if sigs&(sigHighpriRequested|sigHighpriRunning) == 0 {
+		highpriCond.Signal()
+	}
+	if sigs&(sigBufdoneLow|sigBufdoneHigh) != 0 {
+		bufdoneCond.Signal()
+	}
+	rsp.Handler()
+}
+
+// SyncpointID identifies a position in the queue, see [Syncpoint].
+type SyncpointID uint32
+
+// Syncpoint enqueues a syncpoint and returns its id. The RSP raises an
+// interrupt when reaching it, which can be waited for with [WaitSyncpoint].
+// Syncpoints can't be created in highpri mode or while recording a block.
+func Syncpoint() SyncpointID {
+	mtx.Lock()
+	defer mtx.Unlock()
+	return syncpoint()
+}
+
+func syncpoint() SyncpointID {
+	debug.Assert(block == nil, "rspq: syncpoint in block")
+	write(CmdTestWriteStatus,
+		statusSetIntr|uint32(rsp.Signal(sigSyncpoint).SetMask()),
+		sigSyncpoint<<statusSigShift)
+	syncpointsGen++
+	return syncpointsGen
+}
+
+// Reached reports whether the RSP has processed all commands enqueued before
+// the syncpoint.
+func (id SyncpointID) Reached() bool {
+	return int32(uint32(id)-syncpointsDone.Load()) <= 0
+}
+
+// WaitSyncpoint blocks until the syncpoint is reached. It panics with a
+// [CrashError] if the RSP doesn't reach it within a second.
+func WaitSyncpoint(id SyncpointID) {
+	for {
+		syncpointCond.Wait(0) // clear cond
+		if id.Reached() {
+			return
+		}
+		if !syncpointCond.Wait(1 * time.Second) {
+			panic(&CrashError{TakeSnapshot()})
+		}
+	}
+}
+
+// HighpriBegin switches to the highpri queue. All commands passed to
+// [HighpriWrite] until [HighpriEnd] are run by the RSP as soon as it finishes
+// the current command, preempting the lowpri queue.
+//
+// The queue stays locked until [HighpriEnd], which must be called by the same
+// goroutine. Meanwhile other goroutines block in [Write], [Syncpoint] and
+// [Register], while the calling goroutine must only use [HighpriWrite].
+func HighpriBegin() {
+	mtx.Lock()
+	debug.Assert(block == nil, "rspq: highpri mode while recording a block")
+
+	// The RSP clears sigHighpriRequested when switching to the highpri
+	// queue, so a request before it switched for the previous sequence
+	// would be lost. Like libdragon, replace the previous sequence's epilog
+	// by a jump to this sequence instead. If the RSP already read the
+	// epilog, it switches back to this sequence on the new request. The
+	// epilog can't be replaced after a buffer switch, wait for it instead.
+	if highpri.cur >= highpriEpilogSize {
+		buffer := cpu.UncachedSlice(highpri.buffers[highpri.bufIdx])
+		epilog := &buffer[highpri.cur-highpriEpilogSize]
+		if Command(*epilog>>24) == CmdSwapBuffers {
+			*epilog = uint32(CmdJump)<<24 | uint32(cpu.PhysicalAddressSlice(buffer[highpri.cur:]))
+		}
+	} else {
+		HighpriSync()
+	}
+
+	switchContexts(highpri)
+	rsp.SetSignals(sigHighpriRequested)
+	rsp.Resume()
+}
+
+// HighpriWrite enqueues a command in the highpri queue. It must only be called
+// between [HighpriBegin] and [HighpriEnd].
+func HighpriWrite(c Command, args ...uint32) {
+	debug.Assert(ctx == highpri, "rspq: not in highpri mode")
+	write(c, args...)
+}
+
+// Number of words written by [HighpriEnd].
+const highpriEpilogSize = 3
+
+// HighpriEnd switches back to the lowpri queue and unlocks it. The RSP
+// continues with the lowpri queue after running all highpri commands.
+func HighpriEnd() {
+	// Save the highpri position, restore the lowpri one and notify the CPU
+	// via interrupt.
+	HighpriWrite(CmdSwapBuffers, lowpriCallSlot<<2, highpriCallSlot<<2,
+		statusSetIntr|uint32(rsp.Signal(sigHighpriRunning).ClearMask()))
+	switchContexts(lowpri)
+	mtx.Unlock()
+}
+
+// HighpriSync blocks until the RSP finished all highpri commands. It panics
+// with a [CrashError] if it doesn't finish within a second. It must not be
+// called between [HighpriBegin] and [HighpriEnd].
+func HighpriSync() {
+	for {
+		highpriCond.Wait(0) // clear cond
+		if rsp.Signals()&(sigHighpriRequested|sigHighpriRunning) == 0 {
+			return
+		}
+		if !highpriCond.Wait(1 * time.Second) {
+			panic(&CrashError{TakeSnapshot()})
+		}
+	}
+}
@@ -0,0 +1,20 @@
+// Code generated by "n64go ucode gen" from rsp_vec.ucode; DO NOT EDIT.
+
+package vec
+
+import (
+	"github.com/clktmr/n64/drivers/rspq"
+)
+
+// Commands of rsp_vec.ucode
+const (
+	cmdLoad  rspq.Command = 0x00 // 8 bytes
+	cmdStore rspq.Command = 0x01 // 8 bytes
+	cmdTrans rspq.Command = 0x02 // 8 bytes
+)
+
+// DMEM offsets of rsp_vec.ucode
+const (
+	dmemOverlayHeader = 0x260
+	dmemState         = 0x270
+)
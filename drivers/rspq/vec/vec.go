@@ -0,0 +1,245 @@
+// Package vec provides vector and matrix math on the RSP.
+//
+// The rsp_vec overlay keeps up to [SlotCount] slots in DMEM, each holding two
+// 4D vectors in signed 16.16 fixed point format. Vectors are loaded into slots
+// from RDRAM, transformed by a 4x4 matrix occupying two slots and stored back
+// to RDRAM. All commands are executed asynchronously by the rspq, use
+// [rspq.Syncpoint] to wait for their results.
+package vec
+
+import (
+	"embed"
+	"structs"
+	"unsafe"
+
+	"github.com/clktmr/n64/debug"
+	"github.com/clktmr/n64/drivers/cartfs"
+	"github.com/clktmr/n64/drivers/rspq"
+	"github.com/clktmr/n64/rcp/cpu"
+	"github.com/clktmr/n64/rcp/fixed"
+	"github.com/clktmr/n64/rcp/rsp/ucode"
+)
+
+var (
+	// rsp_vec microcode from libdragon's examples
+	// Version: 3feaaadf0 (RSPQ_DEBUG enabled)
+	//
+	//go:embed rsp_vec.ucode
+	_rspVecFiles embed.FS
+	rspVecFiles  cartfs.FS = cartfs.Embed(_rspVecFiles)
+	rspVecId     uint32
+	rspVecUCode  *ucode.UCode // registered as rspVecId
+)
+
+//go:generate go tool n64go ucode gen -cmd Load -cmd Store -cmd Trans rsp_vec.ucode
+
+const (
+	SlotCount  = 32                   // number of slots in DMEM
+	MatrixSlot = SlotCount - MatSlots // slot used by [Transform] for the matrix
+	MatSlots   = 2                    // number of slots occupied by a matrix
+
+	slotSize = int(unsafe.Sizeof(Slot{}))
+)
+
+// Vec4 is a 4D vector.
+type Vec4 [4]float32
+
+// Mat4 is a 4x4 matrix in column-major order, i.e. Mat4[i] is the i-th
+// column.
+type Mat4 [4]Vec4
+
+func (a Vec4) Dot(b Vec4) float32 { return a[0]*b[0] + a[1]*b[1] + a[2]*b[2] + a[3]*b[3] }
+func (m *Mat4) Row(i int) Vec4    { return Vec4{m[0][i], m[1][i], m[2][i], m[3][i]} }
+
+// Mul returns the product of m and v, calculated by the CPU.
+func (m *Mat4) Mul(v Vec4) Vec4 {
+	return Vec4{m.Row(0).Dot(v), m.Row(1).Dot(v), m.Row(2).Dot(v), m.Row(3).Dot(v)}
+}
+
+// Slot is the fixed point layout of two vectors, as required by the ucode.
+type Slot struct {
+	_ structs.HostLayout
+	i [8]int16  // integer parts
+	f [8]uint16 // fractional parts
+}
+
+// MatSlot is the fixed point layout of a matrix, as required by the ucode.
+type MatSlot [MatSlots]Slot
+
+// MakeSlots returns a slice of n slots, which is safe to be shared with the
+// RSP.
+func MakeSlots(n int) []Slot {
+	buf := cpu.MakePaddedSlice[uint64](n * slotSize / 8)
+	return unsafe.Slice((*Slot)(unsafe.Pointer(unsafe.SliceData(buf))), n)
+}
+
+// NewMatSlot returns a matrix, which is safe to be shared with the RSP.
+func NewMatSlot() *MatSlot {
+	return (*MatSlot)(MakeSlots(MatSlots))
+}
+
+// Set stores the vectors v[0] and v[1] in p.
+func (p *Slot) Set(v *[2]Vec4) {
+	for i := range 8 {
+		p.set(i, fixed.Int16_16F(v[i>>2][i&0x3]))
+	}
+}
+
+// Get returns the vectors stored in p.
+func (p *Slot) Get() (v [2]Vec4) {
+	for i := range 8 {
+		v[i>>2][i&0x3] = float32(p.get(i)) / (1 << 16)
+	}
+	return
+}
+
+// SetFixed stores the vectors v[0] and v[1] in p.
+func (p *Slot) SetFixed(v *[2][4]fixed.Int16_16) {
+	for i := range 8 {
+		p.set(i, v[i>>2][i&0x3])
+	}
+}
+
+// Fixed returns the vectors stored in p.
+func (p *Slot) Fixed() (v [2][4]fixed.Int16_16) {
+	for i := range 8 {
+		v[i>>2][i&0x3] = p.get(i)
+	}
+	return
+}
+
+func (p *Slot) set(i int, x fixed.Int16_16) {
+	p.i[i] = int16(x >> 16)
+	p.f[i] = uint16(x)
+}
+
+func (p *Slot) get(i int) fixed.Int16_16 {
+	return fixed.Int16_16(int32(p.i[i])<<16 | int32(p.f[i]))
+}
+
+// Set stores the matrix m in p.
+func (p *MatSlot) Set(m *Mat4) {
+	for i := range MatSlots {
+		p[i].Set((*[2]Vec4)(m[i<<1:]))
+	}
+}
+
+// SetFixed stores the matrix m, given in column-major order, in p.
+func (p *MatSlot) SetFixed(m *[4][4]fixed.Int16_16) {
+	for i := range MatSlots {
+		p[i].SetFixed((*[2][4]fixed.Int16_16)(m[i<<1:]))
+	}
+}
+
+// UCode returns the rsp_vec microcode.
+func UCode() *ucode.UCode {
+	r, err := rspVecFiles.Open("rsp_vec.ucode")
+	if err != nil {
+		panic(err)
+	}
+	uc, err := ucode.Load(r)
+	if err != nil {
+		panic(err)
+	}
+	return uc
+}
+
+// Init registers the rsp_vec overlay. It must be called before any other
+// function of this package and again after each [rspq.Reset]. Subsequent
+// calls reuse the overlay as long as it's registered.
+func Init() error {
+	for _, info := range rspq.Overlays() {
+		if info.ID == rspVecId && info.UCode == rspVecUCode {
+			return nil
+		}
+	}
+
+	uc := UCode()
+	id, err := rspq.Register(uc)
+	if err != nil {
+		return err
+	}
+	rspVecId, rspVecUCode = id, uc
+	return nil
+}
+
+func slotBytes(s []Slot) []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(s))), len(s)*slotSize)
+}
+
+// Load enqueues loading src into DMEM, starting at slot. The slots must have
+// been created by [MakeSlots] and must not be modified until the RSP has
+// loaded them.
+func Load(slot int, src []Slot) {
+	debug.Assert(cpu.PhysicalAddressSlice(src)&0xf == 0, "vec: slot alignment")
+	debug.Assert(slot >= 0 && slot+len(src) <= SlotCount, "vec: slot out of bounds")
+	cpu.WritebackSlice(slotBytes(src))
+	rspq.Write(cmdLoad|rspq.Command(rspVecId>>24),
+		uint32(cpu.PhysicalAddressSlice(src)),
+		uint32((((len(src)*slotSize)-1)&0xfff)<<16|((slot*slotSize)&0xff0)))
+}
+
+// Store enqueues storing DMEM, starting at slot, into dst. The slots must have
+// been created by [MakeSlots] and must not be accessed until the RSP has
+// stored them.
+func Store(slot int, dst []Slot) {
+	debug.Assert(cpu.PhysicalAddressSlice(dst)&0xf == 0, "vec: slot alignment")
+	debug.Assert(slot >= 0 && slot+len(dst) <= SlotCount, "vec: slot out of bounds")
+	cpu.InvalidateSlice(slotBytes(dst))
+	rspq.Write(cmdStore|rspq.Command(rspVecId>>24),
+		uint32(cpu.PhysicalAddressSlice(dst)),
+		uint32((((len(dst)*slotSize)-1)&0xfff)<<16|((slot*slotSize)&0xff0)))
+}
+
+// TransformSlot enqueues the transformation of both vectors in slot vec by the
+// matrix in slots mat and mat+1. The result is written to slot dst, which may
+// be the same as vec.
+func TransformSlot(dst, mat, vec int) {
+	debug.Assert(dst >= 0 && dst < SlotCount, "vec: slot out of bounds")
+	debug.Assert(mat >= 0 && mat+MatSlots <= SlotCount, "vec: slot out of bounds")
+	debug.Assert(vec >= 0 && vec < SlotCount, "vec: slot out of bounds")
+	rspq.Write(cmdTrans|rspq.Command(rspVecId>>24),
+		uint32((dst*slotSize)&0xff0),
+		uint32(((mat*slotSize)&0xff0)<<16|((vec*slotSize)&0xff0)))
+}
+
+// Transform enqueues the transformation of all vectors in src by the matrix m.
+// The results are written to dst, which may be the same as src. It overwrites
+// all slots in DMEM.
+func Transform(dst, src []Slot, m *MatSlot) {
+	debug.Assert(len(dst) >= len(src), "vec: dst too short")
+	Load(MatrixSlot, m[:])
+	for len(src) > 0 {
+		n := min(len(src), MatrixSlot)
+		Load(0, src[:n])
+		for i := range n {
+			TransformSlot(i, MatrixSlot, i)
+		}
+		Store(0, dst[:n])
+		src, dst = src[n:], dst[n:]
+	}
+}
+
+// TransformVec4 transforms all vectors in src by the matrix m on the RSP and
+// writes the results to dst, which may be the same as src. Unlike the other
+// functions of this package, it blocks until the results are available.
+func TransformVec4(dst, src []Vec4, m *Mat4) {
+	debug.Assert(len(dst) >= len(src), "vec: dst too short")
+	slots := MakeSlots((len(src) + 1) >> 1)
+	for i := range slots {
+		pair := [2]Vec4{src[i<<1]}
+		if i<<1+1 < len(src) {
+			pair[1] = src[i<<1+1]
+		}
+		slots[i].Set(&pair)
+	}
+	mat := NewMatSlot()
+	mat.Set(m)
+
+	Transform(slots, slots, mat)
+	rspq.WaitSyncpoint(rspq.Syncpoint())
+
+	for i := range src {
+		dst[i] = slots[i>>1].Get()[i&0x1]
+	}
+}
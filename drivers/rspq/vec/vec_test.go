@@ -0,0 +1,131 @@
+package vec_test
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/clktmr/n64/drivers/rspq"
+	"github.com/clktmr/n64/drivers/rspq/vec"
+	"github.com/clktmr/n64/rcp/fixed"
+	n64testing "github.com/clktmr/n64/testing"
+)
+
+func TestMain(m *testing.M) { n64testing.TestMain(m) }
+
+var vecs = [8]vec.Vec4{
+	{0.1, -0.2, 0.3, -0.4}, {0.5, -0.6, -0.7, 0.8},
+	{1.1, -1.2, 1.3, -1.4}, {1.5, -1.6, -1.7, 1.8},
+	{2.1, -2.2, 2.3, -2.4}, {2.5, -2.6, -2.7, 2.8},
+	{3.1, -3.2, 3.3, -3.4}, {3.5, -3.6, -3.7, 3.8},
+}
+var mat = vec.Mat4{
+	{1.0, 0.0, -0.0, 0.0},
+	{0.0, 1.0, -0.0, 0.0},
+	{0.0, 0.0, -1.0, 0.0},
+	{0.0, 9.0, -0.0, 1.0},
+}
+
+func almostEqual(a, b float32) bool { return math.Abs(float64(a-b)) < 1e-3 }
+
+func TestSlot(t *testing.T) {
+	var s vec.Slot
+	s.Set((*[2]vec.Vec4)(vecs[:]))
+	got := s.Get()
+	for i := range got {
+		if !slices.EqualFunc(got[i][:], vecs[i][:], almostEqual) {
+			t.Fatalf("vec%d: %v != %v", i, got[i], vecs[i])
+		}
+	}
+
+	want := [2][4]fixed.Int16_16{
+		{fixed.Int16_16U(-3), fixed.Int16_16F(0.5), 1, -1},
+		{fixed.Int16_16U(32767), fixed.Int16_16U(-32768), 0, fixed.Int16_16F(-0.25)},
+	}
+	s.SetFixed(&want)
+	if got := s.Fixed(); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestInit(t *testing.T) {
+	rspq.Reset()
+	for range 10 {
+		if err := vec.Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(rspq.Overlays()); n != 1 {
+		t.Fatalf("registered %d overlays, want 1", n)
+	}
+
+	// Registers the overlay again after the registration was lost
+	rspq.Reset()
+	if err := vec.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(rspq.Overlays()); n != 1 {
+		t.Fatalf("registered %d overlays, want 1", n)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	rspq.Reset()
+	if err := vec.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	src := vec.MakeSlots(4)
+	for i := range src {
+		src[i].Set((*[2]vec.Vec4)(vecs[i<<1:]))
+	}
+	m := vec.NewMatSlot()
+	m.Set(&mat)
+
+	dst := vec.MakeSlots(4)
+	vec.Transform(dst, src, m)
+	rspq.WaitSyncpoint(rspq.Syncpoint())
+	if rspq.Crashed() {
+		t.Fatal("rspq crashed")
+	}
+
+	for i, v := range vecs {
+		vecCPU := mat.Mul(v)
+		vecRSP := dst[i>>1].Get()[i&0x1]
+		if !slices.EqualFunc(vecCPU[:], vecRSP[:], almostEqual) {
+			t.Fatalf("vec%d: %v != %v", i, vecCPU, vecRSP)
+		}
+	}
+}
+
+func TestTransformVec4(t *testing.T) {
+	rspq.Reset()
+	if err := vec.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Odd number of vectors, exceeding the slots available in DMEM
+	src := make([]vec.Vec4, 101)
+	for i := range src {
+		src[i] = vecs[i%len(vecs)]
+		src[i][0] += float32(i) / 16
+	}
+	dst := make([]vec.Vec4, len(src))
+	vec.TransformVec4(dst, src, &mat)
+	if rspq.Crashed() {
+		t.Fatal("rspq crashed")
+	}
+
+	for i, v := range src {
+		vecCPU := mat.Mul(v)
+		if !slices.EqualFunc(vecCPU[:], dst[i][:], almostEqual) {
+			t.Fatalf("vec%d: %v != %v", i, vecCPU, dst[i])
+		}
+	}
+
+	// Transform in place
+	vec.TransformVec4(src, src, &mat)
+	if !slices.Equal(src, dst) {
+		t.Fatal("in place transform differs")
+	}
+}
@@ -0,0 +1,128 @@
+package vru
+
+import (
+	"errors"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// ErrEncoding is returned by [Kana] for characters the VRU can't recognize.
+var ErrEncoding = errors.New("unsupported character")
+
+// Kana is the character encoding of the VRU's dictionary. Words must be
+// spelled in hiragana or katakana, which are encoded as two byte Shift JIS
+// codes.
+var Kana encoding.Encoding = kana{}
+
+const (
+	hiraganaFirst = 'ぁ'
+	hiraganaLast  = 'ん'
+	katakanaFirst = 'ァ'
+	katakanaLast  = 'ヶ'
+	prolonged     = 'ー'
+
+	sjisHiragana  = 0x829f
+	sjisKatakana  = 0x8340
+	sjisProlonged = 0x815b
+	sjisGap       = 0x837f // unused code within the katakana range
+)
+
+func encodeRune(r rune) (uint16, bool) {
+	switch {
+	case r >= hiraganaFirst && r <= hiraganaLast:
+		return sjisHiragana + uint16(r-hiraganaFirst), true
+	case r >= katakanaFirst && r <= katakanaLast:
+		c := sjisKatakana + uint16(r-katakanaFirst)
+		if c >= sjisGap {
+			c++
+		}
+		return c, true
+	case r == prolonged:
+		return sjisProlonged, true
+	}
+	return 0, false
+}
+
+func decodeRune(c uint16) (rune, bool) {
+	switch {
+	case c >= sjisHiragana && c <= sjisHiragana+hiraganaLast-hiraganaFirst:
+		return hiraganaFirst + rune(c-sjisHiragana), true
+	case c >= sjisKatakana && c < sjisGap:
+		return katakanaFirst + rune(c-sjisKatakana), true
+	case c > sjisGap && c <= sjisKatakana+katakanaLast-katakanaFirst+1:
+		return katakanaFirst + rune(c-sjisKatakana-1), true
+	case c == sjisProlonged:
+		return prolonged, true
+	}
+	return 0, false
+}
+
+type kana struct{}
+
+func (kana) NewDecoder() *encoding.Decoder {
+	return &encoding.Decoder{Transformer: &decoder{}}
+}
+
+func (kana) NewEncoder() *encoding.Encoder {
+	return &encoding.Encoder{Transformer: &encoder{}}
+}
+
+type decoder struct{ transform.NopResetter }
+
+// Shift JIS to UTF-8
+func (d *decoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		if len(src)-nSrc < 2 {
+			err = transform.ErrShortSrc
+			if atEOF {
+				err = ErrEncoding
+			}
+			break
+		}
+		r, ok := decodeRune(uint16(src[nSrc])<<8 | uint16(src[nSrc+1]))
+		if !ok {
+			err = ErrEncoding
+			break
+		}
+		if utf8.RuneLen(r) > len(dst)-nDst {
+			err = transform.ErrShortDst
+			break
+		}
+		nDst += utf8.EncodeRune(dst[nDst:], r)
+		nSrc += 2
+	}
+	return
+}
+
+type encoder struct{ transform.NopResetter }
+
+// UTF-8 to Shift JIS
+func (d *encoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for {
+		r, size := utf8.DecodeRune(src[nSrc:])
+		if size < 1 {
+			break
+		} else if size == 1 && r == utf8.RuneError {
+			err = encoding.ErrInvalidUTF8
+			if !atEOF && !utf8.FullRune(src[nSrc:]) {
+				err = transform.ErrShortSrc
+			}
+			break
+		}
+		if len(dst)-nDst < 2 {
+			err = transform.ErrShortDst
+			break
+		}
+		c, ok := encodeRune(r)
+		if !ok {
+			err = ErrEncoding
+			break
+		}
+		dst[nDst], dst[nDst+1] = byte(c>>8), byte(c)
+		nDst += 2
+		nSrc += size
+	}
+	return
+}
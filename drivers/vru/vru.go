@@ -0,0 +1,128 @@
+// Package vru implements a driver for the Voice Recognition Unit (VRU).
+//
+// The VRU matches utterances against a dictionary of up to [MaxWords] words,
+// which are spelled in kana (see [Kana]). After loading a vocabulary,
+// recognition is started and the best matching words are reported together
+// with their distance to the utterance.
+package vru
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	MaxWords   = 255 // Maximum number of words in the dictionary
+	MaxWordLen = 15  // Maximum number of characters per word
+	MaxMatches = 5   // Maximum number of matches per result
+
+	chunkSize  = 20 // payload of a single write command
+	resultSize = 36 // payload of a single read command
+)
+
+var (
+	ErrWordLength = errors.New("word too long")
+	ErrVocabulary = errors.New("too many words")
+	ErrNoResult   = errors.New("no result available")
+)
+
+// Warning flags reported with a [Result].
+type Warning uint16
+
+const (
+	WarnTooQuiet Warning = 0x0400 // Utterance was too quiet
+	WarnTooLoud  Warning = 0x0800 // Utterance was too loud
+	WarnNoMatch  Warning = 0x4000 // No word matched well
+	WarnNoisy    Warning = 0x8000 // Too much background noise
+)
+
+// Match is a dictionary word matching the utterance.
+type Match struct {
+	Word     int // Index of the word in the vocabulary
+	Distance int // Distance to the utterance, lower is better
+}
+
+// Result of a single recognition.
+type Result struct {
+	Warning Warning
+	Matches []Match // Best match first
+
+	Level       int // Voice level
+	SignalNoise int // Relative voice level
+	Duration    int // Length of the utterance in milliseconds
+}
+
+// Best returns the best match. Returns false if there was no match or the
+// result has warnings.
+func (r *Result) Best() (Match, bool) {
+	if len(r.Matches) == 0 || r.Warning != 0 {
+		return Match{}, false
+	}
+	return r.Matches[0], true
+}
+
+// parseResult decodes the data returned by a read command.
+//
+// Layout (big-endian words):
+//
+//	0x00 warning
+//	0x02 number of matches
+//	0x04 voice level
+//	0x06 relative voice level
+//	0x08 duration
+//	0x0a word index of matches 1 to 5
+//	0x14 distance of matches 1 to 5
+func parseResult(data []byte) (r Result, err error) {
+	if len(data) != resultSize {
+		return r, fmt.Errorf("result: invalid length %d", len(data))
+	}
+	word := func(i int) int { return int(binary.BigEndian.Uint16(data[2*i:])) }
+
+	r.Warning = Warning(word(0))
+	n := word(1)
+	if n > MaxMatches {
+		return r, fmt.Errorf("result: invalid number of matches %d", n)
+	}
+	r.Level = word(2)
+	r.SignalNoise = word(3)
+	r.Duration = word(4)
+	r.Matches = make([]Match, n)
+	for i := range r.Matches {
+		r.Matches[i] = Match{Word: word(5 + i), Distance: word(10 + i)}
+	}
+	return
+}
+
+// encodeWord returns the chunks for writing a single dictionary word. The word
+// is terminated by a zero character and padded with zeros to full chunks.
+func encodeWord(word string) (chunks [][chunkSize]byte, err error) {
+	enc, err := Kana.NewEncoder().Bytes([]byte(word))
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", word, err)
+	}
+	if len(enc) == 0 || len(enc)/2 > MaxWordLen {
+		return nil, fmt.Errorf("%q: %w", word, ErrWordLength)
+	}
+	enc = append(enc, 0, 0)
+	chunks = make([][chunkSize]byte, (len(enc)+chunkSize-1)/chunkSize)
+	for i := range chunks {
+		copy(chunks[i][:], enc[i*chunkSize:])
+	}
+	return
+}
+
+// encodeVocabulary encodes all words, so no dictionary is written if any of
+// them is invalid.
+func encodeVocabulary(words []string) (vocab [][][chunkSize]byte, err error) {
+	if len(words) > MaxWords {
+		return nil, ErrVocabulary
+	}
+	vocab = make([][][chunkSize]byte, len(words))
+	for i, w := range words {
+		if vocab[i], err = encodeWord(w); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
@@ -0,0 +1,180 @@
+//go:build n64
+
+package vru
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/clktmr/n64/debug"
+	"github.com/clktmr/n64/rcp/serial"
+	"github.com/clktmr/n64/rcp/serial/joybus"
+)
+
+var ErrTimeout = errors.New("vru timeout")
+
+// Config commands
+const (
+	cfgClearDictionary = 0x02
+	cfgStart           = 0x05
+	cfgStop            = 0x06
+)
+
+// VRU is a Voice Recognition Unit connected to a joybus port.
+type VRU struct {
+	mtx  sync.Mutex
+	port uint8
+
+	statusBlock, initBlock, readBlock *serial.CommandBlock
+	writeBlock, configBlock           *serial.CommandBlock
+
+	status joybus.VRUStatusCommand
+	init   joybus.VRUInitCommand
+	read   joybus.VRUReadCommand
+	write  joybus.VRUWriteCommand
+	config joybus.VRUWriteCommand
+}
+
+// newBlock returns a command block with cmd on the given port.
+func newBlock[T any](port uint8, cmd func(joybus.Allocator) (T, error)) (*serial.CommandBlock, T) {
+	block := serial.NewCommandBlock(serial.CmdConfigureJoybus)
+	for range port {
+		err := joybus.ControlByte(block, joybus.CtrlSkip)
+		debug.AssertErrNil(err)
+	}
+	c, err := cmd(block)
+	debug.AssertErrNil(err)
+	err = joybus.ControlByte(block, joybus.CtrlAbort)
+	debug.AssertErrNil(err)
+	return block, c
+}
+
+// Open initializes the VRU connected to port. Use [controller.Port.Device] to
+// find the port a VRU is connected to.
+func Open(port uint8) (*VRU, error) {
+	v := &VRU{port: port}
+	v.statusBlock, v.status = newBlock(port, joybus.NewVRUStatusCommand)
+	v.initBlock, v.init = newBlock(port, joybus.NewVRUInitCommand)
+	v.readBlock, v.read = newBlock(port, joybus.NewVRUReadCommand)
+	v.writeBlock, v.write = newBlock(port, joybus.NewVRUWriteCommand)
+	v.configBlock, v.config = newBlock(port, joybus.NewVRUConfigCommand)
+
+	v.init.SetValue(0)
+	serial.Run(v.initBlock)
+	if _, err := v.init.Result(); err != nil {
+		return nil, err
+	}
+	if err := v.waitIdle(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *VRU) state() (joybus.VRUState, error) {
+	v.status.Reset()
+	serial.Run(v.statusBlock)
+	return v.status.Status()
+}
+
+// waitIdle waits until the VRU finished processing the last command.
+func (v *VRU) waitIdle() error {
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for {
+		state, err := v.state()
+		if err != nil {
+			return err
+		}
+		if state != joybus.VRUBusy {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrTimeout
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (v *VRU) configure(cmd byte, arg uint16) error {
+	v.config.Reset()
+	err := v.config.SetData([]byte{cmd, 0x00, byte(arg >> 8), byte(arg)})
+	debug.AssertErrNil(err)
+	serial.Run(v.configBlock)
+	if err = v.config.Result(); err != nil {
+		return err
+	}
+	return v.waitIdle()
+}
+
+// SetVocabulary replaces the VRU's dictionary. The index of each word is
+// reported in [Match.Word]. All words are validated before the dictionary is
+// changed.
+func (v *VRU) SetVocabulary(words []string) error {
+	vocab, err := encodeVocabulary(words)
+	if err != nil {
+		return err
+	}
+
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	if err = v.configure(cfgClearDictionary, uint16(len(words))); err != nil {
+		return err
+	}
+	for _, chunks := range vocab {
+		for _, chunk := range chunks {
+			v.write.Reset()
+			v.write.SetAddress(0)
+			err = v.write.SetData(chunk[:])
+			debug.AssertErrNil(err)
+			serial.Run(v.writeBlock)
+			if err = v.write.Result(); err != nil {
+				return err
+			}
+		}
+		if err = v.waitIdle(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start starts listening for an utterance.
+func (v *VRU) Start() error {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	return v.configure(cfgStart, 0)
+}
+
+// Stop stops listening. A result which is already available can still be
+// read.
+func (v *VRU) Stop() error {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	return v.configure(cfgStop, 0)
+}
+
+// Result returns the result of the last recognition. Returns [ErrNoResult] if
+// the VRU is still listening or processing. Recognition must be restarted
+// with [VRU.Start] after each result.
+func (v *VRU) Result() (r Result, err error) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	state, err := v.state()
+	if err != nil {
+		return
+	}
+	if state != joybus.VRUEnd {
+		return r, ErrNoResult
+	}
+
+	v.read.Reset()
+	v.read.SetAddress(0)
+	serial.Run(v.readBlock)
+	data, err := v.read.Data()
+	if err != nil {
+		return
+	}
+	return parseResult(data)
+}
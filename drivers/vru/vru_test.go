@@ -0,0 +1,110 @@
+//go:build !n64
+
+package vru
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKana(t *testing.T) {
+	for _, tc := range []struct {
+		s   string
+		enc []byte
+	}{
+		{"あい", []byte{0x82, 0xa0, 0x82, 0xa2}},
+		{"ん", []byte{0x82, 0xf1}},
+		{"ミム", []byte{0x83, 0x7e, 0x83, 0x80}},
+		{"ヶー", []byte{0x83, 0x96, 0x81, 0x5b}},
+	} {
+		enc, err := Kana.NewEncoder().String(tc.s)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.s, err)
+		}
+		if !bytes.Equal([]byte(enc), tc.enc) {
+			t.Errorf("%q: expected % x, got % x", tc.s, tc.enc, []byte(enc))
+		}
+		dec, err := Kana.NewDecoder().Bytes(tc.enc)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.s, err)
+		}
+		if string(dec) != tc.s {
+			t.Errorf("expected %q, got %q", tc.s, dec)
+		}
+	}
+
+	if _, err := Kana.NewEncoder().String("ピカa"); !errors.Is(err, ErrEncoding) {
+		t.Errorf("expected %v, got %v", ErrEncoding, err)
+	}
+	if _, err := Kana.NewDecoder().Bytes([]byte{0x83, 0x7f}); !errors.Is(err, ErrEncoding) {
+		t.Errorf("expected %v, got %v", ErrEncoding, err)
+	}
+}
+
+func TestVocabulary(t *testing.T) {
+	vocab, err := encodeVocabulary([]string{"ピカチュウ", "いけ"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vocab) != 2 || len(vocab[0]) != 1 || len(vocab[1]) != 1 {
+		t.Fatalf("unexpected chunks %v", vocab)
+	}
+	want := [chunkSize]byte{0x82, 0xa2, 0x82, 0xaf}
+	if vocab[1][0] != want {
+		t.Fatalf("expected % x, got % x", want, vocab[1][0])
+	}
+
+	long, err := encodeWord(strings.Repeat("あ", MaxWordLen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(long) != 2 {
+		t.Fatalf("expected 2 chunks, got %d", len(long))
+	}
+
+	for _, w := range []string{"", strings.Repeat("あ", MaxWordLen+1)} {
+		if _, err = encodeVocabulary([]string{"いけ", w}); !errors.Is(err, ErrWordLength) {
+			t.Errorf("%q: expected %v, got %v", w, ErrWordLength, err)
+		}
+	}
+	if _, err = encodeVocabulary(make([]string, MaxWords+1)); err != ErrVocabulary {
+		t.Errorf("expected %v, got %v", ErrVocabulary, err)
+	}
+}
+
+func TestResult(t *testing.T) {
+	data := []byte{
+		0x00, 0x00, 0x00, 0x02, 0x01, 0x20, 0x00, 0x30, 0x01, 0x90,
+		0x00, 0x03, 0x00, 0x07, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x40, 0x01, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	r, err := parseResult(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Level != 0x120 || r.SignalNoise != 0x30 || r.Duration != 400 {
+		t.Errorf("unexpected levels %+v", r)
+	}
+	if len(r.Matches) != 2 || r.Matches[0] != (Match{3, 0x40}) || r.Matches[1] != (Match{7, 0x110}) {
+		t.Fatalf("unexpected matches %v", r.Matches)
+	}
+	if m, ok := r.Best(); !ok || m.Word != 3 {
+		t.Errorf("unexpected best match %v", m)
+	}
+
+	data[0] = byte(WarnNoisy >> 8)
+	if r, _ = parseResult(data); r.Warning != WarnNoisy {
+		t.Errorf("expected warning %#x, got %#x", WarnNoisy, r.Warning)
+	}
+	if _, ok := r.Best(); ok {
+		t.Error("expected no best match with warning")
+	}
+
+	data[3] = MaxMatches + 1
+	if _, err = parseResult(data); err == nil {
+		t.Error("expected error")
+	}
+}
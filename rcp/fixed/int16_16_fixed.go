@@ -0,0 +1,99 @@
+package fixed
+
+import "image"
+
+type Int16_16 int32
+
+func Int16_16U(i int) Int16_16     { return Int16_16(i << 16) }
+func Int16_16F(f float32) Int16_16 { return Int16_16(f * (1 << 16)) }
+
+func (x Int16_16) Floor() int              { return int(x >> 16) }
+func (x Int16_16) Ceil() int               { return int(int64(x) + (1<<16-1)>>16) }
+func (x Int16_16) Mul(y Int16_16) Int16_16 { return Int16_16((int64(x) * int64(y)) >> 16) }
+func (x Int16_16) Div(y Int16_16) Int16_16 { return Int16_16(int64(x) << 16 / int64(y)) }
+func (x Int16_16) String() string          { return asString(int64(x), 16, 5, 5) }
+
+type Point16_16 struct {
+	X, Y Int16_16
+}
+
+func Pt16_16U(x, y int) Point16_16      { return Point16_16{Int16_16U(x), Int16_16U(y)} }
+func Pt16_16F(x, y float32) Point16_16  { return Point16_16{Int16_16F(x), Int16_16F(y)} }
+func Pt16_16P(p image.Point) Point16_16 { return Point16_16{Int16_16U(p.X), Int16_16U(p.Y)} }
+
+func (p Point16_16) Add(q Point16_16) Point16_16 { return Point16_16{p.X + q.X, p.Y + q.Y} }
+func (p Point16_16) Sub(q Point16_16) Point16_16 { return Point16_16{p.X - q.X, p.Y - q.Y} }
+func (p Point16_16) Mul(k Int16_16) Point16_16   { return Point16_16{p.X.Mul(k), p.Y.Mul(k)} }
+func (p Point16_16) Div(k Int16_16) Point16_16   { return Point16_16{p.X.Div(k), p.Y.Div(k)} }
+func (p Point16_16) Pt() image.Point             { return image.Point{p.X.Floor(), p.Y.Floor()} }
+
+type Rectangle16_16 struct {
+	Min, Max Point16_16
+}
+
+func Rect16_16U(x0, y0, x1, y1 int) Rectangle16_16 {
+	return Rectangle16_16{Pt16_16U(x0, y0), Pt16_16U(x1, y1)}
+}
+
+func Rect16_16F(x0, y0, x1, y1 float32) Rectangle16_16 {
+	return Rectangle16_16{Pt16_16F(x0, y0), Pt16_16F(x1, y1)}
+}
+
+func Rect16_16R(r image.Rectangle) Rectangle16_16 {
+	return Rectangle16_16{Pt16_16P(r.Min), Pt16_16P(r.Max)}
+}
+
+func (r Rectangle16_16) Add(p Point16_16) Rectangle16_16 {
+	return Rectangle16_16{
+		Point16_16{r.Min.X + p.X, r.Min.Y + p.Y},
+		Point16_16{r.Max.X + p.X, r.Max.Y + p.Y},
+	}
+}
+
+func (r Rectangle16_16) Sub(p Point16_16) Rectangle16_16 {
+	return Rectangle16_16{
+		Point16_16{r.Min.X - p.X, r.Min.Y - p.Y},
+		Point16_16{r.Max.X - p.X, r.Max.Y - p.Y},
+	}
+}
+
+func (r Rectangle16_16) Intersect(s Rectangle16_16) Rectangle16_16 {
+	r.Min.X = max(r.Min.X, s.Min.X)
+	r.Min.Y = max(r.Min.Y, s.Min.Y)
+	r.Max.X = min(r.Max.X, s.Max.X)
+	r.Max.Y = min(r.Max.Y, s.Max.Y)
+	if r.Empty() {
+		return Rectangle16_16{}
+	}
+	return r
+}
+
+func (r Rectangle16_16) Union(s Rectangle16_16) Rectangle16_16 {
+	if r.Empty() {
+		return s
+	}
+	if s.Empty() {
+		return r
+	}
+	r.Min.X = min(r.Min.X, s.Min.X)
+	r.Min.Y = min(r.Min.Y, s.Min.Y)
+	r.Max.X = max(r.Max.X, s.Max.X)
+	r.Max.Y = max(r.Max.Y, s.Max.Y)
+	return r
+}
+
+func (r Rectangle16_16) Empty() bool {
+	return r.Min.X >= r.Max.X || r.Min.Y >= r.Max.Y
+}
+
+func (r Rectangle16_16) In(s Rectangle16_16) bool {
+	if r.Empty() {
+		return true
+	}
+	return s.Min.X <= r.Min.X && r.Max.X <= s.Max.X &&
+		s.Min.Y <= r.Min.Y && r.Max.Y <= s.Max.Y
+}
+
+func (r Rectangle16_16) Rect() image.Rectangle {
+	return image.Rectangle{Point16_16(r.Min).Pt(), Point16_16(r.Max).Pt()}
+}
@@ -2,6 +2,7 @@ package rcp
 
 import (
 	"embedded/rtos"
+	"sync/atomic"
 
 	_ "unsafe" // for linkname
 )
@@ -79,9 +80,18 @@ func Handler(int interruptFlag) func() {
 // reboots with the button's release, but not before 500ms have passed.
 var Reset rtos.Cond
 
+var resetPending atomic.Bool
+
+// ResetPending reports whether the console's reset button was pressed. Unlike
+// waiting on [Reset] it can be checked by any number of goroutines.
+func ResetPending() bool {
+	return resetPending.Load()
+}
+
 //go:linkname prenmiHandler IRQ5_Handler
 //go:interrupthandler
 func prenmiHandler() {
 	IrqPrenmi.Disable(0)
+	resetPending.Store(true)
 	Reset.Signal()
 }
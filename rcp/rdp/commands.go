@@ -112,6 +112,8 @@ type DisplayList struct {
 	bufIdx     int
 	start, end uintptr
 
+	submitter Submitter
+
 	pinner cpu.Pinner
 }
 
@@ -132,6 +134,7 @@ var RDP DisplayList
 func init() {
 	RDP.start = uintptr(unsafe.Pointer(&RDP.buf[RDP.bufIdx].commands))
 	RDP.end = RDP.start
+	RDP.submitter = directSubmitter{}
 
 	regs().status.Store(clrFlush | clrFreeze | clrXbus)
 	regs().start.Store(cpu.PAddr(RDP.start))
@@ -160,20 +163,15 @@ func (dl *DisplayList) Push(cmds ...command) { // TODO unexport
 		dl.end += 8
 
 		if dl.end == bufend {
-			regs().end.Store(cpu.PAddr(dl.end))
+			dl.submitter.AppendBuffer(cpu.PAddr(dl.end))
 			dl.bufIdx = 1 - dl.bufIdx
 			dl.start = uintptr(unsafe.Pointer(&dl.buf[dl.bufIdx].commands))
 			dl.end = dl.start
-			for retries := 0; regs().status.LoadBits(startPending) != 0; retries++ {
-				if retries > 1024*1024 { // wait max ~1 sec
-					panic("rdp stall")
-				}
-			}
-			regs().start.Store(cpu.PAddr(dl.start))
-			regs().end.Store(cpu.PAddr(dl.start))
+			bufend = dl.start + uintptr(len(dl.buf[0].commands)<<3)
+			dl.submitter.SetBuffer(cpu.PAddr(dl.start), cpu.PAddr(dl.end), cpu.PAddr(bufend))
 		}
 	}
-	regs().end.Store(cpu.PAddr(dl.end))
+	dl.submitter.AppendBuffer(cpu.PAddr(dl.end))
 }
 
 // Sets the framebuffer to render the final image into.
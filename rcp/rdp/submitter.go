@@ -0,0 +1,54 @@
+package rdp
+
+import (
+	"github.com/clktmr/n64/rcp/cpu"
+)
+
+// Submitter passes buffers of commands written by a [DisplayList] to the RDP.
+// By default the display list writes the RDP's registers directly. Other
+// implementations might pass the buffers via the RSP instead, which orders
+// them with commands generated by the RSP.
+type Submitter interface {
+	// SetBuffer lets the RDP continue with the commands from start to end
+	// after it finished the current buffer. The buffer might be extended
+	// up to sentinel by subsequent calls to AppendBuffer. SetBuffer must
+	// not return before the RDP has read all commands previously submitted
+	// from the buffer at start.
+	SetBuffer(start, end, sentinel cpu.Addr)
+
+	// AppendBuffer lets the RDP process the current buffer up to end.
+	AppendBuffer(end cpu.Addr)
+}
+
+// SetSubmitter changes how the display list passes its commands to the RDP.
+// It flushes all commands enqueued so far. Passing nil restores writing the
+// RDP's registers directly.
+func (dl *DisplayList) SetSubmitter(s Submitter) {
+	if s == nil {
+		s = directSubmitter{}
+	}
+	dl.Flush()
+
+	// Continue in the current buffer, starting after the flushed commands.
+	// Appending first lets the new submitter know where the RDP stopped.
+	bufend := dl.start + uintptr(len(dl.buf[0].commands)<<3)
+	dl.submitter = s
+	s.AppendBuffer(cpu.PAddr(dl.end))
+	s.SetBuffer(cpu.PAddr(dl.end), cpu.PAddr(dl.end), cpu.PAddr(bufend))
+}
+
+type directSubmitter struct{}
+
+func (directSubmitter) SetBuffer(start, end, sentinel cpu.Addr) {
+	for retries := 0; regs().status.LoadBits(startPending) != 0; retries++ {
+		if retries > 1024*1024 { // wait max ~1 sec
+			panic("rdp stall")
+		}
+	}
+	regs().start.Store(start)
+	regs().end.Store(end)
+}
+
+func (directSubmitter) AppendBuffer(end cpu.Addr) {
+	regs().end.Store(end)
+}
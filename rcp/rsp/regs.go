@@ -93,6 +93,16 @@ func SetInterrupt(en bool) {
 func Stopped() bool { return regs().status.LoadBits(halted|dmaBusy) == halted }
 func Broke() bool   { return regs().status.LoadBits(broke) != 0 }
 func Resume()       { regs().status.Store(clrBroke | clrHalt) }
+
+// Halt stops the RSP after the current instruction and waits for pending DMA
+// transfers to finish.
+func Halt() {
+	regs().status.Store(setHalt)
+	for !Stopped() {
+		// wait
+	}
+}
+
 func Step() {
 	regs().status.Store(setSingleStep)
 	Resume()
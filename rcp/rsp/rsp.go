@@ -10,15 +10,18 @@ import (
 func init() {
 	regs().status.Store(setHalt | clrSingleStep)
 	pc().Store(0x1000)
-	rcp.SetHandler(rcp.IntrRSP, handler)
+	rcp.SetHandler(rcp.IntrRSP, Handler)
 	rcp.EnableInterrupts(rcp.IntrRSP)
 }
 
 var IntBreak rtos.Cond
 
+// Handler acknowledges the RSP interrupt and signals [IntBreak]. Drivers which
+// install their own interrupt handler must call it.
+//
 //go:nosplit
 //go:nowritebarrierrec
-func handler() {
+func Handler() {
 	regs().status.Store(clrIntr)
 	IntBreak.Signal()
 }
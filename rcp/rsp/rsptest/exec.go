@@ -0,0 +1,180 @@
+package rsptest
+
+import "fmt"
+
+// execute runs a single instruction. When called, r.pc already points to the
+// delay slot and r.npc to the instruction after it.
+func (r *RSP) execute(inst, pc uint32) error {
+	rs, rt, rd, sa := inst>>21&31, inst>>16&31, inst>>11&31, inst>>6&31
+	imm := uint32(int32(int16(inst)))
+	s, t := r.gpr[rs], r.gpr[rt]
+	branch := func(cond bool) {
+		if cond {
+			r.npc = (pc + 4 + imm<<2) & 0xffc
+		}
+	}
+	link := func(reg uint32) {
+		r.gpr[reg] = (pc + 8) & 0xfff
+	}
+	addr := s + imm
+
+	switch op := inst >> 26; op {
+	case 0x00: // SPECIAL
+		switch inst & 0x3f {
+		case 0x00:
+			r.gpr[rd] = t << sa
+		case 0x02:
+			r.gpr[rd] = t >> sa
+		case 0x03:
+			r.gpr[rd] = uint32(int32(t) >> sa)
+		case 0x04:
+			r.gpr[rd] = t << (s & 31)
+		case 0x06:
+			r.gpr[rd] = t >> (s & 31)
+		case 0x07:
+			r.gpr[rd] = uint32(int32(t) >> (s & 31))
+		case 0x08:
+			r.npc = s & 0xffc
+		case 0x09:
+			r.npc = s & 0xffc
+			link(rd)
+		case 0x0d:
+			r.breakpoint()
+		case 0x20, 0x21:
+			r.gpr[rd] = s + t
+		case 0x22, 0x23:
+			r.gpr[rd] = s - t
+		case 0x24:
+			r.gpr[rd] = s & t
+		case 0x25:
+			r.gpr[rd] = s | t
+		case 0x26:
+			r.gpr[rd] = s ^ t
+		case 0x27:
+			r.gpr[rd] = ^(s | t)
+		case 0x2a:
+			r.gpr[rd] = b2u(int32(s) < int32(t))
+		case 0x2b:
+			r.gpr[rd] = b2u(s < t)
+		default:
+			return r.invalid(inst, pc)
+		}
+	case 0x01: // REGIMM
+		switch rt {
+		case 0x00:
+			branch(int32(s) < 0)
+		case 0x01:
+			branch(int32(s) >= 0)
+		case 0x10:
+			branch(int32(s) < 0)
+			link(31)
+		case 0x11:
+			branch(int32(s) >= 0)
+			link(31)
+		default:
+			return r.invalid(inst, pc)
+		}
+	case 0x02, 0x03:
+		r.npc = inst << 2 & 0xffc
+		if op == 0x03 {
+			link(31)
+		}
+	case 0x04:
+		branch(s == t)
+	case 0x05:
+		branch(s != t)
+	case 0x06:
+		branch(int32(s) <= 0)
+	case 0x07:
+		branch(int32(s) > 0)
+	case 0x08, 0x09:
+		r.gpr[rt] = s + imm
+	case 0x0a:
+		r.gpr[rt] = b2u(int32(s) < int32(imm))
+	case 0x0b:
+		r.gpr[rt] = b2u(s < imm)
+	case 0x0c:
+		r.gpr[rt] = s & (inst & 0xffff)
+	case 0x0d:
+		r.gpr[rt] = s | (inst & 0xffff)
+	case 0x0e:
+		r.gpr[rt] = s ^ (inst & 0xffff)
+	case 0x0f:
+		r.gpr[rt] = inst << 16
+	case 0x10: // COP0
+		switch rs {
+		case 0x00:
+			r.gpr[rt] = r.readCop0(rd)
+		case 0x04:
+			r.writeCop0(rd, t)
+		default:
+			return r.invalid(inst, pc)
+		}
+	case 0x12: // COP2
+		if rs&0x10 != 0 {
+			r.vu.compute(inst)
+			break
+		}
+		switch rs {
+		case 0x00:
+			r.gpr[rt] = r.vu.mfc2(rd, inst>>7&15)
+		case 0x02:
+			r.gpr[rt] = r.vu.cfc2(rd)
+		case 0x04:
+			r.vu.mtc2(rd, inst>>7&15, t)
+		case 0x06:
+			r.vu.ctc2(rd, t)
+		default:
+			return r.invalid(inst, pc)
+		}
+	case 0x20:
+		r.gpr[rt] = uint32(int32(int8(r.load(addr, 1))))
+	case 0x21:
+		r.gpr[rt] = uint32(int32(int16(r.load(addr, 2))))
+	case 0x23, 0x27:
+		r.gpr[rt] = r.load(addr, 4)
+	case 0x24:
+		r.gpr[rt] = r.load(addr, 1)
+	case 0x25:
+		r.gpr[rt] = r.load(addr, 2)
+	case 0x28:
+		r.store(addr, 1, t)
+	case 0x29:
+		r.store(addr, 2, t)
+	case 0x2b:
+		r.store(addr, 4, t)
+	case 0x32:
+		r.vu.load(&r.DMEM, rd, rt, inst>>7&15, s, int32(inst<<25)>>25)
+	case 0x3a:
+		r.vu.store(&r.DMEM, rd, rt, inst>>7&15, s, int32(inst<<25)>>25)
+	default:
+		return r.invalid(inst, pc)
+	}
+	return nil
+}
+
+func (r *RSP) invalid(inst, pc uint32) error {
+	return fmt.Errorf("rsp: invalid instruction %08x at %#03x", inst, pc)
+}
+
+// load reads size bytes from DMEM in big endian order. Addresses wrap around
+// and don't need to be aligned.
+func (r *RSP) load(addr uint32, size int) (v uint32) {
+	for i := range size {
+		v = v<<8 | uint32(r.DMEM[(addr+uint32(i))&0xfff])
+	}
+	return
+}
+
+func (r *RSP) store(addr uint32, size int, v uint32) {
+	for i := range size {
+		r.DMEM[(addr+uint32(i))&0xfff] = byte(v >> (8 * (size - 1 - i)))
+	}
+}
+
+func b2u(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,381 @@
+// Package rsptest provides a simulated RSP, which runs microcode on the host.
+//
+// It interprets the scalar and vector unit instructions and implements DMA
+// between IMEM/DMEM and a simulated RDRAM, the status register with its signal
+// bits as seen by package rsp, and the RDP command registers. It's meant for
+// testing microcode in plain 'go test', not for cycle exact emulation: every
+// instruction takes a single cycle and a DMA transfer takes one cycle per eight
+// bytes.
+package rsptest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/clktmr/n64/rcp/rsp/ucode"
+)
+
+const MemSize = 0x1000 // Size of IMEM and DMEM
+
+var (
+	ErrTimeout = errors.New("rsp: timeout")
+	ErrDMA     = errors.New("rsp: dma out of bounds")
+)
+
+// Status register bits on read, see package rsp.
+const (
+	halted uint32 = 1 << iota
+	broke
+	dmaBusy
+	dmaFull
+	ioBusy
+	singleStep
+	intrOnBreak
+	sig0
+)
+
+// Status register bits on write, see package rsp.
+const (
+	clrHalt uint32 = 1 << iota
+	setHalt
+	clrBroke
+	clrIntr
+	setIntr
+	clrSingleStep
+	setSingleStep
+	clrIntbreak
+	setIntbreak
+	clrSig0
+	setSig0
+)
+
+// RDP status register bits.
+const (
+	dpcXbus     uint32 = 0x01
+	dpcCbufRdy  uint32 = 0x80
+	dpcClrXbus  uint32 = 0x01
+	dpcSetXbus  uint32 = 0x02
+	dmaCycleLen        = 8 // bytes transferred per cycle
+)
+
+type dmaTransfer struct {
+	memAddr, dramAddr uint32
+	length            uint32 // length register as written
+	write             bool   // from RSP memory to RDRAM
+	cycles            int
+}
+
+// RSP is a simulated signal processor. The zero value is a halted RSP without
+// RDRAM.
+type RSP struct {
+	IMEM  [MemSize]byte
+	DMEM  [MemSize]byte
+	RDRAM []byte // target of DMA transfers, indexed by physical address
+
+	// RDP is called with the commands sent to the RDP by writing the DPC_END
+	// register, either from RDRAM or DMEM. Commands are discarded if nil.
+	RDP func(cmds []byte)
+
+	// Interrupt is set by a break instruction if interrupts are enabled by
+	// [RSP.SetInterrupt], or by the microcode. It must be cleared by the
+	// caller.
+	Interrupt bool
+
+	Cycles uint64 // number of cycles executed
+
+	pc, npc uint32
+	status  uint32
+	gpr     [32]uint32
+	vu      vectorUnit
+	sem     uint32
+
+	memAddr, dramAddr uint32
+	dma               []dmaTransfer
+
+	dpcStart, dpcEnd, dpcCurrent, dpcStatus uint32
+
+	err error
+}
+
+// New returns a halted RSP with size bytes of RDRAM.
+func New(size int) *RSP {
+	return &RSP{RDRAM: make([]byte, size), status: halted}
+}
+
+// Load copies the microcode to IMEM and DMEM and sets the program counter to
+// its entry point. Like [rsp.Load], it doesn't start execution.
+func (r *RSP) Load(uc *ucode.UCode) {
+	copy(r.IMEM[:], uc.Text)
+	copy(r.DMEM[:], uc.Data)
+	r.SetPC(uint32(uc.Entry))
+}
+
+// SetPC sets the program counter. Only the lower 12 bits are used.
+func (r *RSP) SetPC(pc uint32) {
+	r.pc = pc & 0xffc
+	r.npc = (r.pc + 4) & 0xffc
+}
+
+// PC returns the address of the next instruction to execute.
+func (r *RSP) PC() uint32 { return r.pc }
+
+func (r *RSP) Stopped() bool { return r.status&halted != 0 && len(r.dma) == 0 }
+func (r *RSP) Broke() bool   { return r.status&broke != 0 }
+func (r *RSP) Resume()       { r.writeStatus(clrBroke | clrHalt) }
+
+// SetInterrupt enables raising an interrupt on break instructions.
+func (r *RSP) SetInterrupt(en bool) {
+	if en {
+		r.writeStatus(setIntbreak)
+	} else {
+		r.writeStatus(clrIntbreak)
+	}
+}
+
+func (r *RSP) Signals() uint8       { return uint8(r.status >> 7) }
+func (r *RSP) SetSignals(s uint8)   { r.writeStatus(interleave(s) << 10) }
+func (r *RSP) ClearSignals(s uint8) { r.writeStatus(interleave(s) << 9) }
+
+// interleave puts a zero bit before every bit in mask.
+func interleave(mask uint8) uint32 {
+	var r uint32
+	for i := range 8 {
+		r |= uint32(mask>>i&1) << (2 * i)
+	}
+	return r
+}
+
+// GPR returns the scalar register i.
+func (r *RSP) GPR(i int) uint32 { return r.gpr[i] }
+
+// VPR returns the vector register i.
+func (r *RSP) VPR(i int) [8]uint16 { return r.vu.regs[i] }
+
+// Run executes instructions until the RSP halts, pending DMA transfers are
+// finished included. It returns [ErrTimeout] if it didn't halt within
+// maxCycles.
+func (r *RSP) Run(maxCycles uint64) error {
+	for n := uint64(0); !r.Stopped(); n++ {
+		if n >= maxCycles {
+			return ErrTimeout
+		}
+		if err := r.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Step advances the RSP by a single cycle. It executes one instruction if the
+// RSP isn't halted.
+func (r *RSP) Step() error {
+	if r.status&halted == 0 {
+		inst := binary.BigEndian.Uint32(r.IMEM[r.pc:])
+		pc := r.pc
+		r.pc, r.npc = r.npc, (r.npc+4)&0xffc
+		if err := r.execute(inst, pc); err != nil {
+			r.status |= halted
+			r.pc = pc
+			return err
+		}
+		r.gpr[0] = 0
+		if r.status&singleStep != 0 {
+			r.status |= halted
+		}
+	}
+	r.stepDMA()
+	r.Cycles++
+	if r.err != nil {
+		err := r.err
+		r.err = nil
+		return err
+	}
+	return nil
+}
+
+func (r *RSP) readStatus() uint32 {
+	status := r.status
+	if len(r.dma) > 0 {
+		status |= dmaBusy
+	}
+	if len(r.dma) > 1 {
+		status |= dmaFull
+	}
+	return status
+}
+
+func (r *RSP) writeStatus(v uint32) {
+	pair := func(clr, set, flag uint32) {
+		if v&clr != 0 && v&set == 0 {
+			r.status &^= flag
+		} else if v&set != 0 && v&clr == 0 {
+			r.status |= flag
+		}
+	}
+	pair(clrHalt, setHalt, halted)
+	if v&clrBroke != 0 {
+		r.status &^= broke
+	}
+	if v&clrIntr != 0 && v&setIntr == 0 {
+		r.Interrupt = false
+	} else if v&setIntr != 0 && v&clrIntr == 0 {
+		r.Interrupt = true
+	}
+	pair(clrSingleStep, setSingleStep, singleStep)
+	pair(clrIntbreak, setIntbreak, intrOnBreak)
+	for i := range 8 {
+		pair(clrSig0<<(2*i), setSig0<<(2*i), sig0<<i)
+	}
+}
+
+func (r *RSP) breakpoint() {
+	r.status |= halted | broke
+	if r.status&intrOnBreak != 0 {
+		r.Interrupt = true
+	}
+}
+
+// COP0 registers
+const (
+	c0MemAddr = iota
+	c0DramAddr
+	c0ReadLen
+	c0WriteLen
+	c0Status
+	c0DmaFull
+	c0DmaBusy
+	c0Semaphore
+	c0DpcStart
+	c0DpcEnd
+	c0DpcCurrent
+	c0DpcStatus
+	c0DpcClock
+	c0DpcBufBusy
+	c0DpcPipeBusy
+	c0DpcTmem
+)
+
+func (r *RSP) readCop0(reg uint32) uint32 {
+	switch reg & 15 {
+	case c0MemAddr:
+		return r.memAddr
+	case c0DramAddr:
+		return r.dramAddr
+	case c0ReadLen, c0WriteLen:
+		return 0xff8
+	case c0Status:
+		return r.readStatus()
+	case c0DmaFull:
+		return r.readStatus() >> 3 & 1
+	case c0DmaBusy:
+		return r.readStatus() >> 2 & 1
+	case c0Semaphore:
+		v := r.sem
+		r.sem = 1
+		return v
+	case c0DpcStart:
+		return r.dpcStart
+	case c0DpcEnd:
+		return r.dpcEnd
+	case c0DpcCurrent:
+		return r.dpcCurrent
+	case c0DpcStatus:
+		return r.dpcStatus | dpcCbufRdy
+	}
+	return 0
+}
+
+func (r *RSP) writeCop0(reg uint32, v uint32) {
+	switch reg & 15 {
+	case c0MemAddr:
+		r.memAddr = v & 0x1ff8
+	case c0DramAddr:
+		r.dramAddr = v & 0xff_fff8
+	case c0ReadLen, c0WriteLen:
+		r.dma = append(r.dma, dmaTransfer{
+			memAddr:  r.memAddr,
+			dramAddr: r.dramAddr,
+			length:   v,
+			write:    reg&15 == c0WriteLen,
+		})
+	case c0Status:
+		r.writeStatus(v)
+	case c0Semaphore:
+		r.sem = 0
+	case c0DpcStart:
+		r.dpcStart = v & 0xff_fff8
+		r.dpcCurrent = r.dpcStart
+	case c0DpcEnd:
+		r.dpcEnd = v & 0xff_fff8
+		r.runRDP()
+	case c0DpcStatus:
+		if v&dpcClrXbus != 0 {
+			r.dpcStatus &^= dpcXbus
+		}
+		if v&dpcSetXbus != 0 {
+			r.dpcStatus |= dpcXbus
+		}
+	}
+}
+
+// runRDP passes all commands between DPC_CURRENT and DPC_END to the RDP.
+func (r *RSP) runRDP() {
+	start, end := r.dpcCurrent, r.dpcEnd
+	r.dpcCurrent = end
+	if end <= start || r.RDP == nil {
+		return
+	}
+	if r.dpcStatus&dpcXbus != 0 {
+		start, end = start&0xfff, min(end&0xfff, MemSize)
+		if start < end {
+			r.RDP(r.DMEM[start:end])
+		}
+		return
+	}
+	if int(end) > len(r.RDRAM) {
+		r.err = fmt.Errorf("%w: rdp commands at %#x", ErrDMA, start)
+		return
+	}
+	r.RDP(r.RDRAM[start:end])
+}
+
+// stepDMA advances the active DMA transfer and performs it when done.
+func (r *RSP) stepDMA() {
+	if len(r.dma) == 0 {
+		return
+	}
+	t := &r.dma[0]
+	length := (t.length&0xfff | 7) + 1
+	count := t.length>>12&0xff + 1
+	skip := t.length >> 20 & 0xff8
+	t.cycles++
+	if t.cycles < int(length*count)/dmaCycleLen {
+		return
+	}
+
+	mem := r.DMEM[:]
+	if t.memAddr&0x1000 != 0 {
+		mem = r.IMEM[:]
+	}
+	memAddr, dramAddr := t.memAddr&0xff8, t.dramAddr
+	for range count {
+		if int(dramAddr+length) > len(r.RDRAM) {
+			r.err = fmt.Errorf("%w: %#x-%#x", ErrDMA, dramAddr, dramAddr+length)
+			break
+		}
+		for i := range length {
+			m := &mem[(memAddr+i)&0xfff]
+			if t.write {
+				r.RDRAM[dramAddr+i] = *m
+			} else {
+				*m = r.RDRAM[dramAddr+i]
+			}
+		}
+		memAddr = (memAddr + length) & 0xfff
+		dramAddr += length + skip
+	}
+	r.memAddr = memAddr | t.memAddr&0x1000
+	r.dramAddr = dramAddr
+	r.dma = r.dma[1:]
+}
@@ -0,0 +1,260 @@
+//go:build !n64
+
+package rsptest_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/clktmr/n64/rcp/rsp/rsptest"
+	"github.com/clktmr/n64/rcp/rsp/ucode"
+	"github.com/clktmr/n64/rcp/rsp/ucode/asm"
+)
+
+func assemble(t *testing.T, src string) *ucode.UCode {
+	t.Helper()
+	uc, err := asm.Assemble(fstest.MapFS{"test.S": {Data: []byte(src)}}, "test.S")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uc
+}
+
+func run(t *testing.T, r *rsptest.RSP, src string) {
+	t.Helper()
+	r.Load(assemble(t, src))
+	r.Resume()
+	if err := r.Run(10000); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Broke() {
+		t.Fatal("expected rsp to halt on break")
+	}
+}
+
+func TestRun(t *testing.T) {
+	// Same program as in package rsp, swaps the first two words in DMEM.
+	code := []byte{
+		0x3c, 0x09, 0xa4, 0x00, //lui   t1,0xa400
+		0x8d, 0x29, 0x00, 0x00, //lw    t1,0(t1)
+		0x3c, 0x0a, 0xa4, 0x00, //lui   t2,0xa400
+		0x8d, 0x4a, 0x00, 0x04, //lw    t2,4(t2)
+		0x3c, 0x01, 0xa4, 0x00, //lui   at,0xa400
+		0xac, 0x2a, 0x00, 0x00, //sw    t2,0(at)
+		0x3c, 0x01, 0xa4, 0x00, //lui   at,0xa400
+		0xac, 0x29, 0x00, 0x04, //sw    t1,4(at)
+		0x00, 0x00, 0x00, 0x0d, //break
+	}
+	data := []byte{
+		0xde, 0xad, 0xbe, 0xef,
+		0xbe, 0xef, 0xf0, 0x0d,
+	}
+	r := rsptest.New(0)
+	r.Load(ucode.NewUCode("testcode", 0xa4001000, code, data))
+	if !r.Stopped() {
+		t.Fatal("expected rsp to be halted after load")
+	}
+	r.Resume()
+	if err := r.Run(100); err != nil {
+		t.Fatal(err)
+	}
+	if r.Cycles != 9 {
+		t.Errorf("expected 9 cycles, got %d", r.Cycles)
+	}
+	results := [2]uint32{
+		binary.BigEndian.Uint32(r.DMEM[0:]),
+		binary.BigEndian.Uint32(r.DMEM[4:]),
+	}
+	if results != [2]uint32{0xbeeff00d, 0xdeadbeef} {
+		t.Errorf("got %08x", results)
+	}
+}
+
+func TestScalar(t *testing.T) {
+	r := rsptest.New(0)
+	run(t, r, `
+		li	$t0, 0x12345678
+		li	$t1, -3
+		sra	$t2, $t1, 1
+		srl	$t3, $t1, 28
+		sltu	$t4, $t0, $t1
+		slt	$t5, $t0, $t1
+		li	$s0, 0
+		li	$s1, 10
+	loop:
+		addiu	$s0, $s0, 3
+		bnez	$s1, loop
+		addiu	$s1, $s1, -1
+		jal	sub
+		nop
+		break	0
+	sub:
+		sh	$t0, 1($zero)
+		lbu	$s2, 2($zero)
+		lb	$s3, 1($zero)
+		jr	$ra
+		addiu	$zero, $zero, 1
+	`)
+	want := map[int]uint32{
+		8:  0x12345678,
+		9:  0xfffffffd,
+		10: 0xfffffffe,
+		11: 0xf,
+		12: 1,
+		13: 0,
+		16: 33,
+		17: 0xffffffff,
+		18: 0x78,
+		19: 0x56,
+		0:  0,
+	}
+	for reg, v := range want {
+		if got := r.GPR(reg); got != v {
+			t.Errorf("register %d: expected %#x, got %#x", reg, v, got)
+		}
+	}
+}
+
+func TestDMA(t *testing.T) {
+	r := rsptest.New(0x1000)
+	for i := range 64 {
+		r.RDRAM[0x100+i] = byte(i)
+	}
+	run(t, r, `
+		li	$t0, 0x10
+		mtc0	$t0, $c0	# SP_MEM_ADDR
+		li	$t0, 0x100
+		mtc0	$t0, $c1	# SP_DRAM_ADDR
+		li	$t0, 0x20-1
+		mtc0	$t0, $c2	# SP_RD_LEN
+	wait1:
+		mfc0	$t0, $c6	# SP_DMA_BUSY
+		bnez	$t0, wait1
+		nop
+
+		lw	$t0, 0x10($zero)
+		addiu	$t0, $t0, 1
+		sw	$t0, 0x10($zero)
+
+		# Write back in two rows of 8 bytes, skipping 8 bytes each.
+		li	$t0, 0x10
+		mtc0	$t0, $c0
+		li	$t0, 0x200
+		mtc0	$t0, $c1
+		li	$t0, (8<<20)|(1<<12)|(8-1)
+		mtc0	$t0, $c3	# SP_WR_LEN
+		li	$t0, 0x4000	# set signal 2
+		mtc0	$t0, $c4
+		break	0
+	`)
+	if got := r.DMEM[0x10:0x14]; !slices.Equal(got, []byte{0, 1, 2, 4}) {
+		t.Errorf("read: got % x", got)
+	}
+	want := []byte{0, 1, 2, 4, 4, 5, 6, 7, 0, 0, 0, 0, 0, 0, 0, 0, 8, 9, 10, 11, 12, 13, 14, 15}
+	if got := r.RDRAM[0x200 : 0x200+len(want)]; !slices.Equal(got, want) {
+		t.Errorf("write: got % x", got)
+	}
+	if r.Signals() != 1<<2 {
+		t.Errorf("expected signal 2, got %08b", r.Signals())
+	}
+	r.ClearSignals(1 << 2)
+	if r.Signals() != 0 {
+		t.Errorf("expected signals cleared, got %08b", r.Signals())
+	}
+
+	r.Load(assemble(t, `
+		li	$t0, 0xff0
+		mtc0	$t0, $c1
+		li	$t0, 0x20-1
+		mtc0	$t0, $c2
+		break	0
+	`))
+	r.Resume()
+	if err := r.Run(100); !errors.Is(err, rsptest.ErrDMA) {
+		t.Errorf("expected %v, got %v", rsptest.ErrDMA, err)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	r := rsptest.New(0)
+	r.SetInterrupt(true)
+	run(t, r, `
+		break	0
+	`)
+	if !r.Interrupt {
+		t.Error("expected interrupt on break")
+	}
+
+	r.Load(assemble(t, `
+	loop:
+		b	loop
+		nop
+	`))
+	r.Resume()
+	if err := r.Run(100); err != rsptest.ErrTimeout {
+		t.Errorf("expected %v, got %v", rsptest.ErrTimeout, err)
+	}
+}
+
+func TestVector(t *testing.T) {
+	r := rsptest.New(0)
+	run(t, r, `
+		.data
+	a:	.half 0x7fff, 1, -2, 0x4000, 0xffff, 2, 0, -0x8000
+	b:	.half 1, 2, 3, 0x4000, 1, 3, 0, -1
+	out:	.space 16
+
+		.text
+		lqv	$v01, %lo(a)($zero)
+		lqv	$v02, %lo(b)($zero)
+		vadd	$v03, $v01, $v02
+		vsub	$v04, $v01, $v02.e3
+		vmulf	$v05, $v01, $v02
+		vaddc	$v06, $v01, $v02
+		cfc2	$t0, $vco
+		vmudh	$v07, $v01, $v02.e1
+		vsar	$v08, $v00, $v00[8]
+		vrcp	$v09.e0, $v01.e5
+		vrcph	$v09.e1, $v01.e5
+		vlt	$v10, $v01, $v02
+		cfc2	$t1, $vcc
+		vmrg	$v11, $v01, $v02
+		mfc2	$t2, $v01.e2
+		sqv	$v03, %lo(out)($zero)
+		break	0
+	`)
+	tests := []struct {
+		reg  int
+		want [8]uint16
+	}{
+		{3, [8]uint16{0x7fff, 3, 1, 0x7fff, 0, 5, 0, 0x8000}},
+		{4, [8]uint16{0x3fff, 0xc001, 0xbffe, 0, 0xbfff, 0xc002, 0xc000, 0x8000}},
+		{5, [8]uint16{1, 0, 0, 0x2000, 0, 0, 0, 1}},
+		{6, [8]uint16{0x8000, 3, 1, 0x8000, 0, 5, 0, 0x7fff}},
+		{7, [8]uint16{0x7fff, 2, 0xfffc, 0x7fff, 0xfffe, 4, 0, 0x8000}},
+		{8, [8]uint16{0, 0, 0xffff, 0, 0xffff, 0, 0, 0xffff}},
+		{9, [8]uint16{0xe000, 0x3fff, 0, 0, 0, 0, 0, 0}},
+		{10, [8]uint16{1, 1, 0xfffe, 0x4000, 0xffff, 2, 0, 0x8000}},
+		{11, [8]uint16{1, 1, 0xfffe, 0x4000, 0xffff, 2, 0, 0x8000}},
+	}
+	for _, tc := range tests {
+		if got := r.VPR(tc.reg); got != tc.want {
+			t.Errorf("$v%02d: expected %04x, got %04x", tc.reg, tc.want, got)
+		}
+	}
+	if got := r.GPR(8); got != 0x94 {
+		t.Errorf("vco: expected 0x94, got %#x", got)
+	}
+	if got := r.GPR(9); got != 0xb6 {
+		t.Errorf("vcc: expected 0xb6, got %#x", got)
+	}
+	if got := r.GPR(10); got != 0xfffffffe {
+		t.Errorf("mfc2: expected 0xfffffffe, got %#x", got)
+	}
+	if got := binary.BigEndian.Uint16(r.DMEM[32+2:]); got != 3 {
+		t.Errorf("sqv: expected 3, got %#x", got)
+	}
+}
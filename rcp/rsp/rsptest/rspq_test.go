@@ -0,0 +1,384 @@
+//go:build !n64
+
+package rsptest_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/clktmr/n64/drivers/rspq/mixer/vadpcm"
+	"github.com/clktmr/n64/rcp/rsp/rsptest"
+	"github.com/clktmr/n64/rcp/rsp/ucode"
+)
+
+func loadUCode(t *testing.T, name string) *ucode.UCode {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	uc, err := ucode.Load(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uc
+}
+
+// RDRAM layout of the rspq test
+const (
+	lowpriBuf  = 0x1000
+	highpriBuf = 0x2000
+	dummyState = 0x3000
+	ovlText    = 0x4000
+	ovlData    = 0x6000
+	vecSrc     = 0x8000
+	vecMat     = 0x8100
+	vecDst     = 0x8200
+
+	rspqDataAddress = 32
+	sigBufdoneHigh  = 1 << 5
+	sigBufdoneLow   = 1 << 6
+	sigMore         = 1 << 7
+)
+
+// setupRspq replicates rspq.Reset and rspq.Register for a single overlay with
+// id 1.
+func setupRspq(t *testing.T, r *rsptest.RSP, ovl *ucode.UCode) {
+	queue := loadUCode(t, "../../../drivers/rspq/rsp_queue.ucode")
+	r.Load(queue)
+	textSize, dataSize := len(queue.Text), len(queue.Data)
+
+	be := binary.BigEndian
+	copy(r.RDRAM[ovlText:], ovl.Text)
+	copy(r.RDRAM[ovlData:], ovl.Data)
+	stateStart := be.Uint16(ovl.Data[dataSize:])
+	be.PutUint16(r.RDRAM[ovlData+dataSize+4:], 1<<5) // CommandBase
+
+	// rsp_queue_s, see rspQueue in package rspq
+	q := r.DMEM[rspqDataAddress:]
+	q[1] = 1 * 16 // overlay table
+	desc := func(i int, code, data, state uint32, codeSize, dataSize int) {
+		d := q[16+16*i:]
+		be.PutUint32(d[0:], code)
+		be.PutUint32(d[4:], data)
+		be.PutUint32(d[8:], state)
+		be.PutUint16(d[12:], uint16(codeSize))
+		be.PutUint16(d[14:], uint16(dataSize))
+	}
+	desc(0, 0, 0, dummyState, 0, 16)
+	desc(1, ovlText+uint32(textSize), ovlData+uint32(dataSize), ovlData+uint32(stateStart),
+		len(ovl.Text)-textSize, len(ovl.Data)-dataSize)
+	be.PutUint32(q[176:], lowpriBuf)
+	be.PutUint32(q[180:], highpriBuf)
+	be.PutUint32(q[184:], lowpriBuf)
+
+	// Header of the dummy overlay
+	be.PutUint16(r.DMEM[dataSize+2:], 7)
+
+	r.ClearSignals(0xff)
+	r.SetSignals(sigBufdoneLow | sigBufdoneHigh)
+}
+
+func toFixed(v float32) (int16, uint16) {
+	fixed := uint32(int32(v * (1 << 16)))
+	return int16(fixed >> 16), uint16(fixed)
+}
+
+// putVecs writes vectors in the slot format of rsp_vec to RDRAM.
+func putVecs(rdram []byte, vecs [][4]float32) {
+	for i, vec := range vecs {
+		slot := rdram[i/2*32:]
+		for j, v := range vec {
+			lane := (i%2)*4 + j
+			hi, lo := toFixed(v)
+			binary.BigEndian.PutUint16(slot[lane*2:], uint16(hi))
+			binary.BigEndian.PutUint16(slot[16+lane*2:], lo)
+		}
+	}
+}
+
+func getVec(rdram []byte, i int) (vec [4]float32) {
+	slot := rdram[i/2*32:]
+	for j := range vec {
+		lane := (i%2)*4 + j
+		hi := int16(binary.BigEndian.Uint16(slot[lane*2:]))
+		lo := binary.BigEndian.Uint16(slot[16+lane*2:])
+		vec[j] = float32(int32(hi)<<16|int32(lo)) / (1 << 16)
+	}
+	return
+}
+
+func TestRspqVec(t *testing.T) {
+	r := rsptest.New(0x10000)
+	setupRspq(t, r, loadUCode(t, "../../../drivers/rspq/vec/rsp_vec.ucode"))
+
+	vecs := [][4]float32{
+		{0.1, -0.2, 0.3, -0.4}, {0.5, -0.6, -0.7, 0.8},
+		{1.1, -1.2, 1.3, -1.4}, {1.5, -1.6, -1.7, 1.8},
+		{2.1, -2.2, 2.3, -2.4}, {2.5, -2.6, -2.7, 2.8},
+		{3.1, -3.2, 3.3, -3.4}, {3.5, -3.6, -3.7, 3.8},
+	}
+	mat := [][4]float32{ // column major
+		{1.0, 0.0, -0.0, 0.0},
+		{0.0, 1.0, -0.0, 0.0},
+		{0.0, 0.0, -1.0, 0.0},
+		{0.0, 9.0, -0.0, 1.0},
+	}
+	putVecs(r.RDRAM[vecSrc:], vecs)
+	putVecs(r.RDRAM[vecMat:], mat)
+
+	const (
+		cmdLoad  = 0x10
+		cmdStore = 0x11
+		cmdTrans = 0x12
+		slotSize = 32
+		matSlot  = 30
+	)
+	cmds := []uint32{
+		cmdLoad<<24 | vecSrc, (4*slotSize-1)<<16 | 0,
+		cmdLoad<<24 | vecMat, (2*slotSize-1)<<16 | matSlot*slotSize&0xff0,
+	}
+	for i := range 4 {
+		cmds = append(cmds, cmdTrans<<24|uint32(4+i)*slotSize,
+			matSlot*slotSize&0xff0<<16|uint32(i)*slotSize)
+	}
+	cmds = append(cmds, cmdStore<<24|vecDst, (4*slotSize-1)<<16|4*slotSize)
+	for i, cmd := range cmds {
+		binary.BigEndian.PutUint32(r.RDRAM[lowpriBuf+4*i:], cmd)
+	}
+	r.SetSignals(sigMore)
+	r.Resume()
+	if err := r.Run(100000); err != nil {
+		t.Fatal(err)
+	}
+	// See rspq.Crashed
+	if binary.BigEndian.Uint32(r.IMEM[r.PC()+4:]) == 0x00ba000d {
+		t.Fatal("rspq crashed")
+	}
+
+	for i, vec := range vecs {
+		var want [4]float32
+		for row := range want {
+			for col := range vec {
+				want[row] += mat[col][row] * vec[col]
+			}
+		}
+		got := getVec(r.RDRAM[vecDst:], i)
+		for j := range got {
+			if math.Abs(float64(got[j]-want[j])) > 1e-3 {
+				t.Fatalf("vec%d: expected %v, got %v", i, want, got)
+			}
+		}
+	}
+	if !bytes.Equal(r.RDRAM[vecDst+4*slotSize:vecDst+5*slotSize], make([]byte, slotSize)) {
+		t.Error("store exceeded destination")
+	}
+}
+
+func TestRspqBlock(t *testing.T) {
+	r := rsptest.New(0x10000)
+	setupRspq(t, r, loadUCode(t, "../../../drivers/rspq/vec/rsp_vec.ucode"))
+
+	const (
+		cmdJump        = 0x02
+		cmdCall        = 0x03
+		cmdRet         = 0x04
+		cmdWriteStatus = 0x06
+		block          = 0x9000
+		chunk          = 0x9800
+		setSig2        = 1 << 14
+	)
+	put := func(addr int, words ...uint32) {
+		for i, w := range words {
+			binary.BigEndian.PutUint32(r.RDRAM[addr+4*i:], w)
+		}
+	}
+	put(block, cmdJump<<24|chunk)
+	put(chunk, cmdWriteStatus<<24|setSig2, cmdRet<<24|0)
+	put(lowpriBuf, cmdCall<<24|block, 0, cmdCall<<24|block, 0)
+	r.SetSignals(sigMore)
+	r.Resume()
+	if err := r.Run(100000); err != nil {
+		t.Fatal(err)
+	}
+	if binary.BigEndian.Uint32(r.IMEM[r.PC()+4:]) == 0x00ba000d {
+		t.Fatal("rspq crashed")
+	}
+	if r.Signals()&(1<<2) == 0 {
+		t.Error("block wasn't run")
+	}
+	// The second call must have returned to the lowpri queue
+	if got := binary.BigEndian.Uint32(r.DMEM[rspqDataAddress+184:]) & 0xffffff; got < lowpriBuf || got >= highpriBuf {
+		t.Errorf("expected rspq to return to lowpri buffer, got %#x", got)
+	}
+}
+
+func TestRspqHighpri(t *testing.T) {
+	r := rsptest.New(0x10000)
+	setupRspq(t, r, loadUCode(t, "../../../drivers/rspq/vec/rsp_vec.ucode"))
+
+	const (
+		cmdSwapBuffers      = 0x07
+		cmdWriteStatus      = 0x06
+		sigHighpriRunning   = 1 << 3
+		sigHighpriRequested = 1 << 4
+		setIntr             = 1 << 4
+		setSig0             = 1 << 10
+		setSig1             = 1 << 12
+		clrSig3             = 1 << 15
+	)
+	put := func(addr int, words ...uint32) {
+		for i, w := range words {
+			binary.BigEndian.PutUint32(r.RDRAM[addr+4*i:], w)
+		}
+	}
+	put(lowpriBuf, cmdWriteStatus<<24|setSig0)
+	put(highpriBuf, cmdWriteStatus<<24|setSig1, cmdSwapBuffers<<24|0x20, 0x24, setIntr|clrSig3)
+	r.SetSignals(sigMore | sigHighpriRequested)
+	r.Resume()
+
+	// Highpri must run first
+	for r.Signals()&1 == 0 {
+		if r.Signals()&(sigHighpriRequested|sigHighpriRunning) == 0 {
+			break
+		}
+		if err := r.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.Signals()&1 != 0 {
+		t.Fatal("lowpri ran before highpri")
+	}
+	if err := r.Run(100000); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Signals(); got&3 != 3 || got&(sigHighpriRequested|sigHighpriRunning) != 0 {
+		t.Errorf("unexpected signals %08b", got)
+	}
+	if !r.Interrupt {
+		t.Error("expected interrupt at end of highpri")
+	}
+	// The highpri position is saved after the epilog.
+	if got := binary.BigEndian.Uint32(r.DMEM[rspqDataAddress+180:]) & 0xffffff; got != highpriBuf+16 {
+		t.Errorf("expected highpri address %#x, got %#x", highpriBuf+16, got)
+	}
+}
+
+// TestRspqHighpriQueued runs two highpri sequences with a single request, as
+// queued by rspq.HighpriBegin: the epilog of the first sequence is replaced by
+// a jump to the second one. If the RSP read the epilog before it was replaced,
+// the second sequence runs after the next request.
+func TestRspqHighpriQueued(t *testing.T) {
+	const (
+		cmdJump             = 0x02
+		cmdSwapBuffers      = 0x07
+		cmdWriteStatus      = 0x06
+		sigHighpriRunning   = 1 << 3
+		sigHighpriRequested = 1 << 4
+		setIntr             = 1 << 4
+		setSig1             = 1 << 12
+		setSig2             = 1 << 14
+		clrSig3             = 1 << 15
+	)
+	epilog := []uint32{cmdSwapBuffers<<24 | 0x20, 0x24, setIntr | clrSig3}
+
+	for _, patched := range []bool{true, false} {
+		r := rsptest.New(0x10000)
+		setupRspq(t, r, loadUCode(t, "../../../drivers/rspq/vec/rsp_vec.ucode"))
+		put := func(addr int, words ...uint32) {
+			for i, w := range words {
+				binary.BigEndian.PutUint32(r.RDRAM[addr+4*i:], w)
+			}
+		}
+		run := func() {
+			t.Helper()
+			r.SetSignals(sigMore | sigHighpriRequested)
+			r.Resume()
+			if err := r.Run(100000); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		put(highpriBuf, cmdWriteStatus<<24|setSig1)
+		put(highpriBuf+4, epilog...)
+		if patched {
+			put(highpriBuf+4, cmdJump<<24|highpriBuf+16)
+		} else {
+			run()
+			if got := r.Signals(); got&2 == 0 || got&(sigHighpriRequested|sigHighpriRunning) != 0 {
+				t.Fatalf("unexpected signals %08b", got)
+			}
+		}
+		put(highpriBuf+16, cmdWriteStatus<<24|setSig2)
+		put(highpriBuf+20, epilog...)
+		run()
+
+		if got := r.Signals(); got&6 != 6 || got&(sigHighpriRequested|sigHighpriRunning) != 0 {
+			t.Errorf("patched %v: unexpected signals %08b", patched, got)
+		}
+		if got := binary.BigEndian.Uint32(r.DMEM[rspqDataAddress+180:]) & 0xffffff; got != highpriBuf+32 {
+			t.Errorf("patched %v: expected highpri address %#x, got %#x", patched, highpriBuf+32, got)
+		}
+	}
+}
+
+func TestRspqVADPCM(t *testing.T) {
+	r := rsptest.New(0x10000)
+	setupRspq(t, r, loadUCode(t, "../../../drivers/rspq/mixer/rsp_mixer.ucode"))
+
+	const (
+		cmdVADPCMDecompress = 0x11
+		codebook            = 0x8000
+		state               = 0x8100
+		output              = 0xa000
+	)
+	samples := make([]int16, 100*vadpcm.FrameSamples)
+	for i := range samples {
+		x := float64(i) / 8000
+		samples[i] = int16(12000*math.Sin(2*math.Pi*(200+3000*x)*x) + 3000*math.Sin(float64(i*i)))
+	}
+	book := vadpcm.NewCodebook(samples, 4)
+	frames := vadpcm.NewEncoder(book).Encode(nil, samples)
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, book)
+	copy(r.RDRAM[codebook:], buf.Bytes())
+
+	// The RSP decodes in place, the input must be placed at the end of the
+	// output buffer. Decode in two chunks to test the saved state.
+	var cmds []uint32
+	out := output
+	for _, n := range []int{64, 36} {
+		in := out + ((32-vadpcm.FrameSize)*n)&^7
+		copy(r.RDRAM[in:], frames[:n*vadpcm.FrameSize])
+		frames = frames[n*vadpcm.FrameSize:]
+		cmds = append(cmds, cmdVADPCMDecompress<<24|uint32(in),
+			uint32(n-1)<<24|uint32(out), state, codebook)
+		out += 32 * n
+	}
+	for i, cmd := range cmds {
+		binary.BigEndian.PutUint32(r.RDRAM[lowpriBuf+4*i:], cmd)
+	}
+	r.SetSignals(sigMore)
+	r.Resume()
+	if err := r.Run(1000000); err != nil {
+		t.Fatal(err)
+	}
+	if binary.BigEndian.Uint32(r.IMEM[r.PC()+4:]) == 0x00ba000d {
+		t.Fatal("rspq crashed")
+	}
+
+	want, err := vadpcm.NewDecoder(book).Decode(nil, vadpcm.NewEncoder(book).Encode(nil, samples))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, w := range want {
+		got := int16(binary.BigEndian.Uint16(r.RDRAM[output+2*i:]))
+		if got != w {
+			t.Fatalf("sample %d: expected %d, got %d", i, w, got)
+		}
+	}
+}
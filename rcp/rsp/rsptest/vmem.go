@@ -0,0 +1,175 @@
+package rsptest
+
+// Vector loads and stores. The offset is scaled by the access size of the
+// instruction and e selects the first byte in the vector register.
+
+var vmemScale = [16]int32{1, 2, 4, 8, 16, 16, 8, 8, 16, 16, 16, 16, 1, 1, 1, 1}
+
+func (u *vectorUnit) lane(reg uint32, n uint32) uint16 {
+	return u.regs[reg&31][n&7]
+}
+
+func (u *vectorUnit) load(dmem *[MemSize]byte, sub, vt, e, base uint32, off int32) {
+	addr := base + uint32(off*vmemScale[sub&15])
+	read := func(a uint32) byte { return dmem[a&0xfff] }
+
+	switch sub {
+	case 0, 1, 2, 3: // LBV, LSV, LLV, LDV
+		for i := e; i < min(e+uint32(vmemScale[sub]), 16); i++ {
+			u.setByte(vt, i, read(addr))
+			addr++
+		}
+	case 4: // LQV
+		end := e + 16 - addr&15
+		for i := e; i < min(end, 16); i++ {
+			u.setByte(vt, i, read(addr))
+			addr++
+		}
+	case 5: // LRV
+		start := 16 - (int(addr&15) - int(e))
+		addr &^= 15
+		for i := start; i < 16; i++ {
+			u.setByte(vt, uint32(i)&15, read(addr))
+			addr++
+		}
+	case 6, 7: // LPV, LUV
+		shift := 8 - (sub - 6)
+		index := addr&7 - e
+		addr &^= 7
+		for n := range uint32(8) {
+			u.regs[vt][n] = uint16(read(addr+(index+n)&15)) << shift
+		}
+	case 8: // LHV
+		index := addr&7 - e
+		addr &^= 7
+		for n := range uint32(8) {
+			u.regs[vt][n] = uint16(read(addr+(index+n*2)&15)) << 7
+		}
+	case 9: // LFV
+		index := addr&7 - e
+		addr &^= 7
+		var tmp vectorUnit
+		for n := range uint32(4) {
+			tmp.regs[0][n] = uint16(read(addr+(index+n*4)&15)) << 7
+			tmp.regs[0][n+4] = uint16(read(addr+(index+n*4+8)&15)) << 7
+		}
+		for i := e; i < min(e+8, 16); i++ {
+			u.setByte(vt, i, tmp.byte(0, i))
+		}
+	case 10: // LWV
+		for i := 16 - e; i < e+16; i++ {
+			u.setByte(vt, i&15, read(addr))
+			addr += 4
+		}
+	case 11: // LTV
+		begin := addr &^ 7
+		addr = begin + (e+addr&8)&15
+		vtoff := e >> 1
+		next := func() byte {
+			b := read(addr)
+			if addr++; addr == begin+16 {
+				addr = begin
+			}
+			return b
+		}
+		for i := range uint32(8) {
+			reg := vt&^7 + vtoff
+			u.setByte(reg, i*2, next())
+			u.setByte(reg, i*2+1, next())
+			vtoff = (vtoff + 1) & 7
+		}
+	}
+}
+
+func (u *vectorUnit) store(dmem *[MemSize]byte, sub, vt, e, base uint32, off int32) {
+	addr := base + uint32(off*vmemScale[sub&15])
+	write := func(a uint32, b byte) { dmem[a&0xfff] = b }
+
+	switch sub {
+	case 0, 1, 2, 3: // SBV, SSV, SLV, SDV
+		for i := e; i < e+uint32(vmemScale[sub]); i++ {
+			write(addr, u.byte(vt, i&15))
+			addr++
+		}
+	case 4: // SQV
+		end := e + 16 - addr&15
+		for i := e; i < end; i++ {
+			write(addr, u.byte(vt, i&15))
+			addr++
+		}
+	case 5: // SRV
+		end := e + addr&15
+		b := 16 - addr&15
+		addr &^= 15
+		for i := e; i < end; i++ {
+			write(addr, u.byte(vt, (i+b)&15))
+			addr++
+		}
+	case 6, 7: // SPV, SUV
+		for i := e; i < e+8; i++ {
+			packed := i&15 < 8
+			if sub == 7 {
+				packed = !packed
+			}
+			if packed {
+				write(addr, u.byte(vt, (i&7)<<1))
+			} else {
+				write(addr, byte(u.lane(vt, i)>>7))
+			}
+			addr++
+		}
+	case 8: // SHV
+		index := addr & 7
+		addr &^= 7
+		for n := range uint32(8) {
+			b := n<<1 + e
+			v := u.byte(vt, b&15)<<1 | u.byte(vt, (b+1)&15)>>7
+			write(addr+(index+n*2)&15, v)
+		}
+	case 9: // SFV
+		index := addr & 7
+		addr &^= 7
+		var lanes []uint32
+		switch e {
+		case 0, 15:
+			lanes = []uint32{0, 1, 2, 3}
+		case 1:
+			lanes = []uint32{6, 7, 4, 5}
+		case 4:
+			lanes = []uint32{1, 2, 3, 0}
+		case 5:
+			lanes = []uint32{7, 4, 5, 6}
+		case 8:
+			lanes = []uint32{4, 5, 6, 7}
+		case 11:
+			lanes = []uint32{3, 0, 1, 2}
+		case 12:
+			lanes = []uint32{5, 6, 7, 4}
+		}
+		for n := range uint32(4) {
+			var v byte
+			if lanes != nil {
+				v = byte(u.lane(vt, lanes[n]) >> 7)
+			}
+			write(addr+(index+n*4)&15, v)
+		}
+	case 10: // SWV
+		b := addr & 7
+		addr &^= 7
+		for i := e; i < e+16; i++ {
+			write(addr+b&15, u.byte(vt, i&15))
+			b++
+		}
+	case 11: // STV
+		element := 16 - e&^1
+		b := addr&7 - e&^1
+		addr &^= 7
+		for reg := vt &^ 7; reg < vt&^7+8; reg++ {
+			for range 2 {
+				write(addr+b&15, u.byte(reg, element&15))
+				b++
+				element++
+			}
+		}
+	}
+}
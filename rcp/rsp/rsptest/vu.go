@@ -0,0 +1,542 @@
+package rsptest
+
+import "math/bits"
+
+// vectorUnit is the state of the RSP's COP2. Flag registers store one bit per
+// lane, with lane 0 in bit 0.
+type vectorUnit struct {
+	regs [32][8]uint16
+	acc  [8]uint64 // 48 bit accumulators
+
+	vcoLo, vcoHi uint8 // carry, not equal
+	vccLo, vccHi uint8 // compare
+	vce          uint8
+
+	divIn, divOut uint16
+	divDP         bool
+}
+
+// Reciprocal and inverse square root tables. The leading one bit is implicit.
+var rcpTable, rsqTable [512]uint16
+
+func init() {
+	rcpTable[0] = 0xffff
+	for i := 1; i < len(rcpTable); i++ {
+		b := uint64(1<<34) / uint64(i+512)
+		rcpTable[i] = uint16((b + 1) >> 8)
+	}
+	for i := range rsqTable {
+		a := uint64(i+512) >> (i % 2)
+		b := uint64(1 << 17)
+		for a*(b+1)*(b+1) < 1<<44 {
+			b++
+		}
+		rsqTable[i] = uint16(b >> 1)
+	}
+}
+
+func (u *vectorUnit) accGet(n int) int64 {
+	return int64(u.acc[n]<<16) >> 16
+}
+
+func (u *vectorUnit) accSet(n int, v int64) {
+	u.acc[n] = uint64(v) & (1<<48 - 1)
+}
+
+func (u *vectorUnit) accH(n int) uint16 { return uint16(u.acc[n] >> 32) }
+func (u *vectorUnit) accM(n int) uint16 { return uint16(u.acc[n] >> 16) }
+func (u *vectorUnit) accL(n int) uint16 { return uint16(u.acc[n]) }
+
+func (u *vectorUnit) setAccL(n int, v uint16) {
+	u.acc[n] = u.acc[n]&^0xffff | uint64(v)
+}
+
+// saturate returns the low or middle slice of the accumulator, if it fits into
+// 32 bit signed. Otherwise returns neg or pos.
+func (u *vectorUnit) saturate(n int, mid bool, neg, pos uint16) uint16 {
+	v := u.accGet(n)
+	switch {
+	case v < -1<<31:
+		return neg
+	case v >= 1<<31:
+		return pos
+	case mid:
+		return u.accM(n)
+	}
+	return u.accL(n)
+}
+
+// saturateUnsigned clamps the middle slice to unsigned 16 bit.
+func (u *vectorUnit) saturateUnsigned(n int) uint16 {
+	v := u.accGet(n)
+	switch {
+	case v < 0:
+		return 0
+	case v>>16 > 0x7fff:
+		return 0xffff
+	}
+	return u.accM(n)
+}
+
+func clamp16(v int32) uint16 {
+	return uint16(min(max(v, -0x8000), 0x7fff))
+}
+
+// elem returns the lane of vt which is used for lane n with element e.
+func elem(n int, e uint32) int {
+	switch {
+	case e < 2:
+		return n
+	case e < 4:
+		return n&^1 | int(e&1)
+	case e < 8:
+		return n&^3 | int(e&3)
+	}
+	return int(e & 7)
+}
+
+func (u *vectorUnit) byte(reg uint32, i uint32) byte {
+	v := u.regs[reg&31][i>>1&7]
+	if i&1 == 0 {
+		return byte(v >> 8)
+	}
+	return byte(v)
+}
+
+func (u *vectorUnit) setByte(reg uint32, i uint32, b byte) {
+	v := &u.regs[reg&31][i>>1&7]
+	if i&1 == 0 {
+		*v = *v&0x00ff | uint16(b)<<8
+	} else {
+		*v = *v&0xff00 | uint16(b)
+	}
+}
+
+func (u *vectorUnit) mfc2(vs, e uint32) uint32 {
+	v := uint16(u.byte(vs, e))<<8 | uint16(u.byte(vs, (e+1)&15))
+	return uint32(int32(int16(v)))
+}
+
+func (u *vectorUnit) mtc2(vs, e uint32, v uint32) {
+	u.setByte(vs, e, byte(v>>8))
+	if e != 15 {
+		u.setByte(vs, e+1, byte(v))
+	}
+}
+
+func (u *vectorUnit) cfc2(rd uint32) uint32 {
+	switch rd & 3 {
+	case 0:
+		return uint32(int32(int16(uint16(u.vcoHi)<<8 | uint16(u.vcoLo))))
+	case 1:
+		return uint32(int32(int16(uint16(u.vccHi)<<8 | uint16(u.vccLo))))
+	}
+	return uint32(u.vce)
+}
+
+func (u *vectorUnit) ctc2(rd uint32, v uint32) {
+	switch rd & 3 {
+	case 0:
+		u.vcoHi, u.vcoLo = uint8(v>>8), uint8(v)
+	case 1:
+		u.vccHi, u.vccLo = uint8(v>>8), uint8(v)
+	default:
+		u.vce = uint8(v)
+	}
+}
+
+func bit(b bool, n int) uint8 {
+	if b {
+		return 1 << n
+	}
+	return 0
+}
+
+// compute runs a computational instruction.
+func (u *vectorUnit) compute(inst uint32) {
+	e, vtr, vsr, vdr := inst>>21&15, inst>>16&31, inst>>11&31, inst>>6&31
+	vs := u.regs[vsr]
+	var vt [8]uint16
+	for n := range vt {
+		vt[n] = u.regs[vtr][elem(n, e)]
+	}
+	vd := &u.regs[vdr]
+
+	// Multiplications
+	mul := func(add, ss, su, us, uu bool, f func(n int, p int64)) {
+		for n := range 8 {
+			var p int64
+			switch {
+			case ss:
+				p = int64(int16(vs[n])) * int64(int16(vt[n]))
+			case su:
+				p = int64(int16(vs[n])) * int64(vt[n])
+			case us:
+				p = int64(vs[n]) * int64(int16(vt[n]))
+			case uu:
+				p = int64(vs[n]) * int64(vt[n])
+			}
+			if !add {
+				u.accSet(n, 0)
+			}
+			f(n, p)
+		}
+	}
+	accAdd := func(n int, v int64) { u.accSet(n, u.accGet(n)+v) }
+
+	switch inst & 0x3f {
+	case 0x00, 0x01: // VMULF, VMULU
+		mul(false, true, false, false, false, func(n int, p int64) {
+			u.accSet(n, p*2+0x8000)
+			if inst&1 == 0 {
+				vd[n] = u.saturate(n, true, 0x8000, 0x7fff)
+			} else {
+				vd[n] = u.saturateUnsigned(n)
+			}
+		})
+	case 0x02, 0x0a: // VRNDP, VRNDN
+		for n := range 8 {
+			p := int64(int16(vt[n]))
+			if vsr&1 != 0 {
+				p <<= 16
+			}
+			acc := u.accGet(n)
+			if inst&0x08 == 0 && acc >= 0 || inst&0x08 != 0 && acc < 0 {
+				u.accSet(n, acc+p)
+			}
+			vd[n] = u.saturate(n, true, 0x8000, 0x7fff)
+		}
+	case 0x03: // VMULQ
+		mul(false, true, false, false, false, func(n int, p int64) {
+			if p < 0 {
+				p += 31
+			}
+			u.accSet(n, p<<16)
+			vd[n] = clamp16(int32(p>>1)) &^ 15
+		})
+	case 0x04: // VMUDL
+		mul(false, false, false, false, true, func(n int, p int64) {
+			u.accSet(n, p>>16)
+			vd[n] = u.accL(n)
+		})
+	case 0x05: // VMUDM
+		mul(false, false, true, false, false, func(n int, p int64) {
+			u.accSet(n, p)
+			vd[n] = u.accM(n)
+		})
+	case 0x06: // VMUDN
+		mul(false, false, false, true, false, func(n int, p int64) {
+			u.accSet(n, p)
+			vd[n] = u.accL(n)
+		})
+	case 0x07: // VMUDH
+		mul(false, true, false, false, false, func(n int, p int64) {
+			u.accSet(n, p<<16)
+			vd[n] = u.saturate(n, true, 0x8000, 0x7fff)
+		})
+	case 0x08: // VMACF
+		mul(true, true, false, false, false, func(n int, p int64) {
+			accAdd(n, p*2)
+			vd[n] = u.saturate(n, true, 0x8000, 0x7fff)
+		})
+	case 0x09: // VMACU
+		mul(true, true, false, false, false, func(n int, p int64) {
+			accAdd(n, p*2)
+			vd[n] = u.saturateUnsigned(n)
+		})
+	case 0x0b: // VMACQ
+		for n := range 8 {
+			p := int32(u.accGet(n) >> 16)
+			if p < 0 && p&(1<<5) == 0 {
+				p += 32
+			} else if p >= 32 && p&(1<<5) == 0 {
+				p -= 32
+			}
+			u.accSet(n, int64(p)<<16|int64(u.accL(n)))
+			vd[n] = clamp16(p>>1) &^ 15
+		}
+	case 0x0c: // VMADL
+		mul(true, false, false, false, true, func(n int, p int64) {
+			accAdd(n, p>>16)
+			vd[n] = u.saturate(n, false, 0, 0xffff)
+		})
+	case 0x0d: // VMADM
+		mul(true, false, true, false, false, func(n int, p int64) {
+			accAdd(n, p)
+			vd[n] = u.saturate(n, true, 0x8000, 0x7fff)
+		})
+	case 0x0e: // VMADN
+		mul(true, false, false, true, false, func(n int, p int64) {
+			accAdd(n, p)
+			vd[n] = u.saturate(n, false, 0, 0xffff)
+		})
+	case 0x0f: // VMADH
+		mul(true, true, false, false, false, func(n int, p int64) {
+			accAdd(n, p<<16)
+			vd[n] = u.saturate(n, true, 0x8000, 0x7fff)
+		})
+
+	case 0x10, 0x11: // VADD, VSUB
+		for n := range 8 {
+			c := int32(u.vcoLo >> n & 1)
+			var v int32
+			if inst&1 == 0 {
+				v = int32(int16(vs[n])) + int32(int16(vt[n])) + c
+			} else {
+				v = int32(int16(vs[n])) - int32(int16(vt[n])) - c
+			}
+			u.setAccL(n, uint16(v))
+			vd[n] = clamp16(v)
+		}
+		u.vcoLo, u.vcoHi = 0, 0
+	case 0x13: // VABS
+		for n := range 8 {
+			var v uint16
+			switch s := int16(vs[n]); {
+			case s < 0 && vt[n] == 0x8000:
+				u.setAccL(n, 0x8000)
+				vd[n] = 0x7fff
+				continue
+			case s < 0:
+				v = -vt[n]
+			case s > 0:
+				v = vt[n]
+			}
+			u.setAccL(n, v)
+			vd[n] = v
+		}
+	case 0x14: // VADDC
+		u.vcoLo, u.vcoHi = 0, 0
+		for n := range 8 {
+			v := uint32(vs[n]) + uint32(vt[n])
+			u.setAccL(n, uint16(v))
+			vd[n] = uint16(v)
+			u.vcoLo |= bit(v > 0xffff, n)
+		}
+	case 0x15: // VSUBC
+		u.vcoLo, u.vcoHi = 0, 0
+		for n := range 8 {
+			v := int32(vs[n]) - int32(vt[n])
+			u.setAccL(n, uint16(v))
+			vd[n] = uint16(v)
+			u.vcoLo |= bit(v < 0, n)
+			u.vcoHi |= bit(v != 0, n)
+		}
+	case 0x1d: // VSAR
+		for n := range 8 {
+			switch e {
+			case 8:
+				vd[n] = u.accH(n)
+			case 9:
+				vd[n] = u.accM(n)
+			case 10:
+				vd[n] = u.accL(n)
+			default:
+				vd[n] = 0
+			}
+		}
+
+	case 0x20, 0x21, 0x22, 0x23: // VLT, VEQ, VNE, VGE
+		u.vccLo, u.vccHi = 0, 0
+		for n := range 8 {
+			s, t := int16(vs[n]), int16(vt[n])
+			ne, carry := u.vcoHi>>n&1 != 0, u.vcoLo>>n&1 != 0
+			var c bool
+			switch inst & 0x3f {
+			case 0x20:
+				c = s < t || s == t && ne && carry
+			case 0x21:
+				c = s == t && !ne
+			case 0x22:
+				c = s != t || ne
+			case 0x23:
+				c = s > t || s == t && !(ne && carry)
+			}
+			u.vccLo |= bit(c, n)
+			if c {
+				u.setAccL(n, vs[n])
+			} else {
+				u.setAccL(n, vt[n])
+			}
+			vd[n] = u.accL(n)
+		}
+		u.vcoLo, u.vcoHi = 0, 0
+	case 0x24: // VCL
+		for n := range 8 {
+			s, t := vs[n], vt[n]
+			le, ge := u.vccLo>>n&1 != 0, u.vccHi>>n&1 != 0
+			if u.vcoLo>>n&1 != 0 {
+				if u.vcoHi>>n&1 == 0 {
+					sum := uint32(s) + uint32(t)
+					zero, carry := uint16(sum) == 0, sum > 0xffff
+					if u.vce>>n&1 != 0 {
+						le = zero || carry
+					} else {
+						le = zero && carry
+					}
+				}
+				if le {
+					u.setAccL(n, -t)
+				} else {
+					u.setAccL(n, s)
+				}
+			} else {
+				if u.vcoHi>>n&1 == 0 {
+					ge = int32(s)-int32(t) >= 0
+				}
+				if ge {
+					u.setAccL(n, t)
+				} else {
+					u.setAccL(n, s)
+				}
+			}
+			u.vccLo = u.vccLo&^(1<<n) | bit(le, n)
+			u.vccHi = u.vccHi&^(1<<n) | bit(ge, n)
+			vd[n] = u.accL(n)
+		}
+		u.vcoLo, u.vcoHi, u.vce = 0, 0, 0
+	case 0x25: // VCH
+		u.vcoLo, u.vcoHi, u.vccLo, u.vccHi, u.vce = 0, 0, 0, 0, 0
+		for n := range 8 {
+			s, t := int32(int16(vs[n])), int32(int16(vt[n]))
+			var le, ge, ce, carry bool
+			var v uint16
+			if (s^t)&0x8000 != 0 {
+				sum := s + t
+				le, ge, ce, carry = sum <= 0, t < 0, sum == -1, true
+				if le {
+					v = uint16(-t)
+				} else {
+					v = uint16(s)
+				}
+				u.vcoHi |= bit(sum != 0 && s != ^t, n)
+			} else {
+				diff := s - t
+				le, ge = t < 0, diff >= 0
+				if ge {
+					v = uint16(t)
+				} else {
+					v = uint16(s)
+				}
+				u.vcoHi |= bit(diff != 0 && s != ^t, n)
+			}
+			u.vccLo |= bit(le, n)
+			u.vccHi |= bit(ge, n)
+			u.vce |= bit(ce, n)
+			u.vcoLo |= bit(carry, n)
+			u.setAccL(n, v)
+			vd[n] = v
+		}
+	case 0x26: // VCR
+		u.vccLo, u.vccHi = 0, 0
+		for n := range 8 {
+			s, t := int32(int16(vs[n])), int32(int16(vt[n]))
+			var le, ge bool
+			var v uint16
+			if (s^t)&0x8000 != 0 {
+				le, ge = s+t+1 <= 0, t < 0
+				if le {
+					v = uint16(^t)
+				} else {
+					v = uint16(s)
+				}
+			} else {
+				le, ge = t < 0, s-t >= 0
+				if ge {
+					v = uint16(t)
+				} else {
+					v = uint16(s)
+				}
+			}
+			u.vccLo |= bit(le, n)
+			u.vccHi |= bit(ge, n)
+			u.setAccL(n, v)
+			vd[n] = v
+		}
+		u.vcoLo, u.vcoHi, u.vce = 0, 0, 0
+	case 0x27: // VMRG
+		for n := range 8 {
+			if u.vccLo>>n&1 != 0 {
+				u.setAccL(n, vs[n])
+			} else {
+				u.setAccL(n, vt[n])
+			}
+			vd[n] = u.accL(n)
+		}
+		u.vcoLo, u.vcoHi = 0, 0
+
+	case 0x28, 0x29, 0x2a, 0x2b, 0x2c, 0x2d: // VAND, VNAND, VOR, VNOR, VXOR, VNXOR
+		for n := range 8 {
+			var v uint16
+			switch inst & 0x3e {
+			case 0x28:
+				v = vs[n] & vt[n]
+			case 0x2a:
+				v = vs[n] | vt[n]
+			case 0x2c:
+				v = vs[n] ^ vt[n]
+			}
+			if inst&1 != 0 {
+				v = ^v
+			}
+			u.setAccL(n, v)
+			vd[n] = v
+		}
+
+	case 0x30, 0x31, 0x34, 0x35: // VRCP, VRCPL, VRSQ, VRSQL
+		in := int32(int16(u.regs[vtr][e&7]))
+		if inst&1 != 0 && u.divDP {
+			in = int32(uint32(u.divIn)<<16 | uint32(u.regs[vtr][e&7]))
+		}
+		res := divide(in, inst&0x04 != 0)
+		u.divDP = false
+		u.divOut = uint16(res >> 16)
+		u.setAccLs(vt)
+		vd[vsr&7] = uint16(res)
+	case 0x32, 0x36: // VRCPH, VRSQH
+		u.setAccLs(vt)
+		u.divDP = true
+		u.divIn = u.regs[vtr][e&7]
+		vd[vsr&7] = u.divOut
+	case 0x33: // VMOV
+		u.setAccLs(vt)
+		vd[vsr&7] = vt[vsr&7]
+	case 0x37, 0x3f: // VNOP, VNULL
+
+	default: // Unused opcodes behave like VZERO
+		for n := range 8 {
+			u.setAccL(n, vs[n]+vt[n])
+			vd[n] = 0
+		}
+	}
+}
+
+func (u *vectorUnit) setAccLs(v [8]uint16) {
+	for n := range v {
+		u.setAccL(n, v[n])
+	}
+}
+
+// divide returns the reciprocal or inverse square root of in as looked up in
+// the RSP's tables.
+func divide(in int32, sqrt bool) int32 {
+	mask := in >> 31
+	data := in ^ mask
+	if in > -32768 {
+		data -= mask
+	}
+	switch {
+	case data == 0:
+		return 0x7fff_ffff
+	case in == -32768:
+		return -0x1_0000
+	}
+	shift := bits.LeadingZeros32(uint32(data))
+	index := uint32(uint64(data)<<shift&0x7fc0_0000) >> 22
+	if sqrt {
+		res := int32(0x10000|uint32(rsqTable[index&0x1fe|uint32(shift&1)])) << 14
+		return res>>((31-shift)>>1) ^ mask
+	}
+	res := int32(0x10000|uint32(rcpTable[index])) << 14
+	return res>>(31-shift) ^ mask
+}
@@ -0,0 +1,713 @@
+// Package asm implements an assembler and disassembler for RSP microcode.
+//
+// The syntax follows the GNU assembler as used by libdragon with
+// '.set noreorder', i.e. delay slots are never filled automatically. Scalar
+// registers are written by name or number with optional '$' prefix, vector
+// registers always as "$v00" to "$v31". Elements are selected by the suffixes
+// ".q0"-".q1", ".h0"-".h3" and ".e0"-".e7", or by "[n]" for the raw value of
+// the element field. For loads, stores and moves ".e<n>" selects the n-th
+// lane, i.e. byte 2n, and offsets are given in bytes:
+//
+//	vmudh	$v01, $v02, $v03.e2
+//	vrcph	$v04.e0, $v05.e1
+//	lqv	$v06, 0x10($s0)
+//	mfc2	$t0, $v07.e3
+//
+// Labels in the .text section are IMEM addresses starting at [IMEMBase], labels
+// in the .data section are DMEM offsets and can be used for loads and stores
+// directly. Use %lo() to get the 16 bit offset of any other address. The entry
+// point is the label _start if defined, else the start of IMEM.
+//
+// Overlays for the rspq command queue are defined by libdragon's macros
+// RSPQ_BeginOverlayHeader, RSPQ_DefineCommand, RSPQ_EndOverlayHeader,
+// RSPQ_BeginSavedState and RSPQ_EndSavedState. The overlay source must first
+// include the disassembly of rsp_queue.ucode, so the rspq code and data
+// precede the overlay's.
+package asm
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/clktmr/n64/rcp/cpu"
+	"github.com/clktmr/n64/rcp/rsp/ucode"
+)
+
+const kseg1 = cpu.Addr(cpu.KSEG1 & 0xffff_ffff)
+
+const maxPasses = 32
+
+// statement is a single statement of the source and its position.
+type statement struct {
+	file string
+	line int
+	text string
+}
+
+type section struct {
+	base uint32
+	data []byte
+}
+
+type assembler struct {
+	fsys  fs.FS
+	stmts []statement
+
+	sects   [2]section // text and data
+	cur     *section
+	syms    map[string]int64 // symbols defined in the current pass
+	prev    map[string]int64 // symbols of the previous pass
+	labels  map[string]bool
+	data    map[string]bool // labels in the data section
+	globals map[string]bool
+	sizes   map[string]int64 // value size of the directive following a label
+	pending []string         // labels not followed by a statement yet
+	incbins map[string][]byte
+	errs    []error
+}
+
+// Symbol is a symbol defined by the source.
+type Symbol struct {
+	Name   string
+	Value  int64
+	Label  bool  // defined as label, i.e. an IMEM address or DMEM offset
+	Data   bool  // label in the .data section
+	Global bool  // declared by .globl
+	Size   int64 // value size of a .byte, .half or .word directive following the label
+}
+
+// Assemble assembles the source file name. The source and all files referenced
+// by .include and .incbin directives are read from fsys. Paths are relative to
+// the including file.
+func Assemble(fsys fs.FS, name string) (*ucode.UCode, error) {
+	uc, _, err := AssembleSymbols(fsys, name)
+	return uc, err
+}
+
+// AssembleSymbols is like [Assemble], but additionally returns the symbols
+// defined by the source, sorted by value.
+func AssembleSymbols(fsys fs.FS, name string) (*ucode.UCode, []Symbol, error) {
+	a := &assembler{fsys: fsys, incbins: make(map[string][]byte)}
+	if err := a.read(name, 0); err != nil {
+		return nil, nil, err
+	}
+
+	for range maxPasses {
+		a.pass()
+		if maps.Equal(a.syms, a.prev) {
+			if len(a.errs) > 0 {
+				return nil, nil, errors.Join(a.errs...)
+			}
+			entry := kseg1 | IMEMBase
+			if v, ok := a.syms["_start"]; ok {
+				entry = kseg1 | cpu.Addr(v)&0x1fff_ffff
+			}
+			return ucode.NewUCode(path.Base(name), entry,
+				a.sects[0].data, a.sects[1].data), a.symbols(), nil
+		}
+		a.prev = a.syms
+	}
+	return nil, nil, errors.New("symbol values don't converge")
+}
+
+func (a *assembler) symbols() (syms []Symbol) {
+	for name, v := range a.syms {
+		syms = append(syms, Symbol{name, v, a.labels[name], a.data[name], a.globals[name], a.sizes[name]})
+	}
+	slices.SortFunc(syms, func(a, b Symbol) int {
+		return cmp.Or(cmp.Compare(a.Value, b.Value), strings.Compare(a.Name, b.Name))
+	})
+	return
+}
+
+// read splits the file name into statements and resolves includes.
+func (a *assembler) read(name string, depth int) error {
+	if depth > 16 {
+		return fmt.Errorf("%s: too many nested includes", name)
+	}
+	src, err := fs.ReadFile(a.fsys, name)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for line := 1; scanner.Scan(); line++ {
+		for _, text := range splitStatements(scanner.Text()) {
+			if arg, ok := strings.CutPrefix(text, ".include"); ok && (arg == "" || arg[0] == ' ' || arg[0] == '\t') {
+				file, err := strconv.Unquote(strings.TrimSpace(arg))
+				if err == nil {
+					err = a.read(path.Join(path.Dir(name), file), depth+1)
+				}
+				if err != nil {
+					return fmt.Errorf("%s:%d: %w", name, line, err)
+				}
+				continue
+			}
+			a.stmts = append(a.stmts, statement{name, line, text})
+		}
+	}
+	return scanner.Err()
+}
+
+// splitStatements strips comments from a line and splits it at ';'.
+func splitStatements(line string) (stmts []string) {
+	start, quoted := 0, false
+	add := func(end int) {
+		if s := strings.TrimSpace(line[start:end]); s != "" {
+			stmts = append(stmts, s)
+		}
+		start = end + 1
+	}
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '#' || strings.HasPrefix(line[i:], "//"):
+			add(i)
+			return
+		case c == ';':
+			add(i)
+		}
+	}
+	add(len(line))
+	return
+}
+
+// splitOperands splits s at all commas outside of parentheses and quotes.
+func splitOperands(s string) (ops []string) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	start, depth, quoted := 0, 0, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			ops = append(ops, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(ops, strings.TrimSpace(s[start:]))
+}
+
+func (a *assembler) pass() {
+	a.sects = [2]section{{base: IMEMBase}, {base: DMEMBase}}
+	a.cur = &a.sects[0]
+	a.syms = make(map[string]int64)
+	a.labels = make(map[string]bool)
+	a.data = make(map[string]bool)
+	a.globals = make(map[string]bool)
+	a.sizes = make(map[string]int64)
+	a.pending = nil
+	a.errs = nil
+	for _, stmt := range a.stmts {
+		if err := a.statement(stmt.text); err != nil {
+			a.errs = append(a.errs, fmt.Errorf("%s:%d: %w", stmt.file, stmt.line, err))
+		}
+	}
+	for i, s := range a.sects {
+		if len(s.data) > MemSize {
+			a.errs = append(a.errs, fmt.Errorf("%s section exceeds %d bytes",
+				[]string{".text", ".data"}[i], MemSize))
+		}
+	}
+}
+
+func (a *assembler) pc() uint32 {
+	return a.cur.base + uint32(len(a.cur.data))
+}
+
+func (a *assembler) lookup(name string) (int64, error) {
+	if v, ok := a.syms[name]; ok {
+		return v, nil
+	}
+	if v, ok := a.prev[name]; ok {
+		return v, nil // forward reference
+	}
+	return 0, fmt.Errorf("undefined symbol %s", name)
+}
+
+func (a *assembler) define(name string, v int64, label bool) error {
+	if _, ok := a.syms[name]; ok && (label || a.labels[name]) {
+		return fmt.Errorf("symbol %s already defined", name)
+	}
+	if _, ok := parseReg(name); ok {
+		return fmt.Errorf("register name %s used as symbol", name)
+	}
+	a.syms[name] = v
+	a.labels[name] = label
+	a.data[name] = label && a.cur == &a.sects[1]
+	return nil
+}
+
+func identLen(s string) int {
+	if s == "" || !isIdentStart(s[0]) {
+		return 0
+	}
+	n := 1
+	for n < len(s) && isIdent(s[n]) {
+		n++
+	}
+	return n
+}
+
+func (a *assembler) statement(s string) error {
+	// Labels
+	for {
+		n := identLen(s)
+		if n == 0 || n >= len(s) || s[n] != ':' {
+			break
+		}
+		if err := a.define(s[:n], int64(a.pc()), true); err != nil {
+			return err
+		}
+		a.pending = append(a.pending, s[:n])
+		s = strings.TrimSpace(s[n+1:])
+	}
+	if s == "" {
+		return nil
+	}
+	labels := a.pending
+	a.pending = nil
+
+	n := identLen(s)
+	if n == 0 {
+		return fmt.Errorf("syntax error: %s", s)
+	}
+	name, rest := s[:n], strings.TrimSpace(s[n:])
+
+	// Assignment
+	if expr, ok := strings.CutPrefix(rest, "="); ok && !strings.HasPrefix(expr, "=") {
+		v, err := a.eval(expr)
+		if err != nil {
+			return err
+		}
+		return a.define(name, v, false)
+	}
+
+	ops := splitOperands(rest)
+	switch {
+	case name[0] == '.':
+		if size, ok := intDirectives[name]; ok {
+			for _, label := range labels {
+				a.sizes[label] = int64(size)
+			}
+		}
+		return a.directive(name, ops)
+	case strings.HasPrefix(name, "RSPQ_"):
+		return a.macro(name, ops)
+	}
+	return a.instruction(strings.ToLower(name), ops)
+}
+
+func (a *assembler) emit(b ...byte) {
+	a.cur.data = append(a.cur.data, b...)
+}
+
+func (a *assembler) emitInt(v int64, size int) {
+	for i := size - 1; i >= 0; i-- {
+		a.emit(byte(v >> (8 * i)))
+	}
+}
+
+func (a *assembler) align(n int64) {
+	for int64(a.pc())%n != 0 {
+		a.emit(0)
+	}
+}
+
+// intDirectives maps the directives emitting integers to the size of their
+// values.
+var intDirectives = map[string]int{".byte": 1, ".half": 2, ".short": 2, ".word": 4, ".long": 4}
+
+func (a *assembler) directive(name string, ops []string) error {
+	nargs := func(n int) error {
+		if len(ops) != n {
+			return fmt.Errorf("%s: expected %d operands, got %d", name, n, len(ops))
+		}
+		return nil
+	}
+	switch name {
+	case ".text":
+		a.cur = &a.sects[0]
+	case ".data":
+		a.cur = &a.sects[1]
+	case ".align", ".balign":
+		if err := nargs(1); err != nil {
+			return err
+		}
+		if name == ".align" {
+			n, err := a.evalRange(ops[0], 0, 12)
+			a.align(1 << n)
+			return err
+		}
+		n, err := a.evalRange(ops[0], 1, MemSize)
+		if err == nil && n&(n-1) != 0 {
+			err = fmt.Errorf("alignment %d not a power of two", n)
+		}
+		if err != nil {
+			return err
+		}
+		a.align(n)
+	case ".byte", ".half", ".short", ".word", ".long":
+		size := intDirectives[name]
+		var errs []error
+		for _, op := range ops {
+			v, err := a.evalRange(op, -1<<(8*size-1), 1<<(8*size)-1)
+			errs = append(errs, err)
+			a.emitInt(v, size)
+		}
+		return errors.Join(errs...)
+	case ".ascii", ".asciz", ".string":
+		for _, op := range ops {
+			s, err := strconv.Unquote(op)
+			if err != nil {
+				return fmt.Errorf("invalid string %s", op)
+			}
+			a.emit([]byte(s)...)
+			if name != ".ascii" {
+				a.emit(0)
+			}
+		}
+	case ".space", ".skip":
+		if len(ops) != 1 && len(ops) != 2 {
+			return nargs(1)
+		}
+		n, err := a.evalRange(ops[0], 0, MemSize)
+		if err != nil {
+			return err
+		}
+		var fill int64
+		if len(ops) == 2 {
+			if fill, err = a.evalRange(ops[1], -0x80, 0xff); err != nil {
+				return err
+			}
+		}
+		a.emit(bytes.Repeat([]byte{byte(fill)}, int(n))...)
+	case ".set", ".equ":
+		if len(ops) == 1 && name == ".set" {
+			return nil // assembler options like noreorder
+		}
+		if err := nargs(2); err != nil {
+			return err
+		}
+		v, err := a.eval(ops[1])
+		if err != nil {
+			return err
+		}
+		return a.define(ops[0], v, false)
+	case ".incbin":
+		if err := nargs(1); err != nil {
+			return err
+		}
+		file, err := strconv.Unquote(ops[0])
+		if err != nil {
+			return fmt.Errorf("invalid string %s", ops[0])
+		}
+		data, ok := a.incbins[file]
+		if !ok {
+			if data, err = fs.ReadFile(a.fsys, file); err != nil {
+				return err
+			}
+			a.incbins[file] = data
+		}
+		a.emit(data...)
+	case ".globl", ".global":
+		for _, op := range ops {
+			if identLen(op) != len(op) {
+				return fmt.Errorf("invalid symbol %s", op)
+			}
+			a.globals[op] = true
+		}
+	default:
+		return fmt.Errorf("unknown directive %s", name)
+	}
+	return nil
+}
+
+// macro expands libdragon's macros for defining rspq overlays.
+func (a *assembler) macro(name string, ops []string) error {
+	var src []string
+	switch name {
+	case "RSPQ_BeginOverlayHeader":
+		src = []string{
+			".data",
+			".align 1",
+			"_RSPQ_OVERLAY_HEADER:",
+			".half _RSPQ_SAVED_STATE_START",
+			".half _RSPQ_SAVED_STATE_END - _RSPQ_SAVED_STATE_START - 1",
+			".half 0, 0",
+		}
+	case "RSPQ_DefineCommand":
+		if len(ops) != 2 {
+			return fmt.Errorf("%s: expected 2 operands, got %d", name, len(ops))
+		}
+		size, err := a.eval(ops[1])
+		if err == nil && (size < 0 || size > 62 || size%4 != 0) {
+			err = fmt.Errorf("invalid command size %d", size)
+		}
+		if err != nil {
+			a.emitInt(0, 2)
+			return err
+		}
+		src = []string{fmt.Sprintf(".half ((%s) - %#x) >> 2 | %#x", ops[0], IMEMBase, size<<8)}
+	case "RSPQ_EndOverlayHeader":
+		src = []string{".half 0"}
+	case "RSPQ_BeginSavedState":
+		src = []string{".data", ".align 3", "_RSPQ_SAVED_STATE_START:"}
+	case "RSPQ_EndSavedState":
+		src = []string{".align 3", "_RSPQ_SAVED_STATE_END:"}
+	default:
+		return fmt.Errorf("unknown macro %s", name)
+	}
+	for _, s := range src {
+		if err := a.statement(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pseudo expands pseudo instructions into real ones.
+func (a *assembler) pseudo(name string, ops []string) ([][]string, error) {
+	want := func(n int) error {
+		if len(ops) != n {
+			return fmt.Errorf("%s: expected %d operands, got %d", name, n, len(ops))
+		}
+		return nil
+	}
+	var err error
+	var expanded [][]string
+	switch name {
+	case "move":
+		err = want(2)
+		expanded = [][]string{{"addu", ops[0], ops[1], "$zero"}}
+	case "not":
+		err = want(2)
+		expanded = [][]string{{"nor", ops[0], ops[1], "$zero"}}
+	case "neg", "negu":
+		err = want(2)
+		expanded = [][]string{{"subu", ops[0], "$zero", ops[1]}}
+	case "b":
+		err = want(1)
+		expanded = [][]string{{"beq", "$zero", "$zero", ops[0]}}
+	case "bal":
+		err = want(1)
+		expanded = [][]string{{"bgezal", "$zero", ops[0]}}
+	case "beqz", "bnez":
+		err = want(2)
+		expanded = [][]string{{name[:3], ops[0], "$zero", ops[1]}}
+	case "jalr":
+		if len(ops) != 1 {
+			return nil, nil
+		}
+		expanded = [][]string{{"jalr", "$ra", ops[0]}}
+	case "li", "la":
+		if err = want(2); err != nil {
+			break
+		}
+		var v int64
+		v, err = a.evalRange(ops[1], -1<<31, 1<<32-1)
+		lo, hi := v&0xffff, v>>16&0xffff
+		switch {
+		case v >= -0x8000 && v < 0x8000:
+			expanded = [][]string{{"addiu", ops[0], "$zero", hex(v)}}
+		case v >= 0 && v <= 0xffff:
+			expanded = [][]string{{"ori", ops[0], "$zero", hex(v)}}
+		case lo == 0:
+			expanded = [][]string{{"lui", ops[0], hex(hi)}}
+		default:
+			expanded = [][]string{{"lui", ops[0], hex(hi)}, {"ori", ops[0], ops[0], hex(lo)}}
+		}
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return [][]string{{"nop"}}, err // keep size for the next pass
+	}
+	return expanded, nil
+}
+
+func (a *assembler) instruction(name string, ops []string) error {
+	expanded, err := a.pseudo(name, ops)
+	if expanded == nil && err == nil {
+		expanded = [][]string{append([]string{name}, ops...)}
+	}
+	for _, in := range expanded {
+		if a.pc()%4 != 0 {
+			return errors.New("instruction not word aligned")
+		}
+		w, ierr := a.encode(in[0], in[1:])
+		a.emitInt(int64(w), 4)
+		err = errors.Join(err, ierr)
+	}
+	return err
+}
+
+func (a *assembler) encode(name string, ops []string) (uint32, error) {
+	in, ok := instByName[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown instruction %s", name)
+	}
+	if len(ops) != len(in.args) {
+		return 0, fmt.Errorf("%s: expected %d operands, got %d", name, len(in.args), len(ops))
+	}
+	w := in.match
+	for i, arg := range in.args {
+		v, err := a.operand(in, arg, ops[i])
+		if err != nil {
+			return 0, fmt.Errorf("%s: operand %d: %w", name, i+1, err)
+		}
+		w |= v
+	}
+	return w, nil
+}
+
+// operand returns the encoding of op as the argument kind arg of in.
+func (a *assembler) operand(in *inst, arg argKind, op string) (uint32, error) {
+	reg := func(shift int) (uint32, error) {
+		r, ok := parseReg(op)
+		if !ok {
+			return 0, fmt.Errorf("invalid register %s", op)
+		}
+		return r << shift, nil
+	}
+	vreg := func(shift int, parse func(string) (uint32, error)) (r, e uint32, err error) {
+		r, elem, ok := parseVReg(op)
+		if !ok {
+			return 0, 0, fmt.Errorf("invalid vector register %s", op)
+		}
+		if parse == nil {
+			if elem != "" {
+				return 0, 0, fmt.Errorf("unexpected element %s", elem)
+			}
+		} else if e, err = parse(elem); err != nil {
+			return 0, 0, err
+		}
+		return r << shift, e, nil
+	}
+
+	switch arg {
+	case argRd:
+		return reg(11)
+	case argRs:
+		return reg(21)
+	case argRt:
+		return reg(16)
+	case argSa:
+		v, err := a.evalRange(op, 0, 31)
+		return uint32(v) << 6, err
+	case argImm:
+		v, err := a.evalRange(op, -0x8000, 0xffff)
+		return uint32(v) & 0xffff, err
+	case argUImm:
+		v, err := a.evalRange(op, 0, 0xffff)
+		return uint32(v), err
+	case argBranch:
+		v, err := a.eval(op)
+		if err != nil {
+			return 0, err
+		}
+		off := v - int64(a.pc()) - 4
+		if off%4 != 0 || off < -0x8000<<2 || off >= 0x8000<<2 {
+			return 0, fmt.Errorf("invalid branch target %s", hex(v))
+		}
+		return uint32(off>>2) & 0xffff, nil
+	case argTarget:
+		v, err := a.evalRange(op, 0, 1<<28-1)
+		if err == nil && v%4 != 0 {
+			err = fmt.Errorf("unaligned jump target %s", hex(v))
+		}
+		return uint32(v >> 2), err
+	case argMem, argVMem:
+		off, base, ok := splitMem(op)
+		if !ok {
+			return 0, fmt.Errorf("invalid memory operand %s", op)
+		}
+		r, ok := parseReg(base)
+		if !ok {
+			return 0, fmt.Errorf("invalid register %s", base)
+		}
+		if arg == argMem {
+			v, err := a.evalRange(off, -0x8000, 0xffff)
+			return r<<21 | uint32(v)&0xffff, err
+		}
+		scale := int64(in.scale)
+		v, err := a.evalRange(off, -64*scale, 63*scale)
+		if err == nil && v%scale != 0 {
+			err = fmt.Errorf("offset %s not a multiple of %d", hex(v), scale)
+		}
+		return r<<21 | uint32(v/scale)&0x7f, err
+	case argCode:
+		v, err := a.evalRange(op, 0, 0xf_ffff)
+		return uint32(v) << 6, err
+	case argC0:
+		n, err := strconv.ParseUint(strings.TrimPrefix(op, "$c"), 10, 5)
+		if err != nil || !strings.HasPrefix(op, "$c") {
+			return 0, fmt.Errorf("invalid COP0 register %s", op)
+		}
+		return uint32(n) << 11, nil
+	case argCtrl:
+		for i, n := range ctrlNames {
+			if op == n {
+				return uint32(i) << 11, nil
+			}
+		}
+		return 0, fmt.Errorf("invalid control register %s", op)
+	case argVd:
+		r, _, err := vreg(6, nil)
+		return r, err
+	case argVs:
+		r, _, err := vreg(11, nil)
+		return r, err
+	case argVtE:
+		r, e, err := vreg(16, parseElemVU)
+		return r | e<<21, err
+	case argVdDe:
+		r, e, err := vreg(6, parseElemDe)
+		return r | e<<11, err
+	case argVtB:
+		r, e, err := vreg(16, parseElemByte)
+		return r | e<<7, err
+	case argVsB:
+		r, e, err := vreg(11, parseElemByte)
+		return r | e<<7, err
+	}
+	panic("unknown argument kind")
+}
+
+// splitMem splits a memory operand "offset(base)". The offset may be empty.
+func splitMem(s string) (off, base string, ok bool) {
+	if !strings.HasSuffix(s, ")") {
+		return "", "", false
+	}
+	i := strings.LastIndexByte(s, '(')
+	if i == -1 {
+		return "", "", false
+	}
+	off = strings.TrimSpace(s[:i])
+	if off == "" {
+		off = "0"
+	}
+	return off, strings.TrimSpace(s[i+1 : len(s)-1]), true
+}
@@ -0,0 +1,244 @@
+//go:build !n64
+
+package asm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/clktmr/n64/rcp/rsp/ucode"
+)
+
+func assemble(t *testing.T, src string, files ...string) (*ucode.UCode, error) {
+	t.Helper()
+	fsys := fstest.MapFS{"test.S": {Data: []byte(src)}}
+	for i := 0; i+1 < len(files); i += 2 {
+		fsys[files[i]] = &fstest.MapFile{Data: []byte(files[i+1])}
+	}
+	return Assemble(fsys, "test.S")
+}
+
+func words(b []byte) (w []uint32) {
+	for i := 0; i+4 <= len(b); i += 4 {
+		w = append(w, binary.BigEndian.Uint32(b[i:]))
+	}
+	return
+}
+
+func TestEncoding(t *testing.T) {
+	tests := []struct {
+		src  string
+		want uint32
+	}{
+		{"nop", 0x0000_0000},
+		{"lw s0, 0xd8(zero)", 0x8c10_00d8},
+		{"lw $s0, 0xd8($0)", 0x8c10_00d8},
+		{"jr $ra", 0x03e0_0008},
+		{"mtc0 $t0, $c4", 0x4088_2000},
+		{"mfc0 $t2, $c11", 0x400a_5800},
+		{"break 0x2e800", 0x00ba_000d},
+		{"sll $t0, $t1, 4", 0x0009_4100},
+		{"addiu $sp, $sp, -16", 0x27bd_fff0},
+		{"ori $at, $zero, 0xffff", 0x3401_ffff},
+		{"vmudh $v01, $v02, $v03.e2", 0x4b43_1047},
+		{"vmudh $v01, $v02, $v03", 0x4a03_1047},
+		{"vaddc $v04, $v05, $v06.h1", 0x4aa6_2914},
+		{"vrcph $v04.e0, $v05.e1", 0x4b25_0132},
+		{"vnop", 0x4a00_0037},
+		{"lqv $v06, 0x10($s0)", 0xca06_2001},
+		{"sqv $v06, -0x10($s0)", 0xea06_207f},
+		{"ssv $v07.e3, 4($a0)", 0xe887_0b02},
+		{"lbv $v01[3], 0($a1)", 0xc8a1_0180},
+		{"mfc2 $t0, $v07.e3", 0x4808_3b00},
+		{"cfc2 $t1, $vcc", 0x4849_0800},
+	}
+	for _, tc := range tests {
+		uc, err := assemble(t, tc.src)
+		if err != nil {
+			t.Errorf("%s: %v", tc.src, err)
+			continue
+		}
+		if w := words(uc.Text); len(w) != 1 || w[0] != tc.want {
+			t.Errorf("%s: expected %08x, got %08x", tc.src, tc.want, w)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, src := range []string{
+		"foo $t0",
+		"addiu $t0, $t0, 0x10000",
+		"lw $t0, 4",
+		"lqv $v01, 8($s0)",
+		"vmudh $v01, $v02, $v03.e8",
+		"vmudh $v1, $v02, $v03",
+		"j missing",
+		"l: nop\nl: nop",
+		".space 0x1001",
+	} {
+		if _, err := assemble(t, src); err == nil {
+			t.Errorf("%q: expected error", src)
+		}
+	}
+}
+
+func TestLabels(t *testing.T) {
+	uc, err := assemble(t, `
+	.text
+_start:
+	li	$t0, value	# forward reference, needs lui and ori
+	beqz	$t0, end
+	lw	$t1, %lo(table)($zero)
+loop:	b	loop; nop
+end:	j	_start
+	nop
+
+	.data
+	.align	3
+table:	.word	table, . - table
+	.half	-1
+	.byte	'a', 1 + 2 * 3
+	.asciz	"ok"
+value = 0x12345678
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint32{
+		0x3c08_1234, 0x3508_5678, // li
+		0x1100_0003,              // beqz
+		0x8c09_0000,              // lw
+		0x1000_ffff, 0x0000_0000, // b
+		0x0900_0400, 0x0000_0000, // j
+	}
+	if got := words(uc.Text); !slices.Equal(got, want) {
+		t.Errorf("expected text %08x, got %08x", want, got)
+	}
+	wantData := []byte{0, 0, 0, 0, 0, 0, 0, 4, 0xff, 0xff, 'a', 7, 'o', 'k', 0}
+	if !bytes.Equal(uc.Data, wantData) {
+		t.Errorf("expected data % x, got % x", wantData, uc.Data)
+	}
+	if uc.Entry != 0xa400_1000 {
+		t.Errorf("expected entry %#x, got %#x", 0xa400_1000, uc.Entry)
+	}
+}
+
+func TestOverlayHeader(t *testing.T) {
+	uc, err := assemble(t, `
+	.include "queue.S"
+
+	RSPQ_BeginOverlayHeader
+	RSPQ_DefineCommand cmd0, 8
+	RSPQ_DefineCommand cmd1, 16
+	RSPQ_EndOverlayHeader
+
+	RSPQ_BeginSavedState
+state:	.space 32
+	RSPQ_EndSavedState
+
+	.text
+cmd0:	jr	$ra
+	nop
+cmd1:	jr	$ra
+	nop
+`, "queue.S", "\t.text\n\tnop\n\t.data\n\t.word 0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x00, 0x00, 0x00, 0x00, // rspq data
+		0x00, 0x18, 0x00, 0x1f, 0x00, 0x00, 0x00, 0x00, // header
+		0x08, 0x01, 0x10, 0x03, 0x00, 0x00, // commands
+	}
+	if !bytes.Equal(uc.Data[:len(want)], want) {
+		t.Errorf("expected header % x, got % x", want, uc.Data[:len(want)])
+	}
+	if len(uc.Data) != 0x38 {
+		t.Errorf("expected data size %#x, got %#x", 0x38, len(uc.Data))
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	fsys := fstest.MapFS{"test.S": {Data: []byte(`
+	.globl	cmd, state
+	.text
+	nop
+cmd:	jr	$ra
+	nop
+	.data
+	.word	0
+state:	.space	8
+size = . - state
+flags:
+	.half	1, 2
+count:	.word	3
+`)}}
+	_, syms, err := AssembleSymbols(fsys, "test.S")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Symbol{
+		{Name: "state", Value: 4, Label: true, Data: true, Global: true},
+		{Name: "size", Value: 8},
+		{Name: "flags", Value: 12, Label: true, Data: true, Size: 2},
+		{Name: "count", Value: 16, Label: true, Data: true, Size: 4},
+		{Name: "cmd", Value: IMEMBase + 4, Label: true, Global: true},
+	}
+	if !slices.Equal(syms, want) {
+		t.Errorf("expected symbols %v, got %v", want, syms)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	var files []string
+	err := filepath.WalkDir("../../../../drivers/rspq", func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && filepath.Ext(path) == ".ucode" {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 4 {
+		t.Fatalf("expected ucode files, got %v", files)
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			f, err := os.Open(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			uc, err := ucode.Load(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var src strings.Builder
+			if err := Disassemble(&src, uc); err != nil {
+				t.Fatal(err)
+			}
+			got, err := assemble(t, src.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Text, uc.Text) {
+				t.Error("text differs")
+			}
+			if !bytes.Equal(got.Data, uc.Data) {
+				t.Error("data differs")
+			}
+			if got.Entry != uc.Entry {
+				t.Errorf("expected entry %#x, got %#x", uc.Entry, got.Entry)
+			}
+		})
+	}
+}
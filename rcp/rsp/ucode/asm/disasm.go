@@ -0,0 +1,101 @@
+package asm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/clktmr/n64/rcp/rsp/ucode"
+)
+
+// Disassemble writes the source of uc to w. Assembling the source yields the
+// same text and data. Branch and jump targets inside the text are labeled by
+// their IMEM offset.
+func Disassemble(w io.Writer, uc *ucode.UCode) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %s\n", uc.Name)
+	if uc.Entry != kseg1|IMEMBase {
+		fmt.Fprintf(bw, "_start = %#x\n", uc.Entry&0x1fff_ffff)
+	}
+
+	fmt.Fprintf(bw, "\n\t.text\n")
+	WriteText(bw, uc.Text)
+	fmt.Fprintf(bw, "\n\t.data\n")
+	WriteData(bw, uc.Data, DMEMBase)
+	return bw.Flush()
+}
+
+// WriteText writes the disassembly of text, which is loaded at the start of
+// IMEM, to w.
+func WriteText(w io.Writer, text []byte) {
+	end := IMEMBase + uint32(len(text))
+	label := func(addr uint32) (string, bool) {
+		if addr < IMEMBase || addr > end || addr%4 != 0 {
+			return "", false
+		}
+		return fmt.Sprintf("L%03x", addr-IMEMBase), true
+	}
+
+	targets := make(map[uint32]bool)
+	for i := 0; i+4 <= len(text); i += 4 {
+		inst, pc := binary.BigEndian.Uint32(text[i:]), IMEMBase+uint32(i)
+		in := decode(inst)
+		if in == nil {
+			continue
+		}
+		for _, arg := range in.args {
+			switch arg {
+			case argBranch:
+				targets[pc+4+uint32(int32(int16(inst))<<2)] = true
+			case argTarget:
+				targets[inst&0x3ff_ffff<<2] = true
+			}
+		}
+	}
+
+	for i := 0; i+4 <= len(text); i += 4 {
+		inst, pc := binary.BigEndian.Uint32(text[i:]), IMEMBase+uint32(i)
+		if l, ok := label(pc); ok && targets[pc] {
+			fmt.Fprintf(w, "%s:\n", l)
+		}
+		s, ok := disasm(inst, pc, label)
+		if !ok {
+			s = fmt.Sprintf(".word\t0x%08x", inst)
+		}
+		name, args, _ := strings.Cut(s, "\t")
+		fmt.Fprintf(w, "\t%-7s %-27s # %03x: %08x\n", name, args, pc-IMEMBase, inst)
+	}
+	if targets[end] {
+		l, _ := label(end)
+		fmt.Fprintf(w, "%s:\n", l)
+	}
+	if tail := text[len(text)&^3:]; len(tail) > 0 {
+		writeBytes(w, tail)
+	}
+}
+
+// WriteData writes data as directives to w. The comments show the DMEM offset,
+// starting at addr.
+func WriteData(w io.Writer, data []byte, addr uint32) {
+	for i := 0; i+4 <= len(data); i += 16 {
+		n := min(len(data)-i, 16) &^ 3
+		words := make([]string, n/4)
+		for j := range words {
+			words[j] = fmt.Sprintf("0x%08x", binary.BigEndian.Uint32(data[i+4*j:]))
+		}
+		fmt.Fprintf(w, "\t.word   %s # %03x\n", strings.Join(words, ", "), addr+uint32(i))
+	}
+	if tail := data[len(data)&^3:]; len(tail) > 0 {
+		writeBytes(w, tail)
+	}
+}
+
+func writeBytes(w io.Writer, b []byte) {
+	s := make([]string, len(b))
+	for i := range b {
+		s[i] = fmt.Sprintf("0x%02x", b[i])
+	}
+	fmt.Fprintf(w, "\t.byte   %s\n", strings.Join(s, ", "))
+}
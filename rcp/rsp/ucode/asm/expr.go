@@ -0,0 +1,201 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// exprParser evaluates integer expressions with C operator precedence. Symbols
+// are resolved by the assembler, '.' is the current address.
+type exprParser struct {
+	a   *assembler
+	s   string
+	pos int
+	err error
+}
+
+func (a *assembler) eval(s string) (int64, error) {
+	p := &exprParser{a: a, s: s}
+	v := p.binary(0)
+	p.skipSpace()
+	if p.err == nil && p.pos < len(p.s) {
+		p.fail("unexpected %q", p.s[p.pos:])
+	}
+	if p.err != nil {
+		return 0, fmt.Errorf("%w in expression %q", p.err, s)
+	}
+	return v, nil
+}
+
+func (a *assembler) evalRange(s string, min, max int64) (int64, error) {
+	v, err := a.eval(s)
+	if err != nil {
+		return 0, err
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("value %s out of range [%s, %s]", hex(v), hex(min), hex(max))
+	}
+	return v, nil
+}
+
+func (p *exprParser) fail(format string, args ...any) {
+	if p.err == nil {
+		p.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// Binary operators by increasing precedence.
+var binaryOps = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) binary(level int) int64 {
+	if level == len(binaryOps) {
+		return p.unary()
+	}
+	v := p.binary(level + 1)
+	for p.err == nil {
+		p.skipSpace()
+		op := ""
+		for _, o := range binaryOps[level] {
+			if strings.HasPrefix(p.s[p.pos:], o) {
+				op = o
+			}
+		}
+		if op == "" || op == "%" && p.pos+1 < len(p.s) && isIdentStart(p.s[p.pos+1]) {
+			return v
+		}
+		p.pos += len(op)
+		r := p.binary(level + 1)
+		switch op {
+		case "|":
+			v |= r
+		case "^":
+			v ^= r
+		case "&":
+			v &= r
+		case "<<":
+			v <<= r
+		case ">>":
+			v >>= r
+		case "+":
+			v += r
+		case "-":
+			v -= r
+		case "*":
+			v *= r
+		case "/", "%":
+			if r == 0 {
+				p.fail("division by zero")
+			} else if op == "/" {
+				v /= r
+			} else {
+				v %= r
+			}
+		}
+	}
+	return v
+}
+
+func (p *exprParser) unary() int64 {
+	p.skipSpace()
+	if p.pos >= len(p.s) {
+		p.fail("missing operand")
+		return 0
+	}
+	switch c := p.s[p.pos]; {
+	case c == '-':
+		p.pos++
+		return -p.unary()
+	case c == '+':
+		p.pos++
+		return p.unary()
+	case c == '~':
+		p.pos++
+		return ^p.unary()
+	case c == '(':
+		p.pos++
+		v := p.binary(0)
+		p.expect(')')
+		return v
+	case c == '%':
+		p.pos++
+		name := p.ident()
+		p.expect('(')
+		v := p.binary(0)
+		p.expect(')')
+		switch name {
+		case "lo":
+			return v & 0xffff
+		case "hi":
+			return (v + 0x8000) >> 16 & 0xffff
+		}
+		p.fail("unknown operator %%%s", name)
+	case c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.s) && isIdent(p.s[p.pos]) {
+			p.pos++
+		}
+		v, err := strconv.ParseInt(p.s[start:p.pos], 0, 64)
+		if err != nil {
+			p.fail("invalid number %q", p.s[start:p.pos])
+		}
+		return v
+	case c == '\'':
+		if p.pos+2 < len(p.s) && p.s[p.pos+2] == '\'' {
+			p.pos += 3
+			return int64(p.s[p.pos-2])
+		}
+		p.fail("invalid character literal")
+	case isIdentStart(c):
+		name := p.ident()
+		if name == "." {
+			return int64(p.a.pc())
+		}
+		v, err := p.a.lookup(name)
+		if err != nil {
+			p.fail("%v", err)
+		}
+		return v
+	default:
+		p.fail("unexpected %q", p.s[p.pos:])
+	}
+	return 0
+}
+
+func (p *exprParser) expect(c byte) {
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] == c {
+		p.pos++
+		return
+	}
+	p.fail("missing %q", c)
+}
+
+func (p *exprParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.s) && isIdent(p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '.'
+}
+
+func isIdent(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
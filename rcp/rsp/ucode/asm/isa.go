@@ -0,0 +1,442 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Base addresses of the RSP memories, as used by libdragon's linker script.
+// Labels in the .text and .data sections are relative to these.
+const (
+	DMEMBase = 0x0000_0000
+	IMEMBase = 0x0400_1000
+	MemSize  = 0x1000
+)
+
+// argKind describes how an operand is encoded into an instruction.
+type argKind uint8
+
+const (
+	argRd     argKind = iota // scalar register in rd field
+	argRs                    // scalar register in rs field
+	argRt                    // scalar register in rt field
+	argSa                    // shift amount
+	argImm                   // signed 16 bit immediate
+	argUImm                  // unsigned 16 bit immediate
+	argBranch                // pc relative branch target
+	argTarget                // absolute jump target
+	argMem                   // offset(base) with 16 bit offset
+	argCode                  // 20 bit code of break
+	argC0                    // COP0 register in rd field
+	argCtrl                  // COP2 control register in rd field
+	argVd                    // vector register in sa field
+	argVs                    // vector register in rd field
+	argVtE                   // vector register in rt field, element in rs field
+	argVdDe                  // vector register in sa field, element in rd field
+	argVtB                   // vector register in rt field, byte element
+	argVsB                   // vector register in rd field, byte element
+	argVMem                  // offset(base) with scaled 7 bit offset
+)
+
+type inst struct {
+	name  string
+	match uint32
+	mask  uint32
+	args  []argKind
+	scale int // offset scale of vector loads and stores
+}
+
+func special(name string, funct uint32, args ...argKind) inst {
+	return inst{name: name, match: funct, mask: 0xfc00_003f, args: args}
+}
+
+func regimm(name string, rt uint32) inst {
+	return inst{name: name, match: 1<<26 | rt<<16, mask: 0xfc1f_0000, args: []argKind{argRs, argBranch}}
+}
+
+func op(name string, opcode uint32, args ...argKind) inst {
+	return inst{name: name, match: opcode << 26, mask: 0xfc00_0000, args: args}
+}
+
+func cop(name string, opcode, rs uint32, args ...argKind) inst {
+	return inst{name: name, match: opcode<<26 | rs<<21, mask: 0xffe0_0000, args: args}
+}
+
+func vu(name string, funct uint32, args ...argKind) inst {
+	if args == nil {
+		args = []argKind{argVd, argVs, argVtE}
+	}
+	return inst{name: name, match: 0x12<<26 | 1<<25 | funct, mask: 0xfe00_003f, args: args}
+}
+
+func vmem(name string, opcode, sub uint32, scale int) inst {
+	return inst{name: name, match: opcode<<26 | sub<<11, mask: 0xfc00_f800,
+		args: []argKind{argVtB, argVMem}, scale: scale}
+}
+
+var single = []argKind{argVdDe, argVtE}
+
+// insts is the RSP instruction set. It's searched in order when decoding, so
+// aliases with more specific masks must come first.
+var insts = []inst{
+	{name: "nop", match: 0, mask: 0xffff_ffff},
+	special("sll", 0x00, argRd, argRt, argSa),
+	special("srl", 0x02, argRd, argRt, argSa),
+	special("sra", 0x03, argRd, argRt, argSa),
+	special("sllv", 0x04, argRd, argRt, argRs),
+	special("srlv", 0x06, argRd, argRt, argRs),
+	special("srav", 0x07, argRd, argRt, argRs),
+	special("jr", 0x08, argRs),
+	special("jalr", 0x09, argRd, argRs),
+	special("break", 0x0d, argCode),
+	special("add", 0x20, argRd, argRs, argRt),
+	special("addu", 0x21, argRd, argRs, argRt),
+	special("sub", 0x22, argRd, argRs, argRt),
+	special("subu", 0x23, argRd, argRs, argRt),
+	special("and", 0x24, argRd, argRs, argRt),
+	special("or", 0x25, argRd, argRs, argRt),
+	special("xor", 0x26, argRd, argRs, argRt),
+	special("nor", 0x27, argRd, argRs, argRt),
+	special("slt", 0x2a, argRd, argRs, argRt),
+	special("sltu", 0x2b, argRd, argRs, argRt),
+
+	regimm("bltz", 0x00),
+	regimm("bgez", 0x01),
+	regimm("bltzal", 0x10),
+	regimm("bgezal", 0x11),
+
+	op("j", 0x02, argTarget),
+	op("jal", 0x03, argTarget),
+	op("beq", 0x04, argRs, argRt, argBranch),
+	op("bne", 0x05, argRs, argRt, argBranch),
+	op("blez", 0x06, argRs, argBranch),
+	op("bgtz", 0x07, argRs, argBranch),
+	op("addi", 0x08, argRt, argRs, argImm),
+	op("addiu", 0x09, argRt, argRs, argImm),
+	op("slti", 0x0a, argRt, argRs, argImm),
+	op("sltiu", 0x0b, argRt, argRs, argImm),
+	op("andi", 0x0c, argRt, argRs, argUImm),
+	op("ori", 0x0d, argRt, argRs, argUImm),
+	op("xori", 0x0e, argRt, argRs, argUImm),
+	op("lui", 0x0f, argRt, argUImm),
+	op("lb", 0x20, argRt, argMem),
+	op("lh", 0x21, argRt, argMem),
+	op("lw", 0x23, argRt, argMem),
+	op("lbu", 0x24, argRt, argMem),
+	op("lhu", 0x25, argRt, argMem),
+	op("lwu", 0x27, argRt, argMem),
+	op("sb", 0x28, argRt, argMem),
+	op("sh", 0x29, argRt, argMem),
+	op("sw", 0x2b, argRt, argMem),
+
+	cop("mfc0", 0x10, 0x00, argRt, argC0),
+	cop("mtc0", 0x10, 0x04, argRt, argC0),
+	cop("mfc2", 0x12, 0x00, argRt, argVsB),
+	cop("cfc2", 0x12, 0x02, argRt, argCtrl),
+	cop("mtc2", 0x12, 0x04, argRt, argVsB),
+	cop("ctc2", 0x12, 0x06, argRt, argCtrl),
+
+	vu("vmulf", 0x00),
+	vu("vmulu", 0x01),
+	vu("vrndp", 0x02),
+	vu("vmulq", 0x03),
+	vu("vmudl", 0x04),
+	vu("vmudm", 0x05),
+	vu("vmudn", 0x06),
+	vu("vmudh", 0x07),
+	vu("vmacf", 0x08),
+	vu("vmacu", 0x09),
+	vu("vrndn", 0x0a),
+	vu("vmacq", 0x0b),
+	vu("vmadl", 0x0c),
+	vu("vmadm", 0x0d),
+	vu("vmadn", 0x0e),
+	vu("vmadh", 0x0f),
+	vu("vadd", 0x10),
+	vu("vsub", 0x11),
+	vu("vabs", 0x13),
+	vu("vaddc", 0x14),
+	vu("vsubc", 0x15),
+	vu("vsar", 0x1d),
+	vu("vlt", 0x20),
+	vu("veq", 0x21),
+	vu("vne", 0x22),
+	vu("vge", 0x23),
+	vu("vcl", 0x24),
+	vu("vch", 0x25),
+	vu("vcr", 0x26),
+	vu("vmrg", 0x27),
+	vu("vand", 0x28),
+	vu("vnand", 0x29),
+	vu("vor", 0x2a),
+	vu("vnor", 0x2b),
+	vu("vxor", 0x2c),
+	vu("vnxor", 0x2d),
+	vu("vrcp", 0x30, single...),
+	vu("vrcpl", 0x31, single...),
+	vu("vrcph", 0x32, single...),
+	vu("vmov", 0x33, single...),
+	vu("vrsq", 0x34, single...),
+	vu("vrsql", 0x35, single...),
+	vu("vrsqh", 0x36, single...),
+	{name: "vnop", match: 0x4a00_0037, mask: 0xffff_ffff},
+
+	vmem("lbv", 0x32, 0x00, 1),
+	vmem("lsv", 0x32, 0x01, 2),
+	vmem("llv", 0x32, 0x02, 4),
+	vmem("ldv", 0x32, 0x03, 8),
+	vmem("lqv", 0x32, 0x04, 16),
+	vmem("lrv", 0x32, 0x05, 16),
+	vmem("lpv", 0x32, 0x06, 8),
+	vmem("luv", 0x32, 0x07, 8),
+	vmem("lhv", 0x32, 0x08, 16),
+	vmem("lfv", 0x32, 0x09, 16),
+	vmem("ltv", 0x32, 0x0b, 16),
+	vmem("sbv", 0x3a, 0x00, 1),
+	vmem("ssv", 0x3a, 0x01, 2),
+	vmem("slv", 0x3a, 0x02, 4),
+	vmem("sdv", 0x3a, 0x03, 8),
+	vmem("sqv", 0x3a, 0x04, 16),
+	vmem("srv", 0x3a, 0x05, 16),
+	vmem("spv", 0x3a, 0x06, 8),
+	vmem("suv", 0x3a, 0x07, 8),
+	vmem("shv", 0x3a, 0x08, 16),
+	vmem("sfv", 0x3a, 0x09, 16),
+	vmem("swv", 0x3a, 0x0a, 16),
+	vmem("stv", 0x3a, 0x0b, 16),
+}
+
+var instByName = func() map[string]*inst {
+	m := make(map[string]*inst, len(insts))
+	for i := range insts {
+		m[insts[i].name] = &insts[i]
+	}
+	return m
+}()
+
+var regNames = [32]string{
+	"zero", "at", "v0", "v1", "a0", "a1", "a2", "a3",
+	"t0", "t1", "t2", "t3", "t4", "t5", "t6", "t7",
+	"s0", "s1", "s2", "s3", "s4", "s5", "s6", "s7",
+	"t8", "t9", "k0", "k1", "gp", "sp", "fp", "ra",
+}
+
+var ctrlNames = [3]string{"$vco", "$vcc", "$vce"}
+
+// parseReg parses a scalar register, either by name or number with optional
+// '$' prefix.
+func parseReg(s string) (uint32, bool) {
+	name := strings.TrimPrefix(s, "$")
+	if name == "s8" {
+		return 30, true
+	}
+	for i, n := range regNames {
+		if name == n {
+			return uint32(i), true
+		}
+	}
+	if name != s {
+		if n, err := strconv.ParseUint(name, 10, 5); err == nil {
+			return uint32(n), true
+		}
+	}
+	return 0, false
+}
+
+// parseVReg parses a vector register like "$v03.e2" into the register number
+// and its element suffix.
+func parseVReg(s string) (reg uint32, elem string, ok bool) {
+	if len(s) < 4 || s[:2] != "$v" {
+		return 0, "", false
+	}
+	n, err := strconv.ParseUint(s[2:4], 10, 5)
+	if err != nil {
+		return 0, "", false
+	}
+	return uint32(n), s[4:], true
+}
+
+// parseElem parses an element suffix. The forms ".e", ".h" and ".q" are
+// mapped by the respective function, "[n]" sets the raw field value.
+func parseElem(s string, max uint32, e, h, q func(n uint32) (uint32, bool)) (uint32, error) {
+	var n uint64
+	var err error
+	var conv func(n uint32) (uint32, bool)
+	switch {
+	case s == "":
+		return 0, nil
+	case len(s) > 2 && s[0] == '[' && s[len(s)-1] == ']':
+		n, err = strconv.ParseUint(s[1:len(s)-1], 0, 8)
+		conv = func(n uint32) (uint32, bool) { return n, n <= max }
+	case len(s) > 2 && s[0] == '.':
+		n, err = strconv.ParseUint(s[2:], 10, 8)
+		switch s[1] {
+		case 'e':
+			conv = e
+		case 'h':
+			conv = h
+		case 'q':
+			conv = q
+		}
+	}
+	if err == nil && conv != nil {
+		if v, ok := conv(uint32(n)); ok {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid element: %s", s)
+}
+
+func within(base, max uint32) func(n uint32) (uint32, bool) {
+	if max == 0 {
+		return nil
+	}
+	return func(n uint32) (uint32, bool) { return base + n, n < max }
+}
+
+// Element encodings of the computational instructions, the destination of
+// single lane instructions and of loads, stores and moves.
+func parseElemVU(s string) (uint32, error) {
+	return parseElem(s, 15, within(8, 8), within(4, 4), within(2, 2))
+}
+
+func parseElemDe(s string) (uint32, error) {
+	return parseElem(s, 31, within(0, 8), nil, nil)
+}
+
+func parseElemByte(s string) (uint32, error) {
+	return parseElem(s, 15, func(n uint32) (uint32, bool) { return 2 * n, n < 8 }, nil, nil)
+}
+
+func formatElemVU(e uint32) string {
+	switch {
+	case e == 0:
+		return ""
+	case e == 1:
+		return "[1]"
+	case e < 4:
+		return fmt.Sprintf(".q%d", e-2)
+	case e < 8:
+		return fmt.Sprintf(".h%d", e-4)
+	}
+	return fmt.Sprintf(".e%d", e-8)
+}
+
+func formatElemDe(e uint32) string {
+	if e < 8 {
+		return fmt.Sprintf(".e%d", e)
+	}
+	return fmt.Sprintf("[%d]", e)
+}
+
+func formatElemByte(e uint32) string {
+	switch {
+	case e == 0:
+		return ""
+	case e%2 == 0:
+		return fmt.Sprintf(".e%d", e/2)
+	}
+	return fmt.Sprintf("[%d]", e)
+}
+
+func hex(v int64) string {
+	if v < 0 {
+		return fmt.Sprintf("-%#x", -v)
+	}
+	return fmt.Sprintf("%#x", v)
+}
+
+// Disasm returns the assembly of instruction w at address pc, or false if w
+// isn't a valid instruction.
+func Disasm(w uint32, pc uint32) (string, bool) {
+	return disasm(w, pc, nil)
+}
+
+// disasm formats w, using label to name branch and jump targets if not nil.
+func disasm(w uint32, pc uint32, label func(addr uint32) (string, bool)) (string, bool) {
+	in := decode(w)
+	if in == nil {
+		return "", false
+	}
+	rs, rt, rd, sa := w>>21&31, w>>16&31, w>>11&31, w>>6&31
+	enc := in.match
+	args := make([]string, 0, len(in.args))
+	target := func(addr uint32) string {
+		if label != nil {
+			if l, ok := label(addr); ok {
+				return l
+			}
+		}
+		return fmt.Sprintf("%#x", addr)
+	}
+	for _, arg := range in.args {
+		var s string
+		switch arg {
+		case argRd:
+			s, enc = "$"+regNames[rd], enc|rd<<11
+		case argRs:
+			s, enc = "$"+regNames[rs], enc|rs<<21
+		case argRt:
+			s, enc = "$"+regNames[rt], enc|rt<<16
+		case argSa:
+			s, enc = strconv.Itoa(int(sa)), enc|sa<<6
+		case argImm:
+			s, enc = strconv.Itoa(int(int16(w))), enc|w&0xffff
+		case argUImm:
+			s, enc = fmt.Sprintf("%#x", w&0xffff), enc|w&0xffff
+		case argBranch:
+			s, enc = target(pc+4+uint32(int32(int16(w))<<2)), enc|w&0xffff
+		case argTarget:
+			s, enc = target(w&0x3ff_ffff<<2), enc|w&0x3ff_ffff
+		case argMem:
+			s = fmt.Sprintf("%s($%s)", hex(int64(int16(w))), regNames[rs])
+			enc |= rs<<21 | w&0xffff
+		case argCode:
+			s, enc = fmt.Sprintf("%#x", w>>6&0xf_ffff), enc|w&0x3ff_ffc0
+		case argC0:
+			s, enc = fmt.Sprintf("$c%d", rd), enc|rd<<11
+		case argCtrl:
+			if rd >= uint32(len(ctrlNames)) {
+				return "", false
+			}
+			s, enc = ctrlNames[rd], enc|rd<<11
+		case argVd:
+			s, enc = fmt.Sprintf("$v%02d", sa), enc|sa<<6
+		case argVs:
+			s, enc = fmt.Sprintf("$v%02d", rd), enc|rd<<11
+		case argVtE:
+			e := rs & 15
+			s, enc = fmt.Sprintf("$v%02d%s", rt, formatElemVU(e)), enc|rt<<16|e<<21
+		case argVdDe:
+			s, enc = fmt.Sprintf("$v%02d%s", sa, formatElemDe(rd)), enc|sa<<6|rd<<11
+		case argVtB:
+			e := w >> 7 & 15
+			s, enc = fmt.Sprintf("$v%02d%s", rt, formatElemByte(e)), enc|rt<<16|e<<7
+		case argVsB:
+			e := w >> 7 & 15
+			s, enc = fmt.Sprintf("$v%02d%s", rd, formatElemByte(e)), enc|rd<<11|e<<7
+		case argVMem:
+			off := int64(int32(w<<25)>>25) * int64(in.scale)
+			s = fmt.Sprintf("%s($%s)", hex(off), regNames[rs])
+			enc |= rs<<21 | w&0x7f
+		}
+		args = append(args, s)
+	}
+	if enc != w {
+		return "", false // unused bits set
+	}
+	if len(args) == 0 {
+		return in.name, true
+	}
+	return in.name + "\t" + strings.Join(args, ", "), true
+}
+
+func decode(w uint32) *inst {
+	for i := range insts {
+		if w&insts[i].mask == insts[i].match {
+			return &insts[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package serial
+
+import (
+	"embedded/rtos"
+	"sync/atomic"
+	"time"
+
+	"github.com/clktmr/n64/rcp"
+	"github.com/clktmr/n64/rcp/cpu"
+)
+
+// jobs queued for execution, shared with interrupt handler
+var jobs rcp.IntrQueue[*Job]
+
+// Job is a CommandBlock submitted for asynchronous execution with [Submit].
+type Job struct {
+	block *CommandBlock
+	buf   []byte
+	done  func(block *CommandBlock)
+
+	finished atomic.Bool
+	note     rtos.Note
+}
+
+// Submit queues the block for execution on the PIF and returns immediately.
+// Queued blocks are executed in the order they were submitted. The block must
+// not be modified or submitted again until the returned job has finished.
+//
+// After the response was written back, done is called with the block, if it's
+// not nil. It's called from interrupt context, so it must not block, allocate
+// or contain write barriers.
+func Submit(block *CommandBlock, done func(block *CommandBlock)) *Job {
+	buf := block.Bytes()
+	cpu.WritebackSlice(buf)
+
+	job := &Job{block: block, buf: buf, done: done}
+	jobs.Push(job)
+
+	// If the bus is busy, the job is started after the current execution
+	// has finished.
+	dispatch()
+
+	return job
+}
+
+// Wait blocks until the job has finished or the timeout expired. Reports
+// whether the job has finished.
+func (job *Job) Wait(timeout time.Duration) bool {
+	return job.note.Sleep(timeout)
+}
+
+// Done reports whether the job has finished, i.e. the response was written
+// back to its block.
+func (job *Job) Done() bool {
+	return job.finished.Load()
+}
+
+// Block returns the submitted block.
+func (job *Job) Block() *CommandBlock {
+	return job.block
+}
+
+// dispatch starts the first queued job if the bus is idle.
+//
+//go:nosplit
+//go:nowritebarrierrec
+func dispatch() {
+	for state.CompareAndSwap(stateIdle, stateQueue) {
+		if job, ok := jobs.Peek(); ok {
+			(*job).start()
+			return
+		}
+		state.Store(stateIdle)
+
+		// A job pushed while holding the bus wasn't started by its
+		// submitter, so check again.
+		if _, ok := jobs.Peek(); !ok {
+			break
+		}
+	}
+	if bgPending.Load() {
+		Trigger() // might have been deferred while holding the bus
+	}
+}
+
+// start writes the job's block to PIF RAM.
+//
+//go:nosplit
+func (job *Job) start() {
+	regs().dramAddr.Store(cpu.PhysicalAddressSlice(job.buf))
+	regs().pifWriteAddr.Store(pifRamAddr)
+}
+
+// finish marks the job as finished and notifies the submitter.
+//
+//go:nosplit
+func (job *Job) finish() {
+	job.finished.Store(true)
+	if job.done != nil {
+		job.done(job.block)
+	}
+	job.note.Wakeup()
+}
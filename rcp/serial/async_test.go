@@ -0,0 +1,70 @@
+package serial_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/clktmr/n64/rcp/serial"
+	"github.com/clktmr/n64/rcp/serial/joybus"
+	n64testing "github.com/clktmr/n64/testing"
+)
+
+func TestMain(m *testing.M) { n64testing.TestMain(m) }
+
+const numJobs = 8
+
+// Written from interrupt context, so no pointers.
+var (
+	order    [numJobs]int32
+	finished atomic.Int32
+)
+
+func TestSubmit(t *testing.T) {
+	var blocks [numJobs]*serial.CommandBlock
+	var cmds [numJobs]joybus.InfoCommand
+	for i := range blocks {
+		blocks[i] = serial.NewCommandBlock(serial.CmdConfigureJoybus)
+		var err error
+		if cmds[i], err = joybus.NewInfoCommand(blocks[i]); err != nil {
+			t.Fatal(err)
+		}
+		if err = joybus.ControlByte(blocks[i], joybus.CtrlAbort); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var jobs [numJobs]*serial.Job
+	for i, block := range blocks {
+		jobs[i] = serial.Submit(block, func(*serial.CommandBlock) {
+			order[finished.Add(1)-1] = int32(i)
+		})
+	}
+	for i, job := range jobs {
+		if !job.Wait(1 * time.Second) {
+			t.Fatal("timeout")
+		}
+		if !job.Done() {
+			t.Fatal("woken before done")
+		}
+		if _, _, err := cmds[i].Info(); err != nil && err != joybus.ErrPIFNoResponse {
+			t.Error(err)
+		}
+	}
+
+	if n := finished.Load(); n != numJobs {
+		t.Fatalf("expected %d callbacks, got %d", numJobs, n)
+	}
+	for i, v := range order {
+		if v != int32(i) {
+			t.Fatalf("executed out of order: %v", order)
+		}
+	}
+
+	// Run waits for previously submitted jobs.
+	job := serial.Submit(blocks[0], nil)
+	serial.Run(blocks[1])
+	if !job.Done() {
+		t.Error("Run finished before previous job")
+	}
+}
@@ -0,0 +1,119 @@
+package serial
+
+import (
+	"sync/atomic"
+
+	"github.com/clktmr/n64/rcp"
+	"github.com/clktmr/n64/rcp/cpu"
+)
+
+// state shared with interrupt handler
+var (
+	background rcp.IntrInput[*Background]
+	bgPending  atomic.Bool
+)
+
+// Background holds a sequence of command blocks, which are executed from
+// interrupt context on each call to [Trigger]. It allows to run commands
+// periodically without any goroutine waiting for the bus.
+type Background struct {
+	blocks [][pifRamSize]byte
+	buf    []byte
+	next   int
+	done   func(i int, resp []byte)
+}
+
+// NewBackground returns a Background executing blocks in the given order. The
+// blocks are copied, later changes to them have no effect.
+//
+// After the i-th block was executed, done is called with the response. It's
+// called from interrupt context, so it must not block, allocate or contain
+// write barriers. The response is only valid until done returns.
+func NewBackground(done func(i int, resp []byte), blocks ...*CommandBlock) *Background {
+	bg := &Background{
+		blocks: make([][pifRamSize]byte, len(blocks)),
+		buf:    cpu.MakePaddedSlice[byte](pifRamSize),
+		done:   done,
+	}
+	for i, block := range blocks {
+		copy(bg.blocks[i][:], block.buf[:pifRamSize])
+		bg.blocks[i][pifRamSize-1] = byte(block.cmd)
+	}
+	return bg
+}
+
+// Update changes the i-th block of bg to block without waiting for the bus.
+// Only the bytes which differ are written, one at a time, while bg may be
+// executed concurrently. Thus block must only differ in bytes which can be
+// changed independently, e.g. flags of a command. To change the layout of the
+// commands, set a new Background with [SetBackground].
+func (bg *Background) Update(i int, block *CommandBlock) {
+	dst := &bg.blocks[i]
+	for j, b := range block.buf[:pifRamSize-1] {
+		if dst[j] != b {
+			dst[j] = b
+		}
+	}
+}
+
+// SetBackground sets the command blocks executed by [Trigger]. A nil value
+// disables background execution. Blocks until a currently running background
+// execution has finished.
+func SetBackground(bg *Background) {
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	acquire(stateLocked)
+	bgPending.Store(false)
+	background.Put(bg)
+	state.Store(stateIdle)
+	dispatch()
+}
+
+// Trigger starts executing the background command blocks set by
+// [SetBackground]. If the bus is currently busy, execution starts as soon as
+// it's idle again. Trigger is safe to call from interrupt handlers.
+//
+//go:nosplit
+//go:nowritebarrierrec
+func Trigger() {
+	if !state.CompareAndSwap(stateIdle, stateBackground) {
+		bgPending.Store(true)
+		return
+	}
+	bgPending.Store(false)
+
+	bg, _ := background.Get()
+	if bg == nil || len(bg.blocks) == 0 {
+		state.Store(stateIdle)
+		return
+	}
+	bg.next = 0
+	bg.start()
+}
+
+// start copies the next block into the DMA buffer and writes it to PIF RAM.
+//
+//go:nosplit
+func (bg *Background) start() {
+	copy(bg.buf, bg.blocks[bg.next][:])
+	cpu.WritebackSlice(bg.buf)
+	regs().dramAddr.Store(cpu.PhysicalAddressSlice(bg.buf))
+	regs().pifWriteAddr.Store(pifRamAddr)
+}
+
+// finish passes the response of the current block to the done callback and
+// starts the next block. Returns true if the last block was finished.
+//
+//go:nosplit
+func (bg *Background) finish() bool {
+	if bg.done != nil {
+		bg.done(bg.next, bg.buf)
+	}
+	bg.next++
+	if bg.next < len(bg.blocks) {
+		bg.start()
+		return false
+	}
+	return true
+}
@@ -4,11 +4,12 @@ import (
 	"embedded/rtos"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
-	"unsafe"
 
 	"github.com/clktmr/n64/rcp"
 	"github.com/clktmr/n64/rcp/cpu"
+	"github.com/clktmr/n64/rcp/serial/joybus"
 )
 
 type pifCommand byte
@@ -26,10 +27,18 @@ const (
 
 var mtx sync.Mutex
 
+// Bus states
+const (
+	stateIdle       uint32 = iota
+	stateQueue             // executing the jobs passed to Submit
+	stateBackground        // executing the background blocks
+	stateLocked            // background is being replaced by SetBackground
+)
+
 // state shared with interrupt handler
 var (
-	cmdFinished rtos.Cond
-	cmdBuffer   rcp.IntrInput[[]byte]
+	state   atomic.Uint32
+	busIdle rtos.Cond
 )
 
 func init() {
@@ -42,22 +51,67 @@ func init() {
 func handler() {
 	regs().status.Store(0) // clears interrupt
 
-	buf, _ := cmdBuffer.Get()
-	if buf == nil {
+	prev := state.Load()
+	switch prev {
+	case stateQueue:
+		job, ok := jobs.Peek()
+		if !ok {
+			return
+		}
+		if !finished((*job).buf) {
+			readBack((*job).buf)
+			return
+		}
+		jobs.Pop()
+		(*job).finish()
+		state.Store(stateIdle)
+	case stateBackground:
+		bg, _ := background.Get()
+		if bg == nil {
+			return
+		}
+		if !finished(bg.buf) {
+			readBack(bg.buf)
+			return
+		}
+		if !bg.finish() {
+			return // next block started
+		}
+		state.Store(stateIdle)
+	default:
 		return
 	}
 
-	if buf[pifRamSize-1] == 0x00 {
-		// DMA read finished
-		cmdFinished.Signal()
-	} else {
-		// DMA write finished, trigger read back
-		cpu.InvalidateSlice(buf)
-		regs().dramAddr.Store(cpu.PhysicalAddressSlice(buf))
-		regs().pifReadAddr.Store(pifRamAddr)
+	// Alternate between queued jobs and background execution, so neither
+	// can starve the other.
+	if prev == stateBackground {
+		dispatch()
+	}
+	if bgPending.Load() {
+		Trigger()
+	}
+	dispatch()
+	if state.Load() == stateIdle {
+		busIdle.Signal()
 	}
 }
 
+// finished reports if the response was read back from PIF RAM.
+//
+//go:nosplit
+func finished(buf []byte) bool {
+	return buf[pifRamSize-1] == 0x00
+}
+
+// readBack triggers the DMA of the response from PIF RAM.
+//
+//go:nosplit
+func readBack(buf []byte) {
+	cpu.InvalidateSlice(buf)
+	regs().dramAddr.Store(cpu.PhysicalAddressSlice(buf))
+	regs().pifReadAddr.Store(pifRamAddr)
+}
+
 // CommandBlock holds the buffer that is used to write the command and read the
 // response.
 type CommandBlock struct {
@@ -87,30 +141,47 @@ func (c *CommandBlock) Free() int {
 	return cap(c.buf) - len(c.buf) - 1 // save one byte for PIF command
 }
 
+// Bytes returns the PIF RAM contents of the block, including the PIF command in
+// the last byte.
+func (c *CommandBlock) Bytes() []byte {
+	buf := c.buf[:pifRamSize]
+	buf[pifRamSize-1] = byte(c.cmd)
+	return buf
+}
+
 // Run executes the given CommandBlock on the PIF and blocks until the response
-// was written back.
+// was written back. Blocks submitted before are executed first.
 func Run(block *CommandBlock) {
-	mtx.Lock()
-	defer mtx.Unlock()
+	if !Submit(block, nil).Wait(1 * time.Second) {
+		panic("pif timeout")
+	}
+}
 
-	buf := block.buf[:pifRamSize]
-	buf[len(buf)-1] = byte(block.cmd)
+// Transport creates and runs command blocks with a fixed PIF command. It
+// implements [github.com/clktmr/n64/drivers/controller/pakio.Transport].
+type Transport struct {
+	cmd pifCommand
+}
 
-	cmdBuffer.Put(buf)
-	cpu.WritebackSlice(buf)
-	ramAddr := uintptr(unsafe.Pointer(unsafe.SliceData(buf)))
-	run(ramAddr, pifRamAddr)
+// Joybus runs blocks of joybus commands.
+var Joybus = Transport{CmdConfigureJoybus}
 
-	cmdBuffer.Put(nil)
+// NewBlock returns an empty *[CommandBlock].
+func (t Transport) NewBlock() joybus.Allocator {
+	return NewCommandBlock(t.cmd)
 }
 
-//go:uintptrescapes
-func run(ramAddr uintptr, pifRamAddr cpu.Addr) {
-	regs().dramAddr.Store(cpu.PAddr(ramAddr))
-	regs().pifWriteAddr.Store(pifRamAddr)
+// Run executes the block, which must be a *[CommandBlock], see [Run].
+func (t Transport) Run(block joybus.Allocator) {
+	Run(block.(*CommandBlock))
+}
 
-	// Wait until message was received
-	if !cmdFinished.Wait(1 * time.Second) {
-		panic("pif timeout")
+// acquire waits until the bus is idle and sets its state to s. Must be called
+// with mtx held.
+func acquire(s uint32) {
+	for !state.CompareAndSwap(stateIdle, s) {
+		if !busIdle.Wait(1 * time.Second) {
+			panic("pif timeout")
+		}
 	}
 }
@@ -66,6 +66,7 @@ const (
 	cmdRTCInfo         = "\x01\x03\x06"
 	cmdReadRTC         = "\x02\x09\x07"
 	cmdWriteRTC        = "\x0a\x01\x08"
+	cmdKeyboardState   = "\x02\x07\x13"
 )
 
 type Command []byte
@@ -81,7 +82,9 @@ func newCommand(alloc Allocator, cmd string) (Command, error) {
 	return buf, nil
 }
 
+// Reset clears the response and error flags of the last execution.
 func (c Command) Reset() {
+	c[1] &^= flagMask
 	rx := c.rxData()
 	for i := range rx {
 		rx[i] = 0x00
@@ -123,6 +126,8 @@ const (
 	LinkCable  Device = 0x0003
 	EEPROM4k   Device = 0x0080
 	EEPROM16k  Device = 0x00c0
+
+	GCController Device = 0x0900 // Wired GameCube controller
 )
 
 type InfoCommand struct{ Command }
@@ -214,10 +219,61 @@ func (c ControllerStateCommand) State() (b ButtonMask, x int8, y int8, err error
 	return ButtonMask(uint16(rx[0])<<8 | uint16(rx[1])), int8(rx[2]), int8(rx[3]), nil
 }
 
+// KeyboardLED is a bitmask of the Randnet keyboard's LEDs.
+type KeyboardLED byte
+
+const (
+	LEDNumLock KeyboardLED = 1 << iota
+	LEDCapsLock
+	LEDPower
+)
+
+// KeyboardStatus holds the status flags of a Randnet keyboard.
+type KeyboardStatus byte
+
+const (
+	KeyboardHome        KeyboardStatus = 0x01 // Home key is pressed
+	KeyboardTooManyKeys KeyboardStatus = 0x10 // More than three keys are pressed
+)
+
+// KeyboardStateCommand polls the keys of a Randnet keyboard and sets its LEDs.
+type KeyboardStateCommand struct{ Command }
+
+func NewKeyboardStateCommand(alloc Allocator) (KeyboardStateCommand, error) {
+	cmd, err := newCommand(alloc, cmdKeyboardState)
+	return KeyboardStateCommand{cmd}, err
+}
+
+// SetLEDs sets the LEDs which will be lit after the command was executed.
+func (c KeyboardStateCommand) SetLEDs(leds KeyboardLED) {
+	c.txData()[1] = byte(leds)
+}
+
+// State returns the scan codes of up to three pressed keys. Unused entries are
+// zero.
+func (c KeyboardStateCommand) State() (keys [3]uint16, status KeyboardStatus, err error) {
+	if err = validate(c.Command, cmdKeyboardState); err != nil {
+		return
+	}
+	rx := c.rxData()
+	for i := range keys {
+		keys[i] = uint16(rx[2*i])<<8 | uint16(rx[2*i+1])
+	}
+	return keys, KeyboardStatus(rx[6]), nil
+}
+
 type PakCommand struct{ Command }
 
 func (c PakCommand) SetAddress(addr uint16) {
-	// calculate checksum in lower 5 bits
+	addr = PakAddress(addr)
+	tx := c.txData()
+	tx[1] = byte(addr >> 8)
+	tx[2] = byte(addr)
+}
+
+// PakAddress returns the 32 byte aligned addr with its checksum in the lower 5
+// bits, as it's sent to the pak.
+func PakAddress(addr uint16) uint16 {
 	addr &^= 0x1f
 	const lut = "\x01\x1a\x0d\x1c\x0e\x07\x19\x16\x0b\x1f\x15"
 	for i, v := range lut {
@@ -225,13 +281,17 @@ func (c PakCommand) SetAddress(addr uint16) {
 			addr ^= uint16(v)
 		}
 	}
-	tx := c.txData()
-	tx[1] = byte(addr >> 8)
-	tx[2] = byte(addr)
+	return addr
 }
 
 var pakCRC8 = crc8.MakeTable(crc8.Params{0x85, 0x00, false, false, 0x00, 0xF4, "CRC-8 N64 Pak"})
 
+// PakChecksum returns the checksum a pak responds with when reading or writing
+// data.
+func PakChecksum(data []byte) byte {
+	return crc8.Checksum(data, pakCRC8)
+}
+
 type ReadPakCommand struct{ PakCommand }
 
 func NewReadPakCommand(alloc Allocator) (ReadPakCommand, error) {
@@ -302,6 +362,56 @@ func (c WritePakCommand) Result() error {
 	return nil
 }
 
+// EEPROMBlockSize is the number of bytes in a single EEPROM read or write.
+const EEPROMBlockSize = 8
+
+type ReadEEPROMCommand struct{ Command }
+
+func NewReadEEPROMCommand(alloc Allocator) (ReadEEPROMCommand, error) {
+	cmd, err := newCommand(alloc, cmdReadEEPROM)
+	return ReadEEPROMCommand{cmd}, err
+}
+
+// SetBlock sets the index of the 8 byte block to read.
+func (c ReadEEPROMCommand) SetBlock(n byte) {
+	c.txData()[1] = n
+}
+
+func (c ReadEEPROMCommand) Data() (data []byte, err error) {
+	if err = validate(c.Command, cmdReadEEPROM); err != nil {
+		return
+	}
+	return c.rxData(), nil
+}
+
+type WriteEEPROMCommand struct{ Command }
+
+func NewWriteEEPROMCommand(alloc Allocator) (WriteEEPROMCommand, error) {
+	cmd, err := newCommand(alloc, cmdWriteEEPROM)
+	return WriteEEPROMCommand{cmd}, err
+}
+
+// SetBlock sets the index of the 8 byte block to write.
+func (c WriteEEPROMCommand) SetBlock(n byte) {
+	c.txData()[1] = n
+}
+
+// len(src) must match the payload size, i.e. 8 bytes.
+func (c WriteEEPROMCommand) SetData(src []byte) error {
+	data := c.txData()[2:]
+	if len(src) != len(data) {
+		return ErrDataLength
+	}
+	copy(data, src)
+	return nil
+}
+
+// Result returns an error if the write wasn't acknowledged. The EEPROM is busy
+// for up to 15 ms after each write, see [InfoCommand].
+func (c WriteEEPROMCommand) Result() error {
+	return validate(c.Command, cmdWriteEEPROM)
+}
+
 func validate(c Command, header string) error {
 	expected := []byte(header)
 	got := [headerLen]byte{}
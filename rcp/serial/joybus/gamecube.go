@@ -0,0 +1,125 @@
+package joybus
+
+import "strings"
+
+// GameCube controller commands
+const (
+	cmdGCState     = "\x03\x08\x40"
+	cmdGCOrigin    = "\x01\x0a\x41"
+	cmdGCCalibrate = "\x03\x0a\x42"
+)
+
+// GameCube reports whether the device is a GameCube controller, including
+// wireless ones.
+func (d Device) GameCube() bool {
+	return d&0x0800 != 0
+}
+
+type GCButtonMask uint16
+
+const (
+	GCButtonDLeft GCButtonMask = 1 << iota
+	GCButtonDRight
+	GCButtonDDown
+	GCButtonDUp
+	GCButtonZ
+	GCButtonR
+	GCButtonL
+	_
+	GCButtonA
+	GCButtonB
+	GCButtonX
+	GCButtonY
+	GCButtonStart
+	GCNeedOrigin // Controller requests its origin to be read again
+
+	gcButtons = 0x1f7f
+)
+
+var gcButtonNames = [...]string{
+	"◄", "►", "▼", "▲", "Z", "R", "L", "", "A", "B", "X", "Y", "Start",
+}
+
+func (b GCButtonMask) String() string {
+	var sb strings.Builder
+	for i := len(gcButtonNames) - 1; i >= 0; i-- {
+		if b&(1<<i) != 0 && gcButtonNames[i] != "" {
+			if sb.Len() != 0 {
+				sb.WriteString(" + ")
+			}
+			sb.WriteString(gcButtonNames[i])
+		}
+	}
+	return sb.String()
+}
+
+// GCState is the state of a GameCube controller. All analog values are
+// unsigned, with sticks centered around 0x80.
+type GCState struct {
+	Buttons          GCButtonMask
+	StickX, StickY   uint8
+	CStickX, CStickY uint8
+	L, R             uint8 // Analog triggers
+}
+
+func parseGCState(rx []byte) GCState {
+	return GCState{
+		Buttons: GCButtonMask(uint16(rx[0])<<8|uint16(rx[1])) & (gcButtons | GCNeedOrigin),
+		StickX:  rx[2], StickY: rx[3],
+		CStickX: rx[4], CStickY: rx[5],
+		L: rx[6], R: rx[7],
+	}
+}
+
+// GCStateCommand polls a GameCube controller and sets its rumble motor.
+type GCStateCommand struct{ Command }
+
+func NewGCStateCommand(alloc Allocator) (GCStateCommand, error) {
+	cmd, err := newCommand(alloc, cmdGCState)
+	if err == nil {
+		cmd.txData()[1] = 0x03 // analog mode 3: full precision sticks and triggers
+	}
+	return GCStateCommand{cmd}, err
+}
+
+// SetRumble switches the rumble motor on or off when the command is executed.
+func (c GCStateCommand) SetRumble(on bool) {
+	var v byte
+	if on {
+		v = 0x01
+	}
+	c.txData()[2] = v
+}
+
+func (c GCStateCommand) State() (s GCState, err error) {
+	if err = validate(c.Command, cmdGCState); err != nil {
+		return
+	}
+	return parseGCState(c.rxData()), nil
+}
+
+// GCOriginCommand reads the controller's origin, i.e. the analog values
+// sampled when it was plugged in or last calibrated.
+type GCOriginCommand struct {
+	Command
+	header string
+}
+
+func NewGCOriginCommand(alloc Allocator) (GCOriginCommand, error) {
+	cmd, err := newCommand(alloc, cmdGCOrigin)
+	return GCOriginCommand{cmd, cmdGCOrigin}, err
+}
+
+// NewGCCalibrateCommand returns a command which samples the current analog
+// values as the new origin and returns them.
+func NewGCCalibrateCommand(alloc Allocator) (GCOriginCommand, error) {
+	cmd, err := newCommand(alloc, cmdGCCalibrate)
+	return GCOriginCommand{cmd, cmdGCCalibrate}, err
+}
+
+func (c GCOriginCommand) Origin() (s GCState, err error) {
+	if err = validate(c.Command, c.header); err != nil {
+		return
+	}
+	return parseGCState(c.rxData()), nil
+}
@@ -0,0 +1,83 @@
+//go:build !n64
+
+package joybus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGCState(t *testing.T) {
+	var b testBlock
+	cmd, err := NewGCStateCommand(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.SetRumble(true)
+	if !bytes.Equal(b.buf[:5], []byte{0x03, 0x08, 0x40, 0x03, 0x01}) {
+		t.Fatalf("unexpected command % x", b.buf[:5])
+	}
+	cmd.SetRumble(false)
+	if b.buf[4] != 0x00 {
+		t.Fatal("rumble not cleared")
+	}
+
+	copy(cmd.rxData(), []byte{0x31, 0x88, 0x80, 0x7f, 0x10, 0xf0, 0x20, 0xff})
+	s, err := cmd.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := GCState{
+		Buttons: GCButtonStart | GCButtonA | GCNeedOrigin | GCButtonDUp,
+		StickX:  0x80, StickY: 0x7f,
+		CStickX: 0x10, CStickY: 0xf0,
+		L: 0x20, R: 0xff,
+	}
+	if s != want {
+		t.Fatalf("expected %+v, got %+v", want, s)
+	}
+	if got := s.Buttons.String(); got != "Start + A + ▲" {
+		t.Errorf("unexpected string %q", got)
+	}
+
+	b.buf[1] |= flagNoResponse
+	if _, err = cmd.State(); err != ErrPIFNoResponse {
+		t.Fatalf("expected %v, got %v", ErrPIFNoResponse, err)
+	}
+}
+
+func TestGCOrigin(t *testing.T) {
+	var b testBlock
+	origin, err := NewGCOriginCommand(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calibrate, err := NewGCCalibrateCommand(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(origin.Command[:3], []byte{0x01, 0x0a, 0x41}) {
+		t.Fatalf("unexpected command % x", origin.Command[:3])
+	}
+	if !bytes.Equal(calibrate.Command[:5], []byte{0x03, 0x0a, 0x42, 0x00, 0x00}) {
+		t.Fatalf("unexpected command % x", calibrate.Command[:5])
+	}
+
+	copy(calibrate.rxData(), []byte{0x00, 0x80, 0x82, 0x7e, 0x80, 0x80, 0x1a, 0x1b, 0x00, 0x00})
+	s, err := calibrate.Origin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Buttons != 0 || s.StickX != 0x82 || s.StickY != 0x7e || s.L != 0x1a || s.R != 0x1b {
+		t.Fatalf("unexpected origin %+v", s)
+	}
+	if _, err = origin.Origin(); err != nil {
+		t.Fatal(err)
+	}
+
+	for dev, gc := range map[Device]bool{GCController: true, 0xe9a0: true, Controller: false, Mouse: false} {
+		if dev.GameCube() != gc {
+			t.Errorf("%#04x: expected %v", uint16(dev), gc)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package joybustest
+
+import (
+	"sync"
+
+	"github.com/clktmr/n64/rcp/serial/joybus"
+)
+
+// joybus command IDs
+const (
+	idInfo            = 0x00
+	idControllerState = 0x01
+	idReadPak         = 0x02
+	idWritePak        = 0x03
+	idReadEEPROM      = 0x04
+	idWriteEEPROM     = 0x05
+	idReset           = 0xff
+)
+
+const pakBlockSize = 32
+
+// Controller info flags
+const (
+	pakInserted    = 0x01
+	pakNotInserted = 0x02
+	pakAddrCRC     = 0x04
+)
+
+// Pak is an accessory inserted into a simulated [Controller]. Addresses are 32
+// byte aligned and p is always 32 bytes long.
+type Pak interface {
+	ReadPak(addr uint16, p []byte)
+	WritePak(addr uint16, p []byte)
+}
+
+// Controller simulates a N64 controller. Its fields can be changed any time
+// between commands.
+type Controller struct {
+	mtx sync.Mutex
+
+	Buttons joybus.ButtonMask
+	X, Y    int8
+	Pak     Pak // Inserted pak, nil if none
+
+	addrErr bool
+}
+
+// Set changes the controller's state atomically.
+func (c *Controller) Set(buttons joybus.ButtonMask, x, y int8) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.Buttons, c.X, c.Y = buttons, x, y
+}
+
+// Insert inserts pak into the controller. A nil pak removes the current one.
+func (c *Controller) Insert(pak Pak) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.Pak = pak
+}
+
+func (c *Controller) Exec(tx, rx []byte) int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	switch tx[0] {
+	case idInfo, idReset:
+		flags := byte(pakNotInserted)
+		if c.Pak != nil {
+			flags = pakInserted
+		}
+		if c.addrErr {
+			flags |= pakAddrCRC
+			c.addrErr = false
+		}
+		dev := joybus.Controller
+		rx[0], rx[1], rx[2] = byte(dev>>8), byte(dev), flags
+		return 3
+	case idControllerState:
+		rx[0], rx[1] = byte(c.Buttons>>8), byte(c.Buttons)
+		rx[2], rx[3] = byte(c.X), byte(c.Y)
+		return 4
+	case idReadPak:
+		if len(tx) != 3 {
+			return -1
+		}
+		addr, ok := c.pakAddress(tx[1:3])
+		data := rx[:pakBlockSize]
+		clear(data)
+		if c.Pak != nil && ok {
+			c.Pak.ReadPak(addr, data)
+		}
+		rx[pakBlockSize] = c.checksum(data)
+		return pakBlockSize + 1
+	case idWritePak:
+		if len(tx) != 3+pakBlockSize {
+			return -1
+		}
+		addr, ok := c.pakAddress(tx[1:3])
+		data := tx[3:]
+		if c.Pak != nil && ok {
+			c.Pak.WritePak(addr, data)
+		}
+		rx[0] = c.checksum(data)
+		return 1
+	}
+	return -1
+}
+
+// pakAddress decodes the address and verifies its checksum.
+func (c *Controller) pakAddress(b []byte) (uint16, bool) {
+	addr := uint16(b[0])<<8 | uint16(b[1])
+	if joybus.PakAddress(addr) != addr {
+		c.addrErr = true
+		return addr &^ (pakBlockSize - 1), false
+	}
+	return addr &^ (pakBlockSize - 1), true
+}
+
+// checksum returns the data checksum, which is inverted if no pak is inserted.
+func (c *Controller) checksum(data []byte) byte {
+	csum := joybus.PakChecksum(data)
+	if c.Pak == nil {
+		csum = ^csum
+	}
+	return csum
+}
+
+// MemPakSize is the size of a Controller Pak's SRAM, i.e. the size of a .mpk
+// image.
+const MemPakSize = 0x8000
+
+// MemPak simulates a Controller Pak backed by an image in the .mpk format.
+type MemPak struct {
+	Data []byte
+}
+
+// NewMemPak returns a Controller Pak with a copy of img as its contents. The
+// image is padded or truncated to [MemPakSize].
+func NewMemPak(img []byte) *MemPak {
+	pak := &MemPak{make([]byte, MemPakSize)}
+	copy(pak.Data, img)
+	return pak
+}
+
+func (pak *MemPak) ReadPak(addr uint16, p []byte) {
+	if int(addr) < len(pak.Data) {
+		copy(p, pak.Data[addr:])
+	}
+}
+
+func (pak *MemPak) WritePak(addr uint16, p []byte) {
+	if int(addr) < len(pak.Data) {
+		copy(pak.Data[addr:], p)
+	}
+}
+
+// Rumble Pak address ranges
+const (
+	rumbleProbe = 0x8000
+	rumbleMotor = 0xc000
+
+	probeRumble = 0x80
+)
+
+// RumblePak simulates a Rumble Pak.
+type RumblePak struct {
+	probed bool
+	On     bool // Motor is running
+}
+
+func (pak *RumblePak) ReadPak(addr uint16, p []byte) {
+	if addr >= rumbleProbe && addr < rumbleMotor && pak.probed {
+		for i := range p {
+			p[i] = probeRumble
+		}
+	}
+}
+
+func (pak *RumblePak) WritePak(addr uint16, p []byte) {
+	v := p[pakBlockSize-1]
+	switch {
+	case addr >= rumbleMotor:
+		pak.On = v&0x01 != 0
+	case addr >= rumbleProbe:
+		pak.probed = v == probeRumble
+	}
+}
+
+// EEPROM simulates a 4 Kbit or 16 Kbit EEPROM on the cartridge channel.
+type EEPROM struct {
+	mtx  sync.Mutex
+	Data []byte
+}
+
+// NewEEPROM returns an EEPROM of size bytes, which must be either 512 or 2048.
+func NewEEPROM(size int) *EEPROM {
+	if size != 512 && size != 2048 {
+		panic("invalid EEPROM size")
+	}
+	return &EEPROM{Data: make([]byte, size)}
+}
+
+func (e *EEPROM) Exec(tx, rx []byte) int {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	switch tx[0] {
+	case idInfo, idReset:
+		dev := joybus.EEPROM4k
+		if len(e.Data) > 512 {
+			dev = joybus.EEPROM16k
+		}
+		rx[0], rx[1], rx[2] = byte(dev>>8), byte(dev), 0x00
+		return 3
+	case idReadEEPROM:
+		if len(tx) != 2 {
+			return -1
+		}
+		off := int(tx[1]) * joybus.EEPROMBlockSize
+		data := rx[:joybus.EEPROMBlockSize]
+		clear(data)
+		if off < len(e.Data) {
+			copy(data, e.Data[off:])
+		}
+		return joybus.EEPROMBlockSize
+	case idWriteEEPROM:
+		if len(tx) != 2+joybus.EEPROMBlockSize {
+			return -1
+		}
+		off := int(tx[1]) * joybus.EEPROMBlockSize
+		if off < len(e.Data) {
+			copy(e.Data[off:], tx[2:])
+		}
+		rx[0] = 0x00
+		return 1
+	}
+	return -1
+}
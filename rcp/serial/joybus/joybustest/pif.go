@@ -0,0 +1,202 @@
+// Package joybustest implements a simulated PIF for testing code which creates
+// and parses joybus messages on the host.
+//
+// The [PIF] executes command blocks in the same format as they are written to
+// PIF RAM by [serial.Run], i.e. 64 bytes with the PIF command in the last
+// byte. [Block] can be used in place of [serial.CommandBlock] to build them.
+//
+// [serial.Run]: https://pkg.go.dev/github.com/clktmr/n64/rcp/serial#Run
+// [serial.CommandBlock]: https://pkg.go.dev/github.com/clktmr/n64/rcp/serial#CommandBlock
+package joybustest
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/clktmr/n64/rcp/serial/joybus"
+)
+
+const (
+	ramSize = 64
+
+	cmdConfigureJoybus = 0x01
+)
+
+// Block holds a command block and implements [joybus.Allocator].
+type Block struct {
+	buf [ramSize]byte
+	n   int
+}
+
+// Alloc returns a slice with the next n bytes.
+func (b *Block) Alloc(n int) ([]byte, error) {
+	if n > b.Free() {
+		return nil, errors.New("command block full")
+	}
+	b.n += n
+	return b.buf[b.n-n : b.n], nil
+}
+
+// Free returns the number of free bytes available in the Block for additional
+// commands.
+func (b *Block) Free() int {
+	return len(b.buf) - b.n - 1 // save one byte for PIF command
+}
+
+// Bytes returns the PIF RAM contents of the block.
+func (b *Block) Bytes() []byte {
+	return b.buf[:]
+}
+
+// Device is a joybus device connected to a port of the simulated PIF.
+type Device interface {
+	// Exec executes the command in tx, where tx[0] is the command's ID. The
+	// response is written to rx, which has room for the largest possible
+	// response. Returns the length of the response or -1 if the device
+	// doesn't respond to the command.
+	Exec(tx, rx []byte) int
+}
+
+// Fault is an error injected into the response of a device.
+type Fault int
+
+const (
+	NoResponse      Fault = iota + 1 // Device doesn't respond
+	InvalidResponse                  // Response has the wrong length
+	BadChecksum                      // Last byte of the response is corrupted
+)
+
+const (
+	// command bits in the first header byte
+	flagSkip = 0x80
+
+	// error bits set by the PIF in the second header byte
+	flagNoResponse      = 0x80
+	flagInvalidResponse = 0x40
+	flagMask            = 0xc0
+)
+
+const numChannels = 5
+
+// PIF simulates the PIF-NUS executing joybus commands. Ports 0 to 3 are the
+// controller ports, the Cartridge is the channel used for the EEPROM. Nil
+// devices don't respond to any command.
+type PIF struct {
+	Ports     [4]Device
+	Cartridge Device
+
+	mtx    sync.Mutex
+	faults [numChannels][]Fault
+}
+
+// Inject queues a fault for the next command sent to port. Port 4 is the
+// cartridge channel. Faults are consumed in the order they were injected, one
+// per command.
+func (p *PIF) Inject(port int, f Fault) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.faults[port] = append(p.faults[port], f)
+}
+
+func (p *PIF) fault(ch int) (f Fault) {
+	if len(p.faults[ch]) > 0 {
+		f, p.faults[ch] = p.faults[ch][0], p.faults[ch][1:]
+	}
+	return
+}
+
+func (p *PIF) device(ch int) Device {
+	if ch < len(p.Ports) {
+		return p.Ports[ch]
+	}
+	return p.Cartridge
+}
+
+// NewBlock returns an empty *[Block].
+func (p *PIF) NewBlock() joybus.Allocator {
+	return &Block{}
+}
+
+// Run executes the block's joybus commands and writes the responses back into
+// it, like [serial.Run] does on the console. The block is either a *[Block] or
+// provides the PIF RAM contents including the PIF command by a Bytes method,
+// like [serial.CommandBlock] does.
+//
+// Together with NewBlock it implements [pakio.Transport].
+//
+// [serial.Run]: https://pkg.go.dev/github.com/clktmr/n64/rcp/serial#Run
+// [serial.CommandBlock]: https://pkg.go.dev/github.com/clktmr/n64/rcp/serial#CommandBlock
+// [pakio.Transport]: https://pkg.go.dev/github.com/clktmr/n64/drivers/controller/pakio#Transport
+func (p *PIF) Run(block joybus.Allocator) {
+	if b, ok := block.(*Block); ok {
+		b.buf[ramSize-1] = cmdConfigureJoybus
+	}
+	p.Exec(block.(interface{ Bytes() []byte }).Bytes())
+}
+
+// Exec executes the 64 bytes of PIF RAM in ram. The responses are written back
+// into ram and the PIF command in the last byte is cleared.
+func (p *PIF) Exec(ram []byte) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if len(ram) != ramSize {
+		panic("invalid PIF RAM size")
+	}
+	defer func() { ram[ramSize-1] = 0x00 }()
+	if ram[ramSize-1]&cmdConfigureJoybus == 0 {
+		return
+	}
+
+	var scratch [ramSize]byte
+	for i, ch := 0, 0; i < ramSize-1 && ch < numChannels; {
+		switch ram[i] {
+		case joybus.CtrlSkip, joybus.CtrlReset:
+			i++
+			ch++
+			continue
+		case joybus.CtrlAbort:
+			return
+		case joybus.CtrlNOP:
+			i++
+			continue
+		}
+
+		ram[i+1] &^= flagMask // clear errors of last execution
+		txLen := int(ram[i] &^ flagMask)
+		rxLen := int(ram[i+1])
+		end := i + 2 + txLen + rxLen
+		if end > ramSize-1 {
+			return
+		}
+		tx := ram[i+2 : i+2+txLen]
+		rx := ram[i+2+txLen : end]
+
+		if ram[i]&flagSkip != 0 || txLen == 0 {
+			i = end
+			ch++
+			continue
+		}
+
+		n := -1
+		fault := p.fault(ch)
+		if dev := p.device(ch); dev != nil && fault != NoResponse {
+			n = dev.Exec(tx, scratch[:])
+		}
+		switch {
+		case n < 0:
+			ram[i+1] |= flagNoResponse
+		case n != rxLen || fault == InvalidResponse:
+			copy(rx, scratch[:n])
+			ram[i+1] |= flagInvalidResponse
+		default:
+			copy(rx, scratch[:n])
+			if fault == BadChecksum && n > 0 {
+				rx[n-1] ^= 0xff
+			}
+		}
+
+		i = end
+		ch++
+	}
+}
@@ -0,0 +1,271 @@
+//go:build !n64
+
+package joybustest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/clktmr/n64/rcp/serial/joybus"
+)
+
+// newBlock returns a block with cmd on the given channel.
+func newBlock[T any](t *testing.T, ch int, cmd func(joybus.Allocator) (T, error)) (*Block, T) {
+	t.Helper()
+	b := &Block{}
+	for range ch {
+		if err := joybus.ControlByte(b, joybus.CtrlSkip); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := cmd(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = joybus.ControlByte(b, joybus.CtrlAbort); err != nil {
+		t.Fatal(err)
+	}
+	return b, c
+}
+
+// pakPort sends single block pak commands to a port.
+type pakPort struct {
+	pif               *PIF
+	readBlk, writeBlk *Block
+	read              joybus.ReadPakCommand
+	write             joybus.WritePakCommand
+}
+
+func newPakPort(t *testing.T, pif *PIF, port int) *pakPort {
+	d := &pakPort{pif: pif}
+	d.readBlk, d.read = newBlock(t, port, joybus.NewReadPakCommand)
+	d.writeBlk, d.write = newBlock(t, port, joybus.NewWritePakCommand)
+	return d
+}
+
+func (d *pakPort) readBlock(addr uint16) ([]byte, error) {
+	d.read.Reset()
+	d.read.SetAddress(addr)
+	d.pif.Run(d.readBlk)
+	return d.read.Data()
+}
+
+func (d *pakPort) writeBlock(addr uint16, p []byte) error {
+	d.write.Reset()
+	if err := d.write.SetData(p); err != nil {
+		return err
+	}
+	d.write.SetAddress(addr)
+	d.pif.Run(d.writeBlk)
+	return d.write.Result()
+}
+
+func TestController(t *testing.T) {
+	ctrl := &Controller{}
+	pif := &PIF{Ports: [4]Device{nil, ctrl}}
+	ctrl.Set(joybus.ButtonA|joybus.ButtonCUp, -12, 80)
+
+	b := &Block{}
+	var info [4]joybus.InfoCommand
+	for i := range info {
+		var err error
+		if info[i], err = joybus.NewInfoCommand(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state, err := joybus.NewControllerStateCommand(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = joybus.ControlByte(b, joybus.CtrlAbort); err != nil {
+		t.Fatal(err)
+	}
+	pif.Run(b)
+
+	if b.Bytes()[ramSize-1] != 0 {
+		t.Error("PIF command not cleared")
+	}
+	for i, cmd := range info {
+		dev, flags, err := cmd.Info()
+		if i != 1 {
+			if err != joybus.ErrPIFNoResponse {
+				t.Errorf("port %d: expected %v, got %v", i, joybus.ErrPIFNoResponse, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dev != joybus.Controller || flags != pakNotInserted {
+			t.Errorf("unexpected info %#04x %#02x", uint16(dev), flags)
+		}
+	}
+	// The fifth command goes to the cartridge channel.
+	if _, _, _, err = state.State(); err != joybus.ErrPIFNoResponse {
+		t.Errorf("expected %v, got %v", joybus.ErrPIFNoResponse, err)
+	}
+
+	b, state = newBlock(t, 1, joybus.NewControllerStateCommand)
+	pif.Run(b)
+	buttons, x, y, err := state.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buttons != joybus.ButtonA|joybus.ButtonCUp || x != -12 || y != 80 {
+		t.Errorf("unexpected state %v %d %d", buttons, x, y)
+	}
+}
+
+func TestMemPak(t *testing.T) {
+	pak := NewMemPak(nil)
+	ctrl := &Controller{}
+	ctrl.Insert(pak)
+	pif := &PIF{Ports: [4]Device{ctrl}}
+
+	b, info := newBlock(t, 0, joybus.NewInfoCommand)
+	pif.Run(b)
+	if _, flags, err := info.Info(); err != nil || flags != pakInserted {
+		t.Fatalf("unexpected flags %#02x: %v", flags, err)
+	}
+
+	dev := newPakPort(t, pif, 0)
+	data := bytes.Repeat([]byte("simulated"), 4)[:pakBlockSize]
+	if err := dev.writeBlock(0x0420, data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pak.Data[0x0420:0x0440], data) {
+		t.Fatal("data not written to pak image")
+	}
+	got, err := dev.readBlock(0x0420)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("expected %q, got %q", data, got)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	ctrl := &Controller{}
+	ctrl.Insert(NewMemPak(nil))
+	pif := &PIF{Ports: [4]Device{nil, nil, ctrl}}
+	dev := newPakPort(t, pif, 2)
+
+	var buf [pakBlockSize]byte
+	if _, err := dev.readBlock(0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		fault Fault
+		err   error
+	}{
+		{NoResponse, joybus.ErrPIFNoResponse},
+		{InvalidResponse, joybus.ErrPIFInvalidResponse},
+		{BadChecksum, joybus.ErrChecksum},
+	} {
+		pif.Inject(2, tc.fault)
+		if _, err := dev.readBlock(0); err != tc.err {
+			t.Errorf("read: expected %v, got %v", tc.err, err)
+		}
+		pif.Inject(2, tc.fault)
+		if err := dev.writeBlock(0, buf[:]); err != tc.err {
+			t.Errorf("write: expected %v, got %v", tc.err, err)
+		}
+	}
+
+	// Corrupted address checksum is reported in the controller's status.
+	dev.read.Reset()
+	dev.read.SetAddress(0x0020)
+	dev.read.Command[4] ^= 0x01
+	pif.Run(dev.readBlk)
+	b, info := newBlock(t, 2, joybus.NewInfoCommand)
+	pif.Run(b)
+	if _, flags, _ := info.Info(); flags != pakInserted|pakAddrCRC {
+		t.Errorf("unexpected flags %#02x", flags)
+	}
+
+	// Without a pak the checksum is inverted.
+	ctrl.Insert(nil)
+	if _, err := dev.readBlock(0); err != joybus.ErrChecksum {
+		t.Errorf("expected %v, got %v", joybus.ErrChecksum, err)
+	}
+}
+
+func TestRumblePak(t *testing.T) {
+	pak := &RumblePak{}
+	ctrl := &Controller{}
+	ctrl.Insert(pak)
+	pif := &PIF{Ports: [4]Device{ctrl}}
+	dev := newPakPort(t, pif, 0)
+
+	var data [1]byte
+	for _, probe := range []byte{0x01, 0x80} {
+		data[0] = probe
+		if err := dev.writeBlock(0x8000, bytes.Repeat(data[:], pakBlockSize)); err != nil {
+			t.Fatal(err)
+		}
+		rx, err := dev.readBlock(0x8000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (rx[pakBlockSize-1] == probe) != (probe == 0x80) {
+			t.Errorf("probe %#02x: read back %#02x", probe, rx[pakBlockSize-1])
+		}
+	}
+
+	for _, on := range []bool{true, false} {
+		data[0] = 0
+		if on {
+			data[0] = 1
+		}
+		if err := dev.writeBlock(0xc000, bytes.Repeat(data[:], pakBlockSize)); err != nil {
+			t.Fatal(err)
+		}
+		if pak.On != on {
+			t.Errorf("expected motor %v", on)
+		}
+	}
+}
+
+func TestEEPROM(t *testing.T) {
+	eeprom := NewEEPROM(2048)
+	pif := &PIF{Cartridge: eeprom}
+
+	b, info := newBlock(t, 4, joybus.NewInfoCommand)
+	pif.Run(b)
+	if dev, _, err := info.Info(); err != nil || dev != joybus.EEPROM16k {
+		t.Fatalf("unexpected device %#04x: %v", uint16(dev), err)
+	}
+
+	wb, write := newBlock(t, 4, joybus.NewWriteEEPROMCommand)
+	data := []byte("8 bytes!")
+	write.SetBlock(3)
+	if err := write.SetData(data); err != nil {
+		t.Fatal(err)
+	}
+	pif.Run(wb)
+	if err := write.Result(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(eeprom.Data[24:32], data) {
+		t.Fatalf("unexpected contents % x", eeprom.Data[:32])
+	}
+
+	rb, read := newBlock(t, 4, joybus.NewReadEEPROMCommand)
+	read.SetBlock(3)
+	pif.Run(rb)
+	got, err := read.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("expected %q, got %q", data, got)
+	}
+
+	pif.Inject(4, NoResponse)
+	pif.Run(rb)
+	if _, err = read.Data(); err != joybus.ErrPIFNoResponse {
+		t.Errorf("expected %v, got %v", joybus.ErrPIFNoResponse, err)
+	}
+}
@@ -0,0 +1,124 @@
+package joybus
+
+// Voice Recognition Unit (VRU) commands
+const (
+	cmdVRURead   = "\x03\x25\x09"
+	cmdVRUWrite  = "\x17\x01\x0a"
+	cmdVRUStatus = "\x03\x03\x0b"
+	cmdVRUConfig = "\x07\x01\x0c"
+	cmdVRUInit   = "\x03\x01\x0d"
+)
+
+// VRUReadCommand reads 36 bytes from the VRU, e.g. the recognition results.
+type VRUReadCommand struct{ PakCommand }
+
+func NewVRUReadCommand(alloc Allocator) (VRUReadCommand, error) {
+	cmd, err := newCommand(alloc, cmdVRURead)
+	return VRUReadCommand{PakCommand{cmd}}, err
+}
+
+func (c VRUReadCommand) Data() (data []byte, err error) {
+	if err = validate(c.Command, cmdVRURead); err != nil {
+		return
+	}
+	rx := c.rxData()
+	data = rx[:len(rx)-1]
+	if PakChecksum(data) != rx[len(data)] {
+		err = ErrChecksum
+	}
+	return
+}
+
+// VRUWriteCommand writes data to the VRU. It's used for both the 20 byte
+// write command, e.g. to transfer dictionary words, and the 4 byte config
+// command.
+type VRUWriteCommand struct {
+	PakCommand
+	header string
+	csum   byte
+}
+
+// NewVRUWriteCommand returns a command writing 20 bytes of data.
+func NewVRUWriteCommand(alloc Allocator) (VRUWriteCommand, error) {
+	cmd, err := newCommand(alloc, cmdVRUWrite)
+	return VRUWriteCommand{PakCommand{cmd}, cmdVRUWrite, 0}, err
+}
+
+// NewVRUConfigCommand returns a command writing 4 bytes of configuration.
+func NewVRUConfigCommand(alloc Allocator) (VRUWriteCommand, error) {
+	cmd, err := newCommand(alloc, cmdVRUConfig)
+	return VRUWriteCommand{PakCommand{cmd}, cmdVRUConfig, 0}, err
+}
+
+// len(src) must match the payload size, i.e. 20 or 4 bytes.
+func (c *VRUWriteCommand) SetData(src []byte) (err error) {
+	if err = validate(c.Command, c.header); err != nil {
+		return
+	}
+
+	data := c.txData()[3:] // exclude addr
+	if len(src) != len(data) {
+		return ErrDataLength
+	}
+	copy(data, src)
+	c.csum = PakChecksum(data)
+	return
+}
+
+func (c VRUWriteCommand) Result() error {
+	if err := validate(c.Command, c.header); err != nil {
+		return err
+	} else if c.rxData()[0] != c.csum {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// VRUState is the recognition state returned by [VRUStatusCommand].
+type VRUState uint8
+
+const (
+	VRUReady  VRUState = 0 // Idle
+	VRUStart  VRUState = 1 // Listening
+	VRUCancel VRUState = 3 // Recognition was stopped
+	VRUBusy   VRUState = 5 // Processing a command or utterance
+	VRUEnd    VRUState = 7 // Result is available
+)
+
+// VRUStatusCommand reads the VRU's status.
+type VRUStatusCommand struct{ PakCommand }
+
+func NewVRUStatusCommand(alloc Allocator) (VRUStatusCommand, error) {
+	cmd, err := newCommand(alloc, cmdVRUStatus)
+	return VRUStatusCommand{PakCommand{cmd}}, err
+}
+
+func (c VRUStatusCommand) Status() (state VRUState, err error) {
+	if err = validate(c.Command, cmdVRUStatus); err != nil {
+		return
+	}
+	rx := c.rxData()
+	return VRUState(rx[1] & 0x07), nil
+}
+
+// VRUInitCommand writes the VRU's init register, which resets its state
+// machine.
+type VRUInitCommand struct{ Command }
+
+func NewVRUInitCommand(alloc Allocator) (VRUInitCommand, error) {
+	cmd, err := newCommand(alloc, cmdVRUInit)
+	return VRUInitCommand{cmd}, err
+}
+
+func (c VRUInitCommand) SetValue(v uint16) {
+	tx := c.txData()
+	tx[1] = byte(v >> 8)
+	tx[2] = byte(v)
+}
+
+func (c VRUInitCommand) Result() (byte, error) {
+	if err := validate(c.Command, cmdVRUInit); err != nil {
+		return 0, err
+	}
+	return c.rxData()[0], nil
+}
@@ -0,0 +1,147 @@
+//go:build !n64
+
+package joybus
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+type testBlock struct {
+	buf [64]byte
+	n   int
+}
+
+func (b *testBlock) Alloc(n int) ([]byte, error) {
+	if b.n+n > len(b.buf) {
+		return nil, errors.New("command block full")
+	}
+	b.n += n
+	return b.buf[b.n-n : b.n], nil
+}
+
+func TestVRUChecksum(t *testing.T) {
+	// The VRU uses the pak checksum for messages of any length.
+	for _, tc := range []struct {
+		data []byte
+		crc  byte
+	}{
+		{nil, 0x00},
+		{[]byte{0x00, 0x00, 0x00, 0x00}, 0x00},
+		{[]byte{0x01}, 0x85},
+		{[]byte{0x80}, 0x89},
+	} {
+		if got := PakChecksum(tc.data); got != tc.crc {
+			t.Errorf("%x: expected %#02x, got %#02x", tc.data, tc.crc, got)
+		}
+	}
+}
+
+func TestVRUWrite(t *testing.T) {
+	var b testBlock
+	cmd, err := NewVRUWriteCommand(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.SetAddress(0x0000)
+
+	if err = cmd.SetData(make([]byte, 4)); err != ErrDataLength {
+		t.Fatalf("expected %v, got %v", ErrDataLength, err)
+	}
+	data := []byte("\x00\x03\x82\xa0\x82\xa2\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	if err = cmd.SetData(data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.buf[:6], []byte{0x17, 0x01, 0x0a, 0x00, 0x00, 0x00}) {
+		t.Fatalf("unexpected header % x", b.buf[:6])
+	}
+	if !bytes.Equal(b.buf[5:25], data) {
+		t.Fatalf("unexpected payload % x", b.buf[5:25])
+	}
+
+	b.buf[25] = PakChecksum(data)
+	if err = cmd.Result(); err != nil {
+		t.Fatal(err)
+	}
+	b.buf[25] ^= 0xff
+	if err = cmd.Result(); err != ErrChecksum {
+		t.Fatalf("expected %v, got %v", ErrChecksum, err)
+	}
+	b.buf[1] |= flagNoResponse
+	if err = cmd.Result(); err != ErrPIFNoResponse {
+		t.Fatalf("expected %v, got %v", ErrPIFNoResponse, err)
+	}
+}
+
+func TestVRUConfig(t *testing.T) {
+	var b testBlock
+	cmd, err := NewVRUConfigCommand(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.SetAddress(0x0000)
+	if err = cmd.SetData([]byte{0x00, 0x00, 0x05, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.buf[:10], []byte{0x07, 0x01, 0x0c, 0x00, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00}) {
+		t.Fatalf("unexpected command % x", b.buf[:10])
+	}
+	b.buf[9] = PakChecksum([]byte{0x00, 0x00, 0x05, 0x00})
+	if err = cmd.Result(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVRURead(t *testing.T) {
+	var b testBlock
+	cmd, err := NewVRUReadCommand(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.n != 2+3+37 {
+		t.Fatalf("unexpected command size %d", b.n)
+	}
+
+	rx := cmd.rxData()
+	rand.Read(rx[:36])
+	rx[36] = PakChecksum(rx[:36])
+	data, err := cmd.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, rx[:36]) {
+		t.Fatal("data mismatch")
+	}
+
+	rx[0] ^= 0x01
+	if _, err = cmd.Data(); err != ErrChecksum {
+		t.Fatalf("expected %v, got %v", ErrChecksum, err)
+	}
+}
+
+func TestVRUStatus(t *testing.T) {
+	var b testBlock
+	cmd, err := NewVRUStatusCommand(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(cmd.rxData(), []byte{0x00, 0x07, 0x00})
+	state, err := cmd.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != VRUEnd {
+		t.Fatalf("unexpected state %v", state)
+	}
+
+	init, err := NewVRUInitCommand(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	init.SetValue(0x1234)
+	if !bytes.Equal(init.Command[:5], []byte{0x03, 0x01, 0x0d, 0x12, 0x34}) {
+		t.Fatalf("unexpected command % x", init.Command[:5])
+	}
+}
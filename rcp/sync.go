@@ -47,6 +47,39 @@ func (p *IntrInput[T]) Get() (v T, updated bool) {
 	}
 }
 
+// IntrOutput passes any value safely out of an interrupt context using a double
+// buffer. The interrupt handler is the only writer, any number of goroutines
+// can read. To be written from an interrupt T must not contain pointers.
+type IntrOutput[T any] struct {
+	bufs [2]T
+	seq  atomic.Uint32
+}
+
+// Put updates the stored value. It must only be called from the interrupt
+// handler.
+//
+//go:nosplit
+func (p *IntrOutput[T]) Put(v *T) {
+	new := p.seq.Load() + 1
+	p.bufs[new&0x1] = *v
+	p.seq.Store(new)
+}
+
+// Get returns the currently stored value and its sequence number, which is
+// incremented by each call to [Put].
+func (p *IntrOutput[T]) Get() (v T, seq uint32) {
+	for {
+		seq = p.seq.Load()
+		v = p.bufs[seq&0x1]
+		// The buffer is only overwritten by the second Put after seq was
+		// loaded. An interrupt can't be preempted by the reader, so if
+		// there was no second Put yet, v wasn't torn.
+		if p.seq.Load()-seq < 2 {
+			return
+		}
+	}
+}
+
 const qsize = 32
 
 // IntrQueue queues any value safely into an interrupt context. Multiple writer
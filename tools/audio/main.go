@@ -0,0 +1,152 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/clktmr/n64/drivers/rspq/mixer/vadpcm"
+)
+
+var (
+	flags = flag.NewFlagSet("audio", flag.ExitOnError)
+
+	outfile    = flags.String("o", "", "output `file`")
+	predictors = flags.Int("predictors", 4, "number of predictors in the codebook (1-8)")
+
+	wavfile string
+)
+
+const usageString = `WAV to VADPCM audio converter.
+
+Usage: %s [flags] <wavfile>
+
+Converts an uncompressed WAV file to VADPCM, which is decoded on the RSP by
+the mixer package. The codebook is generated for the input, more predictors
+improve quality. Channels are mixed down to mono. The output is written next to
+the input with the extension replaced by ".vadpcm", unless specified otherwise.
+
+`
+
+func usage() {
+	fmt.Fprintf(flags.Output(), usageString, "audio")
+	flags.PrintDefaults()
+}
+
+func Main(args []string) {
+	flags.Usage = usage
+	flags.Parse(args[1:])
+
+	if flags.NArg() == 1 {
+		wavfile = flags.Arg(0)
+	} else {
+		flags.Usage()
+		os.Exit(1)
+	}
+	if *predictors < 1 || *predictors > vadpcm.MaxPredictors {
+		log.Fatalln("invalid number of predictors:", *predictors)
+	}
+	if *outfile == "" {
+		*outfile = strings.TrimSuffix(wavfile, filepath.Ext(wavfile)) + ".vadpcm"
+	}
+
+	r, err := os.Open(wavfile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer r.Close()
+
+	samples, rate, err := readWAV(bufio.NewReader(r))
+	if err != nil {
+		log.Fatalln(wavfile+":", err)
+	}
+
+	w, err := os.Create(*outfile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer w.Close()
+
+	bw := bufio.NewWriter(w)
+	err = vadpcm.Encode(bw, samples, rate, *predictors)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+var errWAV = errors.New("unsupported wav file")
+
+// readWAV returns the samples of an 8 or 16 bit PCM WAV file mixed down to mono
+// and its sample rate.
+func readWAV(r io.Reader) (samples []int16, rate uint32, err error) {
+	var riff [12]byte
+	if _, err = io.ReadFull(r, riff[:]); err != nil {
+		return
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, 0, errWAV
+	}
+
+	var channels, bits int
+	for {
+		var hdr [8]byte
+		if _, err = io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF {
+				err = errors.New("missing data chunk")
+			}
+			return
+		}
+		size := int64(binary.LittleEndian.Uint32(hdr[4:]))
+		chunk := io.LimitReader(r, size+size&1) // chunks are padded to even size
+
+		switch string(hdr[:4]) {
+		case "fmt ":
+			var f [16]byte
+			if _, err = io.ReadFull(chunk, f[:]); err != nil {
+				return
+			}
+			format := binary.LittleEndian.Uint16(f[0:])
+			channels = int(binary.LittleEndian.Uint16(f[2:]))
+			rate = binary.LittleEndian.Uint32(f[4:])
+			bits = int(binary.LittleEndian.Uint16(f[14:]))
+			if (format != 1 && format != 0xfffe) || channels == 0 || (bits != 8 && bits != 16) {
+				return nil, 0, errWAV
+			}
+		case "data":
+			if channels == 0 {
+				return nil, 0, errors.New("missing fmt chunk")
+			}
+			data := make([]byte, size)
+			if _, err = io.ReadFull(r, data); err != nil {
+				return
+			}
+			frame := channels * bits / 8
+			samples = make([]int16, len(data)/frame)
+			for i := range samples {
+				var sum int
+				for ch := range channels {
+					if bits == 8 {
+						sum += (int(data[i*frame+ch]) - 0x80) << 8
+					} else {
+						sum += int(int16(binary.LittleEndian.Uint16(data[i*frame+2*ch:])))
+					}
+				}
+				samples[i] = int16(sum / channels)
+			}
+			return samples, rate, nil
+		}
+		if _, err = io.Copy(io.Discard, chunk); err != nil {
+			return
+		}
+	}
+}
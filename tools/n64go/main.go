@@ -8,8 +8,10 @@
 //   - [github.com/clktmr/n64/tools/rom]      convert and execute elf to n64 ROMs
 //   - [github.com/clktmr/n64/tools/texture]  generate textures to be used on the n64
 //   - [github.com/clktmr/n64/tools/font]     generate fonts to be used on the n64
+//   - [github.com/clktmr/n64/tools/audio]    encode audio to be played by the mixer
 //   - [github.com/clktmr/n64/tools/pakfs]    modify and inspect pakfs images
-//   - [github.com/clktmr/n64/tools/ucode]    dump rsp microcode elf to binary
+//   - [github.com/clktmr/n64/tools/recording] dump and edit controller input recordings
+//   - [github.com/clktmr/n64/tools/ucode]    assemble, inspect and convert rsp microcode
 //   - [github.com/clktmr/n64/tools/toolexec] used as 'go build -toolexec' parameter
 package main
 
@@ -19,8 +21,10 @@ import (
 	"log"
 	"os"
 
+	"github.com/clktmr/n64/tools/audio"
 	"github.com/clktmr/n64/tools/font"
 	"github.com/clktmr/n64/tools/pakfs"
+	"github.com/clktmr/n64/tools/recording"
 	"github.com/clktmr/n64/tools/rom"
 	"github.com/clktmr/n64/tools/texture"
 	"github.com/clktmr/n64/tools/toolexec"
@@ -38,8 +42,10 @@ The commands are:
 	rom      convert and execute elf to n64 ROMs
 	texture  convert images to n64 textures
 	font     generate fonts to be used on the n64
+	audio    encode audio to be played by the mixer
 	pakfs    modify and inspect pakfs images
-	ucode    dump rsp microcode elf to binary
+	recording dump and edit controller input recordings
+	ucode    assemble, inspect and convert rsp microcode
 	toolexec used as 'go build -toolexec' parameter
 `
 
@@ -63,12 +69,16 @@ func main() {
 		rom.Main(flag.Args())
 	case "pakfs":
 		pakfs.Main(flag.Args())
+	case "recording":
+		recording.Main(flag.Args())
 	case "toolexec":
 		toolexec.Main(flag.Args())
 	case "font":
 		font.Main(flag.Args())
 	case "texture":
 		texture.Main(flag.Args())
+	case "audio":
+		audio.Main(flag.Args())
 	case "ucode":
 		ucode.Main(flag.Args())
 	default:
@@ -0,0 +1,82 @@
+package recording
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/clktmr/n64/drivers/controller/recording"
+)
+
+const usageString = `Controller input recording utility.
+
+Usage:
+
+	%s <command> [arguments]
+
+The commands are:
+
+	dump <recording>		print recording as editable text
+	compile <text> <recording>	convert text back to a recording
+
+Text is read from stdin if the filename is "-".
+`
+
+var flags = flag.NewFlagSet("recording", flag.ExitOnError)
+
+func usage() {
+	fmt.Fprintf(flags.Output(), usageString, "recording")
+	flags.PrintDefaults()
+}
+
+func Main(args []string) {
+	flags.Usage = usage
+	flags.Parse(args[1:])
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	switch flags.Arg(0) {
+	case "dump":
+		if flags.NArg() != 2 {
+			flags.Usage()
+			os.Exit(1)
+		}
+		data, err := os.ReadFile(flags.Arg(1))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err = recording.Dump(os.Stdout, bytes.NewReader(data)); err != nil {
+			log.Fatalln("dump:", err)
+		}
+	case "compile":
+		if flags.NArg() != 3 {
+			flags.Usage()
+			os.Exit(1)
+		}
+		text := os.Stdin
+		if name := flags.Arg(1); name != "-" {
+			f, err := os.Open(name)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer f.Close()
+			text = f
+		}
+		var buf bytes.Buffer
+		if err := recording.Compile(&buf, text); err != nil {
+			log.Fatalln("compile:", err)
+		}
+		if err := os.WriteFile(flags.Arg(2), buf.Bytes(), 0644); err != nil {
+			log.Fatalln(err)
+		}
+	default:
+		fmt.Fprintf(flags.Output(), "unknown command: %s\n", flags.Arg(0))
+		flags.Usage()
+		os.Exit(1)
+	}
+}
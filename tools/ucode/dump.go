@@ -0,0 +1,104 @@
+package ucode
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/clktmr/n64/rcp/rsp/ucode"
+	"github.com/clktmr/n64/rcp/rsp/ucode/asm"
+)
+
+// overlayHeader mirrors rspqOverlayHeader in drivers/rspq.
+type overlayHeader struct {
+	StateStart  uint16
+	StateSize   uint16
+	CommandBase uint16
+	Commands    []uint16
+}
+
+func dump(args []string) {
+	if len(args) != 2 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	r, err := os.Open(args[1])
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer r.Close()
+	uc, err := ucode.Load(r)
+	if err != nil {
+		log.Fatalln("load:", err)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "name:  %s\n", uc.Name)
+	fmt.Fprintf(w, "entry: %#08x\n", uc.Entry)
+	fmt.Fprintf(w, "text:  %d bytes\n", len(uc.Text))
+	fmt.Fprintf(w, "data:  %d bytes\n", len(uc.Data))
+
+	if offset, hdr, ok := findOverlayHeader(uc); ok {
+		fmt.Fprintf(w, "\nrspq overlay header at DMEM %#03x:\n", offset)
+		fmt.Fprintf(w, "  state:    %#03x-%#03x (%d bytes)\n", hdr.StateStart,
+			int(hdr.StateStart)+int(hdr.StateSize), int(hdr.StateSize)+1)
+		fmt.Fprintf(w, "  commands: %d, occupying %d of 16 overlay ids\n", len(hdr.Commands),
+			(len(hdr.Commands)+15)>>4)
+		for i, cmd := range hdr.Commands {
+			fmt.Fprintf(w, "    %#02x: handler %#03x, size %d\n", i,
+				(cmd&0x3ff)<<2, (cmd>>10)<<2)
+		}
+	}
+
+	fmt.Fprintf(w, "\ntext:\n")
+	asm.WriteText(w, uc.Text)
+	fmt.Fprintf(w, "\ndata:\n")
+	fmt.Fprint(w, hex.Dump(uc.Data))
+}
+
+// findOverlayHeader searches the data of an rspq overlay for its header, which
+// follows the data of rsp_queue. Since its size isn't stored in the overlay,
+// the first location which holds a plausible header is used.
+func findOverlayHeader(uc *ucode.UCode) (offset int, hdr *overlayHeader, ok bool) {
+	for offset = 8; offset+12 <= len(uc.Data); offset += 8 {
+		if hdr, ok = parseOverlayHeader(uc, offset); ok {
+			return
+		}
+	}
+	return 0, nil, false
+}
+
+func parseOverlayHeader(uc *ucode.UCode, offset int) (*overlayHeader, bool) {
+	field := func(i int) uint16 {
+		return binary.BigEndian.Uint16(uc.Data[offset+2*i:])
+	}
+	hdr := &overlayHeader{StateStart: field(0), StateSize: field(1), CommandBase: field(2)}
+	if hdr.CommandBase != 0 || field(3) != 0 {
+		return nil, false // filled in by rspq.Register
+	}
+	for i := 4; ; i++ {
+		if offset+2*i+2 > len(uc.Data) {
+			return nil, false
+		}
+		cmd := field(i)
+		if cmd == 0 {
+			break
+		}
+		if handler := int(cmd&0x3ff) << 2; handler >= len(uc.Text) {
+			return nil, false
+		}
+		hdr.Commands = append(hdr.Commands, cmd)
+	}
+	headerEnd := offset + 8 + 2*(len(hdr.Commands)+1)
+	stateEnd := int(hdr.StateStart) + int(hdr.StateSize) + 1
+	if len(hdr.Commands) == 0 || int(hdr.StateStart) < headerEnd ||
+		hdr.StateStart%8 != 0 || stateEnd > len(uc.Data) {
+		return nil, false
+	}
+	return hdr, true
+}
@@ -0,0 +1,324 @@
+package ucode
+
+import (
+	"bytes"
+	"debug/elf"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/clktmr/n64/rcp/cpu"
+	"github.com/clktmr/n64/rcp/rsp/ucode"
+	"github.com/clktmr/n64/rcp/rsp/ucode/asm"
+)
+
+// generate writes Go bindings for an rspq overlay: constants for its commands
+// and exported DMEM symbols, and a struct mirroring its saved state.
+func generate(args []string) {
+	genFlags := flag.NewFlagSet("gen", flag.ExitOnError)
+	genFlags.Usage = func() {
+		fmt.Fprintf(genFlags.Output(), usageString, "ucode")
+		genFlags.PrintDefaults()
+	}
+	outfile := genFlags.String("o", "", "output `file`")
+	pkg := genFlags.String("pkg", os.Getenv("GOPACKAGE"), "package `name` of the generated file")
+	var trims []string
+	genFlags.Func("trim", "trim `prefix` from symbol names, may be repeated", func(s string) error {
+		trims = append(trims, s)
+		return nil
+	})
+	var cmds []string
+	genFlags.Func("cmd", "`name` of the next command in the command table, may be repeated", func(s string) error {
+		cmds = append(cmds, s)
+		return nil
+	})
+	genFlags.Parse(args[1:])
+
+	if genFlags.NArg() != 1 || *pkg == "" {
+		genFlags.Usage()
+		os.Exit(1)
+	}
+	infile := genFlags.Arg(0)
+	if *outfile == "" {
+		*outfile = strings.TrimSuffix(infile, filepath.Ext(infile)) + "_ucode.go"
+	}
+
+	var uc *ucode.UCode
+	var syms []asm.Symbol
+	switch filepath.Ext(infile) {
+	case ".elf":
+		uc, syms = loadElf(infile)
+	case ".ucode":
+		uc = loadUCode(infile)
+	default:
+		uc, syms = assembleFile(infile)
+	}
+
+	src, err := bindings(uc, syms, *pkg, trims, cmds)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	err = os.WriteFile(*outfile, src, 0644)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// loadElf converts an elf file and returns its symbols the way the assembler
+// would report them, except for their Size, which isn't known.
+func loadElf(infile string) (*ucode.UCode, []asm.Symbol) {
+	elffile, err := elf.Open(infile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer elffile.Close()
+
+	uc := &ucode.UCode{
+		Name:  filepath.Base(infile),
+		Entry: cpu.Addr(elffile.Entry),
+		Text:  sectionData(elffile, ".text"),
+		Data:  sectionData(elffile, ".data"),
+	}
+
+	elfsyms, err := elffile.Symbols()
+	if err != nil {
+		log.Fatalln("read symbols:", err)
+	}
+	var syms []asm.Symbol
+	for _, sym := range elfsyms {
+		if sym.Name == "" || int(sym.Section) >= len(elffile.Sections) {
+			continue
+		}
+		sect := elffile.Sections[sym.Section].Name
+		if sect != ".text" && sect != ".data" {
+			continue
+		}
+		syms = append(syms, asm.Symbol{
+			Name:   sym.Name,
+			Value:  int64(sym.Value),
+			Label:  true,
+			Data:   sect == ".data",
+			Global: elf.ST_BIND(sym.Info) == elf.STB_GLOBAL,
+		})
+	}
+	return uc, syms
+}
+
+// loadUCode reads a ucode file, which has no symbols.
+func loadUCode(infile string) *ucode.UCode {
+	r, err := os.Open(infile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer r.Close()
+
+	uc, err := ucode.Load(r)
+	if err != nil {
+		log.Fatalln(infile+":", err)
+	}
+	uc.Name = filepath.Base(infile)
+	return uc
+}
+
+// bindings returns the Go source for the overlay uc. Commands are named by
+// cmds in the order of the command table, the remaining ones after their
+// handler's symbol. Without any syms, e.g. for a ucode file, the state isn't
+// mirrored.
+func bindings(uc *ucode.UCode, syms []asm.Symbol, pkg string, trims, cmds []string) ([]byte, error) {
+	offset, hdr, ok := findOverlayHeader(uc)
+	if !ok {
+		return nil, fmt.Errorf("%s: no rspq overlay header found", uc.Name)
+	}
+	if len(cmds) > len(hdr.Commands) {
+		return nil, fmt.Errorf("%s: %d command names for %d commands", uc.Name, len(cmds), len(hdr.Commands))
+	}
+
+	var text, data []asm.Symbol
+	var labels []int64 // offsets of all data labels
+	for _, sym := range syms {
+		if !sym.Label {
+			continue
+		}
+		sym.Value &= asm.MemSize - 1
+		if sym.Data {
+			labels = append(labels, sym.Value)
+		}
+		if strings.HasPrefix(sym.Name, "_") {
+			continue
+		}
+		if sym.Data {
+			if sym.Global && int(sym.Value) >= offset {
+				data = append(data, sym)
+			}
+		} else {
+			text = append(text, sym)
+		}
+	}
+	slices.Sort(labels)
+	// Prefer global symbols when naming handlers
+	slices.SortStableFunc(text, func(a, b asm.Symbol) int {
+		if a.Global == b.Global {
+			return 0
+		}
+		if a.Global {
+			return -1
+		}
+		return 1
+	})
+
+	names := make(map[string]string)
+	ident := func(prefix, name string) (string, error) {
+		id := goIdent(prefix, name, trims)
+		if other, ok := names[id]; ok {
+			return "", fmt.Errorf("%s and %s both map to %s", other, name, id)
+		}
+		names[id] = name
+		return id, nil
+	}
+
+	command := "rspq.Command"
+	if pkg == "rspq" {
+		command = "Command"
+	}
+
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "// Code generated by \"n64go ucode gen\" from %s; DO NOT EDIT.\n\n", uc.Name)
+	fmt.Fprintf(b, "package %s\n\n", pkg)
+	fmt.Fprintf(b, "import (\n")
+	if syms != nil {
+		fmt.Fprintf(b, "\"structs\"\n\"unsafe\"\n\n")
+	}
+	if pkg != "rspq" {
+		fmt.Fprintf(b, "\"github.com/clktmr/n64/drivers/rspq\"\n")
+	}
+	fmt.Fprintf(b, ")\n\n")
+
+	fmt.Fprintf(b, "// Commands of %s\n", uc.Name)
+	fmt.Fprintf(b, "const (\n")
+	for i, cmd := range hdr.Commands {
+		handler, size := int64(cmd&0x3ff)<<2, (cmd>>10)<<2
+		name := fmt.Sprintf("%02x", i)
+		if i < len(cmds) {
+			name = cmds[i]
+		} else if idx := slices.IndexFunc(text, func(s asm.Symbol) bool { return s.Value == handler }); idx != -1 {
+			name = text[idx].Name
+		}
+		id, err := ident("cmd", name)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(b, "%s %s = %#02x // %d bytes\n", id, command, i, size)
+	}
+	fmt.Fprintf(b, ")\n\n")
+
+	fmt.Fprintf(b, "// DMEM offsets of %s\n", uc.Name)
+	fmt.Fprintf(b, "const (\n")
+	fmt.Fprintf(b, "dmemOverlayHeader = %#03x\n", offset)
+	fmt.Fprintf(b, "dmemState = %#03x\n", hdr.StateStart)
+	for _, sym := range data {
+		id, err := ident("dmem", sym.Name)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(b, "%s = %#03x\n", id, sym.Value)
+	}
+	if syms == nil {
+		fmt.Fprintf(b, ")\n")
+		return format.Source(b.Bytes())
+	}
+	fmt.Fprintf(b, ")\n\n")
+
+	// Exported symbols in the saved state become fields, which extend up
+	// to the next label. Everything else is padding. Fields holding a
+	// single value of an integer directive are typed accordingly, others
+	// are byte arrays.
+	start, end := int64(hdr.StateStart), int64(hdr.StateStart)+int64(hdr.StateSize)+1
+	var fields []asm.Symbol
+	for _, sym := range data {
+		if sym.Value < start || sym.Value >= end {
+			continue
+		}
+		if n := len(fields); n > 0 && fields[n-1].Value == sym.Value {
+			continue
+		}
+		fields = append(fields, sym)
+	}
+
+	type assertion struct{ expr, value string }
+	assertions := []assertion{{"unsafe.Sizeof(state{})", fmt.Sprint(end - start)}}
+
+	fmt.Fprintf(b, "// state mirrors the saved state of %s at DMEM %#03x.\n", uc.Name, start)
+	fmt.Fprintf(b, "type state struct {\n_ structs.HostLayout\n\n")
+	pos := start
+	for _, sym := range fields {
+		if sym.Value > pos {
+			fmt.Fprintf(b, "_ [%d]byte\n", sym.Value-pos)
+		}
+		next := end
+		if idx := slices.IndexFunc(labels, func(l int64) bool { return l > sym.Value }); idx != -1 {
+			next = min(labels[idx], end)
+		}
+		size := next - sym.Value
+		typ := fmt.Sprintf("[%d]byte", size)
+		if size == sym.Size && (sym.Value-start)%size == 0 {
+			typ = fmt.Sprintf("uint%d", size*8)
+		}
+		id, err := ident("", sym.Name)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(b, "%s %s // %#03x\n", id, typ, sym.Value)
+		assertions = append(assertions, assertion{
+			fmt.Sprintf("unsafe.Offsetof(state{}.%s)", id), fmt.Sprint(sym.Value - start)})
+		pos = next
+	}
+	if pos < end {
+		fmt.Fprintf(b, "_ [%d]byte\n", end-pos)
+	}
+	fmt.Fprintf(b, "}\n\n")
+
+	fmt.Fprintf(b, "// Fail to compile if the layout of state doesn't match DMEM.\n")
+	fmt.Fprintf(b, "var (\n")
+	for _, a := range assertions {
+		fmt.Fprintf(b, "_ [0]struct{} = [%s - %s]struct{}{}\n", a.expr, a.value)
+	}
+	fmt.Fprintf(b, ")\n")
+
+	return format.Source(b.Bytes())
+}
+
+// goIdent converts a symbol name to an unexported Go identifier, e.g.
+// VEC_SLOTS to vecSlots or, with prefix "cmd", VecCmd_Load to cmdVecCmdLoad.
+func goIdent(prefix, name string, trims []string) string {
+	for _, trim := range trims {
+		if s, ok := strings.CutPrefix(name, trim); ok && s != "" {
+			name = s
+			break
+		}
+	}
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	id := prefix
+	for _, part := range parts {
+		if strings.ToUpper(part) == part {
+			part = strings.ToLower(part)
+		}
+		if id == "" {
+			id = strings.ToLower(part[:1]) + part[1:]
+		} else {
+			id += strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	if id == "" || !token.IsIdentifier(id) || token.IsKeyword(id) {
+		id = "_" + id
+	}
+	return id
+}
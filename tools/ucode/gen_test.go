@@ -0,0 +1,101 @@
+//go:build !n64
+
+package ucode
+
+import (
+	"bytes"
+	"flag"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/clktmr/n64/rcp/rsp/ucode/asm"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestBindings(t *testing.T) {
+	uc, syms, err := asm.AssembleSymbols(os.DirFS("testdata"), "rsp_gen.S")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Generate into package rspq to compile without its dependencies.
+	src, err := bindings(uc, syms, "rspq", []string{"GEN_"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "rsp_gen_ucode.go.golden")
+	if *update {
+		if err = os.WriteFile(golden, src, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, want) {
+		t.Errorf("generated bindings differ from %s:\n%s", golden, src)
+	}
+
+	// The layout assertions fail to compile if the state doesn't match the
+	// DMEM layout of the console.
+	typeCheck(t, src)
+}
+
+func TestBindingsUCode(t *testing.T) {
+	uc, _, err := asm.AssembleSymbols(os.DirFS("testdata"), "rsp_gen.S")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = bindings(uc, nil, "rspq", nil, []string{"Set", "Get", "Other"}); err == nil {
+		t.Error("expected error for too many command names")
+	}
+
+	src, err := bindings(uc, nil, "rspq", nil, []string{"Set"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"cmdSet Command = 0x00 // 8 bytes",
+		"cmd01  Command = 0x01 // 4 bytes",
+		"dmemOverlayHeader = 0x008",
+		"dmemState         = 0x018",
+	} {
+		if !bytes.Contains(src, []byte(want)) {
+			t.Errorf("missing %q in:\n%s", want, src)
+		}
+	}
+	if bytes.Contains(src, []byte("type state")) {
+		t.Errorf("unexpected state without symbols:\n%s", src)
+	}
+	typeCheck(t, src)
+}
+
+// typeCheck compiles the generated src as part of package rspq.
+func typeCheck(t *testing.T, src []byte) {
+	t.Helper()
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for name, s := range map[string][]byte{"rsp_gen_ucode.go": src, "rspq.go": []byte("package rspq\n\ntype Command byte\n")} {
+		f, err := parser.ParseFile(fset, name, s, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Sizes:    types.SizesFor("gc", "mips64"),
+	}
+	if _, err := conf.Check("rspq", fset, files, nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -12,11 +12,37 @@ import (
 
 	"github.com/clktmr/n64/rcp/cpu"
 	"github.com/clktmr/n64/rcp/rsp/ucode"
+	"github.com/clktmr/n64/rcp/rsp/ucode/asm"
 )
 
 const usageString = `RSP microcode converter.
 
-Usage: %s [flags] <elffile>
+Usage:
+
+	%[1]s [flags] <elffile>
+	%[1]s asm [-o <ucodefile>] <source>
+	%[1]s dump <ucodefile>
+	%[1]s gen [-o <gofile>] [-pkg <name>] [-trim <prefix>] [-cmd <name>] <elffile|ucodefile|source>
+
+The first form converts an elf file built by libdragon's toolchain, the second
+assembles RSP source code. The output is written next to the input with the
+extension replaced by ".ucode", unless specified otherwise. The dump command
+prints the header, disassembly and data of a ucode file, including the command
+table of rspq overlays.
+
+The gen command generates Go bindings for an rspq overlay, either from an elf
+file, a ucode file or from source. The generated file contains an rspq.Command
+constant per entry of the command table, named by the -cmd flags in table order
+or after the handler's symbol, DMEM offsets of the global data symbols and a
+struct mirroring the saved state, including compile time assertions of its
+layout. Fields defined by a single value of a .byte, .half or .word directive
+are integers, all others are byte arrays. Elf files don't record the
+directives, so their fields are always byte arrays. Ucode files don't contain
+any symbols, so only the commands and the offsets of the overlay header and
+state are generated. It's intended to be used with go:generate, e.g.:
+
+	//go:generate go tool n64go ucode gen rsp_foo.S
+	//go:generate go tool n64go ucode gen -cmd Load -cmd Store rsp_bar.ucode
 
 `
 
@@ -35,6 +61,18 @@ func Main(args []string) {
 	flags.Usage = usage
 	flags.Parse(args[1:])
 
+	switch flags.Arg(0) {
+	case "asm":
+		assemble(flags.Args())
+		return
+	case "dump":
+		dump(flags.Args())
+		return
+	case "gen":
+		generate(flags.Args())
+		return
+	}
+
 	if flags.NArg() == 1 {
 		infile = flags.Arg(0)
 	} else {
@@ -43,8 +81,7 @@ func Main(args []string) {
 		os.Exit(1)
 	}
 
-	outfile, _ := strings.CutSuffix(infile, ".elf")
-	outfile += ".ucode"
+	outfile := ucodeFile(infile, ".elf")
 
 	elffile, err := elf.Open(infile)
 	if err != nil {
@@ -59,16 +96,66 @@ func Main(args []string) {
 		Data:  sectionData(elffile, ".data"),
 	}
 
+	store(ucode, outfile)
+}
+
+func ucodeFile(infile, ext string) string {
+	outfile, _ := strings.CutSuffix(infile, ext)
+	return outfile + ".ucode"
+}
+
+func store(uc *ucode.UCode, outfile string) {
 	w, err := os.Create(outfile)
 	if err != nil {
 		log.Fatalln(err)
 	}
 	defer w.Close()
 
-	err = ucode.Store(w)
+	err = uc.Store(w)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func assemble(args []string) {
+	asmFlags := flag.NewFlagSet("asm", flag.ExitOnError)
+	asmFlags.Usage = func() {
+		fmt.Fprintf(asmFlags.Output(), usageString, "ucode")
+		asmFlags.PrintDefaults()
+	}
+	outfile := asmFlags.String("o", "", "output `file`")
+	asmFlags.Parse(args[1:])
+
+	if asmFlags.NArg() != 1 {
+		asmFlags.Usage()
+		os.Exit(1)
+	}
+	infile := asmFlags.Arg(0)
+	if *outfile == "" {
+		*outfile = ucodeFile(infile, filepath.Ext(infile))
+	}
+
+	uc, _ := assembleFile(infile)
+	store(uc, *outfile)
+}
+
+func assembleFile(infile string) (*ucode.UCode, []asm.Symbol) {
+	// Includes may refer to any file relative to the source, so assemble
+	// from the root of the filesystem.
+	abs, err := filepath.Abs(infile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	root := filepath.VolumeName(abs) + string(filepath.Separator)
+	name, err := filepath.Rel(root, abs)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	uc, syms, err := asm.AssembleSymbols(os.DirFS(root), filepath.ToSlash(name))
 	if err != nil {
 		log.Fatalln(err)
 	}
+	return uc, syms
 }
 
 func sectionData(elffile *elf.File, section string) []byte {